@@ -0,0 +1,57 @@
+package main
+
+import (
+    "context"
+    "sync/atomic"
+
+    "qube/internal/execq"
+    "qube/internal/session"
+    "qube/internal/stream"
+)
+
+// execqAdapter はexecq.ExecQContextをexecutor.ExecQインターフェースに適合させる
+type execqAdapter struct{}
+
+func (e *execqAdapter) Run(ctx context.Context, args []string, opts execq.Options) (execq.Result, error) {
+    // Q CLIコマンドを実行（"q"で始まる場合は自動的にバイナリパスを検出）
+    // タイムアウトは executor が ctx の期限として設定する
+    return execq.ExecQContext(ctx, args, opts)
+}
+
+// sessionAdapter はsession.Sessionをexecutor.Sessionインターフェースに適合させる
+type sessionAdapter struct {
+    *session.Session
+    // running は executor の goroutine と PTY の goroutine の双方から参照される
+    running atomic.Bool
+    processor *stream.SimplifiedProcessor
+}
+
+func (s *sessionAdapter) Start(sessionType string) error {
+    // 新しいセッションの出力が前回の状態に引きずられないよう processor をリセット
+    if s.processor != nil {
+        s.processor.Clear()
+    }
+    err := s.Session.Start(sessionType)
+    if err == nil {
+        s.running.Store(true)
+    }
+    return err
+}
+
+func (s *sessionAdapter) Send(text string) error {
+    // エコーバック抑制のためにprocessorに送信コマンドを記録
+    if s.processor != nil {
+        s.processor.SetLastSentCommand(text)
+    }
+    return s.Session.Send(text)
+}
+
+func (s *sessionAdapter) Stop() error {
+    err := s.Session.Stop()
+    s.running.Store(false)
+    return err
+}
+
+func (s *sessionAdapter) IsRunning() bool {
+    return s.running.Load()
+}
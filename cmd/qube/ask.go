@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"qube/internal/headless"
+	"qube/internal/qcli"
+	"qube/internal/session"
+	"qube/internal/telemetry"
+)
+
+// DefaultAskTimeout は qube ask が応答の完了を待つ既定の時間
+const DefaultAskTimeout = 5 * time.Minute
+
+// askFormats は qube ask の出力形式
+var askFormats = []string{"text", "markdown", "ndjson"}
+
+// askEvent は --format=ndjson で 1 行ずつ出力するイベント
+type askEvent struct {
+	Type       string    `json:"type"` // "line" または "result"
+	Text       string    `json:"text,omitempty"`
+	Tool       bool      `json:"tool,omitempty"`
+	Time       time.Time `json:"time"`
+	Status     string    `json:"status,omitempty"` // "ok" / "error" / "timeout"
+	Error      string    `json:"error,omitempty"`
+	ExitCode   *int      `json:"exit_code,omitempty"`
+	DurationMs int64     `json:"duration_ms,omitempty"`
+	Lines      int       `json:"lines,omitempty"`
+}
+
+// runAsk は TUI を使わずに q chat へプロンプトを送り、応答を stdout に出力する
+// プロンプトは引数、または引数が無いか "-" の場合は標準入力から読む
+func runAsk(env *cliEnv, args []string) int {
+	fs := flag.NewFlagSet("qube ask", flag.ContinueOnError)
+	fs.SetOutput(env.stderr)
+	format := fs.String("format", "text", "output format: "+strings.Join(askFormats, ", "))
+	timeout := fs.Duration("timeout", DefaultAskTimeout, "give up if the response has not completed within this time")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if !contains(askFormats, *format) {
+		fmt.Fprintf(env.stderr, "qube ask: unknown format %q (want one of: %s)\n", *format, strings.Join(askFormats, ", "))
+		return exitUsage
+	}
+	prompt, err := readPrompt(fs.Args(), env.stdin)
+	if err != nil {
+		fmt.Fprintf(env.stderr, "qube ask: %v\n", err)
+		return exitUsage
+	}
+
+	// 設定の誤りは既定値で補って続行する（出力を汚さないよう stderr に表示）
+	if env.cfgErr != nil {
+		fmt.Fprintf(env.stderr, "qube: config: %v\n", env.cfgErr)
+	}
+
+	sess := session.New()
+	sess.SetChatArgs(env.cfg.DefaultFlags)
+	sess.SetEnv(env.cfg.Environ())
+	sess.Telemetry = env.telemetry
+	eff := env.cfg.ExecutorTimeouts().Resolve([]string{"q", "chat"})
+	sess.SetTimeouts(eff.Init, eff.Idle)
+	compatCtx, compatCancel := context.WithTimeout(context.Background(), qcli.DefaultProbeTimeout)
+	compat, _ := resolveCompat(compatCtx, env.cfg.ParserOverrides)
+	compatCancel()
+	if w := compatWarning(compat); w != "" {
+		fmt.Fprintf(env.stderr, "qube: warning: %s\n", w)
+	}
+	sess.SetPatterns(compat.Patterns)
+	conv := headless.New(sess)
+	conv.SetPatterns(compat.Patterns)
+	sess.OnData = conv.Data
+	sess.OnInitialized = conv.Initialized
+	sess.OnExit = conv.Exited
+	sess.OnError = conv.Failed
+
+	out := newAskWriter(*format, env.stdout)
+	out.begin(prompt)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	res, err := conv.Ask(ctx, prompt, out.line)
+
+	code := exitOK
+	switch {
+	case errors.Is(err, headless.ErrTimeout):
+		code = exitTimeout
+	case err != nil:
+		code = exitError
+	}
+	out.end(res, err, code)
+	env.telemetry.Record(telemetry.Event{Name: "ask.finished", Mode: *format, DurationMs: res.Duration().Milliseconds(), ExitCode: telemetry.Exit(code), ErrorKind: askErrorKind(err)})
+	if err != nil && *format != "ndjson" {
+		fmt.Fprintf(env.stderr, "qube ask: %v\n", err)
+	}
+	return code
+}
+
+// askErrorKind は qube ask のエラーを記録用の種類に分類する
+func askErrorKind(err error) string {
+	switch {
+	case errors.Is(err, headless.ErrTimeout):
+		return "timeout"
+	case errors.Is(err, headless.ErrSessionEnded):
+		return "session_ended"
+	case errors.Is(err, headless.ErrNoResponse):
+		return "no_response"
+	}
+	return telemetry.ErrorKind(err)
+}
+
+// readPrompt は引数、または標準入力からプロンプトを読む
+func readPrompt(args []string, stdin io.Reader) (string, error) {
+	if len(args) > 0 && !(len(args) == 1 && args[0] == "-") {
+		return strings.Join(args, " "), nil
+	}
+	if f, ok := stdin.(*os.File); ok && len(args) == 0 {
+		if st, err := f.Stat(); err == nil && st.Mode()&os.ModeCharDevice != 0 {
+			return "", errors.New("no prompt given (pass it as arguments or on stdin)")
+		}
+	}
+	b, err := io.ReadAll(stdin)
+	if err != nil {
+		return "", err
+	}
+	prompt := strings.TrimSpace(string(b))
+	if prompt == "" {
+		return "", errors.New("empty prompt")
+	}
+	return prompt, nil
+}
+
+// askWriter は応答を指定の形式で出力する
+type askWriter struct {
+	format string
+	w      io.Writer
+	enc    *json.Encoder
+}
+
+func newAskWriter(format string, w io.Writer) *askWriter {
+	return &askWriter{format: format, w: w, enc: json.NewEncoder(w)}
+}
+
+// begin は応答の前に出力する部分（markdown ではプロンプトの引用）を書き出す
+func (a *askWriter) begin(prompt string) {
+	if a.format != "markdown" {
+		return
+	}
+	for _, l := range strings.Split(prompt, "\n") {
+		fmt.Fprintln(a.w, strings.TrimRight("> "+l, " "))
+	}
+	fmt.Fprintln(a.w)
+}
+
+// line は応答の 1 行を受信順に書き出す
+func (a *askWriter) line(l headless.Line) {
+	switch a.format {
+	case "ndjson":
+		_ = a.enc.Encode(askEvent{Type: "line", Text: l.Text, Tool: l.Tool, Time: l.Time})
+	case "markdown":
+		if l.Tool {
+			fmt.Fprintf(a.w, "_%s_\n", strings.TrimSpace(l.Text))
+			return
+		}
+		fmt.Fprintln(a.w, l.Text)
+	default:
+		fmt.Fprintln(a.w, l.Text)
+	}
+}
+
+// end は ndjson の場合に結果のイベントを書き出す
+func (a *askWriter) end(res headless.Result, err error, code int) {
+	if a.format != "ndjson" {
+		return
+	}
+	ev := askEvent{Type: "result", Status: "ok", Time: time.Now(), Lines: len(res.Lines), ExitCode: &code}
+	if !res.Started.IsZero() && !res.Finished.IsZero() {
+		ev.DurationMs = res.Duration().Milliseconds()
+	}
+	if err != nil {
+		ev.Status = "error"
+		if code == exitTimeout {
+			ev.Status = "timeout"
+		}
+		ev.Error = err.Error()
+	}
+	_ = a.enc.Encode(ev)
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
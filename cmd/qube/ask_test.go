@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"qube/internal/headless"
+)
+
+func TestReadPrompt(t *testing.T) {
+	if p, err := readPrompt([]string{"list", "buckets"}, strings.NewReader("ignored")); err != nil || p != "list buckets" {
+		t.Fatalf("args: %q %v", p, err)
+	}
+	if p, err := readPrompt([]string{"-"}, strings.NewReader("  from stdin\nline 2\n")); err != nil || p != "from stdin\nline 2" {
+		t.Fatalf("stdin: %q %v", p, err)
+	}
+	if _, err := readPrompt(nil, strings.NewReader(" \n")); err == nil {
+		t.Fatal("empty prompt should be an error")
+	}
+}
+
+func TestAskWriter_Formats(t *testing.T) {
+	res := headless.Result{Started: time.Now(), Finished: time.Now().Add(1500 * time.Millisecond)}
+	line := headless.Line{Text: "🛠️  Using tool: calc", Tool: true, Time: time.Now()}
+
+	var md bytes.Buffer
+	w := newAskWriter("markdown", &md)
+	w.begin("what is\n2+2")
+	w.line(line)
+	w.end(res, nil, exitOK)
+	if want := "> what is\n> 2+2\n\n_🛠️  Using tool: calc_\n"; md.String() != want {
+		t.Fatalf("markdown:\n%q\nwant\n%q", md.String(), want)
+	}
+
+	var nd bytes.Buffer
+	w = newAskWriter("ndjson", &nd)
+	w.begin("q")
+	w.line(line)
+	w.end(res, headless.ErrTimeout, exitTimeout)
+	lines := strings.Split(strings.TrimSpace(nd.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("ndjson should emit one event per line: %q", nd.String())
+	}
+	var first, last askEvent
+	if err := errors.Join(json.Unmarshal([]byte(lines[0]), &first), json.Unmarshal([]byte(lines[1]), &last)); err != nil {
+		t.Fatal(err)
+	}
+	if first.Type != "line" || !first.Tool || last.Type != "result" || last.Status != "timeout" || *last.ExitCode != exitTimeout || last.DurationMs != 1500 {
+		t.Fatalf("events: %+v %+v", first, last)
+	}
+}
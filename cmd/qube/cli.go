@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"qube/internal/config"
+	"qube/internal/logging"
+	"qube/internal/qcli"
+	"qube/internal/qcompat"
+	"qube/internal/telemetry"
+)
+
+// 終了コード
+const (
+	exitOK    = 0
+	exitError = 1 // 実行時のエラー
+	exitUsage = 2 // 引数の誤り
+	// exitTimeout は応答を待ちきれなかった場合（timeout(1) に合わせる）
+	exitTimeout = 124
+)
+
+// options はコマンドラインフラグの値
+// 設定ファイル・環境変数より優先する（指定されたフラグだけを set に記録する）
+type options struct {
+	qBin       string
+	profile    string
+	noChat     bool
+	chatArgs   string
+	configFile string
+	logFile    string
+	logLevel   string
+	tracePTY   traceFlag
+	theme      string
+	version    bool
+
+	set map[string]bool // 明示的に指定されたフラグ名
+}
+
+// command は TUI を使わずに実行するサブコマンド
+type command struct {
+	name    string
+	usage   string // 引数の書式（例: "[--json]"）
+	summary string
+	run     func(env *cliEnv, args []string) int
+}
+
+// cliEnv はサブコマンドに渡す実行環境
+type cliEnv struct {
+	opts options
+	// base はプロファイルとフラグを適用する前の設定（/qube profile の切り替えに使う）
+	base   config.Config
+	cfg    config.Config
+	cfgErr error
+	// telemetry は利用状況の記録先（無効なら nil）
+	telemetry *telemetry.Recorder
+	stdin     io.Reader
+	stdout    io.Writer
+	stderr    io.Writer
+}
+
+// commands はサブコマンドの一覧（help の表示順）
+func commands() []command {
+	return []command{
+		{name: "ask", usage: "[--format=text|markdown|ndjson] [--timeout=5m] [prompt...]", summary: "Send one prompt to q chat and print the answer (prompt from stdin if omitted)", run: runAsk},
+		{name: "doctor", usage: "[--json] [--no-chat] [--timeout=15s]", summary: "Check the Q CLI, terminal, config and file permissions, and time a q chat startup", run: runDoctor},
+		{name: "version", summary: "Print the Qube and Q CLI versions", run: runVersion},
+		{name: "config", usage: "[--sources]", summary: "Print the effective configuration and report errors", run: runConfig},
+		{name: "telemetry", usage: "show|export [-o file]|purge", summary: "Show, export or delete the locally recorded usage telemetry", run: runTelemetry},
+		{name: "help", summary: "Show this help", run: runHelp},
+	}
+}
+
+// newFlagSet はグローバルフラグを定義した FlagSet を返す
+func newFlagSet(opts *options, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("qube", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&opts.qBin, "q-bin", "", "Q CLI binary to use (overrides qBin / Q_BIN)")
+	fs.StringVar(&opts.profile, "profile", "", "named profile from the config to use (overrides profile / QUBE_PROFILE; empty for none)")
+	fs.BoolVar(&opts.noChat, "no-chat", false, "start in command mode without launching q chat")
+	fs.StringVar(&opts.chatArgs, "chat-args", "", "extra arguments for q chat, split like a shell: quote or escape spaces (overrides defaultFlags)")
+	fs.StringVar(&opts.configFile, "config", "", "read this config file instead of ./.qube.json and ~/.qube.json")
+	fs.StringVar(&opts.logFile, "log-file", "", "append structured debug logs to this file (or QUBE_LOG_FILE)")
+	fs.StringVar(&opts.logLevel, "log-level", "", "log level: debug, info, warn or error (or QUBE_LOG_LEVEL; default info)")
+	fs.Var(&opts.tracePTY, "trace-pty", "log raw PTY chunks as escaped strings, or --trace-pty=hex (or QUBE_TRACE_PTY); never shown on the terminal")
+	fs.StringVar(&opts.theme, "theme", "", "color theme: auto, dark, light, high-contrast or a custom theme")
+	fs.BoolVar(&opts.version, "version", false, "print the version and exit")
+	fs.Usage = func() { printUsage(fs, stderr) }
+	return fs
+}
+
+// run はコマンドライン引数を解釈して Qube を実行し、終了コードを返す
+func run(args []string, stdout, stderr io.Writer) int {
+	var opts options
+	fs := newFlagSet(&opts, stderr)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	opts.set = map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { opts.set[f.Name] = true })
+
+	if opts.version {
+		fmt.Fprintf(stdout, "qube %s\n", version)
+		return exitOK
+	}
+
+	closeLog, err := setupLogging(opts, len(fs.Args()) == 0, stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "qube: %v\n", err)
+		return exitUsage
+	}
+	defer closeLog()
+
+	// 設定（フラグ → プロファイル → 環境変数 → ./.qube.json → ~/.qube.json → 既定値）
+	base, cfgErr := config.Load(opts.configFile)
+	cfg, err := profileConfig(base, startupProfile(base, opts), opts)
+	if err != nil {
+		if opts.set["profile"] {
+			err = fmt.Errorf("--profile: %w", err)
+		}
+		fmt.Fprintf(stderr, "qube: %v\n", err)
+		return exitUsage
+	}
+	useQCLI(cfg, opts)
+
+	env := &cliEnv{opts: opts, base: base, cfg: cfg, cfgErr: cfgErr, stdin: os.Stdin, stdout: stdout, stderr: stderr}
+	rest := fs.Args()
+	if len(rest) == 0 {
+		env.telemetry = openTelemetry(cfg.Telemetry, stderr)
+		defer env.telemetry.Close()
+		// 誤りのある設定は既定値で補い、TUI の起動後にヘッダーへ表示する
+		return recordRun(env.telemetry, "tui", func() int {
+			if err := runTUI(env); err != nil {
+				fmt.Fprintf(stderr, "qube: %v\n", err)
+				return exitError
+			}
+			return exitOK
+		})
+	}
+	for _, c := range commands() {
+		if c.name != rest[0] {
+			continue
+		}
+		// telemetry サブコマンドは記録そのものを扱うため記録しない
+		if c.name != "telemetry" {
+			env.telemetry = openTelemetry(cfg.Telemetry, stderr)
+			defer env.telemetry.Close()
+		}
+		return recordRun(env.telemetry, c.name, func() int { return c.run(env, rest[1:]) })
+	}
+	fmt.Fprintf(stderr, "qube: unknown command %q (see 'qube help')\n", rest[0])
+	return exitUsage
+}
+
+// recordRun は起動と終了（所要時間・終了コード）を記録しながら f を実行する
+func recordRun(rec *telemetry.Recorder, mode string, f func() int) int {
+	started := time.Now()
+	rec.Record(telemetry.Event{Name: "app.started", Mode: mode})
+	code := f()
+	rec.Record(telemetry.Event{Name: "app.exited", Mode: mode, ExitCode: telemetry.Exit(code), DurationMs: time.Since(started).Milliseconds()})
+	return code
+}
+
+// traceFlag は --trace-pty（値なしで escaped）/ --trace-pty=hex を受け付けるフラグ
+type traceFlag struct {
+	format logging.TraceFormat
+	set    bool
+}
+
+func (t *traceFlag) String() string   { return string(t.format) }
+func (t *traceFlag) IsBoolFlag() bool { return true }
+func (t *traceFlag) Set(s string) error {
+	f, err := logging.ParseTraceFormat(s)
+	t.format, t.set = f, true
+	return err
+}
+
+// setupLogging はフラグと環境変数（QUBE_LOG_FILE / QUBE_LOG_LEVEL / QUBE_TRACE_PTY）に従ってログの出力先を設定する
+// ログファイルが無い場合、TUI では端末を乱さないよう何も書き出さず、サブコマンドでは警告以上を stderr に出す
+func setupLogging(opts options, tui bool, stderr io.Writer) (func() error, error) {
+	lo := logging.Options{File: firstNonEmpty(opts.logFile, os.Getenv("QUBE_LOG_FILE")), Level: slog.LevelInfo, Fallback: stderr}
+	if tui {
+		lo.Fallback = nil
+	}
+	if v := firstNonEmpty(opts.logLevel, os.Getenv("QUBE_LOG_LEVEL")); v != "" {
+		l, err := logging.ParseLevel(v)
+		if err != nil {
+			return nil, err
+		}
+		lo.Level = l
+	}
+	lo.Trace = opts.tracePTY.format
+	if !opts.tracePTY.set {
+		f, err := logging.ParseTraceFormat(os.Getenv("QUBE_TRACE_PTY"))
+		if err != nil {
+			return nil, fmt.Errorf("QUBE_TRACE_PTY: %w", err)
+		}
+		lo.Trace = f
+	}
+
+	path, closeFn, err := logging.Setup(lo)
+	if err != nil {
+		return nil, err
+	}
+	if lo.Trace != logging.TraceOff && lo.File == "" {
+		fmt.Fprintf(stderr, "qube: writing PTY trace to %s\n", path)
+	}
+	slog.Debug("qube starting", "version", version, "tui", tui, "trace", lo.Trace)
+	return closeFn, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// qBinVia は qBin の指定元（フラグ → プロファイル → 環境変数 → 設定ファイル）を返す
+func qBinVia(cfg config.Config, opts options, getenv func(string) string) string {
+	p, _ := cfg.ActiveProfile()
+	switch {
+	case opts.set["q-bin"]:
+		return "--q-bin"
+	case p.QBin != "":
+		return "profile " + cfg.Profile
+	case getenv("QUBE_Q_BIN") != "":
+		return "QUBE_Q_BIN"
+	case getenv("Q_BIN") != "":
+		return "Q_BIN"
+	}
+	return "qBin"
+}
+
+// applyFlags は明示的に指定されたフラグで設定を上書きする
+func applyFlags(cfg *config.Config, opts options) error {
+	if opts.set["q-bin"] {
+		cfg.QBin = opts.qBin
+	}
+	if opts.set["no-chat"] {
+		cfg.AutoStartChat = !opts.noChat
+	}
+	if opts.set["chat-args"] {
+		args, err := splitArgs(opts.chatArgs)
+		if err != nil {
+			return fmt.Errorf("--chat-args: %w", err)
+		}
+		cfg.DefaultFlags = args
+	}
+	if opts.set["theme"] {
+		names := cfg.ThemeNames()
+		if !contains(names, opts.theme) {
+			return fmt.Errorf("--theme: unknown theme %q (want one of: %s)", opts.theme, strings.Join(names, ", "))
+		}
+		cfg.Theme = opts.theme
+	}
+	return nil
+}
+
+// splitArgs は s をシェルと同じ規則で引数に分ける
+// 空白で区切り、'...' はそのまま、"..." とクォート外の \ はエスケープとして扱う（変数展開などはしない）
+func splitArgs(s string) ([]string, error) {
+	var (
+		args  []string
+		cur   strings.Builder
+		inArg bool
+		quote rune
+	)
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\\' && (quote == 0 || i+1 < len(runes) && strings.ContainsRune(`"\$`+"`", runes[i+1])):
+			if i+1 == len(runes) {
+				return nil, errors.New("trailing backslash")
+			}
+			i++
+			cur.WriteRune(runes[i])
+			inArg = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// printUsage は qube の使い方を表示する
+func printUsage(fs *flag.FlagSet, w io.Writer) {
+	fmt.Fprintf(w, "Usage: qube [flags] [command]\n\n")
+	fmt.Fprintf(w, "Without a command, qube starts the full-screen UI and launches q chat.\n\n")
+	fmt.Fprintf(w, "Commands:\n")
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %-28s %s\n", strings.TrimSpace(c.name+" "+c.usage), c.summary)
+	}
+	fmt.Fprintf(w, "\nFlags:\n")
+	fs.PrintDefaults()
+}
+
+func runHelp(env *cliEnv, _ []string) int {
+	var opts options
+	printUsage(newFlagSet(&opts, env.stdout), env.stdout)
+	return exitOK
+}
+
+// runVersion は Qube と Q CLI のバージョンを表示する
+func runVersion(env *cliEnv, _ []string) int {
+	fmt.Fprintf(env.stdout, "qube %s\n", version)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	v, err := qcli.Version(ctx)
+	if err != nil {
+		fmt.Fprintf(env.stdout, "q    unavailable (%v)\n", err)
+		return exitOK
+	}
+	path, _ := qcli.Path()
+	fmt.Fprintf(env.stdout, "q    %s (%s)\n", v, path)
+	res, _ := qcompat.Resolve(v, env.cfg.ParserOverrides)
+	fmt.Fprintf(env.stdout, "     parser profile %s\n", res.Profile)
+	if w := res.Warning(); w != "" {
+		fmt.Fprintf(env.stdout, "     warning: %s\n", w)
+	}
+	return exitOK
+}
+
+// resolveCompat は Q CLI のバージョンを調べ、出力の解釈に使うパターンを選ぶ
+// バージョンが分からない場合も最新のプロファイルで続行する。返すエラーはバージョン取得の失敗
+func resolveCompat(ctx context.Context, overrides []qcompat.Override) (qcompat.Resolution, error) {
+	v, verr := qcli.Version(ctx)
+	res, err := qcompat.Resolve(v, overrides)
+	if err != nil {
+		// 設定の読み込み時に検査済みのため、ここには来ないはず
+		slog.Warn("invalid parserOverrides ignored", "err", err)
+	}
+	slog.Info("Q CLI compatibility", "version", v, "profile", res.Profile, "outOfRange", res.OutOfRange, "overrides", res.Overrides, "err", verr)
+	return res, verr
+}
+
+// redactedValue は qube config で表示しない値の代わりに出す文字列
+const redactedValue = "********"
+
+// redactConfig は表示用に、プロファイルの環境変数の値（認証情報を含みうる）を伏せた設定を返す
+func redactConfig(cfg config.Config) config.Config {
+	if len(cfg.Profiles) == 0 {
+		return cfg
+	}
+	profiles := make(map[string]config.Profile, len(cfg.Profiles))
+	for name, p := range cfg.Profiles {
+		if len(p.Env) > 0 {
+			env := make(map[string]string, len(p.Env))
+			for k := range p.Env {
+				env[k] = redactedValue
+			}
+			p.Env = env
+		}
+		profiles[name] = p
+	}
+	cfg.Profiles = profiles
+	return cfg
+}
+
+// compatWarning は表示すべき互換性の警告を返す
+// Q CLI が見つからない場合は起動時のエラーで分かるため警告しない
+func compatWarning(res qcompat.Resolution) string {
+	if _, err := qcli.Path(); err != nil {
+		return ""
+	}
+	return res.Warning()
+}
+
+// runConfig は実効設定を JSON で表示する。設定に誤りがあれば stderr に表示して 1 を返す
+func runConfig(env *cliEnv, args []string) int {
+	fs := flag.NewFlagSet("qube config", flag.ContinueOnError)
+	fs.SetOutput(env.stderr)
+	sources := fs.Bool("sources", false, "only list the config files that were read")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	if *sources {
+		for _, s := range env.cfg.Sources {
+			fmt.Fprintln(env.stdout, s)
+		}
+	} else {
+		b, err := json.MarshalIndent(redactConfig(env.cfg), "", "  ")
+		if err != nil {
+			fmt.Fprintf(env.stderr, "qube: %v\n", err)
+			return exitError
+		}
+		fmt.Fprintln(env.stdout, string(b))
+	}
+	if env.cfgErr != nil {
+		fmt.Fprintln(env.stderr, env.cfgErr)
+		return exitError
+	}
+	return exitOK
+}
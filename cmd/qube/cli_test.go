@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"qube/internal/config"
+	"qube/internal/conversation"
+	"qube/internal/doctor"
+	"qube/internal/transcript"
+)
+
+func TestRun_VersionAndUnknownCommand(t *testing.T) {
+	var out, errOut bytes.Buffer
+	if code := run([]string{"--version"}, &out, &errOut); code != exitOK || out.String() != "qube "+version+"\n" {
+		t.Fatalf("--version: code=%d out=%q", code, out.String())
+	}
+	out.Reset()
+	if code := run([]string{"frobnicate"}, &out, &errOut); code != exitUsage || !strings.Contains(errOut.String(), `unknown command "frobnicate"`) {
+		t.Fatalf("unknown command: code=%d stderr=%q", code, errOut.String())
+	}
+	if code := run([]string{"--no-such-flag"}, &out, &errOut); code != exitUsage {
+		t.Fatalf("unknown flag: code=%d", code)
+	}
+}
+
+func TestRun_FlagsOverrideConfig(t *testing.T) {
+	t.Setenv("QUBE_THEME", "")
+	t.Setenv("QUBE_Q_BIN", "")
+	t.Setenv("Q_BIN", "")
+	path := filepath.Join(t.TempDir(), "qube.json")
+	if err := os.WriteFile(path, []byte(`{"theme": "light", "defaultFlags": ["--a"], "autoStartChat": true}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out, errOut bytes.Buffer
+	code := run([]string{"--config", path, "--theme", "high-contrast", "--chat-args", `--model x --agent "my agent"`, "--no-chat", "--q-bin", "sh", "config"}, &out, &errOut)
+	if code != exitOK {
+		t.Fatalf("code=%d stderr=%s", code, errOut.String())
+	}
+	var cfg config.Config
+	if err := json.Unmarshal(out.Bytes(), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Theme != "high-contrast" || strings.Join(cfg.DefaultFlags, "|") != "--model|x|--agent|my agent" || cfg.AutoStartChat || cfg.QBin != "sh" {
+		t.Fatalf("flags should override the config file: %+v", cfg)
+	}
+
+	errOut.Reset()
+	if code := run([]string{"--chat-args", `--agent "mine`, "config"}, &out, &errOut); code != exitUsage || !strings.Contains(errOut.String(), "--chat-args: unterminated \" quote") {
+		t.Fatalf("invalid --chat-args: code=%d stderr=%q", code, errOut.String())
+	}
+	errOut.Reset()
+	if code := run([]string{"--theme", "neon", "config"}, &out, &errOut); code != exitUsage || !strings.Contains(errOut.String(), `unknown theme "neon"`) {
+		t.Fatalf("invalid --theme: code=%d stderr=%q", code, errOut.String())
+	}
+}
+
+func TestRun_ConfigReportsErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qube.json")
+	if err := os.WriteFile(path, []byte(`{"history": {"max": 0}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var out, errOut bytes.Buffer
+	if code := run([]string{"--config", path, "config"}, &out, &errOut); code != exitError {
+		t.Fatalf("code=%d", code)
+	}
+	if !strings.Contains(errOut.String(), path+": history.max: must be greater than 0") {
+		t.Fatalf("stderr=%q", errOut.String())
+	}
+}
+
+func TestRun_Telemetry(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "telemetry.jsonl")
+	t.Setenv("QUBE_TELEMETRY_FILE", path)
+
+	// 無効（既定）なら何も記録しない
+	var out, errOut bytes.Buffer
+	run([]string{"--config", filepath.Join(dir, "none.json"), "config"}, &out, &errOut)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("telemetry should be off by default: %v", err)
+	}
+
+	t.Setenv("QUBE_TELEMETRY", "true")
+	cfgPath := filepath.Join(dir, "qube.json")
+	if err := os.WriteFile(cfgPath, []byte(`{}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code := run([]string{"--config", cfgPath, "config"}, &out, &errOut); code != exitOK {
+		t.Fatalf("code=%d stderr=%s", code, errOut.String())
+	}
+
+	out.Reset()
+	if code := run([]string{"--config", cfgPath, "telemetry", "show"}, &out, &errOut); code != exitOK {
+		t.Fatalf("show: code=%d", code)
+	}
+	for _, want := range []string{"Telemetry: enabled", path, "Events:    2", "app.started", "app.exited"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("show output missing %q:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	run([]string{"--config", cfgPath, "telemetry", "export"}, &out, &errOut)
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 2 || !strings.Contains(lines[1], `"exit_code":0`) {
+		t.Fatalf("export: %q", out.String())
+	}
+
+	out.Reset()
+	if code := run([]string{"--config", cfgPath, "telemetry", "purge"}, &out, &errOut); code != exitOK || !strings.Contains(out.String(), "Removed 1") {
+		t.Fatalf("purge: code=%d out=%q", code, out.String())
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("purge should delete the file")
+	}
+}
+
+func TestRun_Doctor(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("QUBE_LOG_FILE", "")
+	var out, errOut bytes.Buffer
+	code := run([]string{"--config", filepath.Join(dir, "none.json"), "--q-bin", filepath.Join(dir, "no-such-q"), "doctor", "--json", "--no-chat"}, &out, &errOut)
+	if code != exitError {
+		t.Fatalf("missing Q CLI and config should fail: code=%d stderr=%s", code, errOut.String())
+	}
+	var got doctorOutput
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, out.String())
+	}
+	status := map[string]doctor.Status{}
+	for _, c := range got.Checks {
+		status[c.Name] = c.Status
+	}
+	want := map[string]doctor.Status{"Q CLI": doctor.Fail, "Compatibility": doctor.Skip, "Config": doctor.Fail, "History": doctor.Pass, "Log file": doctor.Skip, "q chat startup": doctor.Skip}
+	for name, s := range want {
+		if status[name] != s {
+			t.Errorf("%s: status %q, want %q", name, status[name], s)
+		}
+	}
+	if got.Summary["fail"] != 2 {
+		t.Errorf("summary: %v", got.Summary)
+	}
+
+	out.Reset()
+	run([]string{"--config", filepath.Join(dir, "none.json"), "--q-bin", filepath.Join(dir, "no-such-q"), "doctor", "--no-chat"}, &out, &errOut)
+	for _, want := range []string{"[FAIL] Q CLI", "-> fix --q-bin", "[SKIP] q chat startup", "2 failed"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("text output missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestQBinVia(t *testing.T) {
+	env := func(vars map[string]string) func(string) string { return func(k string) string { return vars[k] } }
+	var cfg config.Config
+	if got := qBinVia(cfg, options{set: map[string]bool{"q-bin": true}}, env(map[string]string{"Q_BIN": "x"})); got != "--q-bin" {
+		t.Errorf("flag: %q", got)
+	}
+	if got := qBinVia(cfg, options{}, env(map[string]string{"QUBE_Q_BIN": "x", "Q_BIN": "y"})); got != "QUBE_Q_BIN" {
+		t.Errorf("env: %q", got)
+	}
+	if got := qBinVia(cfg, options{}, env(nil)); got != "qBin" {
+		t.Errorf("config: %q", got)
+	}
+	cfg = config.Config{Profile: "prod", Profiles: map[string]config.Profile{"prod": {QBin: "/opt/q"}}}
+	if got := qBinVia(cfg, options{}, env(map[string]string{"Q_BIN": "y"})); got != "profile prod" {
+		t.Errorf("profile: %q", got)
+	}
+}
+
+func TestRun_Profile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "qube.json")
+	content := `{"qBin": "/opt/q/bin/q", "profile": "dev", "profiles": {
+		"dev": {"awsProfile": "dev-admin"},
+		"prod": {"awsProfile": "prod-ro", "qBin": "/opt/q-prod/bin/q", "defaultFlags": ["--model", "b"], "env": {"API_TOKEN": "s3cret"}}
+	}}`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	effective := func(args ...string) map[string]any {
+		t.Helper()
+		var out, errOut bytes.Buffer
+		if code := run(append([]string{"--config", path}, append(args, "config")...), &out, &errOut); code != exitOK {
+			t.Fatalf("exit %d: %s", code, errOut.String())
+		}
+		var got map[string]any
+		if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	if got := effective(); got["profile"] != "dev" || got["qBin"] != "/opt/q/bin/q" {
+		t.Errorf("config profile: %v %v", got["profile"], got["qBin"])
+	}
+	// --profile はプロファイルを切り替え、他のフラグはプロファイルより優先する
+	if got := effective("--profile", "prod"); got["profile"] != "prod" || got["qBin"] != "/opt/q-prod/bin/q" {
+		t.Errorf("--profile: %v %v", got["profile"], got["qBin"])
+	}
+	if got := effective("--profile", "prod", "--chat-args", "--trust-all-tools"); fmt.Sprint(got["defaultFlags"]) != "[--trust-all-tools]" {
+		t.Errorf("--chat-args over profile: %v", got["defaultFlags"])
+	}
+	if got := effective("--profile="); got["profile"] != nil || got["qBin"] != "/opt/q/bin/q" {
+		t.Errorf("--profile= should disable the profile: %v", got["profile"])
+	}
+
+	// 環境変数の値は認証情報を含みうるため qube config では伏せる
+	prod := effective()["profiles"].(map[string]any)["prod"].(map[string]any)
+	if env := fmt.Sprint(prod["env"]); env != "map[API_TOKEN:"+redactedValue+"]" {
+		t.Errorf("profile env should be redacted: %s", env)
+	}
+
+	var out, errOut bytes.Buffer
+	if code := run([]string{"--config", path, "--profile", "staging", "config"}, &out, &errOut); code != exitUsage || !strings.Contains(errOut.String(), `--profile: unknown profile "staging" (want one of: dev, prod)`) {
+		t.Errorf("unknown profile: exit %d, %s", code, errOut.String())
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	for in, want := range map[string][]string{
+		"":                                  nil,
+		"  --model  x ":                     {"--model", "x"},
+		`--agent "my agent" --x='a b'`:      {"--agent", "my agent", "--x=a b"},
+		`a\ b "c\"d" 'e\f' ""`:              {"a b", `c"d`, `e\f`, ""},
+		"--trust-tools=fs_read,fs_write -a": {"--trust-tools=fs_read,fs_write", "-a"},
+	} {
+		got, err := splitArgs(in)
+		if err != nil || fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+			t.Errorf("splitArgs(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{`"open`, `'open`, `trailing\`} {
+		if _, err := splitArgs(bad); err == nil {
+			t.Errorf("splitArgs(%q) should fail", bad)
+		}
+	}
+}
+
+func TestDescribeProfiles(t *testing.T) {
+	cfg := config.Config{Profile: "prod", Profiles: map[string]config.Profile{
+		"dev":  {AWSProfile: "dev-admin", Region: "us-west-2"},
+		"prod": {AWSProfile: "prod-ro", DefaultFlags: []string{"--model", "b"}},
+	}}
+	got := strings.Join(describeProfiles(cfg), "\n")
+	for _, want := range []string{"  dev   dev-admin, us-west-2", "* prod  prod-ro; flags --model b"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	if got := describeProfiles(config.Config{}); !strings.Contains(got[0], "no profiles defined") {
+		t.Errorf("no profiles: %v", got)
+	}
+}
+
+func TestExporter(t *testing.T) {
+	home := t.TempDir()
+	e := &exporter{cfg: config.Export{Dir: filepath.Join(home, "logs"), Format: "json"}, home: home, cwd: "/work"}
+	tr := transcript.Transcript{Version: transcript.FormatVersion, Turns: []transcript.Turn{{Prompt: "ls", Mode: transcript.ModeCommand}}}
+	// パスを省略すると export.dir を作って既定の形式で書き出す
+	path, err := e.Export(tr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(path) != filepath.Join(home, "logs") || filepath.Ext(path) != ".json" {
+		t.Fatalf("path: %s", path)
+	}
+	b, _ := os.ReadFile(path)
+	if !strings.Contains(string(b), `"cwd": "/work"`) {
+		t.Fatalf("cwd should be recorded: %s", b)
+	}
+
+	// ~ はホームディレクトリに置き換える
+	if path, err = e.Export(tr, "~/notes.md"); err != nil || path != filepath.Join(home, "notes.md") {
+		t.Fatalf("path=%s err=%v", path, err)
+	}
+}
+
+func TestResumeChat(t *testing.T) {
+	t0 := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	records := []conversation.Record{
+		{ID: "cmd", Updated: t0.Add(2 * time.Hour), Title: "ls", Prompts: 1},
+		{ID: "new", Updated: t0.Add(time.Hour), Title: "latest", Prompts: 3, Chat: true, Transcript: transcript.Transcript{Profile: "prod"}},
+		{ID: "old", Updated: t0, Title: "older", Prompts: 1, Chat: true},
+	}
+	cfg := config.Default().Conversations
+
+	// Q の --resume で文脈を戻せるのは chat を使った最新の会話だけ
+	if args, note := resumeChat(records[1], records, cfg); strings.Join(args, " ") != "--resume" || !strings.Contains(note, "most recent conversation in this directory (it may differ") {
+		t.Fatalf("latest chat: %v %q", args, note)
+	}
+	if args, note := resumeChat(records[2], records, cfg); args != nil || !strings.Contains(note, "without the earlier context") {
+		t.Fatalf("older chat: %v %q", args, note)
+	}
+	if args, _ := resumeChat(records[0], records, cfg); args != nil {
+		t.Fatalf("command-only conversation should not resume Q: %v", args)
+	}
+
+	if r, ok := findRecord(records, "old"); !ok || r.Title != "older" {
+		t.Fatalf("findRecord: %+v", r)
+	}
+	if _, ok := findRecord(records, ""); ok {
+		t.Fatal("empty id means a new conversation")
+	}
+	if opts := resumeOptions(records); len(opts) != 3 || opts[1].Detail != "profile prod" || opts[0].Detail != "" {
+		t.Fatalf("options: %+v", opts)
+	}
+}
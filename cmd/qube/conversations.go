@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"qube/internal/config"
+	"qube/internal/conversation"
+	"qube/internal/ui"
+)
+
+// setupConversations は作業ディレクトリの会話の保存先を UI に設定し、保存済みの会話（新しい順）を返す
+// 保存が無効、または保存先が決まらない場合は nil を返す（起動は続ける）
+func setupConversations(m *ui.Model, cfg config.Conversations, now time.Time) (*conversation.Recorder, []conversation.Record) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	dir := cfg.Dir
+	if dir == "" {
+		d, err := conversation.DefaultDir()
+		if err != nil {
+			slog.Warn("conversations disabled", "err", err)
+			return nil, nil
+		}
+		dir = d
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		slog.Warn("conversations disabled", "err", err)
+		return nil, nil
+	}
+	store := conversation.Open(dir, cfg.Max)
+	records, err := store.List(cwd)
+	if err != nil {
+		slog.Warn("failed to list conversations", "dir", dir, "err", err)
+	}
+	rec := conversation.NewRecorder(store, cwd, conversation.NewID(now), now)
+	m.SetConversationRecorder(rec)
+	return rec, records
+}
+
+// resumeOptions はピッカーに表示する会話の候補を返す
+func resumeOptions(records []conversation.Record) []ui.ResumeOption {
+	options := make([]ui.ResumeOption, 0, len(records))
+	for _, r := range records {
+		o := ui.ResumeOption{ID: r.ID, Title: r.Title, Updated: r.Updated, Prompts: r.Prompts}
+		if r.Transcript.Profile != "" {
+			o.Detail = "profile " + r.Transcript.Profile
+		}
+		options = append(options, o)
+	}
+	return options
+}
+
+// findRecord は records から id の会話を探す
+func findRecord(records []conversation.Record, id string) (conversation.Record, bool) {
+	for _, r := range records {
+		if id != "" && r.ID == id {
+			return r, true
+		}
+	}
+	return conversation.Record{}, false
+}
+
+// resumeChat は会話 r を再開するときに q chat へ追加で渡す引数と、スクロールバックに表示するメッセージを返す
+// Q の --resume はディレクトリごとの最新の会話しか戻せないため、Q 側の文脈を戻すのは q chat を使った最新の会話だけ
+// Q の会話の識別子は取得できず、Qube の外で q chat を使った場合は別の会話が戻るため、メッセージでは断定しない
+func resumeChat(r conversation.Record, records []conversation.Record, cfg config.Conversations) (args []string, note string) {
+	note = "Resumed conversation from " + r.Updated.Local().Format("2006-01-02 15:04")
+	if !r.Chat {
+		return nil, note
+	}
+	if latest, ok := conversation.LatestChat(records); ok && latest.ID == r.ID && len(cfg.ResumeArgs) > 0 {
+		return cfg.ResumeArgs, note + "; started q chat with " + strings.Join(cfg.ResumeArgs, " ") +
+			", which restores Q's most recent conversation in this directory (it may differ if q chat was used outside Qube)"
+	}
+	return nil, note + "; Q only resumes the most recent chat in this directory, so this chat starts without the earlier context"
+}
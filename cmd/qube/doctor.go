@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/creack/pty"
+	"qube/internal/doctor"
+	"qube/internal/history"
+	"qube/internal/qcli"
+	"qube/internal/qcompat"
+	"qube/internal/session"
+)
+
+// doctorChatGrace は初期化タイムアウトの後、試験起動の結果を待つ追加の時間
+const doctorChatGrace = 5 * time.Second
+
+// doctorOutput は qube doctor --json の出力
+type doctorOutput struct {
+	Version string         `json:"version"`
+	Checks  []doctor.Check `json:"checks"`
+	Summary map[string]int `json:"summary"`
+}
+
+// runDoctor は実行環境を診断し、pass / warn / fail のチェックリストを表示する
+// fail が 1 つでもあれば 1 を返す
+func runDoctor(env *cliEnv, args []string) int {
+	fs := flag.NewFlagSet("qube doctor", flag.ContinueOnError)
+	fs.SetOutput(env.stderr)
+	asJSON := fs.Bool("json", false, "print the results as JSON")
+	noChat := fs.Bool("no-chat", false, "skip the q chat startup test")
+	timeout := fs.Duration("timeout", 0, "how long to wait for q chat to start (default: the init timeout plus 5s)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*qcli.DefaultProbeTimeout)
+	found := qcli.Default().Discover(ctx)
+	cancel()
+
+	var report doctor.Report
+	add := func(c doctor.Check) { report.Checks = append(report.Checks, c) }
+	add(doctor.QCLI(found))
+	var compat qcompat.Resolution
+	if found.Err == nil {
+		compat, _ = qcompat.Resolve(found.Selected.Version, env.cfg.ParserOverrides)
+		add(doctor.Compat(compat))
+	} else {
+		add(doctor.Check{Name: "Compatibility", Status: doctor.Skip, Summary: "skipped: no usable Q CLI"})
+	}
+	add(doctor.PTY(openPTY()))
+	add(doctor.TerminalCheck(currentTerminal()))
+	cfgCheck := doctor.Config(env.cfg.Sources, env.cfgErr)
+	if name, detail := profileLabel(env.cfg); name != "" {
+		cfgCheck.Details = append(cfgCheck.Details, strings.TrimSpace("profile "+name+" "+parenthesize(detail)))
+	}
+	add(cfgCheck)
+	add(historyCheck(env))
+	add(doctor.File("Log file", firstNonEmpty(env.opts.logFile, os.Getenv("QUBE_LOG_FILE")), true))
+	if env.cfg.Telemetry.Enabled {
+		path, _ := env.cfg.Telemetry.Path()
+		add(doctor.File("Telemetry", path, false))
+	}
+
+	switch {
+	case *noChat:
+		add(doctor.Check{Name: "q chat startup", Status: doctor.Skip, Summary: "skipped (--no-chat)"})
+	case found.Err != nil:
+		add(doctor.Check{Name: "q chat startup", Status: doctor.Skip, Summary: "skipped: no usable Q CLI"})
+	default:
+		if !*asJSON {
+			fmt.Fprintln(env.stderr, "qube doctor: starting q chat to time its startup...")
+		}
+		add(chatCheck(env, compat.Patterns, *timeout))
+	}
+
+	if *asJSON {
+		out := doctorOutput{Version: version, Checks: report.Checks, Summary: map[string]int{}}
+		for _, s := range []doctor.Status{doctor.Pass, doctor.Warn, doctor.Fail, doctor.Skip} {
+			out.Summary[string(s)] = report.Count(s)
+		}
+		enc := json.NewEncoder(env.stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			fmt.Fprintf(env.stderr, "qube doctor: %v\n", err)
+			return exitError
+		}
+	} else {
+		printDoctor(env.stdout, report)
+	}
+	if report.Failed() {
+		return exitError
+	}
+	return exitOK
+}
+
+// openPTY は疑似端末を確保してすぐに閉じる
+func openPTY() error {
+	p, t, err := pty.Open()
+	if err != nil {
+		return err
+	}
+	t.Close()
+	return p.Close()
+}
+
+// currentTerminal は Qube を実行している端末の情報を集める
+func currentTerminal() doctor.Terminal {
+	return doctor.Terminal{
+		Term:      os.Getenv("TERM"),
+		ColorTerm: os.Getenv("COLORTERM"),
+		NoColor:   os.Getenv("NO_COLOR") != "",
+		Profile:   lipgloss.ColorProfile(),
+		StdinTTY:  isTerminal(os.Stdin),
+		StdoutTTY: isTerminal(os.Stdout),
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// historyCheck は永続履歴のファイルを診断する（履歴にはプロンプトが残るため権限も確認する）
+func historyCheck(env *cliEnv) doctor.Check {
+	path := env.cfg.History.File
+	if path == "" {
+		p, err := history.DefaultPath()
+		if err != nil {
+			return doctor.Check{Name: "History", Status: doctor.Fail, Summary: err.Error(), Hint: "set history.file in .qube.json"}
+		}
+		path = p
+	}
+	return doctor.File("History", path, true)
+}
+
+// chatCheck は q chat を試験起動し、初期化の検知までの時間を測る
+func chatCheck(env *cliEnv, patterns qcompat.Patterns, timeout time.Duration) doctor.Check {
+	sess := session.New()
+	sess.SetPatterns(patterns)
+	sess.SetChatArgs(env.cfg.DefaultFlags)
+	sess.SetEnv(env.cfg.Environ())
+	sess.Telemetry = env.telemetry
+	eff := env.cfg.ExecutorTimeouts().Resolve([]string{"q", "chat"})
+	sess.SetTimeouts(eff.Init, 0)
+	initTimeout := eff.Init
+	if initTimeout <= 0 {
+		initTimeout = session.DefaultInitTimeout
+	}
+	if timeout <= 0 {
+		timeout = initTimeout + doctorChatGrace
+	}
+
+	probe := doctor.NewChatProbe(sess)
+	sess.OnInitialized = probe.Initialized
+	sess.OnExit = probe.Exited
+	sess.OnError = probe.Failed
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return doctor.Chat(probe.Run(ctx), initTimeout)
+}
+
+// printDoctor は診断結果をチェックリストとして表示する
+func printDoctor(w io.Writer, r doctor.Report) {
+	fmt.Fprintf(w, "qube %s\n\n", version)
+	width := 0
+	for _, c := range r.Checks {
+		width = max(width, len(c.Name))
+	}
+	indent := strings.Repeat(" ", width+9)
+	for _, c := range r.Checks {
+		fmt.Fprintf(w, "[%s] %-*s  %s\n", strings.ToUpper(string(c.Status)), width, c.Name, c.Summary)
+		for _, d := range c.Details {
+			fmt.Fprintf(w, "%s%s\n", indent, d)
+		}
+		if c.Hint != "" {
+			fmt.Fprintf(w, "%s-> %s\n", indent, c.Hint)
+		}
+	}
+	fmt.Fprintf(w, "\n%d passed, %d warnings, %d failed", r.Count(doctor.Pass), r.Count(doctor.Warn), r.Count(doctor.Fail))
+	if n := r.Count(doctor.Skip); n > 0 {
+		fmt.Fprintf(w, ", %d skipped", n)
+	}
+	fmt.Fprintln(w)
+}
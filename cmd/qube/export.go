@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"qube/internal/config"
+	"qube/internal/transcript"
+)
+
+// exporter は会話の記録をファイルに書き出す（/qube save と終了時の保存）
+type exporter struct {
+	cfg  config.Export
+	home string // パスの先頭の ~ を置き換えるディレクトリ
+	cwd  string // 記録に残す作業ディレクトリ
+}
+
+// newExporter は設定から exporter を作る
+func newExporter(cfg config.Export) *exporter {
+	home, _ := os.UserHomeDir()
+	cwd, _ := os.Getwd()
+	return &exporter{cfg: cfg, home: home, cwd: cwd}
+}
+
+// Export は t を path に書き出す（ui.Exporter）
+// path が空なら export.dir（未設定ならカレントディレクトリ）に既定の名前で作る
+func (e *exporter) Export(t transcript.Transcript, path string) (string, error) {
+	t.Cwd = e.cwd
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		path = filepath.Join(e.home, path[1:])
+	}
+	if path == "" && e.cfg.Dir != "" {
+		if err := os.MkdirAll(e.cfg.Dir, 0o700); err != nil {
+			return "", err
+		}
+		path = e.cfg.Dir
+	}
+	return transcript.Save(path, t, e.cfg.ExportFormat())
+}
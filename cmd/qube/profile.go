@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"qube/internal/config"
+	"qube/internal/qcli"
+)
+
+// profileConfig は base に name のプロファイルとコマンドラインフラグを重ねた設定を返す
+// base はプロファイルを適用する前の設定（起動時に読み込んだもの）。name が空ならプロファイルを使わない
+func profileConfig(base config.Config, name string, opts options) (config.Config, error) {
+	cfg, err := base.WithProfile(name)
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, applyFlags(&cfg, opts)
+}
+
+// startupProfile は起動時に使うプロファイル名を返す（--profile → QUBE_PROFILE → profile）
+func startupProfile(base config.Config, opts options) string {
+	if opts.set["profile"] {
+		return opts.profile
+	}
+	return base.Profile
+}
+
+// useQCLI は cfg の qBin で Q CLI の検出をやり直す
+// 検出結果は execq / session / headless で共有する
+func useQCLI(cfg config.Config, opts options) {
+	qcli.SetDefault(qcli.New(qcli.Options{Explicit: cfg.QBin, ExplicitVia: qBinVia(cfg, opts, os.Getenv)}))
+}
+
+// profileLabel はヘッダーに表示するプロファイル名と補足（AWS プロファイル・リージョン）を返す
+func profileLabel(cfg config.Config) (name, detail string) {
+	p, ok := cfg.ActiveProfile()
+	if !ok {
+		return "", ""
+	}
+	return cfg.Profile, p.Describe()
+}
+
+// describeProfiles は /qube profile で表示するプロファイルの一覧を返す（使用中のものに * を付ける）
+func describeProfiles(cfg config.Config) []string {
+	names := cfg.ProfileNames()
+	if len(names) == 0 {
+		return []string{"profile: no profiles defined; add \"profiles\" to .qube.json (see docs/configuration.md)"}
+	}
+	width := 0
+	for _, n := range names {
+		width = max(width, len(n))
+	}
+	lines := []string{"profiles (* = active, '/qube profile <name>' to switch, '/qube profile -' for none):"}
+	for _, n := range names {
+		mark := " "
+		if n == cfg.Profile {
+			mark = "*"
+		}
+		p := cfg.Profiles[n]
+		var parts []string
+		if d := p.Describe(); d != "" {
+			parts = append(parts, d)
+		}
+		if p.QBin != "" {
+			parts = append(parts, "qBin "+p.QBin)
+		}
+		if len(p.DefaultFlags) > 0 {
+			parts = append(parts, "flags "+strings.Join(p.DefaultFlags, " "))
+		}
+		lines = append(lines, strings.TrimRight(fmt.Sprintf("%s %-*s  %s", mark, width, n, strings.Join(parts, "; ")), " "))
+	}
+	return lines
+}
+
+// parenthesize は空でない s を括弧で囲む
+func parenthesize(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "(" + s + ")"
+}
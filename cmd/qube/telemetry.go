@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"qube/internal/config"
+	"qube/internal/telemetry"
+)
+
+// openTelemetry は設定で有効な場合に記録先を開く。無効なら nil を返す
+// 開けなくても Qube の動作は止めず、記録しないだけにする
+func openTelemetry(cfg config.Telemetry, stderr io.Writer) *telemetry.Recorder {
+	if !cfg.Enabled {
+		return nil
+	}
+	path, err := cfg.Path()
+	if err == nil {
+		var rec *telemetry.Recorder
+		rec, err = telemetry.Open(path, telemetry.Options{MaxBytes: cfg.MaxBytes, MaxFiles: cfg.MaxFiles, Version: version})
+		if err == nil {
+			return rec
+		}
+	}
+	fmt.Fprintf(stderr, "qube: telemetry disabled: %v\n", err)
+	return nil
+}
+
+// runTelemetry は記録済みのテレメトリを表示・書き出し・削除する
+//
+//	qube telemetry show            有効/無効と記録の集計を表示
+//	qube telemetry export [-o f]   記録を JSONL で書き出す（既定は stdout）
+//	qube telemetry purge           記録ファイルを削除する
+func runTelemetry(env *cliEnv, args []string) int {
+	if len(args) == 0 {
+		args = []string{"show"}
+	}
+	path, err := env.cfg.Telemetry.Path()
+	if err != nil {
+		fmt.Fprintf(env.stderr, "qube telemetry: %v\n", err)
+		return exitError
+	}
+	switch args[0] {
+	case "show":
+		return telemetryShow(env, path)
+	case "export":
+		return telemetryExport(env, path, args[1:])
+	case "purge":
+		n, err := telemetry.Purge(path)
+		if err != nil {
+			fmt.Fprintf(env.stderr, "qube telemetry: %v\n", err)
+			return exitError
+		}
+		fmt.Fprintf(env.stdout, "Removed %d telemetry file(s)\n", n)
+		return exitOK
+	}
+	fmt.Fprintf(env.stderr, "qube telemetry: unknown action %q (want show, export or purge)\n", args[0])
+	return exitUsage
+}
+
+// telemetryShow は記録の状態とイベント別の集計を表示する
+func telemetryShow(env *cliEnv, path string) int {
+	state := "disabled (set \"telemetry\": {\"enabled\": true} or QUBE_TELEMETRY=1 to opt in)"
+	if env.cfg.Telemetry.Enabled {
+		state = "enabled"
+	}
+	fmt.Fprintf(env.stdout, "Telemetry: %s\n", state)
+	fmt.Fprintf(env.stdout, "File:      %s (stored locally only, never sent anywhere)\n", path)
+
+	events, err := telemetry.Read(path)
+	if err != nil {
+		fmt.Fprintf(env.stderr, "qube telemetry: %v\n", err)
+		return exitError
+	}
+	if len(events) == 0 {
+		fmt.Fprintln(env.stdout, "Events:    none recorded")
+		return exitOK
+	}
+	fmt.Fprintf(env.stdout, "Events:    %d (%s – %s)\n\n", len(events),
+		events[0].Time.Local().Format("2006-01-02 15:04"), events[len(events)-1].Time.Local().Format("2006-01-02 15:04"))
+
+	type stat struct {
+		count, errors int
+		total         time.Duration
+		timed         int
+	}
+	stats := map[string]*stat{}
+	for _, ev := range events {
+		key := ev.Name
+		if ev.Command != "" {
+			key += " " + ev.Command
+		}
+		s := stats[key]
+		if s == nil {
+			s = &stat{}
+			stats[key] = s
+		}
+		s.count++
+		if ev.ErrorKind != "" {
+			s.errors++
+		}
+		if ev.DurationMs > 0 {
+			s.total += time.Duration(ev.DurationMs) * time.Millisecond
+			s.timed++
+		}
+	}
+	keys := make([]string, 0, len(stats))
+	for k := range stats {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tw := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "EVENT\tCOUNT\tERRORS\tAVG")
+	for _, k := range keys {
+		s := stats[k]
+		avg := "-"
+		if s.timed > 0 {
+			avg = (s.total / time.Duration(s.timed)).Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", k, s.count, s.errors, avg)
+	}
+	tw.Flush()
+	return exitOK
+}
+
+// telemetryExport は記録を古い順に JSONL で書き出す
+func telemetryExport(env *cliEnv, path string, args []string) int {
+	fs := flag.NewFlagSet("qube telemetry export", flag.ContinueOnError)
+	fs.SetOutput(env.stderr)
+	output := fs.String("o", "", "write to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	events, err := telemetry.Read(path)
+	if err != nil {
+		fmt.Fprintf(env.stderr, "qube telemetry: %v\n", err)
+		return exitError
+	}
+	w := env.stdout
+	if *output != "" {
+		f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			fmt.Fprintf(env.stderr, "qube telemetry: %v\n", err)
+			return exitError
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	for _, ev := range events {
+		if err := enc.Encode(ev); err != nil {
+			fmt.Fprintf(env.stderr, "qube telemetry: %v\n", err)
+			return exitError
+		}
+	}
+	if *output != "" {
+		fmt.Fprintf(env.stderr, "Exported %d event(s) to %s\n", len(events), *output)
+	}
+	return exitOK
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log/slog"
+    "os"
+    "strings"
+    "sync"
+    "time"
+
+    tea "github.com/charmbracelet/bubbletea"
+    "qube/internal/config"
+    "qube/internal/executor"
+    "qube/internal/history"
+    "qube/internal/qcompat"
+    "qube/internal/session"
+    "qube/internal/stream"
+    "qube/internal/ui"
+)
+
+// setupHistory は永続履歴（既定: ~/.qube_history）を読み込み、UI に記録先を設定する
+// 履歴が使えなくても起動は続け、メモリ上の履歴だけで動作する
+func setupHistory(m *ui.Model, cfg config.History) {
+    path := cfg.File
+    if path == "" {
+        p, err := history.DefaultPath()
+        if err != nil {
+            slog.Warn("history disabled", "err", err)
+            return
+        }
+        path = p
+    }
+    scope, _ := history.ParseScope(cfg.Scope) // 設定の読み込み時に検査済み
+    cwd, _ := os.Getwd()
+
+    store := history.Open(path, cfg.Max)
+    entries, err := store.Load(scope, cwd)
+    if err != nil {
+        slog.Warn("failed to load history", "path", path, "err", err)
+    }
+    previous := make([]string, 0, len(entries))
+    for _, e := range entries {
+        previous = append(previous, e.Text)
+    }
+    // 履歴検索で古いエントリも引けるよう、メモリ上の上限もファイルに合わせる
+    m.SetHistoryLimit(cfg.Max)
+    m.SetHistoryRecorder(&history.Recorder{Store: store, Cwd: cwd, SessionID: history.NewSessionID()}, previous)
+}
+
+// applyConfig は設定を UI に反映する
+// 設定の誤り（loadErr を含む）は起動を止めず、既定値で補ったうえでヘッダーに表示する
+func applyConfig(m *ui.Model, cfg config.Config, loadErr error) {
+    warn := func(err error) {
+        for _, line := range strings.Split(err.Error(), "\n") {
+            slog.Warn("config", "problem", line)
+            m.AddWarning(line)
+        }
+    }
+
+    if loadErr != nil {
+        warn(loadErr)
+    }
+
+    setupHistory(m, cfg.History)
+
+    // テーマ。NO_COLOR や色を表示できない端末ではモノクロになる
+    theme, err := ui.ResolveTheme(cfg.Theme, cfg.Themes)
+    if err != nil {
+        warn(err)
+    }
+    m.SetTheme(theme)
+
+    keys, err := cfg.KeyMap()
+    if err != nil {
+        warn(err)
+    }
+    m.SetKeyMap(keys)
+
+    if segs, err := cfg.StatusSegments(); err != nil {
+        warn(err)
+    } else {
+        m.SetStatusSegments(segs)
+    }
+    if cwd, err := os.Getwd(); err == nil {
+        m.SetWorkingDir(cwd)
+    }
+}
+
+// runTUI はフルスクリーンの TUI を起動し、終了するまで戻らない
+// env.cfgErr は設定の読み込みで見つかった誤りで、起動後にヘッダーへ表示する
+// env.telemetry は利用状況の記録先（テレメトリ無効なら nil）
+func runTUI(env *cliEnv) error {
+    cfg, cfgErr, rec := env.cfg, env.cfgErr, env.telemetry
+
+    // StreamProcessorを作成
+    processor := stream.NewSimplifiedProcessor()
+    processor.SetLastSentCommand("") // 初期値設定
+    
+    // セッションを作成
+    rawSess := session.New()
+    rawSess.SetChatArgs(cfg.DefaultFlags)
+    rawSess.SetEnv(cfg.Environ())
+    rawSess.Telemetry = rec
+    sess := &sessionAdapter{
+        Session: rawSess,
+        processor: processor,
+    }
+    
+    // 短命コマンド実行アダプターを作成
+    exec := &execqAdapter{}
+    
+    // CommandExecutorを作成
+    cmdExecutor := executor.NewCommandExecutor(sess, exec)
+    cmdExecutor.SetTimeouts(cfg.ExecutorTimeouts())
+    cmdExecutor.SetPolicy(cfg.ExecutionPolicy())
+    cmdExecutor.SetTelemetry(rec)
+    cmdExecutor.SetEnv(cfg.Environ())
+    
+    // UIモデルを作成し、CommandExecutorを設定
+    // executor の状態変化は Events() 経由で UI の Update ループ内に届く
+    m := ui.NewWithExecutor(cmdExecutor)
+    m.SetVersion(version)
+    // 起動時に即座に接続状態をtrueに設定
+    m.SetConnected(true)
+    applyConfig(&m, cfg, cfgErr)
+    m.SetProfile(profileLabel(cfg))
+    if rec != nil {
+        m.SetTelemetry(rec)
+    }
+
+    // 作業ディレクトリの保存済みの会話。再開する会話は起動時に選ぶ（resume: ask ならピッカーを開く）
+    convRec, records := setupConversations(&m, cfg.Conversations, time.Now())
+    chosen := make(chan string, 1)
+    switch {
+    case len(records) == 0 || cfg.Conversations.Resume == config.ResumeNever:
+        chosen <- ""
+    case cfg.Conversations.Resume == config.ResumeLatest:
+        chosen <- records[0].ID
+    default:
+        m.ShowResumePicker(resumeOptions(records), func(id string) { chosen <- id })
+    }
+
+    // Program を先に作成して、goroutine から安全に UI を更新する
+    // マウスサポートを有効にしてviewportのスクロールを可能にする
+    p := tea.NewProgram(&m, tea.WithMouseCellMotion())
+
+    // セッションからの出力をStreamProcessor経由でUIに伝播（Program.Send 経由）
+    rawSess.OnData = func(data []byte) {
+        lines := processor.Process(string(data))
+        for _, line := range lines {
+            p.Send(ui.MsgAddOutput{Line: line})
+        }
+        // progressLineの処理
+        if progressLine := processor.GetProgressLine(); progressLine != "" {
+            p.Send(ui.MsgSetProgress{Line: progressLine, Clear: false})
+        } else {
+            p.Send(ui.MsgSetProgress{Clear: true})
+        }
+        // プロンプトに戻ったらチャットのターンを完了にする（経過時間の表示を止める）
+        if processor.AtPrompt() {
+            p.Send(ui.MsgChatIdle{})
+        }
+    }
+
+    rawSess.OnError = func(err error) {
+        p.Send(ui.MsgIncrementError{})
+        p.Send(ui.MsgAddOutput{Line: "Session Error: " + err.Error()})
+    }
+
+    // セッション初期化完了で Connected に切替、status を ready に戻す
+    rawSess.OnInitialized = func() {
+        // 初期化完了で Connected/ready へ切替
+        p.Send(ui.MsgSetConnected{Connected: true})
+        p.Send(ui.MsgSetStatus{S: ui.StatusReady})
+        if model := rawSess.Model(); model != "" {
+            p.Send(ui.MsgSetQModel{Name: model})
+        }
+    }
+
+    // Q CLI のバージョンを取得し、出力の解釈に使うパターンを選ぶ
+    // 対応表の範囲外のバージョンならヘッダーに警告する（取得に失敗しても最新のパターンで続行する）
+    applyCompat := func(overrides []qcompat.Override) {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        res, _ := resolveCompat(ctx, overrides)
+        processor.SetPatterns(res.Patterns)
+        rawSess.SetPatterns(res.Patterns)
+        if res.Version != "" {
+            p.Send(ui.MsgSetQVersion{Version: res.Version})
+        }
+        if w := compatWarning(res); w != "" {
+            slog.Warn("Q CLI compatibility", "warning", w)
+            p.Send(ui.MsgAddWarning{Text: w})
+        }
+    }
+    compatReady := make(chan struct{})
+    go func() {
+        defer close(compatReady)
+        applyCompat(cfg.ParserOverrides)
+    }()
+
+    // /qube profile でプロファイルを一覧・切り替える（/profile は Q chat 自身のコマンドとしてそのまま送る）
+    // chat の実行中に切り替えた場合は停止し、新しいプロファイルの環境変数・Q CLI・引数で起動し直す
+    var profileMu sync.Mutex
+    current := cfg
+    cmdExecutor.RegisterQubeCommand("profile", func(args []string) error {
+        profileMu.Lock()
+        defer profileMu.Unlock()
+        if len(args) == 0 {
+            for _, line := range describeProfiles(current) {
+                p.Send(ui.MsgAddOutput{Line: line})
+            }
+            return nil
+        }
+        name := args[0]
+        if name == "-" {
+            name = ""
+        }
+        next, err := profileConfig(env.base, name, env.opts)
+        if err != nil {
+            return err
+        }
+
+        restart := cmdExecutor.GetMode() == executor.ModeSession
+        if err := cmdExecutor.StopSession(); err != nil {
+            slog.Warn("failed to stop chat session for profile switch", "err", err)
+        }
+        current = next
+        useQCLI(next, env.opts)
+        rawSess.SetChatArgs(next.DefaultFlags)
+        rawSess.SetEnv(next.Environ())
+        cmdExecutor.SetEnv(next.Environ())
+        label, detail := profileLabel(next)
+        p.Send(ui.MsgSetProfile{Name: label, Detail: detail})
+        applyCompat(next.ParserOverrides)
+        slog.Info("profile switched", "profile", next.Profile, "restart", restart)
+
+        if label == "" {
+            p.Send(ui.MsgAddOutput{Line: "profile: switched to no profile"})
+        } else {
+            p.Send(ui.MsgAddOutput{Line: strings.TrimSpace("profile: switched to " + label + " " + parenthesize(detail))})
+        }
+        if !restart {
+            return nil
+        }
+        // chat の開始で画面はクリアされ、切り替え後のプロファイルはヘッダーに表示される
+        return cmdExecutor.Execute("q chat")
+    })
+
+    // /qube save [path] で会話の記録を書き出す（形式は拡張子で選ぶ。/save は Q chat 自身のコマンドとしてそのまま送る）
+    exp := newExporter(cfg.Export)
+    m.SetExporter(exp)
+    cmdExecutor.RegisterQubeCommand("save", func(args []string) error {
+        p.Send(ui.MsgExport{Path: strings.Join(args, " ")})
+        return nil
+    })
+
+    // 初期化時に自動的にchatセッションを開始（autoStartChat: false ならコマンドモードで待機）
+    // 初期化検知のパターンが決まり、再開する会話が決まってから起動する
+    // chat を使った会話を再開した場合は autoStartChat に関わらず chat を続ける
+    quit := make(chan struct{})
+    go func() {
+        <-compatReady
+        var id string
+        select {
+        case id = <-chosen:
+        case <-quit:
+            return
+        }
+        chat := cfg.AutoStartChat
+        var resumeArgs []string
+        if r, ok := findRecord(records, id); ok {
+            convRec.Continue(r)
+            args, note := resumeChat(r, records, cfg.Conversations)
+            resumeArgs = args
+            chat = chat || r.Chat
+            p.Send(ui.MsgRestoreConversation{Transcript: r.Transcript, Note: note, Chat: chat})
+            slog.Info("conversation resumed", "id", r.ID, "resume_q", args != nil)
+        }
+        if !chat {
+            return
+        }
+        // 再開の引数は最初の chat だけに渡す（/qube profile の切り替えと同時に変えないようロックする）
+        profileMu.Lock()
+        defer profileMu.Unlock()
+        rawSess.SetChatArgs(append(append([]string(nil), current.DefaultFlags...), resumeArgs...))
+        if err := cmdExecutor.Execute("q chat"); err != nil {
+            slog.Error("failed to start initial chat session", "err", err)
+        }
+        rawSess.SetChatArgs(current.DefaultFlags)
+    }()
+
+    _, err := p.Run()
+    // UI はもうイベントを読まないため、終了処理中の通知で executor が止まらないようにする
+    cmdExecutor.Close()
+    close(quit)
+    if convRec != nil {
+        if err := convRec.Save(m.Transcript(time.Now())); err != nil {
+            slog.Warn("failed to save conversation", "err", err)
+            fmt.Fprintf(env.stderr, "qube: failed to save conversation: %v\n", err)
+        }
+    }
+    if err == nil && cfg.Export.OnExit {
+        // 終了時の保存（export.onExit）。保存先は TUI を抜けた後の端末に表示する
+        if t := m.Transcript(time.Now()); t.Prompts() > 0 {
+            if path, err := exp.Export(t, ""); err != nil {
+                fmt.Fprintf(env.stderr, "qube: failed to save conversation: %v\n", err)
+            } else {
+                fmt.Fprintf(env.stderr, "qube: saved conversation to %s\n", path)
+            }
+        }
+    }
+    return err
+}
\ No newline at end of file
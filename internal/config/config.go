@@ -0,0 +1,483 @@
+// Package config は Qube の設定を読み込む
+//
+// 設定は次の順に重ね合わせ、上にあるものほど優先される。
+//  1. 環境変数（QUBE_*）
+//  2. カレントディレクトリの ./.qube.json
+//  3. ホームディレクトリの ~/.qube.json
+//  4. 既定値
+//
+// 名前付きのプロファイル（profiles）は WithProfile で適用し、その qBin / defaultFlags は
+// 上記のどれよりも優先する（コマンドラインフラグは呼び出し側でさらに上から重ねる）。
+//
+// 設定ファイルは読み込み時にスキーマで検査し、誤りはファイル名と JSON パス付きで報告する。
+// 誤りのあるファイルは丸ごと無視し、残りの設定と既定値で起動できるようにする。
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"qube/internal/conversation"
+	"qube/internal/executor"
+	"qube/internal/history"
+	"qube/internal/policy"
+	"qube/internal/qcompat"
+	"qube/internal/telemetry"
+	"qube/internal/transcript"
+	"qube/internal/ui"
+)
+
+// FileName は設定ファイルの名前
+const FileName = ".qube.json"
+
+// Config は Qube の設定
+type Config struct {
+	// QBin は Q CLI のバイナリ（パスまたはコマンド名）。空なら自動検出する
+	QBin string `json:"qBin,omitempty"`
+	// DefaultFlags は chat セッション起動時に q chat へ追加で渡す引数
+	DefaultFlags []string `json:"defaultFlags,omitempty"`
+	// AutoStartChat が true の場合、起動時に q chat を開始する
+	AutoStartChat bool `json:"autoStartChat"`
+	// Theme はテーマ名（auto / dark / light / high-contrast / Themes で定義した名前）
+	Theme string `json:"theme,omitempty"`
+	// Themes はユーザー定義テーマ
+	Themes []ui.ThemeSpec `json:"themes,omitempty"`
+	// Keymap はキー割り当ての上書き（例: "input.submit": ["enter"]）
+	Keymap map[string][]string `json:"keymap,omitempty"`
+	// StatusBar はステータスバーに表示するセグメントと並び順（空なら既定）
+	StatusBar []string `json:"statusBar,omitempty"`
+	Timeouts  Timeouts `json:"timeouts"`
+	// Passthrough が false の場合、q サブコマンド以外のコマンドを実行しない
+	Passthrough bool `json:"passthrough"`
+	// Policy は q 以外のコマンド（パススルー）の実行ポリシー
+	Policy  Policy  `json:"policy"`
+	History History `json:"history"`
+	// Telemetry は利用状況のローカル記録（既定で無効）
+	Telemetry Telemetry `json:"telemetry"`
+	// Export は会話の記録の書き出し（/qube save・終了時の保存）
+	Export Export `json:"export"`
+	// Conversations は作業ディレクトリごとの会話の保存と、起動時の再開
+	Conversations Conversations `json:"conversations"`
+	// Profile は使用するプロファイル名（空ならプロファイルを使わない）
+	Profile string `json:"profile,omitempty"`
+	// Profiles は名前付きのプロファイル（AWS プロファイル・リージョン・Q の設定の組み合わせ）
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+	// ParserOverrides は Q のバージョン別の出力解釈パターンの上書き（上から順に適用）
+	ParserOverrides []qcompat.Override `json:"parserOverrides,omitempty"`
+
+	// TrustedProjects は ./.qube.json の全項目を信頼するディレクトリ（~/.qube.json でのみ指定できる）
+	TrustedProjects []string `json:"trustedProjects,omitempty"`
+
+	// Sources は読み込んだ設定ファイル（優先度の低い順）
+	Sources []string `json:"-"`
+}
+
+// Timeouts はタイムアウト設定（executor.Timeouts に対応）
+type Timeouts struct {
+	Command Duration      `json:"command,omitempty"`
+	Init    Duration      `json:"init,omitempty"`
+	Idle    Duration      `json:"idle,omitempty"`
+	Rules   []TimeoutRule `json:"rules,omitempty"`
+}
+
+// Policy は q 以外のコマンドの実行ポリシー（policy.Policy に対応）
+// 配列は既定値を置き換える（既定の denylist を残す場合はそれも書く）
+type Policy struct {
+	// Allow は確認なしで実行するコマンドのパターン
+	Allow []string `json:"allow"`
+	// Deny は常に拒否するコマンドのパターン（Allow より優先）
+	Deny []string `json:"deny"`
+	// ConfirmUnlisted が true の場合、どちらにも一致しないコマンドは確認を求める
+	ConfirmUnlisted bool `json:"confirmUnlisted"`
+}
+
+// TimeoutRule はコマンドパターン別のタイムアウト上書き
+type TimeoutRule struct {
+	Pattern string   `json:"pattern"`
+	Command Duration `json:"command,omitempty"`
+	Init    Duration `json:"init,omitempty"`
+	Idle    Duration `json:"idle,omitempty"`
+}
+
+// History は永続履歴の設定
+type History struct {
+	// File は履歴ファイルのパス。空なら ~/.qube_history
+	File  string `json:"file,omitempty"`
+	Max   int    `json:"max,omitempty"`
+	Scope string `json:"scope,omitempty"`
+}
+
+// Telemetry は利用状況の記録の設定
+type Telemetry struct {
+	Enabled bool `json:"enabled"`
+	// File は記録ファイルのパス。空なら ~/.qube_telemetry.jsonl
+	File     string `json:"file,omitempty"`
+	MaxBytes int    `json:"maxBytes,omitempty"` // ローテーションする大きさ
+	MaxFiles int    `json:"maxFiles,omitempty"` // ローテーション後も残すファイル数
+}
+
+// Export は会話の記録の書き出しの設定
+type Export struct {
+	// OnExit が true の場合、終了時に会話を書き出す
+	OnExit bool `json:"onExit"`
+	// Dir はパスを省略した /qube save と終了時の書き出し先。空ならカレントディレクトリ
+	Dir string `json:"dir,omitempty"`
+	// Format はパスを省略した場合の形式（markdown / json）
+	Format string `json:"format,omitempty"`
+}
+
+// ExportFormat は書き出し形式を返す（誤った値はスキーマで弾くため、ここでは Markdown に倒す）
+func (e Export) ExportFormat() transcript.Format {
+	f, err := transcript.ParseFormat(e.Format)
+	if err != nil {
+		return transcript.FormatMarkdown
+	}
+	return f
+}
+
+// 起動時の会話の再開（Conversations.Resume）
+const (
+	ResumeAsk    = "ask"    // 保存済みの会話があればピッカーで選ぶ
+	ResumeLatest = "latest" // 最新の会話を自動で再開する
+	ResumeNever  = "never"  // 再開しない（保存は続ける）
+)
+
+// Conversations は作業ディレクトリごとの会話の保存の設定
+type Conversations struct {
+	Enabled bool `json:"enabled"`
+	// Dir は保存先。空なら ~/.qube_conversations
+	Dir string `json:"dir,omitempty"`
+	// Max は作業ディレクトリごとに残す会話の件数
+	Max int `json:"max,omitempty"`
+	// Resume は起動時の再開の方法（ask / latest / never）
+	Resume string `json:"resume,omitempty"`
+	// ResumeArgs は Q 側の会話を再開するときに q chat へ追加で渡す引数
+	ResumeArgs []string `json:"resumeArgs,omitempty"`
+}
+
+// Path は記録ファイルのパスを返す
+func (t Telemetry) Path() (string, error) {
+	if t.File != "" {
+		return t.File, nil
+	}
+	return telemetry.DefaultPath()
+}
+
+// Duration は "30s" 形式の文字列または秒数で指定する時間
+type Duration time.Duration
+
+// UnmarshalJSON は "1m30s" 形式の文字列または秒数を受け付ける
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	parsed, err := parseDuration(v)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON は "30s" 形式の文字列で書き出す
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Default は既定の設定を返す
+func Default() Config {
+	t := executor.DefaultTimeouts()
+	p := policy.Default()
+	return Config{
+		AutoStartChat: true,
+		Passthrough:   p.Passthrough,
+		Policy: Policy{
+			Allow:           p.Allow,
+			Deny:            p.Deny,
+			ConfirmUnlisted: p.ConfirmUnlisted,
+		},
+		Timeouts: Timeouts{
+			Command: Duration(t.Command),
+			Init:    Duration(t.Init),
+			Idle:    Duration(t.Idle),
+		},
+		History: History{
+			Max:   history.DefaultMaxEntries,
+			Scope: history.ScopeGlobal.String(),
+		},
+		Telemetry: Telemetry{
+			MaxBytes: telemetry.DefaultMaxBytes,
+			MaxFiles: telemetry.DefaultMaxFiles,
+		},
+		Export: Export{Format: string(transcript.FormatMarkdown)},
+		Conversations: Conversations{
+			Enabled:    true,
+			Max:        conversation.DefaultMax,
+			Resume:     ResumeAsk,
+			ResumeArgs: []string{"--resume"},
+		},
+	}
+}
+
+// ExecutorTimeouts は executor に渡すタイムアウト設定を返す
+func (c Config) ExecutorTimeouts() executor.Timeouts {
+	t := executor.Timeouts{
+		Command: time.Duration(c.Timeouts.Command),
+		Init:    time.Duration(c.Timeouts.Init),
+		Idle:    time.Duration(c.Timeouts.Idle),
+	}
+	for _, r := range c.Timeouts.Rules {
+		t.Rules = append(t.Rules, executor.TimeoutRule{
+			Pattern: r.Pattern,
+			Command: time.Duration(r.Command),
+			Init:    time.Duration(r.Init),
+			Idle:    time.Duration(r.Idle),
+		})
+	}
+	return t
+}
+
+// ExecutionPolicy は executor に渡す実行ポリシーを返す
+func (c Config) ExecutionPolicy() policy.Policy {
+	return policy.Policy{
+		Passthrough:     c.Passthrough,
+		Allow:           append([]string(nil), c.Policy.Allow...),
+		Deny:            append([]string(nil), c.Policy.Deny...),
+		ConfirmUnlisted: c.Policy.ConfirmUnlisted,
+	}
+}
+
+// KeyMap は既定のキー割り当てに Keymap の上書きを適用したものを返す
+func (c Config) KeyMap() (ui.KeyMap, error) {
+	km := ui.DefaultKeyMap()
+	err := km.Apply(c.Keymap)
+	return km, err
+}
+
+// StatusSegments はステータスバーのセグメントを返す
+func (c Config) StatusSegments() ([]ui.StatusSegment, error) {
+	return ui.ParseStatusSegments(strings.Join(c.StatusBar, ","))
+}
+
+// FieldError は設定の 1 項目の誤り
+type FieldError struct {
+	File    string // 設定ファイルのパス（環境変数の場合は "environment"）
+	Path    string // JSON パス（例: "timeouts.rules[0].command"）または環境変数名
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.File, e.Path, e.Message)
+}
+
+// Options は Load の読み込み元
+type Options struct {
+	File   string              // 指定した場合はこのファイルだけを読む（存在しなければエラー）
+	Home   string              // ~/.qube.json を探すディレクトリ（空なら読まない）
+	Cwd    string              // ./.qube.json を探すディレクトリ（空なら読まない）
+	Getenv func(string) string // 環境変数（nil なら参照しない）
+}
+
+// Load は実行環境（ホーム・カレントディレクトリ・環境変数）から設定を読み込む
+// file を指定した場合は ./.qube.json と ~/.qube.json の代わりにそのファイルを読む
+func Load(file string) (Config, error) {
+	home, _ := os.UserHomeDir()
+	cwd, _ := os.Getwd()
+	return LoadWith(Options{File: file, Home: home, Cwd: cwd, Getenv: os.Getenv})
+}
+
+// LoadWith は opts の読み込み元から設定を読み込む
+// 誤りがあった場合も、誤りのない部分を反映した設定とともに全ての誤りを errors.Join で返す
+func LoadWith(opts Options) (Config, error) {
+	cfg := Default()
+	var errs []error
+
+	// 優先度の低い順にファイルを重ねる（同じファイルは 1 度だけ読む）
+	merged := map[string]any{}
+	origins := map[string]string{} // 最上位の項目ごとに、値を決めたファイル
+	seen := map[string]bool{}
+	var paths []string
+	for _, dir := range []string{opts.Home, opts.Cwd} {
+		if dir != "" {
+			paths = append(paths, filepath.Join(dir, FileName))
+		}
+	}
+	// ./.qube.json は取得したリポジトリに含まれることがあるため、trustedProjects に無ければ一部の項目を無視する
+	project := ""
+	if opts.Cwd != "" {
+		project = filepath.Join(opts.Cwd, FileName)
+		if abs, err := filepath.Abs(project); err == nil {
+			project = abs
+		}
+	}
+	if opts.Home != "" {
+		if home, err := filepath.Abs(filepath.Join(opts.Home, FileName)); err == nil && home == project {
+			project = "" // ホームディレクトリで起動した場合は ~/.qube.json そのもの
+		}
+	}
+	if opts.File != "" {
+		project = ""
+		paths = []string{opts.File}
+		if _, err := os.Stat(opts.File); errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, &FieldError{File: opts.File, Message: "config file not found"})
+		}
+	}
+	for _, path := range paths {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		layer, err := readFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if layer == nil {
+			continue
+		}
+		if path == project {
+			list, _ := merged["trustedProjects"].([]any)
+			if !trusted(filepath.Dir(project), list, opts.Home) {
+				errs = append(errs, stripRestricted(layer, path)...)
+			}
+		}
+		mergeObjects(merged, layer)
+		for k := range layer {
+			origins[k] = path
+		}
+		cfg.Sources = append(cfg.Sources, path)
+	}
+
+	// スキーマ検査済みのため、ここでのデコードは失敗しない
+	if b, err := json.Marshal(merged); err == nil {
+		if err := json.Unmarshal(b, &cfg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// 存在しないテーマ名は無視して既定のテーマで起動する
+	if cfg.Theme != "" && !contains(cfg.ThemeNames(), cfg.Theme) {
+		errs = append(errs, cfg.unknownTheme(origins["theme"], "theme", cfg.Theme))
+		cfg.Theme = ""
+	}
+
+	// 存在しないプロファイル名は無視してプロファイルなしで起動する
+	if _, ok := cfg.Profiles[cfg.Profile]; cfg.Profile != "" && !ok {
+		errs = append(errs, &FieldError{File: origins["profile"], Path: "profile", Message: cfg.unknownProfile(cfg.Profile).Error()})
+		cfg.Profile = ""
+	}
+
+	if opts.Getenv != nil {
+		errs = append(errs, applyEnv(&cfg, opts.Getenv)...)
+	}
+	cfg.QBin = expandHome(cfg.QBin, opts.Home)
+	for name, p := range cfg.Profiles {
+		p.QBin = expandHome(p.QBin, opts.Home)
+		cfg.Profiles[name] = p
+	}
+	cfg.History.File = expandHome(cfg.History.File, opts.Home)
+	cfg.Telemetry.File = expandHome(cfg.Telemetry.File, opts.Home)
+	cfg.Export.Dir = expandHome(cfg.Export.Dir, opts.Home)
+	cfg.Conversations.Dir = expandHome(cfg.Conversations.Dir, opts.Home)
+	return cfg, errors.Join(errs...)
+}
+
+// readFile は設定ファイルを読み込んでスキーマで検査する
+// ファイルが存在しない場合は nil を返す
+func readFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &FieldError{File: path, Message: err.Error()}
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, &FieldError{File: path, Message: syntaxMessage(data, err)}
+	}
+	var errs []error
+	for _, fe := range rootSchema.validate(v, "") {
+		fe.File = path
+		errs = append(errs, fe)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return v.(map[string]any), nil
+}
+
+// syntaxMessage は JSON の構文エラーに行と桁を添える
+func syntaxMessage(data []byte, err error) string {
+	var se *json.SyntaxError
+	if !errors.As(err, &se) {
+		return "invalid JSON: " + err.Error()
+	}
+	// Offset はエラーの原因となった文字を読んだ直後の位置
+	line, col := 1, 1
+	for _, b := range data[:max(min(int(se.Offset)-1, len(data)), 0)] {
+		if b == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+	}
+	return fmt.Sprintf("invalid JSON at line %d, column %d: %v", line, col, err)
+}
+
+// expandHome はパスの先頭の ~ をホームディレクトリに置き換える
+func expandHome(path, home string) string {
+	if home == "" {
+		return path
+	}
+	if path == "~" {
+		return home
+	}
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		return filepath.Join(home, rest)
+	}
+	return path
+}
+
+// mergeObjects は src を dst に重ねる。オブジェクトは再帰的にマージし、それ以外（配列を含む）は置き換える
+func mergeObjects(dst, src map[string]any) {
+	for k, v := range src {
+		if sv, ok := v.(map[string]any); ok {
+			if dv, ok := dst[k].(map[string]any); ok {
+				mergeObjects(dv, sv)
+				continue
+			}
+			copied := map[string]any{}
+			mergeObjects(copied, sv)
+			dst[k] = copied
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// ThemeNames は Theme に指定できる名前（組み込み・ユーザー定義）を返す
+func (c Config) ThemeNames() []string {
+	names := ui.ThemeNames()
+	for _, t := range c.Themes {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+// unknownTheme は存在しないテーマ名の誤りを返す
+func (c Config) unknownTheme(file, path, name string) error {
+	return &FieldError{File: file, Path: path, Message: fmt.Sprintf("unknown theme %q (want one of: %s)", name, strings.Join(c.ThemeNames(), ", "))}
+}
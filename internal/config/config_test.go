@@ -0,0 +1,442 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"qube/internal/transcript"
+)
+
+// writeConfig は dir に .qube.json を書き込む
+func writeConfig(t *testing.T, dir, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, FileName)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func env(vars map[string]string) func(string) string {
+	return func(k string) string { return vars[k] }
+}
+
+func TestLoad_DefaultsWithoutFiles(t *testing.T) {
+	cfg, err := LoadWith(Options{Home: t.TempDir(), Cwd: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.AutoStartChat || cfg.History.Max <= 0 || cfg.Timeouts.Command <= 0 || len(cfg.Sources) != 0 {
+		t.Fatalf("unexpected defaults: %+v", cfg)
+	}
+	if cfg.Telemetry.Enabled {
+		t.Fatal("telemetry must be opt-in")
+	}
+}
+
+func TestLoad_Policy(t *testing.T) {
+	home, cwd := t.TempDir(), t.TempDir()
+	if p := Default().ExecutionPolicy(); !p.Passthrough || !p.ConfirmUnlisted || len(p.Deny) == 0 {
+		t.Fatalf("default policy: %+v", p)
+	}
+	writeConfig(t, home, `{"policy": {"allow": ["make test"], "deny": ["rm", "git push*"], "confirmUnlisted": false}}`)
+	cfg, err := LoadWith(Options{Home: home, Cwd: cwd, Getenv: env(map[string]string{"QUBE_PASSTHROUGH": "false"})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := cfg.ExecutionPolicy()
+	if p.Passthrough || p.ConfirmUnlisted || strings.Join(p.Allow, ",") != "make test" || strings.Join(p.Deny, ",") != "rm,git push*" {
+		t.Fatalf("policy: %+v", p)
+	}
+
+	writeConfig(t, home, `{"policy": {"deny": [""]}}`)
+	if _, err := LoadWith(Options{Home: home, Cwd: cwd}); err == nil || !strings.Contains(err.Error(), "policy.deny[0]") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+func TestLoad_Telemetry(t *testing.T) {
+	home, cwd := t.TempDir(), t.TempDir()
+	writeConfig(t, home, `{"telemetry": {"file": "~/usage.jsonl", "maxFiles": 5}}`)
+	cfg, err := LoadWith(Options{Home: home, Cwd: cwd, Getenv: env(map[string]string{"QUBE_TELEMETRY": "1"})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Telemetry.Enabled || cfg.Telemetry.File != filepath.Join(home, "usage.jsonl") || cfg.Telemetry.MaxFiles != 5 || cfg.Telemetry.MaxBytes <= 0 {
+		t.Fatalf("telemetry: %+v", cfg.Telemetry)
+	}
+
+	writeConfig(t, cwd, `{"telemetry": {"enabled": "yes"}}`)
+	if _, err := LoadWith(Options{Home: home, Cwd: cwd}); err == nil || !strings.Contains(err.Error(), "telemetry.enabled") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+func TestLoad_Export(t *testing.T) {
+	home, cwd := t.TempDir(), t.TempDir()
+	if f := Default().Export.ExportFormat(); f != transcript.FormatMarkdown {
+		t.Fatalf("default format: %q", f)
+	}
+	writeConfig(t, home, `{"export": {"dir": "~/qube-logs", "format": "json"}}`)
+	cfg, err := LoadWith(Options{Home: home, Cwd: cwd, Getenv: env(map[string]string{"QUBE_EXPORT_ON_EXIT": "true"})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Export.OnExit || cfg.Export.Dir != filepath.Join(home, "qube-logs") || cfg.Export.ExportFormat() != transcript.FormatJSON {
+		t.Fatalf("export: %+v", cfg.Export)
+	}
+
+	writeConfig(t, cwd, `{"export": {"format": "html"}}`)
+	if _, err := LoadWith(Options{Home: home, Cwd: cwd}); err == nil || !strings.Contains(err.Error(), "export.format") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+func TestLoad_Conversations(t *testing.T) {
+	home, cwd := t.TempDir(), t.TempDir()
+	def := Default().Conversations
+	if !def.Enabled || def.Resume != ResumeAsk || strings.Join(def.ResumeArgs, " ") != "--resume" {
+		t.Fatalf("defaults: %+v", def)
+	}
+	writeConfig(t, home, `{"conversations": {"dir": "~/convs", "max": 5, "resumeArgs": ["--resume", "--verbose"]}}`)
+	cfg, err := LoadWith(Options{Home: home, Cwd: cwd, Getenv: env(map[string]string{"QUBE_RESUME": "latest"})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cfg.Conversations
+	if !c.Enabled || c.Dir != filepath.Join(home, "convs") || c.Max != 5 || c.Resume != ResumeLatest || len(c.ResumeArgs) != 2 {
+		t.Fatalf("conversations: %+v", c)
+	}
+
+	writeConfig(t, cwd, `{"conversations": {"resume": "sometimes"}}`)
+	_, err = LoadWith(Options{Home: home, Cwd: cwd, Getenv: env(map[string]string{"QUBE_RESUME": "always"})})
+	for _, want := range []string{"conversations.resume", "QUBE_RESUME: invalid value"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("missing error %q in %v", want, err)
+		}
+	}
+}
+
+func TestLoad_ParserOverrides(t *testing.T) {
+	home, cwd := t.TempDir(), t.TempDir()
+	writeConfig(t, home, `{"parserOverrides": [{"versions": ">=1.14.0", "prompt": "^\\s*λ\\s*$"}]}`)
+	cfg, err := LoadWith(Options{Home: home, Cwd: cwd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.ParserOverrides) != 1 || cfg.ParserOverrides[0].Versions != ">=1.14.0" || cfg.ParserOverrides[0].Prompt != `^\s*λ\s*$` {
+		t.Fatalf("parserOverrides: %+v", cfg.ParserOverrides)
+	}
+
+	writeConfig(t, cwd, `{"parserOverrides": [{"versions": "~1.14", "thinking": "(unclosed"}]}`)
+	_, err = LoadWith(Options{Home: home, Cwd: cwd})
+	for _, want := range []string{"parserOverrides[0].versions: invalid version condition", "parserOverrides[0].thinking: invalid regular expression"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("missing error %q in: %v", want, err)
+		}
+	}
+}
+
+func TestLoad_Profiles(t *testing.T) {
+	home, cwd := t.TempDir(), t.TempDir()
+	writeConfig(t, home, `{
+		"qBin": "/usr/local/bin/q",
+		"defaultFlags": ["--trust-all-tools"],
+		"profile": "dev",
+		"profiles": {
+			"dev":  {"awsProfile": "dev-admin", "region": "us-west-2"},
+			"prod": {"awsProfile": "prod-ro", "qBin": "~/q-prod/bin/q", "defaultFlags": ["--model", "b"], "env": {"Q_LOG_LEVEL": "debug"}}
+		}
+	}`)
+	cfg, err := LoadWith(Options{Home: home, Cwd: cwd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Profile != "dev" || strings.Join(cfg.ProfileNames(), ",") != "dev,prod" {
+		t.Fatalf("profiles: %q %v", cfg.Profile, cfg.ProfileNames())
+	}
+	if got := strings.Join(cfg.Environ(), " "); got != "AWS_PROFILE=dev-admin AWS_REGION=us-west-2 AWS_DEFAULT_REGION=us-west-2" {
+		t.Fatalf("environ: %s", got)
+	}
+
+	// プロファイルの qBin / defaultFlags は設定より優先し、元の設定は変えない
+	prod, err := cfg.WithProfile("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prod.QBin != filepath.Join(home, "q-prod/bin/q") || strings.Join(prod.DefaultFlags, " ") != "--model b" || cfg.QBin != "/usr/local/bin/q" {
+		t.Fatalf("WithProfile: qBin=%q flags=%v", prod.QBin, prod.DefaultFlags)
+	}
+	if got := strings.Join(prod.Environ(), " "); got != "AWS_PROFILE=prod-ro Q_LOG_LEVEL=debug" {
+		t.Fatalf("environ: %s", got)
+	}
+	if none, _ := prod.WithProfile(""); none.Profile != "" || none.Environ() != nil {
+		t.Fatalf("no profile: %+v", none)
+	}
+	if _, err := cfg.WithProfile("staging"); err == nil || !strings.Contains(err.Error(), "want one of: dev, prod") {
+		t.Fatalf("unknown profile: %v", err)
+	}
+
+	// 環境変数で選べる。存在しない名前は無視する
+	cfg, _ = LoadWith(Options{Home: home, Cwd: cwd, Getenv: env(map[string]string{"QUBE_PROFILE": "prod"})})
+	if cfg.Profile != "prod" {
+		t.Fatalf("QUBE_PROFILE: %q", cfg.Profile)
+	}
+	cfg, err = LoadWith(Options{Home: home, Cwd: cwd, Getenv: env(map[string]string{"QUBE_PROFILE": "staging"})})
+	if cfg.Profile != "dev" || err == nil || !strings.Contains(err.Error(), "environment: QUBE_PROFILE: unknown profile") {
+		t.Fatalf("unknown QUBE_PROFILE: %q %v", cfg.Profile, err)
+	}
+
+	writeConfig(t, cwd, `{"profile": "staging", "profiles": {"dev": {"region": 1, "env": {"A B": "x"}}}}`)
+	cfg, err = LoadWith(Options{Home: home, Cwd: cwd})
+	for _, want := range []string{"profiles.dev.region: expected a string", `profiles.dev.env: invalid environment variable name "A B"`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("missing error %q in: %v", want, err)
+		}
+	}
+
+	// 存在しない名前を設定ファイルで選んだ場合は誤りとしてプロファイルなしにする
+	// （./.qube.json の profile は信頼しないため --config で読む）
+	writeConfig(t, cwd, `{"profile": "staging", "profiles": {"dev": {"region": "us-west-2"}}}`)
+	cfg, err = LoadWith(Options{File: filepath.Join(cwd, FileName), Home: home, Cwd: cwd})
+	if cfg.Profile != "" || err == nil || !strings.Contains(err.Error(), "profile: unknown profile \"staging\"") {
+		t.Fatalf("unknown profile in file: %q %v", cfg.Profile, err)
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	home, cwd := t.TempDir(), t.TempDir()
+	writeConfig(t, home, `{
+		"trustedProjects": ["`+cwd+`"],
+		"qBin": "/opt/q/bin/q",
+		"theme": "light",
+		"defaultFlags": ["--model", "a"],
+		"keymap": {"input.find": ["ctrl+f"], "input.help": ["f2"]},
+		"timeouts": {"command": "1m", "idle": 5},
+		"history": {"max": 50, "file": "~/.q_hist"}
+	}`)
+	writeConfig(t, cwd, `{
+		"theme": "high-contrast",
+		"defaultFlags": ["--trust-all-tools"],
+		"keymap": {"input.find": ["ctrl+g"]},
+		"timeouts": {"command": "2m"}
+	}`)
+
+	cfg, err := LoadWith(Options{Home: home, Cwd: cwd, Getenv: env(map[string]string{
+		"QUBE_THEME":        "dark",
+		"QUBE_IDLE_TIMEOUT": "7",
+	})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 環境変数 > カレント > ホーム > 既定値
+	if cfg.Theme != "dark" || cfg.QBin != "/opt/q/bin/q" || cfg.History.Max != 50 {
+		t.Fatalf("scalar precedence: %+v", cfg)
+	}
+	// 配列は置き換え、オブジェクトは項目ごとに重ねる
+	if strings.Join(cfg.DefaultFlags, " ") != "--trust-all-tools" {
+		t.Fatalf("arrays should be replaced: %v", cfg.DefaultFlags)
+	}
+	if cfg.Keymap["input.find"][0] != "ctrl+g" || cfg.Keymap["input.help"][0] != "f2" {
+		t.Fatalf("objects should be merged: %v", cfg.Keymap)
+	}
+	if time.Duration(cfg.Timeouts.Command) != 2*time.Minute || time.Duration(cfg.Timeouts.Idle) != 7*time.Second {
+		t.Fatalf("timeouts: %+v", cfg.Timeouts)
+	}
+	if time.Duration(cfg.Timeouts.Init) != 10*time.Second {
+		t.Fatalf("unset timeouts should keep defaults: %+v", cfg.Timeouts)
+	}
+	if cfg.History.File != filepath.Join(home, ".q_hist") {
+		t.Fatalf("~ should be expanded: %q", cfg.History.File)
+	}
+	if len(cfg.Sources) != 2 || !strings.HasPrefix(cfg.Sources[0], home) {
+		t.Fatalf("sources: %v", cfg.Sources)
+	}
+	if _, err := cfg.KeyMap(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad_UntrustedProjectConfig(t *testing.T) {
+	home, cwd := t.TempDir(), t.TempDir()
+	writeConfig(t, home, `{"qBin": "/opt/q/bin/q", "defaultFlags": ["--model", "a"]}`)
+	project := `{
+		"qBin": "./evil",
+		"defaultFlags": ["--trust-all-tools"],
+		"profiles": {"x": {"env": {"AWS_PROFILE": "attacker"}}},
+		"policy": {"allow": ["*"]},
+		"trustedProjects": ["` + cwd + `"],
+		"theme": "light"
+	}`
+	writeConfig(t, cwd, project)
+
+	// 信頼していないディレクトリの ./.qube.json では実行に関わる項目を無視し、その他は反映する
+	cfg, err := LoadWith(Options{Home: home, Cwd: cwd})
+	if cfg.QBin != "/opt/q/bin/q" || strings.Join(cfg.DefaultFlags, " ") != "--model a" || len(cfg.Profiles) != 0 ||
+		strings.Join(cfg.Policy.Allow, ",") == "*" || len(cfg.TrustedProjects) != 0 || cfg.Theme != "light" {
+		t.Fatalf("untrusted project config should not set restricted fields: %+v", cfg)
+	}
+	for _, want := range []string{"qBin: ignored in a project config", "defaultFlags: ignored", "profiles: ignored", "policy: ignored", "trustedProjects: ignored"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("missing error %q in %v", want, err)
+		}
+	}
+
+	// ~/.qube.json の trustedProjects に登録したディレクトリでは全項目を使う
+	writeConfig(t, home, `{"trustedProjects": ["`+cwd+`"]}`)
+	cfg, err = LoadWith(Options{Home: home, Cwd: cwd})
+	if err != nil || cfg.QBin == "/opt/q/bin/q" || strings.Join(cfg.DefaultFlags, " ") != "--trust-all-tools" {
+		t.Fatalf("trusted project: %v %+v", err, cfg)
+	}
+}
+
+func TestLoad_UntrustedProjectProfile(t *testing.T) {
+	home, cwd := t.TempDir(), t.TempDir()
+	writeConfig(t, home, `{"profiles": {"dev": {"awsProfile": "dev"}, "prod": {"awsProfile": "prod-admin"}}, "profile": "dev"}`)
+	writeConfig(t, cwd, `{"profile": "prod"}`)
+
+	// 信頼していないディレクトリの設定ではユーザーのプロファイルを切り替えられない
+	cfg, err := LoadWith(Options{Home: home, Cwd: cwd})
+	if cfg.Profile != "dev" || err == nil || !strings.Contains(err.Error(), "profile: ignored in a project config") {
+		t.Fatalf("untrusted project should not switch the profile: %q %v", cfg.Profile, err)
+	}
+
+	writeConfig(t, home, `{"profiles": {"dev": {"awsProfile": "dev"}, "prod": {"awsProfile": "prod-admin"}}, "profile": "dev", "trustedProjects": ["`+cwd+`"]}`)
+	if cfg, err := LoadWith(Options{Home: home, Cwd: cwd}); err != nil || cfg.Profile != "prod" {
+		t.Fatalf("trusted project: %q %v", cfg.Profile, err)
+	}
+}
+
+func TestLoad_UntrustedProjectResumeArgs(t *testing.T) {
+	home, cwd := t.TempDir(), t.TempDir()
+	writeConfig(t, cwd, `{"conversations": {"resumeArgs": ["--trust-all-tools"], "max": 5}}`)
+
+	// q chat に渡す引数は信頼していないディレクトリの設定では変えられない（他の項目は反映する）
+	cfg, err := LoadWith(Options{Home: home, Cwd: cwd})
+	if strings.Join(cfg.Conversations.ResumeArgs, " ") != "--resume" || cfg.Conversations.Max != 5 ||
+		err == nil || !strings.Contains(err.Error(), "conversations.resumeArgs: ignored in a project config") {
+		t.Fatalf("untrusted project should not set resumeArgs: %+v %v", cfg.Conversations, err)
+	}
+
+	writeConfig(t, home, `{"trustedProjects": ["`+cwd+`"]}`)
+	if cfg, err := LoadWith(Options{Home: home, Cwd: cwd}); err != nil || strings.Join(cfg.Conversations.ResumeArgs, " ") != "--trust-all-tools" {
+		t.Fatalf("trusted project: %+v %v", cfg.Conversations, err)
+	}
+}
+
+func TestLoad_SchemaErrorsReportFileAndPath(t *testing.T) {
+	home, cwd := t.TempDir(), t.TempDir()
+	writeConfig(t, home, `{"theme": "light"}`)
+	path := writeConfig(t, cwd, `{
+		"theme": "dark",
+		"autoStartChat": "yes",
+		"timeouts": {"rules": [{"pattern": "q translate*", "command": "3x"}, {"command": "1s"}]},
+		"keymap": {"input.sumbit": ["enter"]},
+		"themes": [{"name": "mine", "accent": "#zzzzzz"}],
+		"statusbar": ["mode"]
+	}`)
+
+	cfg, err := LoadWith(Options{Home: home, Cwd: cwd})
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{
+		path + `: autoStartChat: expected true or false, got "yes"`,
+		path + `: timeouts.rules[0].command: invalid duration "3x"`,
+		path + `: timeouts.rules[1].pattern: is required`,
+		path + `: keymap["input.sumbit"]: unknown key`,
+		path + `: themes[0].accent: invalid color "#zzzzzz"`,
+		path + `: statusbar: unknown field (did you mean "statusBar"?)`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing error %q in:\n%v", want, err)
+		}
+	}
+	// 誤りのあるファイルは丸ごと無視し、他のファイルの設定は使う
+	if cfg.Theme != "light" || len(cfg.Sources) != 1 {
+		t.Fatalf("invalid file should be ignored: theme=%q sources=%v", cfg.Theme, cfg.Sources)
+	}
+}
+
+func TestLoad_SyntaxErrorHasLineAndColumn(t *testing.T) {
+	cwd := t.TempDir()
+	path := writeConfig(t, cwd, "{\n  \"theme\": \"dark\",\n}\n")
+	_, err := LoadWith(Options{Cwd: cwd})
+	if err == nil || !strings.Contains(err.Error(), path+": invalid JSON at line 3, column 1") {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestLoad_UnknownThemeFallsBack(t *testing.T) {
+	cwd := t.TempDir()
+	path := writeConfig(t, cwd, `{"theme": "solarized", "themes": [{"name": "mine", "base": "light"}]}`)
+	cfg, err := LoadWith(Options{Cwd: cwd})
+	if err == nil || !strings.Contains(err.Error(), path+`: theme: unknown theme "solarized"`) || cfg.Theme != "" {
+		t.Fatalf("theme=%q err=%v", cfg.Theme, err)
+	}
+
+	// ユーザー定義テーマは環境変数からも指定できる
+	cfg, err = LoadWith(Options{Cwd: cwd, Getenv: env(map[string]string{"QUBE_THEME": "mine"})})
+	if cfg.Theme != "mine" || err == nil {
+		t.Fatalf("theme=%q err=%v", cfg.Theme, err)
+	}
+}
+
+func TestLoad_EnvErrors(t *testing.T) {
+	cfg, err := LoadWith(Options{Getenv: env(map[string]string{
+		"QUBE_HISTORY_MAX":     "-1",
+		"QUBE_AUTO_START_CHAT": "false",
+		"QUBE_COMMAND_TIMEOUT": "soon",
+		"QUBE_STATUSBAR":       "mode,clock",
+		"Q_BIN":                "/usr/local/bin/q",
+	})})
+	for _, want := range []string{
+		"environment: QUBE_HISTORY_MAX: expected a positive integer",
+		`environment: QUBE_COMMAND_TIMEOUT: invalid duration "soon"`,
+		"environment: QUBE_STATUSBAR:",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("missing error %q in %v", want, err)
+		}
+	}
+	if cfg.AutoStartChat || cfg.QBin != "/usr/local/bin/q" || cfg.History.Max != Default().History.Max {
+		t.Fatalf("valid variables should still apply: %+v", cfg)
+	}
+}
+
+func TestExecutorTimeouts(t *testing.T) {
+	cwd := t.TempDir()
+	writeConfig(t, cwd, `{"timeouts": {"command": "45s", "rules": [{"pattern": "q translate*", "command": "2m"}]}}`)
+	cfg, err := LoadWith(Options{Cwd: cwd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eff := cfg.ExecutorTimeouts().Resolve([]string{"q", "translate", "list files"})
+	if eff.Command != 2*time.Minute {
+		t.Fatalf("rule should apply: %+v", eff)
+	}
+	if cfg.ExecutorTimeouts().Resolve([]string{"q", "doctor"}).Command != 45*time.Second {
+		t.Fatal("global command timeout should apply")
+	}
+}
+
+func TestLoad_ExplicitFile(t *testing.T) {
+	home, dir := t.TempDir(), t.TempDir()
+	writeConfig(t, home, `{"theme": "light", "autoStartChat": false}`)
+	path := filepath.Join(dir, "team.json")
+	if err := os.WriteFile(path, []byte(`{"theme": "high-contrast"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// 明示したファイルは ~/.qube.json の代わりに読む
+	cfg, err := LoadWith(Options{File: path, Home: home})
+	if err != nil || cfg.Theme != "high-contrast" || !cfg.AutoStartChat {
+		t.Fatalf("cfg=%+v err=%v", cfg, err)
+	}
+	_, err = LoadWith(Options{File: filepath.Join(dir, "missing.json"), Home: home})
+	if err == nil || !strings.Contains(err.Error(), "missing.json: config file not found") {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"qube/internal/ui"
+)
+
+// envSource は環境変数由来の誤りの File に使う名前
+const envSource = "environment"
+
+// EnvVars は設定として読み込む環境変数と、対応する設定項目
+var EnvVars = []struct{ Name, Field string }{
+	{"QUBE_Q_BIN", "qBin"},
+	{"Q_BIN", "qBin"},
+	{"QUBE_DEFAULT_FLAGS", "defaultFlags"},
+	{"QUBE_AUTO_START_CHAT", "autoStartChat"},
+	{"QUBE_PASSTHROUGH", "passthrough"},
+	{"QUBE_THEME", "theme"},
+	{"QUBE_PROFILE", "profile"},
+	{"QUBE_STATUSBAR", "statusBar"},
+	{"QUBE_COMMAND_TIMEOUT", "timeouts.command"},
+	{"QUBE_INIT_TIMEOUT", "timeouts.init"},
+	{"QUBE_IDLE_TIMEOUT", "timeouts.idle"},
+	{"QUBE_HISTORY_FILE", "history.file"},
+	{"QUBE_HISTORY_MAX", "history.max"},
+	{"QUBE_HISTORY_SCOPE", "history.scope"},
+	{"QUBE_TELEMETRY", "telemetry.enabled"},
+	{"QUBE_TELEMETRY_FILE", "telemetry.file"},
+	{"QUBE_EXPORT_ON_EXIT", "export.onExit"},
+	{"QUBE_EXPORT_DIR", "export.dir"},
+	{"QUBE_CONVERSATIONS", "conversations.enabled"},
+	{"QUBE_CONVERSATIONS_DIR", "conversations.dir"},
+	{"QUBE_RESUME", "conversations.resume"},
+}
+
+// applyEnv は QUBE_* 環境変数で設定を上書きする
+// 値の誤った変数は無視し、誤りとして返す
+func applyEnv(cfg *Config, getenv func(string) string) []error {
+	var errs []error
+	fail := func(name, format string, args ...any) {
+		errs = append(errs, &FieldError{File: envSource, Path: name, Message: fmt.Sprintf(format, args...)})
+	}
+	duration := func(name string, dst *Duration) {
+		v := getenv(name)
+		if v == "" {
+			return
+		}
+		// 単位のない数値は秒として扱う
+		var raw any = v
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			raw = n
+		}
+		d, err := parseDuration(raw)
+		if err != nil {
+			fail(name, "%v", err)
+			return
+		}
+		*dst = Duration(d)
+	}
+
+	// Q_BIN は従来からの指定方法のため、QUBE_Q_BIN が無ければこちらを使う
+	if v := getenv("QUBE_Q_BIN"); v != "" {
+		cfg.QBin = v
+	} else if v := getenv("Q_BIN"); v != "" {
+		cfg.QBin = v
+	}
+	if v := getenv("QUBE_DEFAULT_FLAGS"); v != "" {
+		cfg.DefaultFlags = strings.Fields(v)
+	}
+	boolean := func(name string, dst *bool) {
+		v := getenv(name)
+		if v == "" {
+			return
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			fail(name, "expected true or false, got %q", v)
+			return
+		}
+		*dst = b
+	}
+	boolean("QUBE_AUTO_START_CHAT", &cfg.AutoStartChat)
+	boolean("QUBE_PASSTHROUGH", &cfg.Passthrough)
+	if v := getenv("QUBE_THEME"); v != "" {
+		if contains(cfg.ThemeNames(), v) {
+			cfg.Theme = v
+		} else {
+			errs = append(errs, cfg.unknownTheme(envSource, "QUBE_THEME", v))
+		}
+	}
+	if v := getenv("QUBE_PROFILE"); v != "" {
+		if _, ok := cfg.Profiles[v]; ok {
+			cfg.Profile = v
+		} else {
+			fail("QUBE_PROFILE", "%v", cfg.unknownProfile(v))
+		}
+	}
+	if v := getenv("QUBE_STATUSBAR"); v != "" {
+		if _, err := ui.ParseStatusSegments(v); err != nil {
+			fail("QUBE_STATUSBAR", "%v", err)
+		} else {
+			cfg.StatusBar = strings.Split(v, ",")
+		}
+	}
+	duration("QUBE_COMMAND_TIMEOUT", &cfg.Timeouts.Command)
+	duration("QUBE_INIT_TIMEOUT", &cfg.Timeouts.Init)
+	duration("QUBE_IDLE_TIMEOUT", &cfg.Timeouts.Idle)
+	if v := getenv("QUBE_HISTORY_FILE"); v != "" {
+		cfg.History.File = v
+	}
+	if v := getenv("QUBE_HISTORY_MAX"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			fail("QUBE_HISTORY_MAX", "expected a positive integer, got %q", v)
+		} else {
+			cfg.History.Max = n
+		}
+	}
+	if v := getenv("QUBE_HISTORY_SCOPE"); v != "" {
+		if v != "global" && v != "project" {
+			fail("QUBE_HISTORY_SCOPE", "invalid value %q (want one of: global, project)", v)
+		} else {
+			cfg.History.Scope = v
+		}
+	}
+	boolean("QUBE_TELEMETRY", &cfg.Telemetry.Enabled)
+	if v := getenv("QUBE_TELEMETRY_FILE"); v != "" {
+		cfg.Telemetry.File = v
+	}
+	boolean("QUBE_EXPORT_ON_EXIT", &cfg.Export.OnExit)
+	if v := getenv("QUBE_EXPORT_DIR"); v != "" {
+		cfg.Export.Dir = v
+	}
+	boolean("QUBE_CONVERSATIONS", &cfg.Conversations.Enabled)
+	if v := getenv("QUBE_CONVERSATIONS_DIR"); v != "" {
+		cfg.Conversations.Dir = v
+	}
+	if v := getenv("QUBE_RESUME"); v != "" {
+		if v != ResumeAsk && v != ResumeLatest && v != ResumeNever {
+			fail("QUBE_RESUME", "invalid value %q (want one of: ask, latest, never)", v)
+		} else {
+			cfg.Conversations.Resume = v
+		}
+	}
+	return errs
+}
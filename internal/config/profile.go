@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Profile は AWS アカウント・リージョン・Q の設定の組み合わせ
+// 起動時（--profile / QUBE_PROFILE / profile）または実行中の /qube profile で切り替える
+type Profile struct {
+	// AWSProfile は Q に渡す AWS_PROFILE
+	AWSProfile string `json:"awsProfile,omitempty"`
+	// Region は Q に渡す AWS_REGION（AWS_DEFAULT_REGION も同じ値にする）
+	Region string `json:"region,omitempty"`
+	// QBin は使用する Q CLI のバイナリ（空なら qBin の設定に従う）
+	QBin string `json:"qBin,omitempty"`
+	// DefaultFlags は q chat へ渡す引数（指定した場合は defaultFlags を置き換える）
+	DefaultFlags []string `json:"defaultFlags,omitempty"`
+	// Env は Q のプロセスに追加する環境変数
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// Environ は Q のプロセスに追加する環境変数を "KEY=value" の形式で返す
+// Env は名前順に並べ、AWSProfile / Region より後に置く（同じ名前なら Env が優先される）
+func (p Profile) Environ() []string {
+	var env []string
+	if p.AWSProfile != "" {
+		env = append(env, "AWS_PROFILE="+p.AWSProfile)
+	}
+	if p.Region != "" {
+		env = append(env, "AWS_REGION="+p.Region, "AWS_DEFAULT_REGION="+p.Region)
+	}
+	for _, k := range sortedKeys(p.Env) {
+		env = append(env, k+"="+p.Env[k])
+	}
+	return env
+}
+
+// Describe はヘッダーなどに表示する AWS プロファイルとリージョンを返す（例: "prod-admin, us-east-1"）
+func (p Profile) Describe() string {
+	var parts []string
+	for _, s := range []string{p.AWSProfile, p.Region} {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ProfileNames は定義されたプロファイル名を名前順に返す
+func (c Config) ProfileNames() []string {
+	return sortedKeys(c.Profiles)
+}
+
+// ActiveProfile は使用中のプロファイルを返す（使用していなければ ok が false）
+func (c Config) ActiveProfile() (Profile, bool) {
+	if c.Profile == "" {
+		return Profile{}, false
+	}
+	p, ok := c.Profiles[c.Profile]
+	return p, ok
+}
+
+// Environ は使用中のプロファイルが Q のプロセスに追加する環境変数を返す
+func (c Config) Environ() []string {
+	p, _ := c.ActiveProfile()
+	return p.Environ()
+}
+
+// WithProfile は name のプロファイルを適用した設定を返す
+// プロファイルの qBin / defaultFlags は設定ファイルと環境変数の値より優先する
+// name が空の場合はプロファイルを使わない設定を返す
+//
+// c はプロファイルを適用する前の設定であること（適用済みの設定に重ねると、
+// 前のプロファイルの qBin などが残る）
+func (c Config) WithProfile(name string) (Config, error) {
+	c.Profile = name
+	if name == "" {
+		return c, nil
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		c.Profile = ""
+		return c, c.unknownProfile(name)
+	}
+	if p.QBin != "" {
+		c.QBin = p.QBin
+	}
+	if len(p.DefaultFlags) > 0 {
+		c.DefaultFlags = append([]string(nil), p.DefaultFlags...)
+	}
+	return c, nil
+}
+
+// unknownProfile は存在しないプロファイル名の誤りを返す
+func (c Config) unknownProfile(name string) error {
+	names := c.ProfileNames()
+	if len(names) == 0 {
+		return fmt.Errorf("unknown profile %q (no profiles are defined)", name)
+	}
+	return fmt.Errorf("unknown profile %q (want one of: %s)", name, strings.Join(names, ", "))
+}
+
+// envKeys は環境変数の名前として使えないキーを検査する
+func envKeys(v any) error {
+	var bad []string
+	for k := range v.(map[string]any) {
+		if k == "" || strings.ContainsAny(k, "= \t") {
+			bad = append(bad, fmt.Sprintf("%q", k))
+		}
+	}
+	if len(bad) > 0 {
+		sort.Strings(bad)
+		return fmt.Errorf("invalid environment variable name %s", strings.Join(bad, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"qube/internal/qcompat"
+	"qube/internal/ui"
+)
+
+// kind は JSON の値の種類
+type kind string
+
+const (
+	kindObject   kind = "object"   // フィールドが決まったオブジェクト
+	kindMap      kind = "map"      // 任意のキーを持つオブジェクト（値は items）
+	kindArray    kind = "array"    // 配列（要素は items）
+	kindString   kind = "string"   // 文字列
+	kindInteger  kind = "integer"  // 整数
+	kindBoolean  kind = "boolean"  // 真偽値
+	kindDuration kind = "duration" // "30s" 形式の文字列または秒数
+)
+
+// schema は設定ファイルの構造と値の制約
+type schema struct {
+	kind     kind
+	fields   map[string]*schema // kindObject のフィールド
+	required []string           // kindObject で必須のフィールド
+	items    *schema            // kindArray の要素、kindMap の値
+	keys     []string           // kindMap で許可するキー（空なら任意）
+	enum     []string           // kindString で許可する値（空なら任意）
+	check    func(v any) error  // 追加の検査（kindObject / kindMap ではフィールドの検査の後に行う）
+}
+
+// rootSchema は .qube.json のスキーマ
+var rootSchema = &schema{kind: kindObject, fields: map[string]*schema{
+	"qBin":          {kind: kindString, check: nonEmpty},
+	"defaultFlags":  {kind: kindArray, items: &schema{kind: kindString, check: nonEmpty}},
+	"autoStartChat": {kind: kindBoolean},
+	"passthrough":   {kind: kindBoolean},
+	"policy": {kind: kindObject, fields: map[string]*schema{
+		"allow":           {kind: kindArray, items: &schema{kind: kindString, check: nonEmpty}},
+		"deny":            {kind: kindArray, items: &schema{kind: kindString, check: nonEmpty}},
+		"confirmUnlisted": {kind: kindBoolean},
+	}},
+	"theme": {kind: kindString, check: nonEmpty},
+	"themes": {kind: kindArray, items: &schema{
+		kind:     kindObject,
+		required: []string{"name"},
+		fields: map[string]*schema{
+			"name":       {kind: kindString, check: nonEmpty},
+			"base":       {kind: kindString, enum: builtinThemeNames()},
+			"accent":     colorSchema,
+			"border":     colorSchema,
+			"success":    colorSchema,
+			"error":      colorSchema,
+			"warning":    colorSchema,
+			"progress":   colorSchema,
+			"matchFg":    colorSchema,
+			"matchBg":    colorSchema,
+			"currentFg":  colorSchema,
+			"currentBg":  colorSchema,
+			"cursorLine": colorSchema,
+			"selection":  colorSchema,
+			"logo":       {kind: kindArray, items: colorSchema},
+			"boldAccent": {kind: kindBoolean},
+		},
+	}},
+	"keymap": {kind: kindMap, keys: ui.KeyBindingNames(), items: &schema{
+		kind:  kindArray,
+		items: &schema{kind: kindString, check: nonEmpty},
+	}},
+	"statusBar": {kind: kindArray, items: &schema{kind: kindString, enum: ui.StatusSegmentNames()}},
+	"timeouts": {kind: kindObject, fields: map[string]*schema{
+		"command": {kind: kindDuration},
+		"init":    {kind: kindDuration},
+		"idle":    {kind: kindDuration},
+		"rules": {kind: kindArray, items: &schema{
+			kind:     kindObject,
+			required: []string{"pattern"},
+			fields: map[string]*schema{
+				"pattern": {kind: kindString, check: nonEmpty},
+				"command": {kind: kindDuration},
+				"init":    {kind: kindDuration},
+				"idle":    {kind: kindDuration},
+			},
+		}},
+	}},
+	"history": {kind: kindObject, fields: map[string]*schema{
+		"file":  {kind: kindString, check: nonEmpty},
+		"max":   {kind: kindInteger, check: positive},
+		"scope": {kind: kindString, enum: []string{"global", "project"}},
+	}},
+	"telemetry": {kind: kindObject, fields: map[string]*schema{
+		"enabled":  {kind: kindBoolean},
+		"file":     {kind: kindString, check: nonEmpty},
+		"maxBytes": {kind: kindInteger, check: positive},
+		"maxFiles": {kind: kindInteger, check: positive},
+	}},
+	"export": {kind: kindObject, fields: map[string]*schema{
+		"onExit": {kind: kindBoolean},
+		"dir":    {kind: kindString, check: nonEmpty},
+		"format": {kind: kindString, enum: []string{"markdown", "md", "json"}},
+	}},
+	"conversations": {kind: kindObject, fields: map[string]*schema{
+		"enabled":    {kind: kindBoolean},
+		"dir":        {kind: kindString, check: nonEmpty},
+		"max":        {kind: kindInteger, check: positive},
+		"resume":     {kind: kindString, enum: []string{"ask", "latest", "never"}},
+		"resumeArgs": {kind: kindArray, items: &schema{kind: kindString, check: nonEmpty}},
+	}},
+	"trustedProjects": {kind: kindArray, items: &schema{kind: kindString, check: nonEmpty}},
+	"profile":         {kind: kindString, check: nonEmpty},
+	"profiles": {kind: kindMap, items: &schema{kind: kindObject, fields: map[string]*schema{
+		"awsProfile":   {kind: kindString, check: nonEmpty},
+		"region":       {kind: kindString, check: nonEmpty},
+		"qBin":         {kind: kindString, check: nonEmpty},
+		"defaultFlags": {kind: kindArray, items: &schema{kind: kindString, check: nonEmpty}},
+		"env":          {kind: kindMap, items: &schema{kind: kindString}, check: envKeys},
+	}}},
+	"parserOverrides": {kind: kindArray, items: &schema{
+		kind: kindObject,
+		fields: map[string]*schema{
+			"versions":      {kind: kindString, check: func(v any) error { return qcompat.ValidateConstraint(v.(string)) }},
+			"initBanner":    patternSchema,
+			"initSeparator": patternSchema,
+			"spinner":       patternSchema,
+			"thinking":      patternSchema,
+			"prompt":        patternSchema,
+		},
+	}},
+}}
+
+// patternSchema は Q の出力を解釈する正規表現（Go の regexp 構文）
+var patternSchema = &schema{kind: kindString, check: func(v any) error {
+	if v.(string) == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	if _, err := regexp.Compile(v.(string)); err != nil {
+		return fmt.Errorf("invalid regular expression: %v", err)
+	}
+	return nil
+}}
+
+// colorSchema はテーマの色（0-255 または #rrggbb）
+var colorSchema = &schema{kind: kindString, check: func(v any) error { return ui.ValidateColor(v.(string)) }}
+
+// builtinThemeNames は base に指定できる組み込みテーマ名を返す
+func builtinThemeNames() []string {
+	var names []string
+	for _, n := range ui.ThemeNames() {
+		if n != "auto" {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+func nonEmpty(v any) error {
+	if strings.TrimSpace(v.(string)) == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	return nil
+}
+
+func positive(v any) error {
+	if v.(float64) <= 0 {
+		return fmt.Errorf("must be greater than 0")
+	}
+	return nil
+}
+
+// validate は JSON をデコードした値 v を検査し、見つかった誤りをすべて返す
+// path はエラーに含める JSON パス（例: "timeouts.rules[0].command"）
+func (s *schema) validate(v any, path string) []*FieldError {
+	fail := func(format string, args ...any) []*FieldError {
+		return []*FieldError{{Path: path, Message: fmt.Sprintf(format, args...)}}
+	}
+	switch s.kind {
+	case kindObject, kindMap:
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fail("expected an object, got %s", describe(v))
+		}
+		var errs []*FieldError
+		for _, name := range s.required {
+			if _, ok := obj[name]; !ok {
+				errs = append(errs, &FieldError{Path: joinPath(path, name), Message: "is required"})
+			}
+		}
+		for _, name := range sortedKeys(obj) {
+			sub := s.items
+			if s.kind == kindObject {
+				sub = s.fields[name]
+				if sub == nil {
+					errs = append(errs, &FieldError{Path: joinPath(path, name), Message: "unknown field" + suggest(name, sortedKeys(s.fields))})
+					continue
+				}
+			} else if len(s.keys) > 0 && !contains(s.keys, name) {
+				errs = append(errs, &FieldError{Path: joinPath(path, name), Message: "unknown key" + suggest(name, s.keys)})
+				continue
+			}
+			errs = append(errs, sub.validate(obj[name], joinPath(path, name))...)
+		}
+		if s.check != nil {
+			if err := s.check(v); err != nil {
+				errs = append(errs, &FieldError{Path: path, Message: err.Error()})
+			}
+		}
+		return errs
+	case kindArray:
+		arr, ok := v.([]any)
+		if !ok {
+			return fail("expected an array, got %s", describe(v))
+		}
+		var errs []*FieldError
+		for i, item := range arr {
+			errs = append(errs, s.items.validate(item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return errs
+	case kindString:
+		str, ok := v.(string)
+		if !ok {
+			return fail("expected a string, got %s", describe(v))
+		}
+		if len(s.enum) > 0 && !contains(s.enum, str) {
+			return fail("invalid value %q (want one of: %s)", str, strings.Join(s.enum, ", "))
+		}
+	case kindInteger:
+		n, ok := v.(float64)
+		if !ok || n != math.Trunc(n) {
+			return fail("expected an integer, got %s", describe(v))
+		}
+	case kindBoolean:
+		if _, ok := v.(bool); !ok {
+			return fail("expected true or false, got %s", describe(v))
+		}
+	case kindDuration:
+		if _, err := parseDuration(v); err != nil {
+			return fail("%v", err)
+		}
+	}
+	if s.check != nil {
+		if err := s.check(v); err != nil {
+			return fail("%v", err)
+		}
+	}
+	return nil
+}
+
+// parseDuration は "1m30s" 形式の文字列または秒数（数値）を時間に変換する
+func parseDuration(v any) (time.Duration, error) {
+	var d time.Duration
+	switch x := v.(type) {
+	case string:
+		var err error
+		if d, err = time.ParseDuration(x); err != nil {
+			return 0, fmt.Errorf("invalid duration %q (e.g. \"30s\", \"2m\")", x)
+		}
+	case float64:
+		d = time.Duration(x * float64(time.Second))
+	default:
+		return 0, fmt.Errorf("expected a duration such as \"30s\", got %s", describe(v))
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("duration must not be negative")
+	}
+	return d, nil
+}
+
+// describe はエラーメッセージ用に値の種類を表す
+func describe(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(x)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	case []any:
+		return "an array"
+	case map[string]any:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// joinPath は JSON パスにキーを連結する。識別子として書けないキーは ["…"] で表す
+func joinPath(path, key string) string {
+	simple := key != ""
+	for _, r := range key {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			simple = false
+			break
+		}
+	}
+	switch {
+	case !simple:
+		return path + "[" + strconv.Quote(key) + "]"
+	case path == "":
+		return key
+	default:
+		return path + "." + key
+	}
+}
+
+// suggest は綴り間違いと思われる名前に候補を添える
+func suggest(name string, candidates []string) string {
+	lower := strings.ToLower(name)
+	for _, c := range candidates {
+		if strings.ToLower(c) == lower {
+			return fmt.Sprintf(" (did you mean %q?)", c)
+		}
+	}
+	return ""
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// projectRestricted はカレントディレクトリの ./.qube.json では信頼しない項目（JSON パス）
+// 取得したリポジトリに置かれた設定で、実行するバイナリ・Q への引数と環境変数・実行ポリシー・
+// 書き込み先を変えられないようにする。trustedProjects に登録したディレクトリでは制限しない
+var projectRestricted = []string{
+	"qBin",
+	"defaultFlags",
+	"profile",
+	"profiles",
+	"passthrough",
+	"policy",
+	"trustedProjects",
+	"history.file",
+	"telemetry.file",
+	"export.dir",
+	"conversations.dir",
+	"conversations.resumeArgs",
+}
+
+// trusted は dir が trusted（~/.qube.json の trustedProjects）に含まれるかを返す
+func trusted(dir string, trusted []any, home string) bool {
+	dir = filepath.Clean(dir)
+	for _, t := range trusted {
+		s, ok := t.(string)
+		if !ok || s == "" {
+			continue
+		}
+		if filepath.Clean(expandHome(s, home)) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// stripRestricted は信頼していないプロジェクトの設定から projectRestricted の項目を取り除き、
+// 取り除いた項目ごとの誤りを返す
+func stripRestricted(layer map[string]any, file string) []error {
+	var errs []error
+	for _, path := range projectRestricted {
+		keys := strings.Split(path, ".")
+		obj := layer
+		for _, k := range keys[:len(keys)-1] {
+			next, ok := obj[k].(map[string]any)
+			if !ok {
+				obj = nil
+				break
+			}
+			obj = next
+		}
+		last := keys[len(keys)-1]
+		if obj == nil {
+			continue
+		}
+		if _, ok := obj[last]; !ok {
+			continue
+		}
+		delete(obj, last)
+		errs = append(errs, &FieldError{File: file, Path: path,
+			Message: "ignored in a project config; set it in ~/.qube.json or add this directory to trustedProjects there"})
+	}
+	return errs
+}
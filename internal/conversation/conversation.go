@@ -0,0 +1,238 @@
+// Package conversation は作業ディレクトリごとに会話の記録を保存し、次回の起動で再開できるようにする
+//
+// 記録は 1 会話 1 ファイルの JSON で、作業ディレクトリごとのサブディレクトリに置く
+// （~/.qube_conversations/<ディレクトリ名>-<ハッシュ>/<ID>.json）。
+// 中身は transcript.Transcript に、一覧の表示に使うメタデータ（タイトル・入力数など）を加えたもの。
+package conversation
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"qube/internal/transcript"
+)
+
+const (
+	// DefaultDirName はホームディレクトリ直下の保存先ディレクトリ名
+	DefaultDirName = ".qube_conversations"
+	// DefaultMax は作業ディレクトリごとに残す会話の既定の件数
+	DefaultMax = 20
+	// titleMax はタイトル（最初の入力）の最大文字数
+	titleMax = 60
+)
+
+// Record は 1 会話分の記録
+type Record struct {
+	ID      string    `json:"id"`
+	Cwd     string    `json:"cwd"`
+	Started time.Time `json:"started"`
+	Updated time.Time `json:"updated"`
+	// Title は最初の入力（1 行に詰めて titleMax 文字まで）
+	Title   string `json:"title"`
+	Prompts int    `json:"prompts"`
+	// Chat は q chat を使ったか（Q 側の会話を再開できるか）
+	Chat       bool                  `json:"chat"`
+	Transcript transcript.Transcript `json:"transcript"`
+}
+
+// DefaultDir は既定の保存先（~/.qube_conversations）を返す
+func DefaultDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve home directory: %w", err)
+	}
+	return filepath.Join(home, DefaultDirName), nil
+}
+
+// NewID は会話の ID を返す（開始時刻とランダムな文字列。ファイル名の順が開始順になる）
+func NewID(now time.Time) string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return now.Format("20060102-150405.000000000")
+	}
+	return now.Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// Store は会話の記録の保存・一覧を行う
+type Store struct {
+	dir string
+	max int
+}
+
+// Open は dir に会話を保存する Store を返す
+// ディレクトリは最初の保存時に作成される。max が 0 以下の場合は DefaultMax を使う
+func Open(dir string, max int) *Store {
+	if max <= 0 {
+		max = DefaultMax
+	}
+	return &Store{dir: dir, max: max}
+}
+
+// Dir は保存先のディレクトリを返す
+func (s *Store) Dir() string { return s.dir }
+
+// dirFor は cwd の会話を置くディレクトリを返す
+// 名前は人が見て分かるようディレクトリ名を先頭に付け、衝突しないようパスのハッシュを加える
+func (s *Store) dirFor(cwd string) string {
+	cwd = filepath.Clean(cwd)
+	sum := sha256.Sum256([]byte(cwd))
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' {
+			return '_'
+		}
+		return r
+	}, filepath.Base(cwd))
+	return filepath.Join(s.dir, name+"-"+hex.EncodeToString(sum[:6]))
+}
+
+// Save は r を保存し、作業ディレクトリごとの上限を超えた古い会話を削除する
+// 一時ファイルに書いてから rename し、途中で落ちても前回の記録を壊さない
+func (s *Store) Save(r Record) error {
+	dir := s.dirFor(r.Cwd)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("save conversation: %w", err)
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("encode conversation: %w", err)
+	}
+	path := filepath.Join(dir, r.ID+".json")
+	tmp, err := os.CreateTemp(dir, r.ID+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("save conversation %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("save conversation %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("save conversation %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("save conversation %s: %w", path, err)
+	}
+	return s.prune(r.Cwd)
+}
+
+// List は cwd の会話を新しい順に返す
+// 読めないファイルや別のディレクトリの記録（ハッシュの衝突）は読み飛ばす
+func (s *Store) List(cwd string) ([]Record, error) {
+	dir := s.dirFor(cwd)
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(b, &r); err != nil || r.ID == "" || filepath.Clean(r.Cwd) != filepath.Clean(cwd) {
+			continue
+		}
+		records = append(records, r)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Updated.After(records[j].Updated) })
+	return records, nil
+}
+
+// prune は cwd の会話のうち、新しいものから上限件数を残して削除する
+func (s *Store) prune(cwd string) error {
+	records, err := s.List(cwd)
+	if err != nil || len(records) <= s.max {
+		return err
+	}
+	for _, r := range records[s.max:] {
+		if err := os.Remove(filepath.Join(s.dirFor(cwd), r.ID+".json")); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("prune conversations: %w", err)
+		}
+	}
+	return nil
+}
+
+// LatestChat は records（新しい順）のうち q chat を使った最新の会話を返す
+// Q の --resume はディレクトリごとの最新の会話しか再開できないため、Q 側の文脈を戻せるのはこの会話だけ
+func LatestChat(records []Record) (Record, bool) {
+	for _, r := range records {
+		if r.Chat {
+			return r, true
+		}
+	}
+	return Record{}, false
+}
+
+// Recorder は Qube の起動ごとの会話を Store に保存する
+// 再開した場合は Continue で元の会話の ID を引き継ぎ、同じ記録を更新する
+type Recorder struct {
+	Store *Store
+	Cwd   string
+
+	mu      sync.Mutex
+	id      string
+	started time.Time
+	base    int // 再開した時点の入力の件数（新しい入力がなければ保存しない）
+}
+
+// NewRecorder は新しい会話として保存する Recorder を返す
+func NewRecorder(store *Store, cwd, id string, started time.Time) *Recorder {
+	return &Recorder{Store: store, Cwd: cwd, id: id, started: started}
+}
+
+// Continue は以降の保存を r の続きとして行う
+func (c *Recorder) Continue(r Record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.id, c.started, c.base = r.ID, r.Started, r.Prompts
+}
+
+// Save は t を会話の記録として保存する
+// 入力が 1 件もない場合や、再開してから新しい入力がない場合は保存しない（更新日時を変えない）
+func (c *Recorder) Save(t transcript.Transcript) error {
+	c.mu.Lock()
+	id, started, base := c.id, c.started, c.base
+	c.mu.Unlock()
+	if t.Prompts() <= base {
+		return nil
+	}
+
+	t.Cwd = c.Cwd
+	r := Record{ID: id, Cwd: c.Cwd, Started: started, Updated: t.Exported, Prompts: t.Prompts(), Transcript: t}
+	for _, turn := range t.Turns {
+		if turn.Mode == transcript.ModeChat {
+			r.Chat = true
+		}
+		if r.Title == "" && turn.Prompt != "" && turn.Mode != transcript.ModeQube {
+			r.Title = title(turn.Prompt)
+		}
+	}
+	if r.Title == "" {
+		for _, turn := range t.Turns {
+			if turn.Prompt != "" {
+				r.Title = title(turn.Prompt)
+				break
+			}
+		}
+	}
+	return c.Store.Save(r)
+}
+
+// title は入力を 1 行に詰め、長ければ切り詰める
+func title(prompt string) string {
+	s := strings.Join(strings.Fields(prompt), " ")
+	if r := []rune(s); len(r) > titleMax {
+		return string(r[:titleMax-1]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,116 @@
+package conversation
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"qube/internal/transcript"
+)
+
+// chat は入力 prompts を持つ記録を返す（"/" で始まる入力はスラッシュコマンドとする）
+func chat(now time.Time, mode string, prompts ...string) transcript.Transcript {
+	t := transcript.Transcript{Version: transcript.FormatVersion, Exported: now}
+	for _, p := range prompts {
+		m := mode
+		if strings.HasPrefix(p, "/") {
+			m = transcript.ModeQube
+		}
+		t.Turns = append(t.Turns, transcript.Turn{Prompt: p, Mode: m, Started: now, Status: "done"})
+	}
+	return t
+}
+
+func TestRecorder_SaveAndList(t *testing.T) {
+	store := Open(t.TempDir(), 0)
+	t0 := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	cwd, other := "/work/app", "/work/other"
+
+	first := NewRecorder(store, cwd, "a", t0)
+	// 入力のない会話は保存しない
+	if err := first.Save(chat(t0, "")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.List(cwd); len(got) != 0 {
+		t.Fatalf("empty conversation should not be saved: %+v", got)
+	}
+	if err := first.Save(chat(t0, transcript.ModeChat, "/profile", "explain   this\nrepo")); err != nil {
+		t.Fatal(err)
+	}
+	second := NewRecorder(store, cwd, "b", t0.Add(time.Hour))
+	if err := second.Save(chat(t0.Add(time.Hour), transcript.ModeCommand, "ls")); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewRecorder(store, other, "c", t0).Save(chat(t0, transcript.ModeChat, "hi")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.List(cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != "b" || got[1].ID != "a" {
+		t.Fatalf("conversations should be listed newest first per directory: %+v", got)
+	}
+	// タイトルはスラッシュコマンドを除いた最初の入力を 1 行に詰めたもの
+	a := got[1]
+	if a.Title != "explain this repo" || a.Prompts != 2 || !a.Chat || a.Transcript.Cwd != cwd {
+		t.Fatalf("record: %+v", a)
+	}
+	if latest, ok := LatestChat(got); !ok || latest.ID != "a" {
+		t.Fatalf("latest chat: %+v", latest)
+	}
+
+	// 再開した会話は同じ記録を更新し、新しい入力がなければ更新しない
+	second.Continue(a)
+	if err := second.Save(chat(t0.Add(2*time.Hour), transcript.ModeChat, "/profile", "explain this repo")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = store.List(cwd); got[0].ID != "b" {
+		t.Fatalf("resume without new input should not touch the record: %+v", got)
+	}
+	if err := second.Save(chat(t0.Add(2*time.Hour), transcript.ModeChat, "/profile", "explain this repo", "more")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = store.List(cwd); len(got) != 2 || got[0].ID != "a" || got[0].Prompts != 3 || !got[0].Started.Equal(t0) {
+		t.Fatalf("resumed conversation should be updated in place: %+v", got)
+	}
+}
+
+func TestStore_PrunesOldConversations(t *testing.T) {
+	dir := t.TempDir()
+	store := Open(dir, 2)
+	t0 := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, id := range []string{"a", "b", "c"} {
+		now := t0.Add(time.Duration(i) * time.Minute)
+		if err := NewRecorder(store, "/work", id, now).Save(chat(now, transcript.ModeChat, id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, _ := store.List("/work")
+	if len(got) != 2 || got[0].ID != "c" || got[1].ID != "b" {
+		t.Fatalf("only the newest conversations should be kept: %+v", got)
+	}
+
+	// 壊れたファイルは読み飛ばす
+	sub := filepath.Dir(filepath.Join(store.dirFor("/work"), "x"))
+	if !strings.HasPrefix(filepath.Base(sub), "work-") {
+		t.Fatalf("directory name should start with the base name: %s", sub)
+	}
+	if err := os.WriteFile(filepath.Join(sub, "broken.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.List("/work"); err != nil || len(got) != 2 {
+		t.Fatalf("broken files should be skipped: %v %+v", err, got)
+	}
+}
+
+func TestNewID(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	a, b := NewID(now), NewID(now)
+	if !strings.HasPrefix(a, "20250102-030405-") || a == b {
+		t.Fatalf("ids: %s %s", a, b)
+	}
+}
@@ -0,0 +1,94 @@
+package doctor
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ChatSession は試験起動する chat セッションの操作（session.Session が実装する）
+type ChatSession interface {
+	Start(mode string) error
+	Stop() error
+	Model() string
+	InitDetection() string
+}
+
+// exitGrace はエラーの通知後に終了の通知を待つ時間
+// Linux では子プロセスが PTY を閉じると、終了コードより先に読み込みエラー（EIO）が届く
+const exitGrace = time.Second
+
+// ChatProbe は q chat を起動し、初期化完了までの時間を測る
+// セッションのコールバック（OnInitialized / OnExit / OnError）から
+// Initialized / Exited / Failed を呼び出す
+type ChatProbe struct {
+	sess ChatSession
+
+	initOnce    sync.Once
+	initialized chan struct{}
+	exited      chan int
+	failed      chan error
+}
+
+// NewChatProbe は ChatProbe を作成する
+func NewChatProbe(sess ChatSession) *ChatProbe {
+	return &ChatProbe{
+		sess:        sess,
+		initialized: make(chan struct{}),
+		exited:      make(chan int, 1),
+		failed:      make(chan error, 1),
+	}
+}
+
+// Initialized はセッションの初期化完了を通知する
+func (p *ChatProbe) Initialized() { p.initOnce.Do(func() { close(p.initialized) }) }
+
+// Exited はセッションの終了を通知する
+func (p *ChatProbe) Exited(code int) {
+	select {
+	case p.exited <- code:
+	default:
+	}
+}
+
+// Failed はセッションのエラーを通知する
+func (p *ChatProbe) Failed(err error) {
+	select {
+	case p.failed <- err:
+	default:
+	}
+}
+
+// Run はセッションを起動し、初期化完了・終了・失敗・ctx の期限のいずれかまで待つ
+// セッションは戻る前に停止する
+func (p *ChatProbe) Run(ctx context.Context) ChatResult {
+	started := time.Now()
+	if err := p.sess.Start("chat"); err != nil {
+		return ChatResult{Elapsed: time.Since(started), Err: err}
+	}
+	defer p.sess.Stop()
+
+	var r ChatResult
+	select {
+	case <-p.initialized:
+		r.Detection = p.sess.InitDetection()
+		r.Model = p.sess.Model()
+	case code := <-p.exited:
+		r.Exited, r.ExitCode = true, code
+	case err := <-p.failed:
+		r.Err, r.Elapsed = err, time.Since(started)
+		timer := time.NewTimer(exitGrace)
+		defer timer.Stop()
+		select {
+		case code := <-p.exited:
+			r.Err, r.Exited, r.ExitCode = nil, true, code
+		case <-timer.C:
+		}
+	case <-ctx.Done():
+		r.Err = ctx.Err()
+	}
+	if r.Elapsed == 0 {
+		r.Elapsed = time.Since(started)
+	}
+	return r
+}
@@ -0,0 +1,350 @@
+// Package doctor は qube doctor の診断項目を組み立てる
+//
+// 各診断は Check を 1 件返し、結果（pass / warn / fail / skip）と詳細、
+// 問題がある場合の対処方法（Hint）を持つ。診断に必要な情報の収集（Q CLI の検出、
+// PTY の確保、q chat の試験起動など）は呼び出し側で行い、このパッケージは判定だけを受け持つ。
+package doctor
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/muesli/termenv"
+	"qube/internal/qcli"
+	"qube/internal/qcompat"
+)
+
+// Status は診断の結果
+type Status string
+
+const (
+	Pass Status = "pass"
+	Warn Status = "warn"
+	Fail Status = "fail"
+	Skip Status = "skip" // 診断しなかった（設定されていない・--no-chat など）
+)
+
+// Check は 1 つの診断項目の結果
+type Check struct {
+	Name    string   `json:"name"`
+	Status  Status   `json:"status"`
+	Summary string   `json:"summary"`
+	Details []string `json:"details,omitempty"`
+	// Hint は warn / fail の場合の対処方法
+	Hint string `json:"hint,omitempty"`
+}
+
+// Report は全ての診断結果
+type Report struct {
+	Checks []Check `json:"checks"`
+}
+
+// Count は status の診断の数を返す
+func (r Report) Count(status Status) int {
+	n := 0
+	for _, c := range r.Checks {
+		if c.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed は fail の診断が 1 つでもあるかを返す
+func (r Report) Failed() bool { return r.Count(Fail) > 0 }
+
+// QCLI は Q CLI の検出結果を診断する
+func QCLI(res qcli.Result) Check {
+	c := Check{Name: "Q CLI"}
+	for _, cand := range res.Candidates {
+		if cand.Path == "" && cand.Source != qcli.SourceExplicit {
+			continue // 見つからなかった自動検出の候補は表示しない
+		}
+		c.Details = append(c.Details, describeCandidate(cand, cand.Path == res.Selected.Path && cand.Source == res.Selected.Source))
+	}
+
+	sel := res.Selected
+	switch {
+	case res.Err != nil && sel.Path == "":
+		c.Status = Fail
+		c.Summary = res.Err.Error()
+		if sel.Source == qcli.SourceExplicit {
+			c.Hint = fmt.Sprintf("fix %s or unset it to use automatic discovery", orDefault(sel.Via, "qBin"))
+		} else {
+			c.Hint = "install Amazon Q Developer CLI (https://aws.amazon.com/q/developer/) or set qBin / --q-bin to its path"
+		}
+	case res.Err != nil:
+		c.Status = Fail
+		c.Summary = fmt.Sprintf("%s is not usable: %v", sel.Path, res.Err)
+		c.Hint = "run '" + sel.Path + " --version' to check the installation, or point qBin / --q-bin to a working binary"
+	default:
+		c.Status = Pass
+		c.Summary = fmt.Sprintf("q %s at %s (%s)", sel.Version, sel.Path, describeSource(sel))
+		if v := otherVersions(res); len(v) > 0 {
+			c.Status = Warn
+			c.Hint = "several Q CLI versions are installed (" + strings.Join(v, ", ") + "); remove the stale ones or set qBin to pin one"
+		}
+	}
+	return c
+}
+
+// otherVersions は使用するバイナリと異なるバージョンの候補を返す
+func otherVersions(res qcli.Result) []string {
+	var out []string
+	for _, cand := range res.Candidates {
+		if cand.Usable() && cand.Version != res.Selected.Version {
+			out = append(out, cand.Version+" at "+cand.Path)
+		}
+	}
+	return out
+}
+
+func describeCandidate(c qcli.Candidate, selected bool) string {
+	mark := " "
+	if selected {
+		mark = "*"
+	}
+	state := c.Version
+	if c.Err != nil {
+		state = "error: " + c.Err.Error()
+	}
+	where := c.Path
+	if where == "" {
+		where = c.Name
+	}
+	return fmt.Sprintf("%s %s [%s] %s", mark, where, describeSource(c), state)
+}
+
+func describeSource(c qcli.Candidate) string {
+	if c.Source == qcli.SourceExplicit && c.Via != "" {
+		return string(c.Source) + " via " + c.Via
+	}
+	return string(c.Source)
+}
+
+// Compat は Q CLI のバージョンに対して選んだ出力の解釈パターンを診断する
+// バージョンが不明または対応表の範囲外なら、最も近いプロファイルで代用しているため警告する
+func Compat(res qcompat.Resolution) Check {
+	c := Check{Name: "Compatibility"}
+	for _, o := range res.Overrides {
+		c.Details = append(c.Details, "parserOverrides applied for versions "+o)
+	}
+	c.Hint = "if the banner, spinner or prompt is misread, add parserOverrides for this version in .qube.json (see docs/compatibility.md)"
+	if res.OutOfRange {
+		c.Status = Warn
+		c.Summary = res.Warning()
+		return c
+	}
+	c.Status = Pass
+	c.Summary = fmt.Sprintf("q %s uses parser profile %s", res.Version, res.Profile)
+	return c
+}
+
+// PTY は疑似端末を確保できるかを診断する（openErr は確保を試みた結果）
+func PTY(openErr error) Check {
+	c := Check{Name: "PTY"}
+	if openErr != nil {
+		c.Status = Fail
+		c.Summary = "cannot allocate a pseudo-terminal: " + openErr.Error()
+		c.Hint = "Qube runs q chat in a PTY; check that /dev/ptmx is accessible (in containers, mount devpts or run with a TTY)"
+		return c
+	}
+	c.Status = Pass
+	c.Summary = "pseudo-terminal allocated"
+	return c
+}
+
+// Terminal は端末の情報
+type Terminal struct {
+	Term      string // TERM
+	ColorTerm string // COLORTERM
+	NoColor   bool   // NO_COLOR が設定されている
+	Profile   termenv.Profile
+	StdinTTY  bool
+	StdoutTTY bool
+}
+
+// TerminalCheck は TERM と色の表示能力を診断する
+func TerminalCheck(t Terminal) Check {
+	c := Check{Name: "Terminal", Status: Pass}
+	c.Summary = fmt.Sprintf("TERM=%s, %s", orDefault(t.Term, "(unset)"), profileName(t.Profile))
+	if t.ColorTerm != "" {
+		c.Details = append(c.Details, "COLORTERM="+t.ColorTerm)
+	}
+	if t.NoColor {
+		c.Details = append(c.Details, "NO_COLOR is set: the monochrome theme is used")
+	}
+	c.Details = append(c.Details, "q chat runs with TERM=xterm-256color regardless of this terminal")
+
+	var hints []string
+	if !t.StdinTTY || !t.StdoutTTY {
+		c.Status = Warn
+		c.Details = append(c.Details, "stdin or stdout is not a terminal")
+		hints = append(hints, "the full-screen UI needs an interactive terminal; use 'qube ask' in scripts and pipes")
+	}
+	switch {
+	case t.Term == "" || t.Term == "dumb":
+		c.Status = Warn
+		hints = append(hints, "set TERM to your terminal type (e.g. xterm-256color)")
+	case t.Profile == termenv.Ascii && !t.NoColor && t.StdoutTTY:
+		c.Status = Warn
+		hints = append(hints, "this terminal reports no color support; set COLORTERM=truecolor or TERM=xterm-256color if it can show colors")
+	}
+	c.Hint = strings.Join(hints, "; ")
+	return c
+}
+
+func profileName(p termenv.Profile) string {
+	switch p {
+	case termenv.TrueColor:
+		return "true color"
+	case termenv.ANSI256:
+		return "256 colors"
+	case termenv.ANSI:
+		return "16 colors"
+	}
+	return "no color"
+}
+
+// Config は読み込んだ設定ファイルと検査結果を診断する
+func Config(sources []string, loadErr error) Check {
+	c := Check{Name: "Config"}
+	for _, s := range sources {
+		c.Details = append(c.Details, "read "+s)
+	}
+	if loadErr != nil {
+		c.Status = Fail
+		problems := strings.Split(loadErr.Error(), "\n")
+		c.Summary = fmt.Sprintf("%d problem(s); invalid files and values are ignored", len(problems))
+		c.Details = append(c.Details, problems...)
+		c.Hint = "fix the reported fields ('qube config' prints the effective configuration); see docs/configuration.md"
+		return c
+	}
+	c.Status = Pass
+	if len(sources) == 0 {
+		c.Summary = "no config file, using defaults"
+	} else {
+		c.Summary = fmt.Sprintf("%d file(s), valid", len(sources))
+	}
+	return c
+}
+
+// File は name の記録先 path への書き込みを診断する
+// private が true の場合、他のユーザーが読めるファイルを警告する（プロンプトなどを含むため）
+// path が空の場合は記録しない設定として skip を返す
+func File(name, path string, private bool) Check {
+	c := Check{Name: name}
+	if path == "" {
+		c.Status = Skip
+		c.Summary = "not configured"
+		return c
+	}
+
+	st, err := os.Stat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		dir := filepath.Dir(path)
+		if err := dirWritable(dir); err != nil {
+			c.Status = Fail
+			c.Summary = fmt.Sprintf("%s does not exist and cannot be created: %v", path, err)
+			c.Hint = "create " + dir + " or choose another path"
+			return c
+		}
+		c.Status = Pass
+		c.Summary = path + " (will be created)"
+		return c
+	case err != nil:
+		c.Status = Fail
+		c.Summary = err.Error()
+		c.Hint = "check the permissions of " + filepath.Dir(path)
+		return c
+	case st.IsDir():
+		c.Status = Fail
+		c.Summary = path + " is a directory"
+		c.Hint = "point the setting to a file"
+		return c
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		c.Status = Fail
+		c.Summary = fmt.Sprintf("%s is not writable: %v", path, err)
+		c.Hint = "chmod u+rw " + path
+		return c
+	}
+	f.Close()
+
+	c.Status = Pass
+	c.Summary = fmt.Sprintf("%s (%s, %d bytes)", path, st.Mode().Perm(), st.Size())
+	if private && st.Mode().Perm()&0o077 != 0 {
+		c.Status = Warn
+		c.Hint = "the file is readable by other users; chmod 600 " + path
+	}
+	return c
+}
+
+// dirWritable は dir にファイルを作成できるかを調べる
+func dirWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".qube-doctor-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+// ChatResult は q chat の試験起動の結果
+type ChatResult struct {
+	Elapsed time.Duration // 起動から初期化完了（または終了・失敗）まで
+	// Detection は初期化完了の判定方法（"banner" / "timeout"。完了しなかった場合は空）
+	Detection string
+	Model     string
+	Exited    bool // 初期化完了の前に終了した
+	ExitCode  int
+	Err       error // 起動の失敗・待ちきれなかった場合など
+}
+
+// Chat は q chat の試験起動の結果を診断する（initTimeout は初期化検知を待つ時間の設定）
+func Chat(r ChatResult, initTimeout time.Duration) Check {
+	c := Check{Name: "q chat startup"}
+	elapsed := r.Elapsed.Round(10 * time.Millisecond)
+	switch {
+	case r.Err != nil:
+		c.Status = Fail
+		c.Summary = "q chat did not start: " + r.Err.Error()
+		c.Hint = "run 'q chat' directly to see the error; 'qube --trace-pty --log-file /tmp/qube.log' records what Qube received"
+	case r.Exited:
+		c.Status = Fail
+		c.Summary = fmt.Sprintf("q chat exited with code %d after %s before it became ready", r.ExitCode, elapsed)
+		c.Hint = "run 'q chat' directly; you may need to sign in with 'q login'"
+	case r.Detection == "timeout":
+		c.Status = Warn
+		c.Summary = fmt.Sprintf("startup banner not detected; assumed ready after the %s init timeout", initTimeout)
+		c.Hint = "the Q CLI output may have changed; capture it with 'qube --trace-pty --log-file /tmp/qube.log' and report it"
+	default:
+		c.Status = Pass
+		c.Summary = "ready in " + elapsed.String()
+		if r.Model != "" {
+			c.Summary += ", model " + r.Model
+		}
+		// 既定の待ち時間の半分を超える場合は、遅い環境でタイムアウト扱いになりやすい
+		if r.Elapsed > initTimeout/2 {
+			c.Status = Warn
+			c.Hint = fmt.Sprintf("startup is close to the %s init timeout; raise timeouts.init in .qube.json", initTimeout)
+		}
+	}
+	return c
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
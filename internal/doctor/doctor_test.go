@@ -0,0 +1,196 @@
+package doctor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muesli/termenv"
+	"qube/internal/qcli"
+	"qube/internal/qcompat"
+)
+
+func TestQCLI(t *testing.T) {
+	sel := qcli.Candidate{Name: "q", Source: qcli.SourcePath, Path: "/usr/local/bin/q", Version: "1.12.1"}
+	c := QCLI(qcli.Result{Selected: sel, Candidates: []qcli.Candidate{
+		{Name: "amazonq", Source: qcli.SourcePath, Err: errors.New("not found")},
+		sel,
+	}})
+	if c.Status != Pass || !strings.Contains(c.Summary, "1.12.1") || len(c.Details) != 1 {
+		t.Fatalf("single binary: %+v", c)
+	}
+
+	// 異なるバージョンが並存していれば警告する
+	old := qcli.Candidate{Name: "/usr/bin/q", Source: qcli.SourceWellKnown, Path: "/usr/bin/q", Version: "1.9.0"}
+	c = QCLI(qcli.Result{Selected: sel, Candidates: []qcli.Candidate{sel, old}})
+	if c.Status != Warn || !strings.Contains(c.Hint, "1.9.0 at /usr/bin/q") {
+		t.Fatalf("mixed versions: %+v", c)
+	}
+
+	c = QCLI(qcli.Result{Err: qcli.ErrNotFound})
+	if c.Status != Fail || c.Hint == "" {
+		t.Fatalf("not found: %+v", c)
+	}
+
+	bad := qcli.Candidate{Name: "/opt/q", Source: qcli.SourceExplicit, Via: "--q-bin", Err: errors.New("not executable")}
+	c = QCLI(qcli.Result{Selected: bad, Candidates: []qcli.Candidate{bad}, Err: bad.Err})
+	if c.Status != Fail || !strings.Contains(c.Hint, "--q-bin") || !strings.Contains(c.Details[0], "explicit via --q-bin") {
+		t.Fatalf("explicit: %+v", c)
+	}
+}
+
+func TestCompat(t *testing.T) {
+	res, _ := qcompat.Resolve("1.12.1", []qcompat.Override{{Versions: "1.12", Spec: qcompat.Spec{Prompt: `^λ$`}}})
+	c := Compat(res)
+	if c.Status != Pass || !strings.Contains(c.Summary, res.Profile) || len(c.Details) != 1 {
+		t.Fatalf("known version: %+v", c)
+	}
+	res, _ = qcompat.Resolve("9.0.0", nil)
+	if c := Compat(res); c.Status != Warn || !strings.Contains(c.Summary, "9.0.0 is outside the known range") {
+		t.Fatalf("newer version: %+v", c)
+	}
+	res, _ = qcompat.Resolve("", nil)
+	if c := Compat(res); c.Status != Warn || !strings.Contains(c.Hint, "parserOverrides") {
+		t.Fatalf("unknown version: %+v", c)
+	}
+}
+
+func TestTerminalCheck(t *testing.T) {
+	ok := Terminal{Term: "xterm-256color", Profile: termenv.ANSI256, StdinTTY: true, StdoutTTY: true}
+	if c := TerminalCheck(ok); c.Status != Pass || c.Summary != "TERM=xterm-256color, 256 colors" {
+		t.Fatalf("good terminal: %+v", c)
+	}
+
+	dumb := ok
+	dumb.Term = "dumb"
+	if c := TerminalCheck(dumb); c.Status != Warn || !strings.Contains(c.Hint, "TERM") {
+		t.Fatalf("dumb terminal: %+v", c)
+	}
+
+	// NO_COLOR による色なしは意図した設定なので警告しない
+	noColor := ok
+	noColor.Profile, noColor.NoColor = termenv.Ascii, true
+	if c := TerminalCheck(noColor); c.Status != Pass {
+		t.Fatalf("NO_COLOR: %+v", c)
+	}
+
+	piped := ok
+	piped.StdoutTTY = false
+	if c := TerminalCheck(piped); c.Status != Warn || !strings.Contains(c.Hint, "qube ask") {
+		t.Fatalf("piped: %+v", c)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	if c := Config(nil, nil); c.Status != Pass || !strings.Contains(c.Summary, "defaults") {
+		t.Fatalf("no config: %+v", c)
+	}
+	err := errors.Join(errors.New("a.json: theme: unknown"), errors.New("a.json: timeouts.idle: bad"))
+	c := Config([]string{"/home/u/.qube.json"}, err)
+	if c.Status != Fail || !strings.HasPrefix(c.Summary, "2 problem") || len(c.Details) != 3 {
+		t.Fatalf("invalid config: %+v", c)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	if c := File("Log file", "", true); c.Status != Skip {
+		t.Fatalf("empty path: %+v", c)
+	}
+	if c := File("History", filepath.Join(dir, "history"), true); c.Status != Pass || !strings.Contains(c.Summary, "will be created") {
+		t.Fatalf("missing file: %+v", c)
+	}
+	if c := File("History", filepath.Join(dir, "no", "such", "history"), true); c.Status != Fail {
+		t.Fatalf("missing dir: %+v", c)
+	}
+
+	path := filepath.Join(dir, "shared")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if c := File("History", path, true); c.Status != Warn || !strings.Contains(c.Hint, "chmod 600") {
+		t.Fatalf("world-readable private file: %+v", c)
+	}
+	if c := File("Telemetry", path, false); c.Status != Pass {
+		t.Fatalf("world-readable non-private file: %+v", c)
+	}
+	if c := File("History", dir, true); c.Status != Fail {
+		t.Fatalf("directory: %+v", c)
+	}
+}
+
+func TestChat(t *testing.T) {
+	initTimeout := 10 * time.Second
+	cases := []struct {
+		name string
+		r    ChatResult
+		want Status
+	}{
+		{"banner", ChatResult{Elapsed: time.Second, Detection: "banner", Model: "claude-sonnet-4"}, Pass},
+		{"slow", ChatResult{Elapsed: 7 * time.Second, Detection: "banner"}, Warn},
+		{"timeout", ChatResult{Elapsed: initTimeout, Detection: "timeout"}, Warn},
+		{"exited", ChatResult{Exited: true, ExitCode: 1}, Fail},
+		{"error", ChatResult{Err: context.DeadlineExceeded}, Fail},
+	}
+	for _, tc := range cases {
+		c := Chat(tc.r, initTimeout)
+		if c.Status != tc.want {
+			t.Errorf("%s: status %s, want %s (%+v)", tc.name, c.Status, tc.want, c)
+		}
+		if c.Status != Pass && c.Hint == "" {
+			t.Errorf("%s: missing hint", tc.name)
+		}
+	}
+}
+
+// fakeChat は ChatSession のテスト用実装
+type fakeChat struct {
+	start     func()
+	detection string
+	stopped   bool
+}
+
+func (f *fakeChat) Start(string) error {
+	go f.start()
+	return nil
+}
+func (f *fakeChat) Stop() error           { f.stopped = true; return nil }
+func (f *fakeChat) Model() string         { return "claude-sonnet-4" }
+func (f *fakeChat) InitDetection() string { return f.detection }
+
+func TestChatProbe(t *testing.T) {
+	sess := &fakeChat{detection: "banner"}
+	p := NewChatProbe(sess)
+	sess.start = p.Initialized
+	r := p.Run(context.Background())
+	if r.Detection != "banner" || r.Model != "claude-sonnet-4" || r.Err != nil || !sess.stopped {
+		t.Fatalf("initialized: %+v stopped=%v", r, sess.stopped)
+	}
+
+	// PTY の読み込みエラーの直後に終了した場合は終了コードを報告する
+	sess = &fakeChat{}
+	p = NewChatProbe(sess)
+	sess.start = func() {
+		p.Failed(errors.New("read /dev/ptmx: input/output error"))
+		p.Exited(1)
+	}
+	r = p.Run(context.Background())
+	if !r.Exited || r.ExitCode != 1 || r.Err != nil {
+		t.Fatalf("exited: %+v", r)
+	}
+
+	sess = &fakeChat{start: func() {}}
+	p = NewChatProbe(sess)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if r := p.Run(ctx); !errors.Is(r.Err, context.DeadlineExceeded) {
+		t.Fatalf("deadline: %+v", r)
+	}
+}
@@ -1,73 +1,212 @@
 package execq
 
 import (
-    "bytes"
     "context"
     "errors"
+    "os"
     "os/exec"
+    "sync"
     "time"
+
+    "qube/internal/qcli"
 )
 
+// ErrIdleTimeout は出力が IdleTimeout の間途絶えたためにコマンドを停止した場合のエラー
+var ErrIdleTimeout = errors.New("no output within idle timeout")
+
+// Options は短命コマンド実行時の追加設定
+type Options struct {
+    // IdleTimeout は出力が途絶えてから停止するまでの時間（0 で無効）
+    IdleTimeout time.Duration
+    // Env はプロセスの環境変数に追加する "KEY=value"（プロファイルの AWS_PROFILE など）
+    Env []string
+}
+
+// MaxOutputBytes は Result.Output に保持する出力の上限バイト数
+// 上限を超えた分は破棄し、Result.Truncated を立てる
+var MaxOutputBytes = 1 << 20
+
+// Result は短命コマンド 1 回分の実行記録
+type Result struct {
+    Argv      []string  // 実行した引数（"q" は検出済みバイナリパスへ置換前の値）
+    Output    string    // 結合出力(stdout+stderr)。MaxOutputBytes で切り詰められる
+    ExitCode  int       // 終了コード。起動失敗・タイムアウト時は -1
+    StartedAt time.Time // 開始時刻
+    EndedAt   time.Time // 終了時刻
+    Bytes     int64     // プロセスが出力した総バイト数（切り詰め前）
+    Truncated bool      // Output が切り詰められたか
+    TimedOut  bool      // タイムアウト（全体またはアイドル）で強制終了したか
+}
+
+// Duration は実行に要した時間を返す
+func (r Result) Duration() time.Duration {
+    return r.EndedAt.Sub(r.StartedAt)
+}
+
+// killGrace は強制終了したプロセスの出力が閉じるのを待つ上限
+// 子プロセスが出力を開いたまま残っていても、この時間で打ち切ってそれまでの出力を返す
+const killGrace = 500 * time.Millisecond
+
+// limitedBuffer は上限まで出力を保持し、総バイト数を数える io.Writer
+// onWrite は書き込みのたびに呼ばれる（アイドル監視のリセットに使う）
+// タイムアウト時は書き込み中でも fill で読み出すため mu で保護する
+type limitedBuffer struct {
+    mu      sync.Mutex
+    buf     []byte
+    limit   int
+    total   int64
+    onWrite func()
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+    if b.onWrite != nil {
+        b.onWrite()
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.total += int64(len(p))
+    if room := b.limit - len(b.buf); room > 0 {
+        if len(p) > room {
+            b.buf = append(b.buf, p[:room]...)
+        } else {
+            b.buf = append(b.buf, p...)
+        }
+    }
+    return len(p), nil
+}
+
+// fill はそれまでの出力を res に記録する
+func (b *limitedBuffer) fill(res *Result) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    res.Output = string(b.buf)
+    res.Bytes = b.total
+    res.Truncated = b.total > int64(len(b.buf))
+}
+
 // Run は短命コマンドを実行し、結合出力(stdout+stderr)、終了コード、エラーを返す。
 // タイムアウト時は ("", -1, context.DeadlineExceeded) を返す。
 func Run(args []string, timeout time.Duration) (string, int, error) {
-    if len(args) == 0 {
-        return "", -1, errors.New("no command provided")
+    res, err := Exec(args, timeout)
+    if res.TimedOut {
+        return "", -1, err
     }
+    return res.Output, res.ExitCode, err
+}
+
+// Exec は短命コマンドを実行し、実行記録を返す。
+// タイムアウト時は TimedOut を立て、context.DeadlineExceeded を返す。
+func Exec(args []string, timeout time.Duration) (Result, error) {
     ctx, cancel := context.WithTimeout(context.Background(), timeout)
     defer cancel()
+    return ExecContext(ctx, args, Options{})
+}
+
+// ExecContext は ctx の期限に従って短命コマンドを実行し、実行記録を返す。
+// 期限切れ時は context.DeadlineExceeded、アイドルタイムアウト時は ErrIdleTimeout を返す。
+// タイムアウト時も、停止までの出力は Output / Bytes / Truncated に残す。
+func ExecContext(ctx context.Context, args []string, opts Options) (Result, error) {
+    res := Result{Argv: args, ExitCode: -1, StartedAt: time.Now()}
+    if len(args) == 0 {
+        res.EndedAt = res.StartedAt
+        return res, errors.New("no command provided")
+    }
+
+    cmd := exec.Command(args[0], args[1:]...)
+    if len(opts.Env) > 0 {
+        cmd.Env = append(os.Environ(), opts.Env...)
+    }
 
-    cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+    // stdout/stderr の書き込みは exec パッケージが同一 Writer なら直列化する
+    buf := &limitedBuffer{limit: MaxOutputBytes}
+    cmd.Stdout = buf
+    cmd.Stderr = buf
 
-    var buf bytes.Buffer
-    cmd.Stdout = &buf
-    cmd.Stderr = &buf
+    // アイドル監視: 出力があるたびにタイマーをリセットする
+    idle := make(chan struct{})
+    if opts.IdleTimeout > 0 {
+        timer := time.AfterFunc(opts.IdleTimeout, func() { close(idle) })
+        defer timer.Stop()
+        buf.onWrite = func() { timer.Reset(opts.IdleTimeout) }
+    }
 
     if err := cmd.Start(); err != nil {
-        return "", -1, err
+        res.EndedAt = time.Now()
+        return res, err
     }
 
     done := make(chan error, 1)
     go func() { done <- cmd.Wait() }()
 
+    // kill はタイムアウトしたプロセスを止め、出力が閉じるのを（killGrace まで）待ってから途中までの出力を記録する
+    kill := func(err error) (Result, error) {
+        _ = cmd.Process.Kill()
+        res.EndedAt = time.Now()
+        select {
+        case <-done:
+        case <-time.After(killGrace):
+        }
+        buf.fill(&res)
+        res.TimedOut = true
+        return res, err
+    }
+
     select {
     case <-ctx.Done():
-        // タイムアウト: Kill して即時リターン
-        _ = cmd.Process.Kill()
-        return "", -1, context.DeadlineExceeded
+        return kill(ctx.Err())
+    case <-idle:
+        return kill(ErrIdleTimeout)
     case err := <-done:
-        out := buf.String()
+        res.EndedAt = time.Now()
+        buf.fill(&res)
         if err == nil {
-            return out, 0, nil
+            res.ExitCode = 0
+            return res, nil
         }
         if exitErr, ok := err.(*exec.ExitError); ok {
-            return out, exitErr.ExitCode(), err
+            res.ExitCode = exitErr.ExitCode()
+            return res, err
         }
         // 上記以外のエラー（起動失敗など）
-        return out, -1, err
+        return res, err
     }
 }
 
 // RunQ はAmazon Q CLIコマンドを実行する
 // args[0]が"q"の場合、実際のQ CLIバイナリパスに置き換える
 func RunQ(args []string, timeout time.Duration) (string, int, error) {
-    if len(args) == 0 {
-        return "", -1, errors.New("no command provided")
+    res, err := ExecQ(args, timeout)
+    if res.TimedOut {
+        return "", -1, err
     }
+    return res.Output, res.ExitCode, err
+}
 
-    // Q CLIコマンドの場合、バイナリパスを検出して置き換え
-    if args[0] == "q" {
-        qPath, err := DetectQCLI()
-        if err != nil {
-            return "", -1, err
-        }
-        // args[0]をQ CLIバイナリパスに置き換え
-        newArgs := make([]string, len(args))
-        copy(newArgs, args)
-        newArgs[0] = qPath
-        return Run(newArgs, timeout)
+// ExecQ はAmazon Q CLIコマンドを実行し、実行記録を返す
+// args[0]が"q"の場合、実際のQ CLIバイナリパスに置き換えて実行する（Result.Argv は置換前の値）
+func ExecQ(args []string, timeout time.Duration) (Result, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+    return ExecQContext(ctx, args, Options{})
+}
+
+// ExecQContext は ctx の期限に従って ExecQ と同様に実行する
+func ExecQContext(ctx context.Context, args []string, opts Options) (Result, error) {
+    if len(args) == 0 || args[0] != "q" {
+        // Q CLI以外のコマンドはそのまま実行
+        return ExecContext(ctx, args, opts)
     }
 
-    // Q CLI以外のコマンドはそのまま実行
-    return Run(args, timeout)
-}
\ No newline at end of file
+    // Q CLIコマンドの場合、バイナリパスを検出して置き換え（検出結果は qcli がキャッシュする）
+    qPath, err := qcli.Path()
+    if err != nil {
+        now := time.Now()
+        return Result{Argv: args, ExitCode: -1, StartedAt: now, EndedAt: now}, err
+    }
+    newArgs := make([]string, len(args))
+    copy(newArgs, args)
+    newArgs[0] = qPath
+    res, err := ExecContext(ctx, newArgs, opts)
+    res.Argv = args
+    return res, err
+}
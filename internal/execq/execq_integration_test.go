@@ -1,16 +1,17 @@
 package execq
 
 import (
-	"os"
 	"strings"
 	"testing"
 	"time"
+
+	"qube/internal/qcli"
 )
 
 // TestRunQ_ActualQCLI は実際のQ CLIを使用した統合テスト
 func TestRunQ_ActualQCLI(t *testing.T) {
 	// Q CLIが存在しない場合はスキップ
-	if _, err := DetectQCLI(); err != nil {
+	if _, err := qcli.Path(); err != nil {
 		t.Skipf("Q CLI not found: %v", err)
 	}
 
@@ -34,18 +35,16 @@ func TestRunQ_ActualQCLI(t *testing.T) {
 
 // TestRunQ_WithQBinEnv は環境変数Q_BINを使用したテスト
 func TestRunQ_WithQBinEnv(t *testing.T) {
-	// 元の環境変数を保存
-	originalQBin := os.Getenv("Q_BIN")
-	defer os.Setenv("Q_BIN", originalQBin)
-	
 	// Q CLIが存在しない場合はスキップ
-	qPath, err := DetectQCLI()
+	qPath, err := qcli.Path()
 	if err != nil {
 		t.Skipf("Q CLI not found: %v", err)
 	}
-	
-	// Q_BINを設定
-	os.Setenv("Q_BIN", qPath)
+
+	// 明示的な指定（Q_BIN）として検出結果を設定
+	prev := qcli.Default()
+	defer qcli.SetDefault(prev)
+	qcli.SetDefault(qcli.New(qcli.Options{Explicit: qPath, ExplicitVia: "Q_BIN"}))
 	
 	// "q help" コマンドを実行
 	output, exitCode, err := RunQ([]string{"q", "help"}, 5*time.Second)
@@ -84,3 +84,97 @@ func Test_Run_NonZeroExitCode(t *testing.T) {
         t.Fatalf("combined output mismatch: %q", out)
     }
 }
+
+func Test_Exec_RecordsResult(t *testing.T) {
+    requireUnix(t)
+    res, err := Exec([]string{"/bin/sh", "-c", "printf abc; exit 3"}, 5*time.Second)
+    if err == nil {
+        t.Fatalf("expected error for non-zero exit, got nil")
+    }
+    if res.ExitCode != 3 {
+        t.Fatalf("exit code: got %d want 3", res.ExitCode)
+    }
+    if res.Output != "abc" || res.Bytes != 3 || res.Truncated {
+        t.Fatalf("output: got %q bytes=%d truncated=%v", res.Output, res.Bytes, res.Truncated)
+    }
+    if len(res.Argv) != 3 || res.Argv[0] != "/bin/sh" {
+        t.Fatalf("argv not recorded: %q", res.Argv)
+    }
+    if res.StartedAt.IsZero() || res.EndedAt.Before(res.StartedAt) || res.Duration() < 0 {
+        t.Fatalf("invalid timestamps: %v - %v", res.StartedAt, res.EndedAt)
+    }
+}
+
+func Test_Exec_TruncatesLargeOutput(t *testing.T) {
+    requireUnix(t)
+    orig := MaxOutputBytes
+    MaxOutputBytes = 4
+    defer func() { MaxOutputBytes = orig }()
+
+    res, err := Exec([]string{"/bin/sh", "-c", "printf 0123456789"}, 5*time.Second)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if res.Output != "0123" || res.Bytes != 10 || !res.Truncated {
+        t.Fatalf("got output=%q bytes=%d truncated=%v", res.Output, res.Bytes, res.Truncated)
+    }
+}
+
+func Test_Exec_TimeoutFlag(t *testing.T) {
+    requireUnix(t)
+    res, err := Exec([]string{"/bin/sh", "-c", "sleep 2"}, 200*time.Millisecond)
+    if !errors.Is(err, context.DeadlineExceeded) || !res.TimedOut || res.ExitCode != -1 {
+        t.Fatalf("got err=%v timedOut=%v exit=%d", err, res.TimedOut, res.ExitCode)
+    }
+}
+
+func Test_ExecContext_IdleTimeout(t *testing.T) {
+    requireUnix(t)
+    // 出力が続いている間は止めず、途絶えたら停止する
+    start := time.Now()
+    res, err := ExecContext(context.Background(),
+        []string{"/bin/sh", "-c", "echo a; sleep 0.1; echo b; sleep 3"},
+        Options{IdleTimeout: 400 * time.Millisecond})
+    if !errors.Is(err, ErrIdleTimeout) || !res.TimedOut {
+        t.Fatalf("got err=%v timedOut=%v", err, res.TimedOut)
+    }
+    if time.Since(start) > 2*time.Second {
+        t.Fatalf("idle timeout did not stop the command quickly")
+    }
+}
+
+func Test_ExecContext_TimeoutKeepsPartialOutput(t *testing.T) {
+    requireUnix(t)
+    // 停止までに出た出力は結果に残る（全体・アイドルのどちらのタイムアウトでも）
+    ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+    defer cancel()
+    res, err := ExecContext(ctx, []string{"/bin/sh", "-c", "echo partial; sleep 3"}, Options{})
+    if !errors.Is(err, context.DeadlineExceeded) || !res.TimedOut {
+        t.Fatalf("got err=%v timedOut=%v", err, res.TimedOut)
+    }
+    if strings.TrimSpace(res.Output) != "partial" || res.Bytes != int64(len("partial\n")) || res.Truncated {
+        t.Fatalf("partial output lost: %+v", res)
+    }
+
+    start := time.Now()
+    res, err = ExecContext(context.Background(), []string{"/bin/sh", "-c", "echo a; echo b; sleep 3"}, Options{IdleTimeout: 200 * time.Millisecond})
+    if !errors.Is(err, ErrIdleTimeout) || res.Output != "a\nb\n" {
+        t.Fatalf("idle timeout: err=%v output=%q", err, res.Output)
+    }
+    if time.Since(start) > 2*time.Second {
+        t.Fatalf("waiting for the killed command's output took too long")
+    }
+}
+
+func Test_ExecContext_Env(t *testing.T) {
+    requireUnix(t)
+    res, err := ExecContext(context.Background(),
+        []string{"/bin/sh", "-c", "echo $AWS_PROFILE"},
+        Options{Env: []string{"AWS_PROFILE=staging"}})
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if strings.TrimSpace(res.Output) != "staging" {
+        t.Fatalf("env not passed: %q", res.Output)
+    }
+}
@@ -0,0 +1,67 @@
+package executor
+
+import (
+	"time"
+
+	"qube/internal/execq"
+	"qube/internal/policy"
+)
+
+// Event は CommandExecutor から UI へ通知されるイベント
+// tea.Msg は任意の値を受け付けるため、UI は Events() から受け取った値をそのままメッセージとして扱える
+type Event interface {
+	isEvent()
+}
+
+// EventStatusChanged はステータス遷移を通知する
+type EventStatusChanged struct {
+	From Status
+	To   Status
+	// Mode は遷移時点のモード（UI が入力可否を判断するために使う）
+	Mode Mode
+}
+
+// EventModeChanged はモード遷移を通知する
+type EventModeChanged struct {
+	From Mode
+	To   Mode
+}
+
+// EventOutput は短命コマンドの出力を通知する
+type EventOutput struct {
+	Text string
+}
+
+// EventError は実行エラーを通知する
+type EventError struct {
+	Err error
+}
+
+// EventPolicyDecision は q 以外のコマンドに対する実行ポリシーの判定を通知する
+// Decision が Confirm の場合、UI は Confirm で回答する必要がある
+type EventPolicyDecision struct {
+	Argv    []string
+	Verdict policy.Verdict
+}
+
+// EventCommandStarted は短命コマンドの開始を通知する
+// Deadline はタイムアウト時刻（タイムアウトなしの場合はゼロ値）
+type EventCommandStarted struct {
+	Argv     []string
+	Deadline time.Time
+}
+
+// EventCommandFinished は短命コマンドの完了を実行記録付きで通知する
+// Err は実行エラー（非ゼロ終了・タイムアウト等）。成功時は nil
+type EventCommandFinished struct {
+	Result execq.Result
+	Err    error
+}
+
+func (EventStatusChanged) isEvent()   {}
+func (EventModeChanged) isEvent()     {}
+func (EventOutput) isEvent()          {}
+func (EventError) isEvent()           {}
+func (EventPolicyDecision) isEvent()  {}
+func (EventCommandStarted) isEvent()  {}
+func (EventCommandFinished) isEvent() {}
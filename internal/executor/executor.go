@@ -2,9 +2,18 @@ package executor
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
+	"sync"
 	"time"
+
+	"qube/internal/execq"
+	"qube/internal/logging"
+	"qube/internal/policy"
+	"qube/internal/stream"
+	"qube/internal/telemetry"
 )
 
 // Session インタフェース（PTYセッションの抽象化）
@@ -16,72 +25,129 @@ type Session interface {
 }
 
 // ExecQ インタフェース（短命コマンド実行の抽象化）
+// args[0] が "q" の場合は Q CLI のサブコマンドとして実行される
+// 実行時間の上限は ctx の期限で渡される
 type ExecQ interface {
-	Run(ctx context.Context, args []string) (string, error)
+	Run(ctx context.Context, args []string, opts execq.Options) (execq.Result, error)
+}
+
+// SessionTimeoutSetter はセッションがタイムアウト設定を受け付ける場合に実装する任意インタフェース
+// startSession は Start の前に、セッション種別に一致するルールを適用した値で呼び出す
+type SessionTimeoutSetter interface {
+	SetTimeouts(init, idle time.Duration)
 }
 
+// ErrPolicyDenied は実行ポリシーによりコマンドが拒否された場合のエラー
+var ErrPolicyDenied = errors.New("command denied by policy")
+
+// ErrNoPendingConfirmation は確認待ちのコマンドがないのに Confirm が呼ばれた場合のエラー
+var ErrNoPendingConfirmation = errors.New("no command awaiting confirmation")
+
+// noTimeout は /timeout off で「次のコマンドはタイムアウトなし」を表す番兵値
+const noTimeout time.Duration = -1
+
+// eventBufferSize はイベントチャネルのバッファサイズ
+// UI の描画が一時的に詰まっても実行側がブロックしにくいよう余裕を持たせる
+const eventBufferSize = 256
+
 // CommandExecutor はコマンド実行を管理する構造体
+// mode/status は mu で保護され、状態変化は Events() のチャネルへ順序通りに通知される
 type CommandExecutor struct {
-	session        Session
-	execQ          ExecQ
-	mode           string // "command" | "session"
-	status         string // "ready" | "running" | "error"
-	onStatusChange func(status string)
-	onModeChange   func(mode string)
-	onOutput       func(output string)
-	onError        func(err error)
+	session Session
+	execQ   ExecQ
+
+	mu      sync.Mutex
+	mode    Mode
+	status  Status
+	events  chan Event
+	done    chan struct{} // Close で閉じる。閉じた後のイベントは送らずに捨てる
+	dropped int           // Close の後に捨てたイベントの数
+	policy  policy.Policy
+	pending []string // 確認待ちのパススルーコマンド
+
+	timeouts    Timeouts
+	nextTimeout time.Duration // /timeout による次回コマンドのみの上書き（0 なら上書きなし）
+	slash       map[string]SlashHandler
+	qube        map[string]SlashHandler // "/qube <name>" で呼び出すサブコマンド
+	closeOnce   sync.Once
+	telemetry   *telemetry.Recorder // 利用状況の記録先（nil なら記録しない）
+	env         []string            // 短命コマンドに追加する環境変数（SetEnv で変更）
 }
 
 // NewCommandExecutor は新しいCommandExecutorを作成する
 func NewCommandExecutor(session Session, execQ ExecQ) *CommandExecutor {
-	return &CommandExecutor{
-		session: session,
-		execQ:   execQ,
-		mode:    "command",
-		status:  "ready",
-		// デフォルトの空のハンドラー
-		onStatusChange: func(string) {},
-		onModeChange:   func(string) {},
-		onOutput:       func(string) {},
-		onError:        func(error) {},
+	c := &CommandExecutor{
+		session:  session,
+		execQ:    execQ,
+		mode:     ModeCommand,
+		status:   StatusReady,
+		events:   make(chan Event, eventBufferSize),
+		done:     make(chan struct{}),
+		policy:   policy.Default(),
+		timeouts: DefaultTimeouts(),
 	}
+	c.registerBuiltinSlashCommands()
+	return c
 }
 
-// SetEventHandlers はイベントハンドラーを設定する
-func (c *CommandExecutor) SetEventHandlers(
-	onStatusChange func(string),
-	onModeChange func(string),
-	onOutput func(string),
-	onError func(error),
-) {
-	if onStatusChange != nil {
-		c.onStatusChange = onStatusChange
-	}
-	if onModeChange != nil {
-		c.onModeChange = onModeChange
-	}
-	if onOutput != nil {
-		c.onOutput = onOutput
-	}
-	if onError != nil {
-		c.onError = onError
-	}
+// SetTimeouts はタイムアウト設定を変更する
+func (c *CommandExecutor) SetTimeouts(t Timeouts) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timeouts = t
+}
+
+// SetEnv は短命コマンドの実行時に追加する環境変数（"KEY=value"）を設定する
+// プロファイルの切り替えで AWS_PROFILE などを差し替えるために使う
+func (c *CommandExecutor) SetEnv(env []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.env = append([]string(nil), env...)
+}
+
+// SetPolicy は q 以外のコマンドに適用する実行ポリシーを設定する
+func (c *CommandExecutor) SetPolicy(p policy.Policy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policy = p
+}
+
+// Events はイベントチャネルを返す
+// 受信側（UI）は単一の消費者であることを前提とする
+func (c *CommandExecutor) Events() <-chan Event {
+	return c.events
 }
 
 // Execute はコマンドを実行する
+// 複数の goroutine から同時に呼び出されても安全
 func (c *CommandExecutor) Execute(command string) error {
 	// 空コマンドの場合は何もしない
 	if strings.TrimSpace(command) == "" {
 		return nil
 	}
 
+	// 登録済みのスラッシュコマンドはモードに関わらず Qube 側で処理する
+	if h, args, ok := c.lookupSlash(command); ok {
+		started := time.Now()
+		logger().Debug("slash command", "command", command)
+		err := h(args)
+		c.recordSlash(strings.TrimPrefix(strings.Fields(command)[0], "/"), started, err)
+		if err != nil {
+			c.emit(EventError{Err: err})
+			return err
+		}
+		return nil
+	}
+
 	// セッションモードで実行中の場合はセッションにコマンドを送信
-	if c.mode == "session" && c.status == "running" && c.session.IsRunning() {
+	// 未登録のスラッシュコマンドは Q chat 自身のコマンドとしてそのまま送る
+	if c.inRunningSession() && c.session.IsRunning() {
 		// セッションにコマンドを送信（CRを付加）
-		err := c.session.Send(command + "\r")
+		logger().Debug("sending to session", "len", len(command), "multiline", strings.Contains(command, "\n"))
+		err := c.session.Send(SessionPayload(command))
+		c.record(telemetry.Event{Name: "chat.sent", ErrorKind: errorKind(err)})
 		if err != nil {
-			c.setStatus("error")
-			c.onError(err)
+			c.fail(err)
 			return fmt.Errorf("failed to send command to session: %w", err)
 		}
 		return nil
@@ -92,6 +158,11 @@ func (c *CommandExecutor) Execute(command string) error {
 	if len(parts) == 0 {
 		return nil
 	}
+	if strings.HasPrefix(parts[0], "/") {
+		err := fmt.Errorf("%w: %s", ErrUnknownCommand, parts[0])
+		c.emit(EventError{Err: err})
+		return err
+	}
 
 	// "q" プレフィックスの処理
 	isQCommand := parts[0] == "q"
@@ -100,83 +171,304 @@ func (c *CommandExecutor) Execute(command string) error {
 		if parts[1] == "chat" {
 			return c.startSession("chat")
 		}
-		// その他のqコマンドは短命コマンドとして実行
-		return c.runShortLivedCommand(parts[1:])
+		// その他のqコマンドは denylist を通して短命コマンドとして実行
+		return c.runQCommand(parts)
+	}
+
+	// qプレフィックスなしのコマンドは実行ポリシーを通して短命コマンドとして実行
+	return c.runPassthrough(parts)
+}
+
+// runQCommand は q サブコマンドを短命コマンドとして実行する
+// "q rm -rf x" のように q を前に付けて denylist を避けられないよう、q 以降の引数も denylist で検査する
+func (c *CommandExecutor) runQCommand(argv []string) error {
+	c.mu.Lock()
+	verdict, denied := c.policy.Denied(argv[1:])
+	if denied {
+		c.emitLocked(EventPolicyDecision{Argv: argv, Verdict: verdict})
 	}
+	c.mu.Unlock()
+	if denied {
+		logger().Info("policy decision", "argv", argv, "decision", verdict.Decision, "reason", verdict.Reason, "rule", verdict.Rule)
+		c.record(telemetry.Event{Name: "policy.decision", Command: commandLabel(argv), Outcome: verdict.Decision.String()})
+		return fmt.Errorf("%w: %s", ErrPolicyDenied, strings.Join(argv, " "))
+	}
+	return c.runShortLivedCommand(argv)
+}
+
+// runPassthrough は q 以外のコマンドを実行ポリシーに従って実行する
+// 確認が必要な場合は確認待ちとして保持し、Confirm で実行/取り消しを決める
+func (c *CommandExecutor) runPassthrough(argv []string) error {
+	c.mu.Lock()
+	verdict := c.policy.Evaluate(argv)
+	c.pending = nil
+	if verdict.Decision == policy.Confirm {
+		c.pending = argv
+	}
+	c.emitLocked(EventPolicyDecision{Argv: argv, Verdict: verdict})
+	c.mu.Unlock()
+	logger().Info("policy decision", "argv", argv, "decision", verdict.Decision, "reason", verdict.Reason, "rule", verdict.Rule)
+	c.record(telemetry.Event{Name: "policy.decision", Command: commandLabel(argv), Outcome: verdict.Decision.String()})
 
-	// qプレフィックスなしのコマンドも短命コマンドとして実行
-	return c.runShortLivedCommand(parts)
+	switch verdict.Decision {
+	case policy.Deny:
+		return fmt.Errorf("%w: %s", ErrPolicyDenied, strings.Join(argv, " "))
+	case policy.Confirm:
+		return nil
+	}
+	return c.runShortLivedCommand(argv)
+}
+
+// Confirm は確認待ちのコマンドに対するユーザーの回答を反映する
+// approve が true の場合は実行し、false の場合は取り消す
+func (c *CommandExecutor) Confirm(approve bool) error {
+	c.mu.Lock()
+	argv := c.pending
+	c.pending = nil
+	if argv == nil {
+		c.mu.Unlock()
+		return ErrNoPendingConfirmation
+	}
+	verdict := policy.Verdict{Decision: policy.Deny, Reason: "declined by user"}
+	if approve {
+		verdict = policy.Verdict{Decision: policy.Allow, Reason: "confirmed by user"}
+	}
+	c.emitLocked(EventPolicyDecision{Argv: argv, Verdict: verdict})
+	c.mu.Unlock()
+	c.record(telemetry.Event{Name: "policy.confirmed", Command: commandLabel(argv), Outcome: verdict.Decision.String()})
+
+	if !approve {
+		return nil
+	}
+	return c.runShortLivedCommand(argv)
+}
+
+// SessionPayload はセッションへ送る文字列を組み立てる
+// 複数行の入力はブラケットペーストで包み、途中の改行で送信されず 1 つのメッセージとして Q に届くようにする
+func SessionPayload(text string) string {
+	if !strings.Contains(text, "\n") {
+		return text + "\r"
+	}
+	return stream.BracketedPasteStart + text + stream.BracketedPasteEnd + "\r"
 }
 
 // startSession はchatセッションを開始する
 func (c *CommandExecutor) startSession(sessionType string) error {
 	// ステータスをrunningに変更
-	c.setStatus("running")
+	if err := c.begin(); err != nil {
+		return err
+	}
+
+	// セッション種別に応じたタイムアウトを適用
+	if ts, ok := c.session.(SessionTimeoutSetter); ok {
+		eff := c.effectiveTimeouts([]string{"q", sessionType})
+		ts.SetTimeouts(eff.Init, eff.Idle)
+	}
 
 	// セッションを開始
 	err := c.session.Start(sessionType)
 	if err != nil {
-		c.setStatus("error")
-		c.onError(err)
+		c.fail(err)
 		return fmt.Errorf("failed to start session: %w", err)
 	}
 
 	// モードをsessionに変更
-	c.setMode("session")
+	return c.setMode(ModeSession)
+}
 
-	return nil
+// StopSession は対話セッションを停止し、command モードの ready に戻す
+// セッションモードでない場合は何もしない。プロファイルの切り替えなどでセッションを起動し直す前に呼ぶ
+func (c *CommandExecutor) StopSession() error {
+	if c.GetMode() != ModeSession {
+		return nil
+	}
+	err := c.session.Stop()
+	logger().Info("session stopped", "err", err)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.setStatusLocked(StatusReady); err != nil {
+		return err
+	}
+	if c.mode == ModeSession {
+		c.mode = ModeCommand
+		c.emitLocked(EventModeChanged{From: ModeSession, To: ModeCommand})
+	}
+	return err
 }
 
 // runShortLivedCommand は短命コマンドを実行する
 func (c *CommandExecutor) runShortLivedCommand(args []string) error {
 	// ステータスをrunningに変更
-	c.setStatus("running")
+	if err := c.begin(); err != nil {
+		return err
+	}
 
-	// タイムアウト付きコンテキストを作成
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	// タイムアウト付きコンテキストを作成（/timeout の上書きは 1 回で消費する）
+	c.mu.Lock()
+	eff := c.timeouts.Resolve(args)
+	if c.nextTimeout != 0 {
+		eff.Command = c.nextTimeout
+		c.nextTimeout = 0
+	}
+	env := c.env
+	c.mu.Unlock()
+
+	ctx := context.Background()
+	var deadline time.Time
+	if eff.Command > 0 {
+		deadline = time.Now().Add(eff.Command)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	c.emit(EventCommandStarted{Argv: args, Deadline: deadline})
+	logger().Info("command started", "argv", args, "timeout", eff.Command, "idle", eff.Idle)
 
 	// コマンドを実行
-	output, err := c.execQ.Run(ctx, args)
+	result, err := c.execQ.Run(ctx, args, execq.Options{IdleTimeout: eff.Idle, Env: env})
+	if len(result.Argv) == 0 {
+		result.Argv = args
+	}
+
+	// 出力を通知（非ゼロ終了でも出力は表示する）
+	if result.Output != "" {
+		c.emit(EventOutput{Text: result.Output})
+	}
+
+	c.recordCommand(result, err)
+	logger().Info("command finished", "argv", args, "exit", result.ExitCode, "duration", result.Duration(),
+		"bytes", result.Bytes, "truncated", result.Truncated, "timedOut", result.TimedOut, "err", err)
 	if err != nil {
-		c.setStatus("error")
-		c.onError(err)
+		c.fail(err)
+		c.emit(EventCommandFinished{Result: result, Err: err})
 		return fmt.Errorf("command execution failed: %w", err)
 	}
 
-	// 出力を通知
-	if output != "" {
-		c.onOutput(output)
+	// ステータスをreadyに戻す
+	if err := c.setStatus(StatusReady); err != nil {
+		return err
 	}
+	c.emit(EventCommandFinished{Result: result})
+	return nil
+}
 
-	// ステータスをreadyに戻す
-	c.setStatus("ready")
+// effectiveTimeouts は argv に一致するルールを適用したタイムアウトを返す
+func (c *CommandExecutor) effectiveTimeouts(argv []string) Timeouts {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.timeouts.Resolve(argv)
+}
 
-	return nil
+// inRunningSession はセッションモードで実行中かどうかを返す
+func (c *CommandExecutor) inRunningSession() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mode == ModeSession && c.status == StatusRunning
+}
+
+// begin は実行開始としてステータスを running に遷移させる
+// 短命コマンド実行中（command モードで running）の場合は ErrBusy を返す
+func (c *CommandExecutor) begin() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mode == ModeCommand && c.status == StatusRunning {
+		return ErrBusy
+	}
+	return c.setStatusLocked(StatusRunning)
+}
+
+// fail はステータスを error に遷移させ、エラーを通知する
+func (c *CommandExecutor) fail(err error) {
+	c.mu.Lock()
+	logger().Warn("execution failed", "mode", c.mode, "err", err)
+	_ = c.setStatusLocked(StatusError)
+	c.emitLocked(EventError{Err: err})
+	r, ev := c.telemetry, telemetry.Event{Name: "executor.error", Mode: c.mode.String(), ErrorKind: errorKind(err)}
+	c.mu.Unlock()
+	// ファイルへの書き込みは mu を放してから行う（遅いディスクで他の操作を止めない）
+	r.Record(ev)
 }
 
 // setStatus はステータスを変更し、イベントを通知する
-func (c *CommandExecutor) setStatus(status string) {
-	if c.status != status {
-		c.status = status
-		c.onStatusChange(status)
+func (c *CommandExecutor) setStatus(status Status) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.setStatusLocked(status)
+}
+
+// setStatusLocked は mu を保持した状態でステータスを遷移させる
+// 遷移表にない遷移は ErrInvalidTransition を返し、状態を変更しない
+func (c *CommandExecutor) setStatusLocked(status Status) error {
+	if c.status == status {
+		return nil
+	}
+	if !canTransitionStatus(c.status, status) {
+		logger().Warn("invalid status transition", "from", c.status, "to", status, "mode", c.mode)
+		return fmt.Errorf("%w: status %s -> %s", ErrInvalidTransition, c.status, status)
 	}
+	from := c.status
+	c.status = status
+	logger().Debug("status changed", "from", from, "to", status, "mode", c.mode)
+	c.emitLocked(EventStatusChanged{From: from, To: status, Mode: c.mode})
+	return nil
 }
 
 // setMode はモードを変更し、イベントを通知する
-func (c *CommandExecutor) setMode(mode string) {
-	if c.mode != mode {
-		c.mode = mode
-		c.onModeChange(mode)
+func (c *CommandExecutor) setMode(mode Mode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mode == mode {
+		return nil
 	}
+	if !canTransitionMode(c.mode, mode) {
+		logger().Warn("invalid mode transition", "from", c.mode, "to", mode)
+		return fmt.Errorf("%w: mode %s -> %s", ErrInvalidTransition, c.mode, mode)
+	}
+	from := c.mode
+	c.mode = mode
+	logger().Debug("mode changed", "from", from, "to", mode)
+	c.emitLocked(EventModeChanged{From: from, To: mode})
+	return nil
+}
+
+// emit はイベントを通知する
+func (c *CommandExecutor) emit(ev Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.emitLocked(ev)
+}
+
+// emitLocked は mu を保持した状態でイベントを送出する
+// ロック下で送出することで、複数 goroutine からの通知でも状態遷移の順序が保たれる
+// 受信側（UI）が終了して Close された後は、バッファが埋まっていても待たずに捨てる
+func (c *CommandExecutor) emitLocked(ev Event) {
+	select {
+	case c.events <- ev:
+	case <-c.done:
+		c.dropped++
+		logger().Debug("event dropped after close", "event", fmt.Sprintf("%T", ev), "dropped", c.dropped)
+	}
+}
+
+// Close はイベントの受信をやめたことを通知する（UI の終了後に呼ぶ）
+// 以降のイベントは捨てるため、終了処理中の通知で mu を保持したまま止まることがない。複数回呼んでもよい
+func (c *CommandExecutor) Close() {
+	c.closeOnce.Do(func() { close(c.done) })
 }
 
 // GetMode は現在のモードを取得する
-func (c *CommandExecutor) GetMode() string {
+func (c *CommandExecutor) GetMode() Mode {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.mode
 }
 
 // GetStatus は現在のステータスを取得する
-func (c *CommandExecutor) GetStatus() string {
+func (c *CommandExecutor) GetStatus() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.status
-}
\ No newline at end of file
+}
+
+func logger() *slog.Logger { return logging.For("executor") }
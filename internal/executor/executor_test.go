@@ -2,11 +2,15 @@ package executor
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"qube/internal/execq"
+	"qube/internal/policy"
 )
 
 // モックセッション
@@ -37,213 +41,488 @@ func (m *mockSession) IsRunning() bool {
 // モック短命コマンド実行
 type mockExecQ struct {
 	mock.Mock
+	opts execq.Options // 最後に渡された Options
 }
 
-func (m *mockExecQ) Run(ctx context.Context, args []string) (string, error) {
+func (m *mockExecQ) Run(ctx context.Context, args []string, opts execq.Options) (execq.Result, error) {
+	m.opts = opts
 	argsMock := m.Called(ctx, args)
-	return argsMock.String(0), argsMock.Error(1)
+	return argsMock.Get(0).(execq.Result), argsMock.Error(1)
 }
 
-// イベントリスナー
+// output は指定の出力を持つ成功時の実行記録を返す
+func output(text string) execq.Result {
+	return execq.Result{Output: text, ExitCode: 0}
+}
+
+// EventListener はイベントチャネルに溜まったイベントを種類別に集計する
 type EventListener struct {
-	StatusChanges []string
-	ModeChanges   []string
+	StatusChanges []Status
+	ModeChanges   []Mode
 	Outputs       []string
 	Errors        []error
+	Decisions     []policy.Decision
+	Results       []execq.Result
 }
 
-func (e *EventListener) OnStatusChange(status string) {
-	e.StatusChanges = append(e.StatusChanges, status)
-}
-
-func (e *EventListener) OnModeChange(mode string) {
-	e.ModeChanges = append(e.ModeChanges, mode)
-}
-
-func (e *EventListener) OnOutput(output string) {
-	e.Outputs = append(e.Outputs, output)
-}
-
-func (e *EventListener) OnError(err error) {
-	e.Errors = append(e.Errors, err)
+// drainEvents はバッファ済みのイベントをブロックせずに全て読み出す
+func drainEvents(c *CommandExecutor) *EventListener {
+	l := &EventListener{}
+	for {
+		select {
+		case ev := <-c.Events():
+			switch e := ev.(type) {
+			case EventStatusChanged:
+				l.StatusChanges = append(l.StatusChanges, e.To)
+			case EventModeChanged:
+				l.ModeChanges = append(l.ModeChanges, e.To)
+			case EventOutput:
+				l.Outputs = append(l.Outputs, e.Text)
+			case EventError:
+				l.Errors = append(l.Errors, e.Err)
+			case EventCommandFinished:
+				l.Results = append(l.Results, e.Result)
+			case EventPolicyDecision:
+				l.Decisions = append(l.Decisions, e.Verdict.Decision)
+			}
+		default:
+			return l
+		}
+	}
 }
 
 func TestCommandExecutor_Execute_ChatCommand(t *testing.T) {
 	// q chatコマンドでセッションを開始する
 	session := new(mockSession)
 	execQ := new(mockExecQ)
-	listener := &EventListener{}
 
+	session.On("IsRunning").Return(false)
 	session.On("Start", "chat").Return(nil)
 
-	executor := &CommandExecutor{
-		session:        session,
-		execQ:          execQ,
-		mode:           "command",
-		status:         "ready",
-		onStatusChange: listener.OnStatusChange,
-		onModeChange:   listener.OnModeChange,
-		onOutput:       listener.OnOutput,
-		onError:        listener.OnError,
-	}
+	executor := NewCommandExecutor(session, execQ)
 
 	// q chatコマンドを実行
 	err := executor.Execute("q chat")
+	listener := drainEvents(executor)
 
 	// アサーション
 	assert.NoError(t, err)
-	session.AssertExpectations(t)
-	
+	session.AssertCalled(t, "Start", "chat")
+
 	// モードがsessionに変更されたことを確認
-	assert.Equal(t, "session", executor.mode)
-	assert.Contains(t, listener.ModeChanges, "session")
-	
+	assert.Equal(t, ModeSession, executor.GetMode())
+	assert.Contains(t, listener.ModeChanges, ModeSession)
+
 	// ステータスがrunningに変更されたことを確認
-	assert.Equal(t, "running", executor.status)
-	assert.Contains(t, listener.StatusChanges, "running")
+	assert.Equal(t, StatusRunning, executor.GetStatus())
+	assert.Contains(t, listener.StatusChanges, StatusRunning)
 }
 
-
 func TestCommandExecutor_Execute_SessionSend(t *testing.T) {
 	// セッション中にコマンドを送信する
 	session := new(mockSession)
 	execQ := new(mockExecQ)
-	listener := &EventListener{}
 
 	session.On("IsRunning").Return(true)
 	session.On("Send", "Hello, world!\r").Return(nil)
 
-	executor := &CommandExecutor{
-		session:        session,
-		execQ:          execQ,
-		mode:           "session", // 既にセッションモード
-		status:         "running",  // 既に実行中
-		onStatusChange: listener.OnStatusChange,
-		onModeChange:   listener.OnModeChange,
-		onOutput:       listener.OnOutput,
-		onError:        listener.OnError,
-	}
+	executor := NewCommandExecutor(session, execQ)
+	executor.mode = ModeSession     // 既にセッションモード
+	executor.status = StatusRunning // 既に実行中
 
 	// セッション中にメッセージを送信
 	err := executor.Execute("Hello, world!")
+	listener := drainEvents(executor)
 
 	// アサーション
 	assert.NoError(t, err)
 	session.AssertExpectations(t)
-	
+
 	// モードとステータスは変更されない
-	assert.Equal(t, "session", executor.mode)
-	assert.Equal(t, "running", executor.status)
+	assert.Equal(t, ModeSession, executor.GetMode())
+	assert.Equal(t, StatusRunning, executor.GetStatus())
+	assert.Empty(t, listener.StatusChanges)
+	assert.Empty(t, listener.ModeChanges)
+}
+
+func TestCommandExecutor_Execute_SessionSendMultiline(t *testing.T) {
+	// 複数行の入力はブラケットペーストで 1 つのメッセージとして送信される
+	session := new(mockSession)
+	session.On("IsRunning").Return(true)
+	session.On("Send", "\x1b[200~line one\nline two\x1b[201~\r").Return(nil)
+
+	executor := NewCommandExecutor(session, new(mockExecQ))
+	executor.mode = ModeSession
+	executor.status = StatusRunning
+
+	assert.NoError(t, executor.Execute("line one\nline two"))
+	session.AssertExpectations(t)
+}
+
+func TestCommandExecutor_StopSession(t *testing.T) {
+	// セッションを停止すると command モードの ready に戻り、再び q chat で起動できる
+	session := new(mockSession)
+	session.On("IsRunning").Return(true)
+	session.On("Start", "chat").Return(nil)
+	session.On("Stop").Return(nil)
+
+	executor := NewCommandExecutor(session, new(mockExecQ))
+	assert.NoError(t, executor.Execute("q chat"))
+	drainEvents(executor)
+
+	assert.NoError(t, executor.StopSession())
+	listener := drainEvents(executor)
+	session.AssertNumberOfCalls(t, "Stop", 1)
+	assert.Equal(t, ModeCommand, executor.GetMode())
+	assert.Equal(t, StatusReady, executor.GetStatus())
+	assert.Equal(t, []Mode{ModeCommand}, listener.ModeChanges)
+
+	// セッションモードでなければ何もしない
+	assert.NoError(t, executor.StopSession())
+	session.AssertNumberOfCalls(t, "Stop", 1)
+
+	assert.NoError(t, executor.Execute("q chat"))
+	session.AssertNumberOfCalls(t, "Start", 2)
+	assert.Equal(t, ModeSession, executor.GetMode())
+}
+
+func TestCommandExecutor_SetEnv(t *testing.T) {
+	// 短命コマンドにプロファイルの環境変数を渡す
+	execQ := new(mockExecQ)
+	execQ.On("Run", mock.Anything, []string{"q", "whoami"}).Return(output("ok"), nil)
+
+	executor := NewCommandExecutor(new(mockSession), execQ)
+	executor.SetEnv([]string{"AWS_PROFILE=prod"})
+	assert.NoError(t, executor.Execute("q whoami"))
+	assert.Equal(t, []string{"AWS_PROFILE=prod"}, execQ.opts.Env)
 }
 
 func TestCommandExecutor_Execute_ShortLivedCommand(t *testing.T) {
 	// 短命コマンドを実行する
 	session := new(mockSession)
 	execQ := new(mockExecQ)
-	listener := &EventListener{}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	execQ.On("Run", mock.Anything, []string{"help"}).Return("Q CLI Help Output", nil)
-
-	executor := &CommandExecutor{
-		session:        session,
-		execQ:          execQ,
-		mode:           "command",
-		status:         "ready",
-		onStatusChange: listener.OnStatusChange,
-		onModeChange:   listener.OnModeChange,
-		onOutput:       listener.OnOutput,
-		onError:        listener.OnError,
-	}
+
+	session.On("IsRunning").Return(false)
+	execQ.On("Run", mock.Anything, []string{"q", "help"}).Return(output("Q CLI Help Output"), nil)
+
+	executor := NewCommandExecutor(session, execQ)
 
 	// q helpコマンドを実行
 	err := executor.Execute("q help")
+	listener := drainEvents(executor)
 
 	// アサーション
 	assert.NoError(t, err)
 	execQ.AssertExpectations(t)
-	
+
 	// 出力が通知されたことを確認
 	assert.Contains(t, listener.Outputs, "Q CLI Help Output")
-	
+
 	// ステータスが一時的にrunningになり、readyに戻ることを確認
-	assert.Equal(t, "ready", executor.status)
-	assert.Contains(t, listener.StatusChanges, "running")
-	assert.Contains(t, listener.StatusChanges, "ready")
-	
-	_ = ctx // コンテキストを使用（linterエラー回避）
+	assert.Equal(t, StatusReady, executor.GetStatus())
+	assert.Equal(t, []Status{StatusRunning, StatusReady}, listener.StatusChanges)
+
+	// 実行記録が完了イベントで通知されることを確認
+	if assert.Len(t, listener.Results, 1) {
+		assert.Equal(t, []string{"q", "help"}, listener.Results[0].Argv)
+		assert.Equal(t, 0, listener.Results[0].ExitCode)
+	}
 }
 
 func TestCommandExecutor_Execute_CommandWithoutQPrefix(t *testing.T) {
 	// qプレフィックスなしのコマンドを実行する
 	session := new(mockSession)
 	execQ := new(mockExecQ)
-	listener := &EventListener{}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	execQ.On("Run", mock.Anything, []string{"help"}).Return("Q CLI Help Output", nil)
-
-	executor := &CommandExecutor{
-		session:        session,
-		execQ:          execQ,
-		mode:           "command",
-		status:         "ready",
-		onStatusChange: listener.OnStatusChange,
-		onModeChange:   listener.OnModeChange,
-		onOutput:       listener.OnOutput,
-		onError:        listener.OnError,
-	}
+
+	session.On("IsRunning").Return(false)
+	execQ.On("Run", mock.Anything, []string{"help"}).Return(output("Q CLI Help Output"), nil)
+
+	executor := NewCommandExecutor(session, execQ)
+	executor.SetPolicy(policy.Policy{Passthrough: true, Allow: []string{"help"}})
 
 	// プレフィックスなしでhelpコマンドを実行
 	err := executor.Execute("help")
+	listener := drainEvents(executor)
 
 	// アサーション
 	assert.NoError(t, err)
 	execQ.AssertExpectations(t)
-	
-	// 出力が通知されたことを確認
+
+	// 出力とポリシー判定が通知されたことを確認
 	assert.Contains(t, listener.Outputs, "Q CLI Help Output")
-	
-	_ = ctx // コンテキストを使用（linterエラー回避）
+	assert.Equal(t, []policy.Decision{policy.Allow}, listener.Decisions)
+}
+
+func TestCommandExecutor_Execute_PolicyDeny(t *testing.T) {
+	// denylist に一致するコマンドは実行されない
+	session := new(mockSession)
+	execQ := new(mockExecQ)
+	session.On("IsRunning").Return(false)
+
+	executor := NewCommandExecutor(session, execQ)
+
+	err := executor.Execute("rm -rf /tmp/x")
+	listener := drainEvents(executor)
+
+	assert.True(t, errors.Is(err, ErrPolicyDenied))
+	execQ.AssertNotCalled(t, "Run", mock.Anything, mock.Anything)
+	assert.Equal(t, []policy.Decision{policy.Deny}, listener.Decisions)
+	assert.Equal(t, StatusReady, executor.GetStatus())
+}
+
+func TestCommandExecutor_Execute_PolicyDenyWithQPrefix(t *testing.T) {
+	// q を前に付けても denylist は避けられない
+	session := new(mockSession)
+	execQ := new(mockExecQ)
+	session.On("IsRunning").Return(false)
+
+	executor := NewCommandExecutor(session, execQ)
+
+	err := executor.Execute("q rm -rf x")
+	listener := drainEvents(executor)
+
+	assert.True(t, errors.Is(err, ErrPolicyDenied))
+	execQ.AssertNotCalled(t, "Run", mock.Anything, mock.Anything)
+	assert.Equal(t, []policy.Decision{policy.Deny}, listener.Decisions)
+	assert.Equal(t, StatusReady, executor.GetStatus())
+}
+
+func TestCommandExecutor_Execute_PolicyConfirm(t *testing.T) {
+	// 未登録のコマンドは確認待ちになり、承認後に実行される
+	session := new(mockSession)
+	execQ := new(mockExecQ)
+	session.On("IsRunning").Return(false)
+	execQ.On("Run", mock.Anything, []string{"make", "all"}).Return(output("built"), nil)
+
+	executor := NewCommandExecutor(session, execQ)
+
+	assert.NoError(t, executor.Execute("make all"))
+	execQ.AssertNotCalled(t, "Run", mock.Anything, mock.Anything)
+	assert.Equal(t, []policy.Decision{policy.Confirm}, drainEvents(executor).Decisions)
+
+	assert.NoError(t, executor.Confirm(true))
+	listener := drainEvents(executor)
+	assert.Equal(t, []policy.Decision{policy.Allow}, listener.Decisions)
+	assert.Contains(t, listener.Outputs, "built")
+
+	// 確認待ちがなければエラー
+	assert.True(t, errors.Is(executor.Confirm(true), ErrNoPendingConfirmation))
+
+	// 拒否した場合は実行されない
+	assert.NoError(t, executor.Execute("make clean"))
+	assert.NoError(t, executor.Confirm(false))
+	execQ.AssertNotCalled(t, "Run", mock.Anything, []string{"make", "clean"})
+}
+
+func TestCommandExecutor_Execute_PassthroughDisabled(t *testing.T) {
+	// パススルー無効時は q サブコマンドのみ実行される
+	session := new(mockSession)
+	execQ := new(mockExecQ)
+	session.On("IsRunning").Return(false)
+	execQ.On("Run", mock.Anything, []string{"q", "help"}).Return(output("ok"), nil)
+
+	executor := NewCommandExecutor(session, execQ)
+	executor.SetPolicy(policy.Policy{Passthrough: false})
+
+	assert.True(t, errors.Is(executor.Execute("ls"), ErrPolicyDenied))
+	assert.NoError(t, executor.Execute("q help"))
+	execQ.AssertNumberOfCalls(t, "Run", 1)
 }
 
 func TestCommandExecutor_Execute_ErrorHandling(t *testing.T) {
 	// エラーハンドリングのテスト
 	session := new(mockSession)
 	execQ := new(mockExecQ)
-	listener := &EventListener{}
 
 	expectedError := assert.AnError
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	execQ.On("Run", mock.Anything, []string{"unknown"}).Return("", expectedError)
-
-	executor := &CommandExecutor{
-		session:        session,
-		execQ:          execQ,
-		mode:           "command",
-		status:         "ready",
-		onStatusChange: listener.OnStatusChange,
-		onModeChange:   listener.OnModeChange,
-		onOutput:       listener.OnOutput,
-		onError:        listener.OnError,
-	}
+	session.On("IsRunning").Return(false)
+	execQ.On("Run", mock.Anything, []string{"q", "unknown"}).Return(execq.Result{Output: "bad command", ExitCode: 2}, expectedError)
+
+	executor := NewCommandExecutor(session, execQ)
 
 	// 存在しないコマンドを実行
 	err := executor.Execute("q unknown")
+	listener := drainEvents(executor)
 
 	// アサーション
 	assert.Error(t, err)
 	execQ.AssertExpectations(t)
-	
-	// エラーが通知されたことを確認
+
+	// エラーが通知されたことを確認（非ゼロ終了でも出力と終了コードは届く）
 	assert.Contains(t, listener.Errors, expectedError)
-	
+	assert.Contains(t, listener.Outputs, "bad command")
+	if assert.Len(t, listener.Results, 1) {
+		assert.Equal(t, 2, listener.Results[0].ExitCode)
+	}
+
 	// ステータスがerrorに変更されたことを確認
-	assert.Equal(t, "error", executor.status)
-	assert.Contains(t, listener.StatusChanges, "error")
-	
-	_ = ctx // コンテキストを使用（linterエラー回避）
-}
\ No newline at end of file
+	assert.Equal(t, StatusError, executor.GetStatus())
+	assert.Contains(t, listener.StatusChanges, StatusError)
+
+	// error からは再実行で running に戻れる
+	execQ.On("Run", mock.Anything, []string{"q", "help"}).Return(output("ok"), nil)
+	assert.NoError(t, executor.Execute("q help"))
+	assert.Equal(t, StatusReady, executor.GetStatus())
+}
+
+func TestCommandExecutor_RejectsInvalidTransition(t *testing.T) {
+	// 遷移表にない遷移（ready → error）は拒否され、状態は変わらない
+	executor := NewCommandExecutor(new(mockSession), new(mockExecQ))
+
+	err := executor.setStatus(StatusError)
+	assert.True(t, errors.Is(err, ErrInvalidTransition))
+	assert.Equal(t, StatusReady, executor.GetStatus())
+	assert.Empty(t, drainEvents(executor).StatusChanges)
+}
+
+func TestCommandExecutor_CloseUnblocksEmit(t *testing.T) {
+	// 受信側がいないままバッファが埋まっても、Close すれば mu を保持したまま止まらない
+	executor := NewCommandExecutor(new(mockSession), new(mockExecQ))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i <= eventBufferSize; i++ {
+			executor.emit(EventOutput{Text: "line"})
+		}
+	}()
+	executor.Close()
+	executor.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("emit blocked after Close")
+	}
+	assert.Equal(t, StatusReady, executor.GetStatus())
+	executor.mu.Lock()
+	defer executor.mu.Unlock()
+	assert.GreaterOrEqual(t, executor.dropped, 1)
+}
+
+func TestCommandExecutor_RejectsConcurrentShortLivedCommand(t *testing.T) {
+	// 短命コマンド実行中の別コマンドは ErrBusy で拒否される
+	session := new(mockSession)
+	execQ := new(mockExecQ)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	session.On("IsRunning").Return(false)
+	execQ.On("Run", mock.Anything, []string{"slow"}).Run(func(mock.Arguments) {
+		close(started)
+		<-release
+	}).Return(output(""), nil)
+
+	executor := NewCommandExecutor(session, execQ)
+	executor.SetPolicy(policy.Policy{Passthrough: true})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, executor.Execute("slow"))
+	}()
+	<-started
+
+	err := executor.Execute("other")
+	assert.True(t, errors.Is(err, ErrBusy))
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, StatusReady, executor.GetStatus())
+	execQ.AssertNotCalled(t, "Run", mock.Anything, []string{"other"})
+}
+
+// timeoutSession はタイムアウト設定を受け付けるモックセッション
+type timeoutSession struct {
+	mockSession
+	init, idle time.Duration
+}
+
+func (s *timeoutSession) SetTimeouts(init, idle time.Duration) {
+	s.init, s.idle = init, idle
+}
+
+func TestCommandExecutor_Timeouts(t *testing.T) {
+	// パターン別のタイムアウトがコマンドとセッションに適用される
+	session := &timeoutSession{}
+	execQ := new(mockExecQ)
+	session.On("IsRunning").Return(false)
+	session.On("Start", "chat").Return(nil)
+
+	var deadlines []time.Duration
+	execQ.On("Run", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		d, ok := args.Get(0).(context.Context).Deadline()
+		if !ok {
+			deadlines = append(deadlines, 0)
+			return
+		}
+		deadlines = append(deadlines, time.Until(d).Round(time.Minute))
+	}).Return(output(""), nil)
+
+	executor := NewCommandExecutor(session, execQ)
+	executor.SetTimeouts(Timeouts{
+		Command: 30 * time.Second,
+		Init:    10 * time.Second,
+		Rules: []TimeoutRule{
+			{Pattern: "q translate*", Command: 5 * time.Minute},
+			{Pattern: "q chat", Init: 45 * time.Second, Idle: 2 * time.Minute},
+		},
+	})
+
+	assert.NoError(t, executor.Execute("q translate list files"))
+	// /timeout は次のコマンドにだけ適用される
+	assert.NoError(t, executor.Execute("/timeout 10m"))
+	assert.NoError(t, executor.Execute("q help"))
+	assert.NoError(t, executor.Execute("/timeout off"))
+	assert.NoError(t, executor.Execute("q help"))
+	assert.Equal(t, []time.Duration{5 * time.Minute, 10 * time.Minute, 0}, deadlines)
+
+	assert.NoError(t, executor.Execute("q chat"))
+	assert.Equal(t, 45*time.Second, session.init)
+	assert.Equal(t, 2*time.Minute, session.idle)
+}
+
+func TestCommandExecutor_SlashCommands(t *testing.T) {
+	session := new(mockSession)
+	execQ := new(mockExecQ)
+	session.On("IsRunning").Return(true)
+	session.On("Send", "/tools\r").Return(nil)
+	session.On("Send", "/profile\r").Return(nil)
+
+	executor := NewCommandExecutor(session, execQ)
+
+	// 不正な引数はエラーとして通知される
+	assert.Error(t, executor.Execute("/timeout soon"))
+	assert.Len(t, drainEvents(executor).Errors, 1)
+
+	// コマンドモードでの未登録スラッシュコマンドはエラー
+	assert.True(t, errors.Is(executor.Execute("/nope"), ErrUnknownCommand))
+
+	// セッション中の未登録スラッシュコマンドは Q chat へそのまま送られる
+	executor.mode = ModeSession
+	executor.status = StatusRunning
+	var got []string
+	executor.RegisterSlashCommand("echo", func(args []string) error {
+		got = args
+		return nil
+	})
+	assert.NoError(t, executor.Execute("/tools"))
+	assert.NoError(t, executor.Execute("/echo a b"))
+	assert.Equal(t, []string{"a", "b"}, got)
+	session.AssertCalled(t, "Send", "/tools\r")
+	session.AssertNotCalled(t, "Send", "/echo a b\r")
+
+	// "/qube <name>" のサブコマンドは Qube 側で処理し、同名の Q のコマンドはそのまま Q に送る
+	got = nil
+	executor.RegisterQubeCommand("profile", func(args []string) error {
+		got = args
+		return nil
+	})
+	assert.NoError(t, executor.Execute("/qube profile work"))
+	assert.Equal(t, []string{"work"}, got)
+	assert.NoError(t, executor.Execute("/profile"))
+	session.AssertCalled(t, "Send", "/profile\r")
+	assert.True(t, errors.Is(executor.Execute("/qube nope"), ErrUnknownCommand))
+	drainEvents(executor)
+	assert.NoError(t, executor.Execute("/qube"))
+	assert.Equal(t, []string{"usage: /qube <profile> [args...]"}, drainEvents(executor).Outputs)
+}
@@ -0,0 +1,126 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrUnknownCommand は未登録のスラッシュコマンドをコマンドモードで受け付けた場合のエラー
+var ErrUnknownCommand = errors.New("unknown command")
+
+// SlashHandler は "/name args..." 形式のコマンドを処理する関数
+type SlashHandler func(args []string) error
+
+// RegisterSlashCommand はスラッシュコマンドを登録する（name は "/" を除いた名前）
+// 登録済みの名前はセッション中でも Q に送らず Qube 側で処理する
+func (c *CommandExecutor) RegisterSlashCommand(name string, h SlashHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.slash == nil {
+		c.slash = map[string]SlashHandler{}
+	}
+	c.slash[name] = h
+}
+
+// QubeCommand は Qube 側の操作をまとめるスラッシュコマンドの名前空間（"/qube <name> args..."）
+// Q chat にも同名のコマンドがある操作は、Q のコマンドを隠さないようここに登録する
+const QubeCommand = "qube"
+
+// RegisterQubeCommand は "/qube name args..." で呼び出すサブコマンドを登録する
+func (c *CommandExecutor) RegisterQubeCommand(name string, h SlashHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.qube == nil {
+		c.qube = map[string]SlashHandler{}
+	}
+	c.qube[name] = h
+}
+
+// slashQube は "/qube <name> args..." を登録済みのサブコマンドに振り分ける
+// 名前を省略した場合はサブコマンドの一覧を表示する
+func (c *CommandExecutor) slashQube(args []string) error {
+	c.mu.Lock()
+	if len(args) == 0 {
+		names := make([]string, 0, len(c.qube))
+		for name := range c.qube {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		c.emitLocked(EventOutput{Text: fmt.Sprintf("usage: /%s <%s> [args...]", QubeCommand, strings.Join(names, "|"))})
+		c.mu.Unlock()
+		return nil
+	}
+	h, ok := c.qube[args[0]]
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: /%s %s", ErrUnknownCommand, QubeCommand, args[0])
+	}
+	return h(args[1:])
+}
+
+// lookupSlash は command が登録済みスラッシュコマンドならハンドラーと引数を返す
+func (c *CommandExecutor) lookupSlash(command string) (SlashHandler, []string, bool) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return nil, nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h, ok := c.slash[strings.TrimPrefix(fields[0], "/")]
+	return h, fields[1:], ok
+}
+
+// registerBuiltinSlashCommands は executor 自身が提供するスラッシュコマンドを登録する
+func (c *CommandExecutor) registerBuiltinSlashCommands() {
+	c.RegisterSlashCommand("timeout", c.slashTimeout)
+	c.RegisterSlashCommand(QubeCommand, c.slashQube)
+}
+
+// slashTimeout は次の短命コマンドだけに適用するタイムアウトを設定する
+//
+//	/timeout        現在の設定を表示
+//	/timeout 5m     次のコマンドを 5 分で打ち切る
+//	/timeout off    次のコマンドはタイムアウトなし
+func (c *CommandExecutor) slashTimeout(args []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(args) == 0 {
+		c.emitLocked(EventOutput{Text: c.describeTimeoutsLocked()})
+		return nil
+	}
+	switch arg := args[0]; arg {
+	case "off", "none", "0":
+		c.nextTimeout = noTimeout
+		c.emitLocked(EventOutput{Text: "timeout: next command runs without a timeout"})
+	default:
+		d, err := time.ParseDuration(arg)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid timeout %q: use a duration like 90s or 5m, or \"off\"", arg)
+		}
+		c.nextTimeout = d
+		c.emitLocked(EventOutput{Text: fmt.Sprintf("timeout: next command times out after %s", d)})
+	}
+	return nil
+}
+
+// describeTimeoutsLocked は現在のタイムアウト設定を 1 行で表す
+func (c *CommandExecutor) describeTimeoutsLocked() string {
+	idle := "off"
+	if c.timeouts.Idle > 0 {
+		idle = c.timeouts.Idle.String()
+	}
+	s := fmt.Sprintf("timeout: command %s, init %s, idle %s", c.timeouts.Command, c.timeouts.Init, idle)
+	switch {
+	case c.nextTimeout == noTimeout:
+		s += " (next command: none)"
+	case c.nextTimeout > 0:
+		s += fmt.Sprintf(" (next command: %s)", c.nextTimeout)
+	}
+	if n := len(c.timeouts.Rules); n > 0 {
+		s += fmt.Sprintf(", %d pattern rule(s)", n)
+	}
+	return s
+}
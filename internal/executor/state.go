@@ -0,0 +1,87 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Mode は CommandExecutor の実行モードを表す
+type Mode int
+
+const (
+	ModeCommand Mode = iota // 短命コマンド実行
+	ModeSession             // 対話セッション（q chat）
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeCommand:
+		return "command"
+	case ModeSession:
+		return "session"
+	default:
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+}
+
+// Status は CommandExecutor の実行状態を表す
+type Status int
+
+const (
+	StatusReady   Status = iota // アイドル
+	StatusRunning               // 実行中
+	StatusError                 // エラー発生
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusReady:
+		return "ready"
+	case StatusRunning:
+		return "running"
+	case StatusError:
+		return "error"
+	default:
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+}
+
+// ErrInvalidTransition は遷移表にない状態遷移を要求した場合のエラー
+var ErrInvalidTransition = errors.New("invalid state transition")
+
+// ErrBusy は短命コマンド実行中に別のコマンドを受け付けた場合のエラー
+var ErrBusy = errors.New("another command is running")
+
+// statusTransitions は許可されるステータス遷移の一覧
+// 同一ステータスへの遷移は常に no-op として扱う
+var statusTransitions = map[Status][]Status{
+	StatusReady:   {StatusRunning},
+	StatusRunning: {StatusReady, StatusError},
+	StatusError:   {StatusRunning, StatusReady},
+}
+
+// modeTransitions は許可されるモード遷移の一覧
+var modeTransitions = map[Mode][]Mode{
+	ModeCommand: {ModeSession},
+	ModeSession: {ModeCommand},
+}
+
+// canTransitionStatus は from → to のステータス遷移が許可されているかを返す
+func canTransitionStatus(from, to Status) bool {
+	for _, s := range statusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// canTransitionMode は from → to のモード遷移が許可されているかを返す
+func canTransitionMode(from, to Mode) bool {
+	for _, m := range modeTransitions[from] {
+		if m == to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package executor
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"qube/internal/execq"
+	"qube/internal/telemetry"
+)
+
+// SetTelemetry は利用状況の記録先を設定する（nil なら記録しない）
+func (c *CommandExecutor) SetTelemetry(r *telemetry.Recorder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.telemetry = r
+}
+
+// record はテレメトリのイベントを記録する
+// 記録先だけを mu の下で読み、ファイルへの書き込みは mu を放してから行う
+func (c *CommandExecutor) record(ev telemetry.Event) {
+	c.mu.Lock()
+	r := c.telemetry
+	c.mu.Unlock()
+	r.Record(ev)
+}
+
+// knownSubcommands は記録してよい q のサブコマンド名
+// q の後に入力した語がそのまま記録されないよう、Q CLI のサブコマンドに限る（引数やパスは記録しない）
+var knownSubcommands = map[string]bool{
+	"chat": true, "translate": true, "doctor": true, "settings": true, "update": true,
+	"login": true, "logout": true, "whoami": true, "profile": true, "user": true,
+	"theme": true, "integrations": true, "inline": true, "mcp": true, "init": true,
+	"issue": true, "diagnostic": true, "setup": true, "dashboard": true, "debug": true,
+	"telemetry": true, "restart": true, "quit": true, "version": true, "help": true,
+}
+
+// commandLabel は argv を記録用のコマンド名に変換する
+// 既知の q のサブコマンドは名前だけを残し、それ以外の語は "q other"、q 以外のコマンドはすべて "passthrough" とする
+func commandLabel(argv []string) string {
+	switch {
+	case len(argv) == 0 || argv[0] != "q":
+		return "passthrough"
+	case len(argv) == 1 || strings.HasPrefix(argv[1], "-"):
+		return "q"
+	case knownSubcommands[argv[1]]:
+		return "q " + argv[1]
+	}
+	return "q other"
+}
+
+// errorKind はエラーを記録用の種類に分類する
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, ErrPolicyDenied):
+		return "policy_denied"
+	case errors.Is(err, ErrBusy):
+		return "busy"
+	case errors.Is(err, ErrUnknownCommand):
+		return "unknown_command"
+	case errors.Is(err, ErrInvalidTransition):
+		return "invalid_transition"
+	case errors.Is(err, execq.ErrIdleTimeout):
+		return "idle_timeout"
+	}
+	return telemetry.ErrorKind(err)
+}
+
+// recordCommand は短命コマンドの完了を記録する
+func (c *CommandExecutor) recordCommand(res execq.Result, err error) {
+	ev := telemetry.Event{
+		Name:      "command.finished",
+		Command:   commandLabel(res.Argv),
+		ExitCode:  telemetry.Exit(res.ExitCode),
+		ErrorKind: errorKind(err),
+	}
+	if !res.StartedAt.IsZero() && !res.EndedAt.IsZero() {
+		ev.DurationMs = res.Duration().Milliseconds()
+	}
+	c.record(ev)
+}
+
+// recordSlash はスラッシュコマンドの実行を記録する（登録済みのコマンド名のみ）
+func (c *CommandExecutor) recordSlash(name string, started time.Time, err error) {
+	c.record(telemetry.Event{
+		Name:       "slash.executed",
+		Command:    "/" + name,
+		DurationMs: time.Since(started).Milliseconds(),
+		ErrorKind:  errorKind(err),
+	})
+}
@@ -0,0 +1,72 @@
+package executor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"qube/internal/execq"
+	"qube/internal/telemetry"
+)
+
+func TestCommandExecutor_RecordsTelemetry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telemetry.jsonl")
+	rec, err := telemetry.Open(path, telemetry.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := new(mockSession)
+	execQ := new(mockExecQ)
+	session.On("IsRunning").Return(false)
+	started := time.Now()
+	execQ.On("Run", mock.Anything, []string{"q", "translate", "list", "my", "secret-bucket"}).
+		Return(execq.Result{ExitCode: 0, Output: "aws s3 ls", StartedAt: started, EndedAt: started.Add(1500 * time.Millisecond)}, nil).Once()
+	execQ.On("Run", mock.Anything, []string{"q", "/home/me/private"}).
+		Return(execq.Result{ExitCode: 2, StartedAt: started, EndedAt: started}, errors.New("exit status 2")).Once()
+
+	executor := NewCommandExecutor(session, execQ)
+	executor.SetTelemetry(rec)
+	assert.NoError(t, executor.Execute("q translate list my secret-bucket"))
+	assert.Error(t, executor.Execute("q /home/me/private"))
+	_ = executor.setStatus(StatusReady)
+	assert.Error(t, executor.Execute("rm -rf /tmp/x"))
+	assert.NoError(t, executor.Execute("/timeout 5m"))
+	drainEvents(executor)
+	rec.Close()
+
+	raw, _ := os.ReadFile(path)
+	for _, secret := range []string{"secret-bucket", "/home/me", "/tmp/x", "aws s3 ls", "5m"} {
+		assert.NotContains(t, string(raw), secret, "telemetry must not contain arguments or output")
+	}
+
+	events, err := telemetry.Read(path)
+	assert.NoError(t, err)
+	var names []string
+	for _, ev := range events {
+		names = append(names, strings.Join(strings.Fields(ev.Name+" "+ev.Command+" "+ev.Outcome+" "+ev.ErrorKind), " "))
+	}
+	assert.Equal(t, []string{
+		"command.finished q translate",
+		"command.finished q other other",
+		"executor.error other",
+		"policy.decision passthrough deny",
+		"slash.executed /timeout",
+	}, names)
+	assert.Equal(t, int64(1500), events[0].DurationMs)
+	assert.Equal(t, 2, *events[1].ExitCode)
+}
+
+func TestCommandLabel(t *testing.T) {
+	assert.Equal(t, "q doctor", commandLabel([]string{"q", "doctor", "--all"}))
+	assert.Equal(t, "q", commandLabel([]string{"q", "--version"}))
+	assert.Equal(t, "q", commandLabel([]string{"q"}))
+	// サブコマンドではない語（入力した文章など）は記録しない
+	assert.Equal(t, "q other", commandLabel([]string{"q", "deploy-prod-secrets"}))
+	assert.Equal(t, "q other", commandLabel([]string{"q", "/home/me/private"}))
+	assert.Equal(t, "passthrough", commandLabel([]string{"ls", "-la"}))
+}
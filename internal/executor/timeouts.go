@@ -0,0 +1,58 @@
+package executor
+
+import (
+	"time"
+
+	"qube/internal/policy"
+)
+
+// TimeoutRule はコマンドパターン別のタイムアウト上書き
+// 0 の項目は上書きせず、全体設定を引き継ぐ
+type TimeoutRule struct {
+	Pattern string // policy.Match と同じグロブ（例: "q translate*"）
+	Command time.Duration
+	Init    time.Duration
+	Idle    time.Duration
+}
+
+// Timeouts はコマンド実行とセッション各フェーズのタイムアウト設定
+type Timeouts struct {
+	// Command は短命コマンドの実行時間の上限
+	Command time.Duration
+	// Init は chat セッションの初期化検知を待つ時間（経過後は初期化完了扱い）
+	Init time.Duration
+	// Idle は出力が途絶えてから諦めるまでの時間（0 で無効）
+	// 短命コマンドでは停止、セッションでは送信後に応答が始まらない場合にエラーを通知する
+	Idle time.Duration
+	// Rules はパターン別の上書き。先に一致したものが優先される
+	Rules []TimeoutRule
+}
+
+// DefaultTimeouts は既定のタイムアウト設定を返す
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		Command: 30 * time.Second,
+		Init:    10 * time.Second,
+	}
+}
+
+// Resolve は argv に一致するルールを適用した実効値を返す（Rules は空になる）
+func (t Timeouts) Resolve(argv []string) Timeouts {
+	eff := Timeouts{Command: t.Command, Init: t.Init, Idle: t.Idle}
+	for _, r := range t.Rules {
+		if !policy.Match(r.Pattern, argv) {
+			continue
+		}
+		if r.Command > 0 {
+			eff.Command = r.Command
+		}
+		if r.Init > 0 {
+			eff.Init = r.Init
+		}
+		if r.Idle > 0 {
+			eff.Idle = r.Idle
+		}
+		break
+	}
+	return eff
+}
@@ -0,0 +1,35 @@
+package executor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeouts_Resolve(t *testing.T) {
+	to := Timeouts{
+		Command: 30 * time.Second,
+		Init:    10 * time.Second,
+		Rules: []TimeoutRule{
+			{Pattern: "q translate*", Command: 2 * time.Minute},
+			{Pattern: "q chat*", Init: 30 * time.Second, Idle: time.Minute},
+			{Pattern: "q *", Command: time.Second},
+		},
+	}
+
+	// 先に一致したルールのみ適用され、0 の項目は全体設定を引き継ぐ
+	eff := to.Resolve([]string{"q", "translate", "list files"})
+	assert.Equal(t, 2*time.Minute, eff.Command)
+	assert.Equal(t, 10*time.Second, eff.Init)
+
+	eff = to.Resolve([]string{"q", "chat"})
+	assert.Equal(t, 30*time.Second, eff.Command)
+	assert.Equal(t, 30*time.Second, eff.Init)
+	assert.Equal(t, time.Minute, eff.Idle)
+
+	eff = to.Resolve([]string{"ls"})
+	assert.Equal(t, 30*time.Second, eff.Command)
+	assert.Zero(t, eff.Idle)
+	assert.Empty(t, eff.Rules)
+}
@@ -0,0 +1,265 @@
+// Package headless は TUI を使わずに Q chat へ 1 つのプロンプトを送り、応答を受け取る
+//
+// セッションの出力は stream.Processor で整形し（スピナー・Thinking・エコーバックを除く）、
+// 応答の後に Q の入力プロンプト（"> "）が戻ってきた時点で応答の完了とみなす。
+package headless
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/x/ansi"
+	"qube/internal/executor"
+	"qube/internal/logging"
+	"qube/internal/qcompat"
+	"qube/internal/stream"
+)
+
+// Session は Conversation が使う chat セッションの操作（session.Session が実装する）
+type Session interface {
+	Start(mode string) error
+	Send(text string) error
+	Stop() error
+}
+
+// エラーの種類（errors.Is で判定できる）
+var (
+	ErrTimeout      = errors.New("timed out waiting for the response")
+	ErrSessionEnded = errors.New("q chat exited before the response completed")
+	ErrNoResponse   = errors.New("q chat finished without a response")
+)
+
+// DefaultSettle は入力プロンプトを検出してから完了とみなすまでの待ち時間
+// 応答の途中で偶然 "> " が現れた場合に備え、この間に出力が続けば完了とはしない
+const DefaultSettle = 500 * time.Millisecond
+
+// Line は応答の 1 行（ANSI エスケープは除去済み）
+type Line struct {
+	Text string
+	Tool bool // ツール実行の通知（"Using tool: …"）
+	Time time.Time
+}
+
+// Result は 1 回の問い合わせの結果
+type Result struct {
+	Lines    []Line
+	Started  time.Time // プロンプトを送信した時刻
+	Finished time.Time
+}
+
+// Text は応答の本文を返す
+func (r Result) Text() string {
+	texts := make([]string, 0, len(r.Lines))
+	for _, l := range r.Lines {
+		texts = append(texts, l.Text)
+	}
+	return strings.Join(texts, "\n")
+}
+
+// Duration は送信から応答完了までの時間を返す
+func (r Result) Duration() time.Duration { return r.Finished.Sub(r.Started) }
+
+// Conversation は chat セッション上の問い合わせを管理する
+// セッションのコールバック（OnData / OnInitialized / OnExit / OnError）から
+// Data / Initialized / Exited / Failed を呼び出して出力を渡す
+type Conversation struct {
+	sess      Session
+	processor *stream.Processor
+	settle    time.Duration
+	// prompt は Q chat の入力プロンプト（"> ", "!> ", "[profile] > " など）に一致する
+	prompt *regexp.Regexp
+
+	mu          sync.Mutex
+	initialized chan struct{}
+	initOnce    sync.Once
+	done        chan error // 応答の完了（nil）または失敗
+	sending     bool       // プロンプト送信後、完了まで
+	onLine      func(Line)
+	lines       []Line
+	progress    string // 直前の進捗表示（Processor が履歴に確定した進捗行を除くために使う）
+	tail        string // 最後の改行以降の未確定出力（プロンプト検出用）
+	settleTimer *time.Timer
+}
+
+// New は Conversation を作成する
+func New(sess Session) *Conversation {
+	c := &Conversation{
+		sess:        sess,
+		settle:      DefaultSettle,
+		prompt:      qcompat.Default().Prompt,
+		initialized: make(chan struct{}),
+		done:        make(chan error, 1),
+	}
+	c.processor = stream.NewProcessor(c.addLines, c.setProgress)
+	return c
+}
+
+// SetSettle はプロンプト検出後の待ち時間を変更する
+func (c *Conversation) SetSettle(d time.Duration) { c.settle = d }
+
+// SetPatterns は Q の出力の解釈に使うパターンを設定する（Ask の前に呼ぶ）
+func (c *Conversation) SetPatterns(p qcompat.Patterns) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prompt = p.Prompt
+	c.processor.SetPatterns(p)
+}
+
+// Initialized はセッションの初期化完了を通知する
+func (c *Conversation) Initialized() {
+	c.initOnce.Do(func() { close(c.initialized) })
+}
+
+// Data はセッションの出力を渡す
+func (c *Conversation) Data(b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.sending {
+		// 送信前の出力（最初のプロンプト表示など）は捨てる
+		return
+	}
+	// 出力が続いているのでプロンプト検出を取り消す
+	if c.settleTimer != nil {
+		logger().Debug("prompt was not final, output continued")
+		c.settleTimer.Stop()
+		c.settleTimer = nil
+	}
+	c.processor.ProcessData("stdout", string(b))
+
+	// 改行・CR 以降の未確定部分がプロンプトの形なら、応答の完了候補とする
+	c.tail += string(b)
+	if i := strings.LastIndexAny(c.tail, "\r\n"); i >= 0 {
+		c.tail = c.tail[i+1:]
+	}
+	if len(c.lines) > 0 && c.prompt.MatchString(ansi.Strip(c.tail)) {
+		logger().Debug("prompt detected, waiting for output to settle", "settle", c.settle, "lines", len(c.lines))
+		c.settleTimer = time.AfterFunc(c.settle, func() { c.finish(nil) })
+	}
+}
+
+// Exited はセッションの終了を通知する
+func (c *Conversation) Exited(code int) {
+	c.finish(fmt.Errorf("%w (exit code %d)", ErrSessionEnded, code))
+}
+
+// Failed はセッションのエラーを通知する
+func (c *Conversation) Failed(err error) {
+	c.finish(err)
+}
+
+// finish は問い合わせを完了させる（最初の 1 回のみ有効）
+func (c *Conversation) finish(err error) {
+	select {
+	case c.done <- err:
+	default:
+	}
+}
+
+// Ask はセッションを開始してプロンプトを送り、応答の完了を待つ
+// onLine が nil でなければ、確定した行を受信順に渡す
+func (c *Conversation) Ask(ctx context.Context, prompt string, onLine func(Line)) (Result, error) {
+	c.onLine = onLine
+	if err := c.sess.Start("chat"); err != nil {
+		return Result{}, err
+	}
+	defer c.sess.Stop()
+
+	select {
+	case <-c.initialized:
+	case err := <-c.done:
+		if err == nil {
+			err = ErrNoResponse
+		}
+		return Result{}, err
+	case <-ctx.Done():
+		return Result{}, contextErr(ctx)
+	}
+
+	res := Result{Started: time.Now()}
+	c.mu.Lock()
+	c.sending = true
+	c.processor.SetLastSentCommand(prompt)
+	c.mu.Unlock()
+	if err := c.sess.Send(executor.SessionPayload(prompt)); err != nil {
+		return res, err
+	}
+
+	var err error
+	select {
+	case err = <-c.done:
+	case <-ctx.Done():
+		err = contextErr(ctx)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sending = false
+	if c.settleTimer != nil {
+		c.settleTimer.Stop()
+	}
+	res.Lines = append([]Line(nil), c.lines...)
+	res.Finished = time.Now()
+	logger().Info("ask finished", "lines", len(res.Lines), "duration", res.Duration(), "err", err)
+	if err == nil && len(res.Lines) == 0 {
+		err = ErrNoResponse
+	}
+	return res, err
+}
+
+func contextErr(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	return ctx.Err()
+}
+
+// addLines は Processor が確定した行を受け取る（mu を保持した状態で呼ばれる）
+func (c *Conversation) addLines(lines []string) {
+	for _, raw := range lines {
+		// 進捗行（スピナー・Loading 等）は応答に含めない
+		// Processor は最後の進捗表示をそのまま（後続の行を含むことがある）1 度だけ確定する
+		if c.progress != "" && raw == c.progress {
+			logger().Debug("line suppressed", "reason", "committed progress", "line", raw)
+			continue
+		}
+		text := strings.TrimRight(ansi.Strip(raw), " \t\r")
+		plain := strings.TrimSpace(text)
+		if plain != "" && plain == firstLine(c.progress) {
+			logger().Debug("line suppressed", "reason", "progress", "line", plain)
+			continue
+		}
+		// 応答の末尾に付く入力プロンプトは本文ではない
+		if c.prompt.MatchString(text) {
+			logger().Debug("line suppressed", "reason", "prompt", "line", text)
+			continue
+		}
+		l := Line{Text: text, Tool: strings.Contains(text, "Using tool:"), Time: time.Now()}
+		c.lines = append(c.lines, l)
+		if c.onLine != nil {
+			c.onLine(l)
+		}
+	}
+}
+
+// setProgress は Processor の進捗表示の更新を受け取る（mu を保持した状態で呼ばれる）
+func (c *Conversation) setProgress(line *string) {
+	if line != nil {
+		c.progress = *line
+	}
+}
+
+// firstLine は s の最初の行を ANSI エスケープと前後の空白を除いて返す
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(ansi.Strip(s))
+}
+
+func logger() *slog.Logger { return logging.For("headless") }
@@ -0,0 +1,140 @@
+package headless
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSession は Send に応じて台本どおりの出力を返す chat セッション
+type fakeSession struct {
+	conv   *Conversation
+	banner []string // 初期化前後の出力
+	reply  []string // Send 後の出力（チャンク単位）
+	noInit bool     // 初期化せずに終了する
+	mu     sync.Mutex
+	sent   []string
+	stops  int
+}
+
+func (f *fakeSession) Start(string) error {
+	go func() {
+		if f.noInit {
+			f.conv.Exited(1)
+			return
+		}
+		f.conv.Initialized()
+		for _, b := range f.banner {
+			f.conv.Data([]byte(b))
+		}
+	}()
+	return nil
+}
+
+func (f *fakeSession) Send(text string) error {
+	f.mu.Lock()
+	f.sent = append(f.sent, text)
+	f.mu.Unlock()
+	go func() {
+		for _, chunk := range f.reply {
+			f.conv.Data([]byte(chunk))
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	return nil
+}
+
+func (f *fakeSession) Stop() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stops++
+	return nil
+}
+
+func newFake(reply ...string) (*fakeSession, *Conversation) {
+	f := &fakeSession{reply: reply}
+	c := New(f)
+	c.SetSettle(20 * time.Millisecond)
+	f.conv = c
+	return f, c
+}
+
+func TestAsk_CleansResponseAndStopsAtPrompt(t *testing.T) {
+	f, c := newFake(
+		"what is 2+2\r\n",
+		"⠋ Thinking...\r⠙ Thinking...\r",
+		"\x1b[32m2 + 2\x1b[0m = 4\r\n",
+		"🛠️  Using tool: calc\r\n",
+		"Loading... 50%\rLoading... 100%\r\nDone.\r\n",
+		"\r\n\x1b[35m> \x1b[0m",
+	)
+	f.banner = []string{"\x1b[35m> \x1b[0m"}
+
+	var streamed []string
+	res, err := c.Ask(context.Background(), "what is 2+2", func(l Line) { streamed = append(streamed, l.Text) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "2 + 2 = 4\n🛠️  Using tool: calc\nDone."
+	if res.Text() != want {
+		t.Fatalf("answer:\n%q\nwant\n%q", res.Text(), want)
+	}
+	if strings.Join(streamed, "\n") != want {
+		t.Fatalf("lines should be streamed in order: %q", streamed)
+	}
+	if !res.Lines[1].Tool || res.Lines[0].Tool {
+		t.Fatalf("tool lines should be marked: %+v", res.Lines)
+	}
+	if f.sent[0] != "what is 2+2\r" || f.stops != 1 {
+		t.Fatalf("sent=%q stops=%d", f.sent, f.stops)
+	}
+}
+
+func TestAsk_MultilinePromptUsesBracketedPaste(t *testing.T) {
+	f, c := newFake("line one\r\n", "line two\r\n", "answer\r\n", "> ")
+	res, err := c.Ask(context.Background(), "line one\nline two", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Text() != "answer" {
+		t.Fatalf("echo of every prompt line should be dropped: %q", res.Text())
+	}
+	if !strings.HasPrefix(f.sent[0], "\x1b[200~") {
+		t.Fatalf("multi-line prompt should be pasted: %q", f.sent[0])
+	}
+}
+
+func TestAsk_PromptInsideResponseDoesNotFinish(t *testing.T) {
+	// 応答の途中で "> " が現れても、出力が続けば完了としない
+	f, c := newFake("first\r\n> ", "quoted text\r\n", "last\r\n> ")
+	c.SetSettle(200 * time.Millisecond)
+	_ = f
+	res, err := c.Ask(context.Background(), "q", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(res.Text(), "last") {
+		t.Fatalf("response ended early: %q", res.Text())
+	}
+}
+
+func TestAsk_Failures(t *testing.T) {
+	// 応答が完了しなければタイムアウト
+	_, c := newFake("partial answer\r\n")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	res, err := c.Ask(ctx, "q", nil)
+	if !errors.Is(err, ErrTimeout) || res.Text() != "partial answer" {
+		t.Fatalf("err=%v text=%q", err, res.Text())
+	}
+
+	// 初期化前にセッションが終了
+	f, c := newFake()
+	f.noInit = true
+	if _, err := c.Ask(context.Background(), "q", nil); !errors.Is(err, ErrSessionEnded) {
+		t.Fatalf("err=%v", err)
+	}
+}
@@ -0,0 +1,353 @@
+// Package history はコマンド/チャット履歴をディスクに永続化する
+//
+// 履歴は JSONL（1 行 1 エントリ）の追記専用ファイルとして保存する。
+// 複数の Qube を同時に起動しても壊れないよう、書き込みと圧縮はファイルロック下で行う。
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mode はエントリが入力されたときのモード
+type Mode string
+
+const (
+	ModeCommand Mode = "command" // 短命コマンド・スラッシュコマンド
+	ModeChat    Mode = "chat"    // q chat への入力
+)
+
+// Entry は履歴 1 件分の記録
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Text      string    `json:"text"`
+	Mode      Mode      `json:"mode"`
+	Cwd       string    `json:"cwd,omitempty"`
+	Exit      *int      `json:"exit,omitempty"` // 終了コード（短命コマンドのみ）
+	SessionID string    `json:"session_id,omitempty"`
+}
+
+// Scope は読み込む履歴の範囲
+type Scope int
+
+const (
+	ScopeGlobal  Scope = iota // すべてのディレクトリの履歴
+	ScopeProject              // 現在のプロジェクト配下で入力された履歴のみ
+)
+
+// ParseScope は "global" / "project" を Scope に変換する
+func ParseScope(s string) (Scope, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "global":
+		return ScopeGlobal, nil
+	case "project":
+		return ScopeProject, nil
+	}
+	return ScopeGlobal, fmt.Errorf("unknown history scope %q (want global or project)", s)
+}
+
+func (s Scope) String() string {
+	if s == ScopeProject {
+		return "project"
+	}
+	return "global"
+}
+
+const (
+	// DefaultMaxEntries は保持する履歴の既定の上限
+	DefaultMaxEntries = 10000
+	// DefaultFileName はホームディレクトリ直下の履歴ファイル名
+	DefaultFileName = ".qube_history"
+)
+
+// DefaultPath は既定の履歴ファイルのパス（~/.qube_history）を返す
+func DefaultPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve home directory: %w", err)
+	}
+	return filepath.Join(home, DefaultFileName), nil
+}
+
+// NewSessionID は Qube の起動ごとに割り当てるランダムな ID を返す
+func NewSessionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Store は履歴ファイルへの追記・読み込み・圧縮を行う
+//
+// 上限を超えたエントリは一定の余裕（上限の 1/10）を超えた時点でまとめて圧縮する。
+// 圧縮では同じテキストの重複を最新の 1 件にまとめ、新しいものから上限件数を残す。
+type Store struct {
+	path string
+	max  int
+
+	mu    sync.Mutex
+	count int // ファイル上のおおよその行数（-1 は未計測）
+}
+
+// Open は path の履歴ファイルを扱う Store を返す
+// ファイルは最初の追記時に作成される。max が 0 以下の場合は DefaultMaxEntries を使う
+func Open(path string, max int) *Store {
+	if max <= 0 {
+		max = DefaultMaxEntries
+	}
+	return &Store{path: path, max: max, count: -1}
+}
+
+// Path は履歴ファイルのパスを返す
+func (s *Store) Path() string { return s.path }
+
+// Append はエントリを 1 行追記する
+// 他のインスタンスと競合しないよう排他ロックを取り、必要に応じて圧縮する
+func (s *Store) Append(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encode history entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := openLocked(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	defer unlockFile(f)
+
+	if _, err := f.Write(line); err != nil {
+		return fmt.Errorf("write history %s: %w", s.path, err)
+	}
+
+	if s.count < 0 {
+		entries, err := readEntries(s.path)
+		if err != nil {
+			return err
+		}
+		s.count = len(entries)
+	} else {
+		s.count++
+	}
+	if s.count > s.max+s.max/10 {
+		return s.compactLocked()
+	}
+	return nil
+}
+
+// openLocked は追記用に履歴ファイルを開き、排他ロックを取る
+// ロック待ちの間に別インスタンスの圧縮でファイルが置き換えられた場合は開き直す
+func openLocked(path string) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("open history %s: %w", path, err)
+		}
+		if err := lockFile(f, true); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("lock history %s: %w", path, err)
+		}
+		opened, err1 := f.Stat()
+		current, err2 := os.Stat(path)
+		if err1 == nil && err2 == nil && os.SameFile(opened, current) {
+			return f, nil
+		}
+		unlockFile(f)
+		f.Close()
+		if err1 != nil {
+			return nil, fmt.Errorf("stat history %s: %w", path, err1)
+		}
+	}
+}
+
+// compactLocked は重複を除いて上限件数まで履歴を切り詰め、ファイルを置き換える
+// 呼び出し元は s.path の排他ロックを保持していること
+func (s *Store) compactLocked() error {
+	entries, err := readEntries(s.path)
+	if err != nil {
+		return err
+	}
+	entries = Dedup(entries)
+	if len(entries) > s.max {
+		entries = entries[len(entries)-s.max:]
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("encode history entry: %w", err)
+		}
+	}
+
+	// 一時ファイルに書いてから rename し、途中で落ちても履歴を失わないようにする
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("compact history %s: %w", s.path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("compact history %s: %w", s.path, err)
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("compact history %s: %w", s.path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("compact history %s: %w", s.path, err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("compact history %s: %w", s.path, err)
+	}
+	s.count = len(entries)
+	return nil
+}
+
+// Load は履歴を古い順に読み込む
+// 同じテキストは最新の 1 件にまとめ、scope が ScopeProject の場合は
+// cwd を含むプロジェクト（ProjectRoot）配下で入力されたエントリのみを返す
+func (s *Store) Load(scope Scope, cwd string) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open history %s: %w", s.path, err)
+	}
+	defer f.Close()
+	if err := lockFile(f, false); err != nil {
+		return nil, fmt.Errorf("lock history %s: %w", s.path, err)
+	}
+	defer unlockFile(f)
+
+	entries, err := decodeEntries(f)
+	if err != nil {
+		return nil, fmt.Errorf("read history %s: %w", s.path, err)
+	}
+	s.count = len(entries)
+
+	if scope == ScopeProject {
+		root := ProjectRoot(cwd)
+		filtered := entries[:0]
+		for _, e := range entries {
+			if within(root, e.Cwd) {
+				filtered = append(filtered, e)
+			}
+		}
+		entries = filtered
+	}
+	return Dedup(entries), nil
+}
+
+// Dedup は同じテキストのエントリを最新の 1 件にまとめる（順序は古い順を保つ）
+func Dedup(entries []Entry) []Entry {
+	seen := make(map[string]bool, len(entries))
+	out := make([]Entry, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		if seen[entries[i].Text] {
+			continue
+		}
+		seen[entries[i].Text] = true
+		out = append(out, entries[i])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// readEntries は path の履歴をすべて読み込む（ロックは呼び出し元で取る）
+func readEntries(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open history %s: %w", path, err)
+	}
+	defer f.Close()
+	entries, err := decodeEntries(f)
+	if err != nil {
+		return nil, fmt.Errorf("read history %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// decodeEntries は JSONL を読み込む
+// 書き込み途中で終了した行など、壊れた行は読み飛ばす
+func decodeEntries(f *os.File) ([]Entry, error) {
+	var entries []Entry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil || e.Text == "" {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// ProjectRoot は dir を含むプロジェクトのルートを返す
+// 親ディレクトリをたどって .git を探し、見つからなければ dir 自身をルートとする
+func ProjectRoot(dir string) string {
+	dir = filepath.Clean(dir)
+	for d := dir; ; {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return d
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir
+		}
+		d = parent
+	}
+}
+
+// within は path が root と同じか、その配下にあるかを返す
+func within(root, path string) bool {
+	if path == "" {
+		return false
+	}
+	rel, err := filepath.Rel(root, filepath.Clean(path))
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// Recorder は Store に対し、起動ごとの共通情報（作業ディレクトリとセッション ID）を付けて記録する
+type Recorder struct {
+	Store     *Store
+	Cwd       string
+	SessionID string
+}
+
+// Record はテキスト・モード・終了コードからエントリを作って追記する
+func (r *Recorder) Record(text string, mode Mode, exit *int) error {
+	return r.Store.Append(Entry{
+		Time:      time.Now(),
+		Text:      text,
+		Mode:      mode,
+		Cwd:       r.Cwd,
+		Exit:      exit,
+		SessionID: r.SessionID,
+	})
+}
@@ -0,0 +1,169 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func texts(entries []Entry) []string {
+	var out []string
+	for _, e := range entries {
+		out = append(out, e.Text)
+	}
+	return out
+}
+
+func Test_AppendAndLoad_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	s := Open(path, 0)
+	r := &Recorder{Store: s, Cwd: "/work/app", SessionID: "abc"}
+
+	code := 2
+	if err := r.Record("q help", ModeCommand, &code); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Record("hello", ModeChat, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := Open(path, 0).Load(ScopeGlobal, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries", len(entries))
+	}
+	e := entries[0]
+	if e.Text != "q help" || e.Mode != ModeCommand || e.Exit == nil || *e.Exit != 2 ||
+		e.Cwd != "/work/app" || e.SessionID != "abc" || e.Time.IsZero() {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	if entries[1].Mode != ModeChat || entries[1].Exit != nil {
+		t.Fatalf("unexpected chat entry: %+v", entries[1])
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("history should be private, got %v", info.Mode().Perm())
+	}
+}
+
+func Test_Load_DedupsAndSkipsBrokenLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	s := Open(path, 0)
+	for _, text := range []string{"a", "b", "a", "c"} {
+		if err := s.Append(Entry{Text: text, Mode: ModeChat}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 書き込み途中で落ちた行を模擬する
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`{"text":"trunc`)
+	f.Close()
+
+	entries, err := s.Load(ScopeGlobal, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(texts(entries)); got != "[b a c]" {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func Test_Append_CompactsToCap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	s := Open(path, 10)
+	for i := 0; i < 30; i++ {
+		if err := s.Append(Entry{Text: fmt.Sprintf("cmd %d", i), Mode: ModeCommand}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := readEntries(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 上限 + 余裕（1/10）を超えないこと、最新のエントリが残ること
+	if len(entries) > 11 {
+		t.Fatalf("history should be compacted, got %d lines", len(entries))
+	}
+	if last := entries[len(entries)-1].Text; last != "cmd 29" {
+		t.Fatalf("latest entry lost: %q", last)
+	}
+}
+
+func Test_Load_ProjectScope(t *testing.T) {
+	root := t.TempDir()
+	project := filepath.Join(root, "project")
+	sub := filepath.Join(project, "sub")
+	if err := os.MkdirAll(filepath.Join(project, ".git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	s := Open(filepath.Join(root, "history"), 0)
+	s.Append(Entry{Text: "in project", Cwd: project})
+	s.Append(Entry{Text: "in sub", Cwd: sub})
+	s.Append(Entry{Text: "elsewhere", Cwd: filepath.Join(root, "other")})
+	s.Append(Entry{Text: "sibling", Cwd: project + "-2"})
+
+	entries, err := s.Load(ScopeProject, sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(texts(entries)); got != "[in project in sub]" {
+		t.Fatalf("got %s", got)
+	}
+	all, _ := s.Load(ScopeGlobal, sub)
+	if len(all) != 4 {
+		t.Fatalf("global scope should return everything, got %d", len(all))
+	}
+}
+
+func Test_Append_ConcurrentInstances(t *testing.T) {
+	// 複数の Qube が同じファイルに書き込む状況を、別々の Store で模擬する
+	path := filepath.Join(t.TempDir(), "history")
+	const instances, perInstance = 4, 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < instances; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := Open(path, 1000)
+			for j := 0; j < perInstance; j++ {
+				if err := s.Append(Entry{Text: fmt.Sprintf("%d-%d", i, j)}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	entries, err := readEntries(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != instances*perInstance {
+		t.Fatalf("expected %d intact entries, got %d", instances*perInstance, len(entries))
+	}
+}
+
+func Test_ParseScope(t *testing.T) {
+	if s, err := ParseScope("Project"); err != nil || s != ScopeProject {
+		t.Fatalf("got %v, %v", s, err)
+	}
+	if s, err := ParseScope(""); err != nil || s != ScopeGlobal {
+		t.Fatalf("got %v, %v", s, err)
+	}
+	if _, err := ParseScope("team"); err == nil {
+		t.Fatal("unknown scope should be an error")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package history
+
+import "os"
+
+// flock がないプラットフォームではロックしない
+// 1 行ずつの O_APPEND 書き込みに頼るため、圧縮と同時に書き込まれた行は失われることがある
+func lockFile(f *os.File, exclusive bool) error { return nil }
+
+func unlockFile(f *os.File) error { return nil }
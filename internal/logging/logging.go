@@ -0,0 +1,122 @@
+// Package logging は Qube の構造化ログ（log/slog）の出力先と PTY の生データトレースを設定する
+//
+// 各パッケージは For(component) で得たロガーに書き込む。出力先は Setup で決まり、
+// TUI の実行中は端末を乱さないようファイル以外には書き出さない。
+// PTY トレースは Q との間でやり取りした生のバイト列を hex またはエスケープ表記で記録し、
+// 初期化検知やエコー抑制の不具合を調べるために使う。トレースは常にファイルへ書き出す。
+package logging
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// TraceFormat は PTY トレースの表記
+type TraceFormat string
+
+const (
+	TraceOff     TraceFormat = ""
+	TraceHex     TraceFormat = "hex"     // 16 進数（バイト列を正確に残す）
+	TraceEscaped TraceFormat = "escaped" // Go の文字列リテラル形式（\x1b[0m や \r を読める形で残す）
+)
+
+// ParseTraceFormat は "hex" / "escaped" / "off" を TraceFormat に変換する
+// "1" / "true" は escaped として扱う
+func ParseTraceFormat(s string) (TraceFormat, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "0", "off", "false":
+		return TraceOff, nil
+	case "hex":
+		return TraceHex, nil
+	case "escaped", "1", "true", "on":
+		return TraceEscaped, nil
+	}
+	return TraceOff, fmt.Errorf("unknown trace format %q (want hex, escaped or off)", s)
+}
+
+// ParseLevel は "debug" / "info" / "warn" / "error" を slog.Level に変換する
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return slog.LevelInfo, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", s)
+	}
+	return l, nil
+}
+
+// Options はログの出力先の設定
+type Options struct {
+	// File はログの追記先。空なら Fallback に書き出す
+	File  string
+	Level slog.Level
+	// Trace は PTY トレースの表記（TraceOff なら記録しない）
+	// File が空の場合は一時ディレクトリにファイルを作り、Level は Debug になる
+	Trace TraceFormat
+	// Fallback は File が空の場合の出力先（nil なら破棄する）。Warn 以上だけを書き出す
+	Fallback io.Writer
+}
+
+// trace は現在の PTY トレースの表記（Setup で設定する）
+var trace atomic.Value // TraceFormat
+
+// Setup はログの出力先を設定し、slog の既定のロガーにする
+// 返す path は実際に書き出すファイル（Fallback の場合は空）、closeFn はファイルを閉じる
+func Setup(opts Options) (path string, closeFn func() error, err error) {
+	closeFn = func() error { return nil }
+	trace.Store(opts.Trace)
+
+	if opts.Trace != TraceOff {
+		opts.Level = min(opts.Level, slog.LevelDebug)
+		if opts.File == "" {
+			opts.File = filepath.Join(os.TempDir(), fmt.Sprintf("qube-trace-%d.log", os.Getpid()))
+		}
+	}
+
+	var w io.Writer = io.Discard
+	level := opts.Level
+	switch {
+	case opts.File != "":
+		f, err := os.OpenFile(opts.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			trace.Store(TraceOff)
+			return "", closeFn, err
+		}
+		w, path, closeFn = f, opts.File, f.Close
+	case opts.Fallback != nil:
+		w = opts.Fallback
+		level = max(level, slog.LevelWarn)
+	}
+
+	// SetDefault により log.Printf（依存ライブラリを含む）も同じハンドラーに Info で書き出される
+	slog.SetDefault(slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: level})))
+	return path, closeFn, nil
+}
+
+// For は component 属性付きのロガーを返す
+// Setup 後に出力先が変わっても追従するよう、ロガーは使うたびに取得する
+func For(component string) *slog.Logger {
+	return slog.Default().With("component", component)
+}
+
+// Trace は PTY とやり取りした生データを記録する（トレースが無効なら何もしない）
+// dir は "read"（Q からの出力）または "write"（Q への入力）
+func Trace(dir string, b []byte) {
+	f, _ := trace.Load().(TraceFormat)
+	if f == TraceOff || len(b) == 0 {
+		return
+	}
+	For("pty").Debug("pty "+dir, "len", len(b), "data", formatTrace(f, b))
+}
+
+func formatTrace(f TraceFormat, b []byte) string {
+	if f == TraceHex {
+		return hex.EncodeToString(b)
+	}
+	return strconv.Quote(string(b))
+}
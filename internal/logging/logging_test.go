@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// restoreDefault はテスト後に slog の既定のロガーとトレース設定を戻す
+func restoreDefault(t *testing.T) {
+	prev := slog.Default()
+	t.Cleanup(func() {
+		slog.SetDefault(prev)
+		trace.Store(TraceOff)
+	})
+}
+
+func TestSetup_FileAndTrace(t *testing.T) {
+	restoreDefault(t)
+	path := filepath.Join(t.TempDir(), "qube.log")
+	got, closeFn, err := Setup(Options{File: path, Level: slog.LevelInfo, Trace: TraceEscaped})
+	if err != nil || got != path {
+		t.Fatalf("path=%q err=%v", got, err)
+	}
+	For("session").Debug("chat init detected", "model", "claude")
+	Trace("read", []byte("\x1b[32m> \x1b[0m\r\n"))
+	closeFn()
+
+	b, _ := os.ReadFile(path)
+	out := string(b)
+	for _, want := range []string{
+		"component=session", "chat init detected", // トレース有効時は Debug まで記録する
+		"component=pty", `msg="pty read"`, `len=13`, `\\x1b[32m> \\x1b[0m\\r\\n`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestSetup_TraceWithoutFileUsesTempFile(t *testing.T) {
+	restoreDefault(t)
+	var stderr bytes.Buffer
+	path, closeFn, err := Setup(Options{Trace: TraceHex, Fallback: &stderr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+	Trace("write", []byte("hi\r"))
+	closeFn()
+
+	if !strings.HasPrefix(path, os.TempDir()) {
+		t.Fatalf("trace should go to a temp file: %q", path)
+	}
+	if b, _ := os.ReadFile(path); !strings.Contains(string(b), "data=68690d") {
+		t.Fatalf("hex trace: %s", b)
+	}
+	if stderr.Len() != 0 {
+		t.Fatalf("trace must never be written to the terminal: %q", stderr.String())
+	}
+}
+
+func TestSetup_FallbackOnlyWarnings(t *testing.T) {
+	restoreDefault(t)
+	var stderr bytes.Buffer
+	if _, _, err := Setup(Options{Level: slog.LevelDebug, Fallback: &stderr}); err != nil {
+		t.Fatal(err)
+	}
+	For("executor").Info("status changed")
+	Trace("read", []byte("ignored"))
+	For("executor").Warn("execution failed")
+	if out := stderr.String(); strings.Contains(out, "status changed") || strings.Contains(out, "ignored") || !strings.Contains(out, "execution failed") {
+		t.Fatalf("fallback should only show warnings: %q", out)
+	}
+}
+
+func TestParseTraceFormatAndLevel(t *testing.T) {
+	for in, want := range map[string]TraceFormat{"": TraceOff, "off": TraceOff, "1": TraceEscaped, "escaped": TraceEscaped, "HEX": TraceHex} {
+		if got, err := ParseTraceFormat(in); err != nil || got != want {
+			t.Errorf("ParseTraceFormat(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseTraceFormat("binary"); err == nil {
+		t.Error("unknown trace format should be an error")
+	}
+	if l, err := ParseLevel("debug"); err != nil || l != slog.LevelDebug {
+		t.Errorf("ParseLevel(debug) = %v, %v", l, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("unknown level should be an error")
+	}
+}
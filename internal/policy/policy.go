@@ -0,0 +1,175 @@
+package policy
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Decision は q 以外のコマンド（パススルー）に対する実行可否を表す
+type Decision int
+
+const (
+	Allow   Decision = iota // そのまま実行
+	Deny                    // 実行しない
+	Confirm                 // ユーザーの確認後に実行
+)
+
+func (d Decision) String() string {
+	switch d {
+	case Allow:
+		return "allow"
+	case Deny:
+		return "deny"
+	case Confirm:
+		return "confirm"
+	default:
+		return fmt.Sprintf("Decision(%d)", int(d))
+	}
+}
+
+// Verdict は評価結果。Rule は一致したパターン（なければ空）、Reason は判定理由
+type Verdict struct {
+	Decision Decision
+	Rule     string
+	Reason   string
+}
+
+func (v Verdict) String() string {
+	if v.Rule != "" {
+		return fmt.Sprintf("%s (%s: %q)", v.Decision, v.Reason, v.Rule)
+	}
+	return fmt.Sprintf("%s (%s)", v.Decision, v.Reason)
+}
+
+// Policy は Qube に入力された q 以外のコマンドの実行ポリシー
+// 評価順: パススルー無効 → denylist → allowlist → 未登録時の既定動作
+type Policy struct {
+	// Passthrough が false の場合、q サブコマンド以外は全て拒否する
+	Passthrough bool
+	// Allow は確認なしで実行するコマンドのパターン
+	Allow []string
+	// Deny は常に拒否するコマンドのパターン（Allow より優先）
+	Deny []string
+	// ConfirmUnlisted が true の場合、どちらにも一致しないコマンドは確認を求める
+	// false の場合はそのまま実行する
+	ConfirmUnlisted bool
+}
+
+// Default は既定のポリシーを返す
+// 読み取り系の基本コマンドのみ許可し、破壊的なコマンドは拒否、それ以外は確認する
+func Default() Policy {
+	return Policy{
+		Passthrough: true,
+		Allow: []string{
+			"ls", "pwd", "echo", "cat", "head", "tail", "wc", "whoami", "date",
+			"git status*", "git diff*", "git log*",
+		},
+		Deny: []string{
+			"rm", "rmdir", "sudo", "su", "dd", "mkfs*",
+			"shutdown", "reboot", "halt", "poweroff",
+		},
+		ConfirmUnlisted: true,
+	}
+}
+
+// Evaluate は argv に対する実行可否を判定する
+func (p Policy) Evaluate(argv []string) Verdict {
+	if len(argv) == 0 {
+		return Verdict{Decision: Deny, Reason: "empty command"}
+	}
+	if !p.Passthrough {
+		return Verdict{Decision: Deny, Reason: "passthrough disabled"}
+	}
+	if v, denied := p.Denied(argv); denied {
+		return v
+	}
+	for _, pat := range p.Allow {
+		if MatchAllow(pat, argv) {
+			return Verdict{Decision: Allow, Rule: pat, Reason: "allowlist"}
+		}
+	}
+	if p.ConfirmUnlisted {
+		return Verdict{Decision: Confirm, Reason: "not listed"}
+	}
+	return Verdict{Decision: Allow, Reason: "not listed"}
+}
+
+// Denied は argv が denylist に一致するかを返す（パススルーの有効・無効は問わない）
+// q サブコマンドの引数（"q rm -rf x" の "rm -rf x"）の検査にも使う
+func (p Policy) Denied(argv []string) (Verdict, bool) {
+	for _, pat := range p.Deny {
+		if Match(pat, argv) {
+			return Verdict{Decision: Deny, Rule: pat, Reason: "denylist"}, true
+		}
+	}
+	return Verdict{}, false
+}
+
+// Match はパターンが argv に一致するかを返す（denylist・タイムアウトの規則に使う）
+// パターンは空白区切りで連結したコマンドライン全体に対して照合する。
+// 空白を含まないパターンは、コマンド名（argv[0] とそのベース名）にも照合する。
+// "/bin/rm" も "rm" の拒否に一致させるためで、allowlist には MatchAllow を使う。
+// グロブは '*'（任意の文字列）と '?'（任意の 1 文字）をサポートし、'/' や空白も跨ぐ
+func Match(pattern string, argv []string) bool {
+	if len(argv) == 0 || pattern == "" {
+		return false
+	}
+	if glob(pattern, strings.Join(argv, " ")) {
+		return true
+	}
+	if !strings.ContainsAny(pattern, " \t") {
+		return glob(pattern, argv[0]) || glob(pattern, filepath.Base(argv[0]))
+	}
+	return false
+}
+
+// MatchAllow は allowlist のパターンが argv に一致するかを返す
+// Match と違いベース名では照合しない。"ls" の許可で "./ls" や "/tmp/evil/ls" が実行されないよう、
+// パスを含むコマンド名はパスを含むパターン（例: "/bin/ls"）にだけ一致する
+func MatchAllow(pattern string, argv []string) bool {
+	if len(argv) == 0 || pattern == "" {
+		return false
+	}
+	name := strings.Fields(pattern)
+	if len(name) == 0 {
+		return false
+	}
+	if strings.ContainsRune(argv[0], '/') && !strings.ContainsRune(name[0], '/') {
+		return false
+	}
+	if glob(pattern, strings.Join(argv, " ")) {
+		return true
+	}
+	return len(name) == 1 && glob(pattern, argv[0])
+}
+
+// glob は '*' と '?' のみを解釈する単純なワイルドカード照合を行う
+func glob(pattern, s string) bool {
+	p := []rune(pattern)
+	r := []rune(s)
+	pi, si := 0, 0
+	star, mark := -1, 0
+	for si < len(r) {
+		switch {
+		case pi < len(p) && (p[pi] == '?' || p[pi] == r[si]):
+			pi++
+			si++
+		case pi < len(p) && p[pi] == '*':
+			star = pi
+			mark = si
+			pi++
+		case star >= 0:
+			// 直前の '*' に 1 文字多く吸収させてやり直す
+			pi = star + 1
+			mark++
+			si = mark
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
@@ -0,0 +1,96 @@
+package policy
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		argv    []string
+		want    bool
+	}{
+		{"rm", []string{"rm", "-rf", "/"}, true},
+		{"rm", []string{"/bin/rm", "x"}, true},
+		{"rm", []string{"rmdir", "x"}, false},
+		{"git status*", []string{"git", "status", "-s"}, true},
+		{"git status*", []string{"git", "push"}, false},
+		{"mkfs*", []string{"mkfs.ext4", "/dev/sda"}, true},
+		{"* /dev/sd?", []string{"dd", "of=x", "/dev/sda"}, true},
+		{"", []string{"ls"}, false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.argv); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.argv, got, tt.want)
+		}
+	}
+}
+
+func TestMatchAllow(t *testing.T) {
+	tests := []struct {
+		pattern string
+		argv    []string
+		want    bool
+	}{
+		{"ls", []string{"ls", "-la"}, true},
+		{"ls", []string{"./ls"}, false},
+		{"cat", []string{"/tmp/evil/cat", "x"}, false},
+		{"/bin/ls", []string{"/bin/ls", "-l"}, true},
+		{"/bin/*", []string{"/bin/cat", "x"}, true},
+		{"git status*", []string{"git", "status", "-s"}, true},
+		{"git status*", []string{"./git", "status"}, false},
+		{"*", []string{"bin/evil"}, false},
+		{"", []string{"ls"}, false},
+	}
+	for _, tt := range tests {
+		if got := MatchAllow(tt.pattern, tt.argv); got != tt.want {
+			t.Errorf("MatchAllow(%q, %q) = %v, want %v", tt.pattern, tt.argv, got, tt.want)
+		}
+	}
+}
+
+func TestPolicy_Evaluate(t *testing.T) {
+	p := Policy{
+		Passthrough:     true,
+		Allow:           []string{"ls", "rm *.tmp"},
+		Deny:            []string{"rm"},
+		ConfirmUnlisted: true,
+	}
+
+	// denylist は allowlist より優先される
+	if v := p.Evaluate([]string{"rm", "a.tmp"}); v.Decision != Deny || v.Rule != "rm" {
+		t.Errorf("rm a.tmp: got %v, want deny by rm", v)
+	}
+	if v := p.Evaluate([]string{"ls", "-la"}); v.Decision != Allow || v.Rule != "ls" {
+		t.Errorf("ls -la: got %v, want allow by ls", v)
+	}
+	if v := p.Evaluate([]string{"make"}); v.Decision != Confirm {
+		t.Errorf("make: got %v, want confirm", v)
+	}
+	// パス付きのコマンド名は名前だけの allowlist に一致しない
+	if v := p.Evaluate([]string{"/tmp/evil/ls"}); v.Decision != Confirm {
+		t.Errorf("/tmp/evil/ls: got %v, want confirm", v)
+	}
+
+	// 未登録を許可する設定
+	p.ConfirmUnlisted = false
+	if v := p.Evaluate([]string{"make"}); v.Decision != Allow {
+		t.Errorf("make without confirm: got %v, want allow", v)
+	}
+
+	// パススルー無効時は全て拒否
+	p.Passthrough = false
+	if v := p.Evaluate([]string{"ls"}); v.Decision != Deny || v.Reason != "passthrough disabled" {
+		t.Errorf("ls with passthrough off: got %v, want deny", v)
+	}
+}
+
+func TestDefault_DeniesDestructiveCommands(t *testing.T) {
+	p := Default()
+	for _, argv := range [][]string{{"rm", "-rf", "."}, {"sudo", "ls"}, {"dd", "if=/dev/zero"}} {
+		if v := p.Evaluate(argv); v.Decision != Deny {
+			t.Errorf("%q: got %v, want deny", argv, v)
+		}
+	}
+	if v := p.Evaluate([]string{"pwd"}); v.Decision != Allow {
+		t.Errorf("pwd: got %v, want allow", v)
+	}
+}
@@ -0,0 +1,357 @@
+// Package qcli は Amazon Q CLI のバイナリを検出する
+//
+// 検出順:
+//  1. 明示的な指定（--q-bin / QUBE_Q_BIN / Q_BIN / 設定ファイルの qBin）
+//  2. PATH 上の amazonq, q
+//  3. 既知のインストール先（Homebrew、macOS のアプリ、~/.local/bin など）
+//
+// 明示的に指定されたバイナリが使えない場合は、他の候補へは切り替えずにエラーとする。
+// 検出結果はプロセス内でキャッシュし、execq・session など全てのパッケージで共有する。
+package qcli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source は候補の出どころ
+type Source string
+
+const (
+	SourceExplicit  Source = "explicit"   // 設定・フラグ・環境変数で指定
+	SourcePath      Source = "PATH"       // PATH 上のコマンド名
+	SourceWellKnown Source = "well-known" // 既知のインストール先
+)
+
+// ErrNotFound は Q CLI が見つからない場合のエラー
+var ErrNotFound = errors.New("Amazon Q CLI not found: install it (https://aws.amazon.com/q/developer/) or set qBin / Q_BIN")
+
+// DefaultProbeTimeout は --version の実行を待つ時間
+const DefaultProbeTimeout = 5 * time.Second
+
+// pathNames は PATH から探すコマンド名（優先順）
+var pathNames = []string{"amazonq", "q"}
+
+// Candidate は検出の候補 1 件
+type Candidate struct {
+	Name   string // 指定・探索した名前（"q"、"/opt/homebrew/bin/q" など）
+	Source Source
+	Via    string // 指定元（"--q-bin"、"Q_BIN" など。SourceExplicit のみ）
+	Path   string // 解決した実行ファイルのパス（見つからなければ空）
+	// Version は --version から取得したバージョン（Probe 前・失敗時は空）
+	Version string
+	Err     error // 使えない理由（見つからない・--version の失敗など）
+}
+
+// Usable は実行ファイルが見つかり、エラーがないかを返す
+func (c Candidate) Usable() bool { return c.Path != "" && c.Err == nil }
+
+// Result は検出結果
+type Result struct {
+	// Selected は使用するバイナリ（見つからなければ Path が空）
+	Selected Candidate
+	// Candidates は調べた全ての候補（優先順）。同じ実行ファイルは 1 件にまとめる
+	Candidates []Candidate
+	// Err は使えるバイナリが無い場合のエラー
+	Err error
+}
+
+// Options は Discoverer の設定
+type Options struct {
+	// Explicit は明示的に指定されたバイナリ（パスまたはコマンド名）。空なら自動検出する
+	Explicit string
+	// ExplicitVia は Explicit の指定元（エラーメッセージに使う）
+	ExplicitVia string
+	// WellKnown は既知のインストール先（nil なら WellKnownPaths()）
+	WellKnown []string
+	// ProbeTimeout は --version の実行を待つ時間（0 以下なら DefaultProbeTimeout）
+	ProbeTimeout time.Duration
+
+	// テスト用の差し替え（nil なら exec.LookPath / --version の実行）
+	LookPath func(file string) (string, error)
+	Probe    func(ctx context.Context, path string) (string, error)
+}
+
+// Discoverer は Q CLI を検出し、結果をキャッシュする
+type Discoverer struct {
+	opts Options
+
+	mu       sync.Mutex
+	looked   bool
+	result   Result            // 探索結果（バージョンは versions から補う）
+	versions map[string]probed // 実行ファイルのパス → --version の結果
+}
+
+type probed struct {
+	version string
+	err     error
+}
+
+// New は Discoverer を作成する
+func New(opts Options) *Discoverer {
+	if opts.WellKnown == nil {
+		opts.WellKnown = WellKnownPaths()
+	}
+	if opts.ProbeTimeout <= 0 {
+		opts.ProbeTimeout = DefaultProbeTimeout
+	}
+	if opts.LookPath == nil {
+		opts.LookPath = exec.LookPath
+	}
+	if opts.Probe == nil {
+		opts.Probe = probeVersion
+	}
+	return &Discoverer{opts: opts, versions: map[string]probed{}}
+}
+
+// WellKnownPaths は Q CLI の既知のインストール先を返す
+func WellKnownPaths() []string {
+	var paths []string
+	if runtime.GOOS == "darwin" {
+		paths = append(paths,
+			"/Applications/Amazon Q.app/Contents/MacOS/q",
+			"/opt/homebrew/bin/q",
+		)
+	}
+	paths = append(paths, "/usr/local/bin/q", "/usr/bin/q")
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".local", "bin", "q"))
+	}
+	return paths
+}
+
+// Path は使用する Q CLI の実行ファイルのパスを返す（--version は実行しない）
+func (d *Discoverer) Path() (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lookLocked()
+	if d.result.Err != nil {
+		return "", d.result.Err
+	}
+	return d.result.Selected.Path, nil
+}
+
+// Version は使用する Q CLI のバージョンを返す（--version の結果はキャッシュする）
+func (d *Discoverer) Version(ctx context.Context) (string, error) {
+	path, err := d.Path()
+	if err != nil {
+		return "", err
+	}
+	p := d.probe(ctx, path)
+	return p.version, p.err
+}
+
+// Discover は全ての候補を調べ、見つかった実行ファイルの --version を実行した結果を返す
+func (d *Discoverer) Discover(ctx context.Context) Result {
+	d.mu.Lock()
+	d.lookLocked()
+	res := d.result
+	res.Candidates = append([]Candidate(nil), d.result.Candidates...)
+	d.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := range res.Candidates {
+		if res.Candidates[i].Path == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(c *Candidate) {
+			defer wg.Done()
+			p := d.probe(ctx, c.Path)
+			c.Version, c.Err = p.version, p.err
+		}(&res.Candidates[i])
+	}
+	wg.Wait()
+	for _, c := range res.Candidates {
+		if c.Path == res.Selected.Path && c.Source == res.Selected.Source {
+			res.Selected = c
+			break
+		}
+	}
+	if res.Err == nil && res.Selected.Err != nil {
+		res.Err = res.Selected.Err
+	}
+	return res
+}
+
+// Refresh はキャッシュを捨てる（インストール・設定の変更後に使う）
+func (d *Discoverer) Refresh() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.looked = false
+	d.result = Result{}
+	d.versions = map[string]probed{}
+}
+
+// lookLocked は候補を探す（mu を保持した状態で呼ぶ。結果はキャッシュする）
+func (d *Discoverer) lookLocked() {
+	if d.looked {
+		return
+	}
+	d.looked = true
+
+	var cands []Candidate
+	seen := map[string]bool{}
+	add := func(c Candidate) {
+		if c.Path != "" {
+			if seen[c.Path] {
+				return
+			}
+			seen[c.Path] = true
+		}
+		cands = append(cands, c)
+	}
+
+	if d.opts.Explicit != "" {
+		c := Candidate{Name: d.opts.Explicit, Source: SourceExplicit, Via: d.opts.ExplicitVia}
+		if path, err := d.opts.LookPath(d.opts.Explicit); err == nil {
+			c.Path = absPath(path)
+		} else {
+			c.Err = fmt.Errorf("%s: Amazon Q CLI %q not found or not executable", orDefault(c.Via, "qBin"), d.opts.Explicit)
+		}
+		add(c)
+	}
+	for _, name := range pathNames {
+		c := Candidate{Name: name, Source: SourcePath}
+		if path, err := d.opts.LookPath(name); err == nil {
+			c.Path = absPath(path)
+		} else {
+			c.Err = err
+		}
+		add(c)
+	}
+	for _, p := range d.opts.WellKnown {
+		c := Candidate{Name: p, Source: SourceWellKnown}
+		if path, err := d.opts.LookPath(p); err == nil {
+			c.Path = absPath(path)
+		} else {
+			c.Err = err
+		}
+		add(c)
+	}
+
+	res := Result{Candidates: cands}
+	if d.opts.Explicit != "" {
+		// 明示的な指定は他の候補で代替しない
+		res.Selected = cands[0]
+		res.Err = cands[0].Err
+	} else {
+		res.Err = ErrNotFound
+		for _, c := range cands {
+			if c.Usable() {
+				res.Selected, res.Err = c, nil
+				break
+			}
+		}
+	}
+	d.result = res
+}
+
+// probe は path の --version を実行する（結果はパスごとにキャッシュする）
+func (d *Discoverer) probe(ctx context.Context, path string) probed {
+	d.mu.Lock()
+	p, ok := d.versions[path]
+	d.mu.Unlock()
+	if ok {
+		return p
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.opts.ProbeTimeout)
+	defer cancel()
+	out, err := d.opts.Probe(ctx, path)
+	switch {
+	case err != nil:
+		p.err = fmt.Errorf("%s --version: %w", path, err)
+	case ParseVersion(out) == "":
+		p.err = fmt.Errorf("%s --version: unexpected output %q", path, firstLine(out))
+	default:
+		p.version = ParseVersion(out)
+	}
+	// 期限切れ・取り消しは一時的な失敗なのでキャッシュしない
+	if ctx.Err() == nil {
+		d.mu.Lock()
+		d.versions[path] = p
+		d.mu.Unlock()
+	}
+	return p
+}
+
+func probeVersion(ctx context.Context, path string) (string, error) {
+	out, err := exec.CommandContext(ctx, path, "--version").CombinedOutput()
+	if ctx.Err() != nil {
+		return string(out), ctx.Err()
+	}
+	return string(out), err
+}
+
+// reVersion は "q 1.12.1" や "amazonq v1.12.1-beta" のようなバージョン表記に一致する
+var reVersion = regexp.MustCompile(`v?(\d+\.\d+(?:\.\d+)?(?:[-+][0-9A-Za-z.-]+)?)`)
+
+// ParseVersion は --version の出力からバージョン番号を取り出す（見つからなければ空）
+func ParseVersion(output string) string {
+	m := reVersion.FindStringSubmatch(output)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+func absPath(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+var (
+	defaultMu sync.Mutex
+	def       *Discoverer
+)
+
+// Default は全パッケージで共有する Discoverer を返す
+// SetDefault が呼ばれていなければ Q_BIN 環境変数だけを明示的な指定として扱う
+func Default() *Discoverer {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if def == nil {
+		def = New(Options{Explicit: os.Getenv("Q_BIN"), ExplicitVia: "Q_BIN"})
+	}
+	return def
+}
+
+// SetDefault は共有する Discoverer を設定する（起動時に設定を読み込んだ後に呼ぶ）
+func SetDefault(d *Discoverer) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	def = d
+}
+
+// Path は共有の Discoverer で Q CLI のパスを返す
+func Path() (string, error) { return Default().Path() }
+
+// Version は共有の Discoverer で Q CLI のバージョンを返す
+func Version(ctx context.Context) (string, error) { return Default().Version(ctx) }
@@ -0,0 +1,124 @@
+package qcli
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeSystem は LookPath と --version の結果を差し替える
+type fakeSystem struct {
+	files   map[string]string // 名前 → 解決後のパス
+	outputs map[string]string // パス → --version の出力（無ければ失敗）
+	probes  atomic.Int32
+	lookups atomic.Int32
+}
+
+func (f *fakeSystem) options(explicit, via string, wellKnown ...string) Options {
+	return Options{
+		Explicit:    explicit,
+		ExplicitVia: via,
+		WellKnown:   append([]string{}, wellKnown...),
+		LookPath: func(name string) (string, error) {
+			f.lookups.Add(1)
+			if p, ok := f.files[name]; ok {
+				return p, nil
+			}
+			return "", exec.ErrNotFound
+		},
+		Probe: func(_ context.Context, path string) (string, error) {
+			f.probes.Add(1)
+			if out, ok := f.outputs[path]; ok {
+				return out, nil
+			}
+			return "", errors.New("exit status 1")
+		},
+	}
+}
+
+func TestDiscover_PathThenWellKnown(t *testing.T) {
+	f := &fakeSystem{
+		files: map[string]string{
+			"q":                   "/usr/local/bin/q",
+			"/usr/local/bin/q":    "/usr/local/bin/q", // PATH と同じ実行ファイルは 1 件にまとめる
+			"/opt/homebrew/bin/q": "/opt/homebrew/bin/q",
+		},
+		outputs: map[string]string{"/usr/local/bin/q": "q 1.12.1\n", "/opt/homebrew/bin/q": "q 1.10.0\n"},
+	}
+	d := New(f.options("", "", "/usr/local/bin/q", "/opt/homebrew/bin/q"))
+
+	path, err := d.Path()
+	if err != nil || path != "/usr/local/bin/q" {
+		t.Fatalf("path=%q err=%v", path, err)
+	}
+	if f.probes.Load() != 0 {
+		t.Fatal("Path should not run --version")
+	}
+
+	res := d.Discover(context.Background())
+	if res.Err != nil || res.Selected.Version != "1.12.1" || res.Selected.Source != SourcePath {
+		t.Fatalf("selected: %+v err=%v", res.Selected, res.Err)
+	}
+	var got []string
+	for _, c := range res.Candidates {
+		got = append(got, string(c.Source)+" "+c.Name+" "+c.Path+" "+c.Version)
+	}
+	want := []string{
+		"PATH amazonq  ",
+		"PATH q /usr/local/bin/q 1.12.1",
+		"well-known /opt/homebrew/bin/q /opt/homebrew/bin/q 1.10.0",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("candidates:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestDiscover_CachesLookupsAndProbes(t *testing.T) {
+	f := &fakeSystem{files: map[string]string{"amazonq": "/bin/amazonq"}, outputs: map[string]string{"/bin/amazonq": "amazonq 1.13.0"}}
+	d := New(f.options("", ""))
+	for i := 0; i < 3; i++ {
+		if v, err := d.Version(context.Background()); err != nil || v != "1.13.0" {
+			t.Fatalf("v=%q err=%v", v, err)
+		}
+		d.Path()
+	}
+	lookups := f.lookups.Load()
+	if f.probes.Load() != 1 || lookups != 2 {
+		t.Fatalf("should look up and probe once: lookups=%d probes=%d", lookups, f.probes.Load())
+	}
+	d.Refresh()
+	d.Path()
+	if f.lookups.Load() == lookups {
+		t.Fatal("Refresh should drop the cache")
+	}
+}
+
+func TestDiscover_ExplicitDoesNotFallBack(t *testing.T) {
+	f := &fakeSystem{files: map[string]string{"q": "/usr/bin/q"}}
+	d := New(f.options("/opt/q/bin/q", "--q-bin"))
+	_, err := d.Path()
+	if err == nil || err.Error() != `--q-bin: Amazon Q CLI "/opt/q/bin/q" not found or not executable` {
+		t.Fatalf("err=%v", err)
+	}
+	res := d.Discover(context.Background())
+	if len(res.Candidates) != 3 || res.Candidates[2].Path != "/usr/bin/q" || res.Selected.Source != SourceExplicit {
+		t.Fatalf("other candidates should still be listed for diagnostics: %+v", res.Candidates)
+	}
+}
+
+func TestDiscover_NotFoundAndBadVersion(t *testing.T) {
+	d := New((&fakeSystem{}).options("", ""))
+	if _, err := d.Path(); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err=%v", err)
+	}
+
+	// q という名前の別のツールは --version で見分ける
+	f := &fakeSystem{files: map[string]string{"q": "/usr/bin/q"}, outputs: map[string]string{"/usr/bin/q": "usage: q [options] query"}}
+	res := New(f.options("", "")).Discover(context.Background())
+	if res.Err == nil || !strings.Contains(res.Err.Error(), "unexpected output") {
+		t.Fatalf("err=%v", res.Err)
+	}
+}
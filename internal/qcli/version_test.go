@@ -0,0 +1,17 @@
+package qcli
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	cases := map[string]string{
+		"q 1.12.1\n":              "1.12.1",
+		"amazonq v1.13.0-beta.2":  "1.13.0-beta.2",
+		"Amazon Q CLI 2.0":        "2.0",
+		"error: unknown argument": "",
+	}
+	for in, want := range cases {
+		if got := ParseVersion(in); got != want {
+			t.Errorf("ParseVersion(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
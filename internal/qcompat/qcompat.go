@@ -0,0 +1,303 @@
+// Package qcompat は Q CLI のバージョンごとの出力の違いを吸収する
+//
+// Qube は Q の出力（起動バナー・罫線・スピナー・Thinking 表示・入力プロンプト）を
+// 正規表現で解釈しており、これらは Q のリリースによって変わることがある。
+// このパッケージは動作を確認したバージョンの範囲と解釈用のパターン（パーサープロファイル）の
+// 対応表を持ち、起動時に検出した Q のバージョンに合うパターンを選ぶ。
+// 対応表に無い新しいバージョン向けには、設定ファイルでパターンを上書きできる。
+package qcompat
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Patterns は Q の出力を解釈する正規表現
+type Patterns struct {
+	// InitBanner は chat の起動完了を示すバナー。1 つ目のサブマッチを使用モデル名とする
+	InitBanner *regexp.Regexp
+	// InitSeparator はバナーの代わりに起動完了とみなす罫線
+	InitSeparator *regexp.Regexp
+	// Spinner は CR で上書きされる進捗表示（スピナーのグリフ・"Loading..." など）
+	Spinner *regexp.Regexp
+	// Thinking は応答前の思考中表示（履歴に残さない）
+	Thinking *regexp.Regexp
+	// Prompt は入力待ちのプロンプト（ANSI 除去済みの 1 行に一致させる）
+	Prompt *regexp.Regexp
+}
+
+// Spec は Patterns の文字列表現（設定ファイルの上書きに使う）
+// 空のフィールドは元のパターンをそのまま使う
+type Spec struct {
+	InitBanner    string `json:"initBanner,omitempty"`
+	InitSeparator string `json:"initSeparator,omitempty"`
+	Spinner       string `json:"spinner,omitempty"`
+	Thinking      string `json:"thinking,omitempty"`
+	Prompt        string `json:"prompt,omitempty"`
+}
+
+// Apply は base の各パターンを s の空でないフィールドで置き換えた Patterns を返す
+func (s Spec) Apply(base Patterns) (Patterns, error) {
+	out := base
+	var errs []error
+	set := func(name, src string, dst **regexp.Regexp) {
+		if src == "" {
+			return
+		}
+		re, err := regexp.Compile(src)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		*dst = re
+	}
+	set("initBanner", s.InitBanner, &out.InitBanner)
+	set("initSeparator", s.InitSeparator, &out.InitSeparator)
+	set("spinner", s.Spinner, &out.Spinner)
+	set("thinking", s.Thinking, &out.Thinking)
+	set("prompt", s.Prompt, &out.Prompt)
+	return out, errors.Join(errs...)
+}
+
+// Profile は Q のバージョン範囲と、その範囲で使うパターン
+type Profile struct {
+	Name string
+	// Min 以上 Max 未満のバージョンに使う（Max が空なら上限なし）
+	Min, Max string
+	Spec     Spec
+}
+
+// Contains は version がプロファイルの範囲内かを返す
+func (p Profile) Contains(version string) bool {
+	return Compare(version, p.Min) >= 0 && (p.Max == "" || Compare(version, p.Max) < 0)
+}
+
+// Range は範囲を ">=1.10.0 <1.14.0" の形式で返す
+func (p Profile) Range() string {
+	if p.Max == "" {
+		return ">=" + p.Min
+	}
+	return fmt.Sprintf(">=%s <%s", p.Min, p.Max)
+}
+
+// baseSpec は Q 1.10 – 1.13 の表示に合わせたパターン
+var baseSpec = Spec{
+	InitBanner:    `(?i)You are chatting with ([^\r\n]+)`,
+	InitSeparator: `(?m)[━─]{10,}[\s\S]*?\n\s*\n`,
+	Spinner:       `[⠋⠙⠹⠸⠼⠴⠦⠧⠇⠏].*\.{3}|(?i:Loading\.{3}|Processing\.{3}|Downloading|Uploading|Indexing)`,
+	Thinking:      `(?i)Thinking`,
+	Prompt:        `^\s*(\[[^\]]*\]\s*)?!?>\s*$`,
+}
+
+// Profiles は互換性の対応表（古い順）。Q のリリースで表示が変わったら追加する
+var Profiles = []Profile{
+	{Name: "q-1.10", Min: "1.10.0", Max: "1.14.0", Spec: baseSpec},
+}
+
+// defaultPatterns は最新のプロファイルのパターン
+var defaultPatterns = mustCompile(Profiles[len(Profiles)-1].Spec)
+
+func mustCompile(s Spec) Patterns {
+	p, err := s.Apply(Patterns{})
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Default は最新のプロファイルのパターンを返す（バージョンが分かる前や不明な場合に使う）
+func Default() Patterns { return defaultPatterns }
+
+// Override は設定ファイルによるパターンの上書き
+type Override struct {
+	// Versions は上書きを適用するバージョンの条件（Match を参照。空なら全てのバージョン）
+	Versions string `json:"versions,omitempty"`
+	Spec
+}
+
+// Resolution は Q のバージョンに対して選んだパターン
+type Resolution struct {
+	Version string // 検出したバージョン（不明なら空）
+	Profile string // 使用するプロファイル名
+	// OutOfRange は Version が不明、または対応表のどの範囲にも入らないか（最も近いプロファイルで代用している）
+	OutOfRange bool
+	// Overrides は適用した上書きの Versions（空の条件は "*"）
+	Overrides []string
+	Patterns  Patterns
+}
+
+// Warning は対応表の範囲外のバージョンで表示する警告を返す（範囲内なら空）
+func (r Resolution) Warning() string {
+	if !r.OutOfRange {
+		return ""
+	}
+	if r.Version == "" {
+		return fmt.Sprintf("Q CLI version unknown; output parsing assumes %s (%s)", r.Profile, KnownRange())
+	}
+	return fmt.Sprintf("Q CLI %s is outside the known range (%s); output parsing assumes %s and may break", r.Version, KnownRange(), r.Profile)
+}
+
+// KnownRange は対応表全体のバージョン範囲を返す
+func KnownRange() string {
+	first, last := Profiles[0], Profiles[len(Profiles)-1]
+	return Profile{Min: first.Min, Max: last.Max}.Range()
+}
+
+// Resolve は version に合うプロファイルを選び、条件に合う上書きを順に適用する
+// 範囲外のバージョンには最も近いプロファイル（古ければ最古、新しければ最新）を使う
+func Resolve(version string, overrides []Override) (Resolution, error) {
+	r := Resolution{Version: version, OutOfRange: true}
+	prof := Profiles[len(Profiles)-1]
+	if version != "" {
+		if Compare(version, Profiles[0].Min) < 0 {
+			prof = Profiles[0]
+		}
+		for _, p := range Profiles {
+			if p.Contains(version) {
+				prof, r.OutOfRange = p, false
+				break
+			}
+		}
+	}
+	r.Profile = prof.Name
+
+	var errs []error
+	patterns := mustCompile(prof.Spec)
+	for i, o := range overrides {
+		ok, err := Match(o.Versions, version)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("parserOverrides[%d].versions: %w", i, err))
+			continue
+		}
+		if !ok {
+			continue
+		}
+		p, err := o.Spec.Apply(patterns)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("parserOverrides[%d]: %w", i, err))
+			continue
+		}
+		patterns = p
+		r.Overrides = append(r.Overrides, orDefault(o.Versions, "*"))
+	}
+	r.Patterns = patterns
+	return r, errors.Join(errs...)
+}
+
+// Match は version が条件 constraint を満たすかを返す
+//
+// 条件は空白またはカンマ区切りの比較の組み合わせ（全てを満たす場合に一致）:
+//
+//	""、"*"            全てのバージョン
+//	">=1.14.0 <2"      比較演算子（>=, >, <=, <, =）とバージョン
+//	"1.14"             前方一致（1.14 と 1.14.x）
+//
+// version が空（不明）の場合は、空の条件と "*" にだけ一致する
+func Match(constraint, version string) (bool, error) {
+	terms := strings.FieldsFunc(constraint, func(r rune) bool { return r == ' ' || r == ',' })
+	for _, t := range terms {
+		if err := checkTerm(t); err != nil {
+			return false, err
+		}
+	}
+	if len(terms) == 0 || (len(terms) == 1 && terms[0] == "*") {
+		return true, nil
+	}
+	if version == "" {
+		return false, nil
+	}
+	for _, t := range terms {
+		if !matchTerm(t, version) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// ValidateConstraint は Match の条件の書式を検査する
+func ValidateConstraint(constraint string) error {
+	_, err := Match(constraint, "")
+	return err
+}
+
+var reTerm = regexp.MustCompile(`^(>=|<=|>|<|=)?v?(\d+(?:\.\d+){0,2}(?:-[0-9A-Za-z.-]+)?)$`)
+
+func checkTerm(t string) error {
+	if t == "*" || reTerm.MatchString(t) {
+		return nil
+	}
+	return fmt.Errorf("invalid version condition %q (e.g. \">=1.14.0\", \"<2\", \"1.14\")", t)
+}
+
+func matchTerm(t, version string) bool {
+	if t == "*" {
+		return true
+	}
+	m := reTerm.FindStringSubmatch(t)
+	op, v := m[1], m[2]
+	c := Compare(version, v)
+	switch op {
+	case ">=":
+		return c >= 0
+	case ">":
+		return c > 0
+	case "<=":
+		return c <= 0
+	case "<":
+		return c < 0
+	case "=":
+		return c == 0
+	}
+	// 演算子なしは前方一致（"1.14" は "1.14.0" や "1.14.3-beta" に一致）
+	return version == v || strings.HasPrefix(version, v+".") || strings.HasPrefix(version, v+"-")
+}
+
+// Compare はバージョン a と b を比較し、a < b なら負、a == b なら 0、a > b なら正を返す
+// 省略した部分は 0 とみなし（"1.14" == "1.14.0"）、プレリリース（"-beta"）は正式版より前とする
+func Compare(a, b string) int {
+	an, apre := splitVersion(a)
+	bn, bpre := splitVersion(b)
+	for i := range an {
+		if an[i] != bn[i] {
+			if an[i] < bn[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case apre == bpre:
+		return 0
+	case apre == "":
+		return 1
+	case bpre == "":
+		return -1
+	}
+	return strings.Compare(apre, bpre)
+}
+
+// splitVersion は "v1.14.0-beta+1" を数値部分 [1 14 0] とプレリリース "beta" に分ける
+func splitVersion(v string) ([3]int, string) {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		v = v[:i]
+	}
+	pre := ""
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		v, pre = v[:i], v[i+1:]
+	}
+	var n [3]int
+	for i, part := range strings.SplitN(v, ".", 3) {
+		fmt.Sscanf(part, "%d", &n[i])
+	}
+	return n, pre
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
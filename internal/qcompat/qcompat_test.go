@@ -0,0 +1,113 @@
+package qcompat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1.12.1", "1.12.1", 0},
+		{"1.14", "1.14.0", 0},
+		{"v1.9.0", "1.10.0", -1},
+		{"1.13.9", "1.14.0", -1},
+		{"2.0.0", "1.99.99", 1},
+		{"1.14.0-beta", "1.14.0", -1},
+		{"1.14.0-beta", "1.14.0-alpha", 1},
+		{"1.14.0+build.1", "1.14.0", 0},
+	}
+	for _, tc := range cases {
+		if got := Compare(tc.a, tc.b); got != tc.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		constraint, version string
+		want                bool
+	}{
+		{"", "1.12.1", true},
+		{"*", "", true},
+		{">=1.14.0", "1.14.0", true},
+		{">=1.14.0", "1.13.9", false},
+		{">=1.14 <2", "1.20.3", true},
+		{">=1.14,<2", "2.0.0", false},
+		{"1.14", "1.14.3", true},
+		{"1.14", "1.140.0", false},
+		{"=1.12.1", "1.12.1", true},
+		{">=1.14.0", "", false}, // バージョン不明は条件付きの上書きに一致しない
+	}
+	for _, tc := range cases {
+		got, err := Match(tc.constraint, tc.version)
+		if err != nil || got != tc.want {
+			t.Errorf("Match(%q, %q) = %v, %v; want %v", tc.constraint, tc.version, got, err, tc.want)
+		}
+	}
+	for _, bad := range []string{"~1.14", "1.x", ">= 1.14 && <2"} {
+		if err := ValidateConstraint(bad); err == nil {
+			t.Errorf("ValidateConstraint(%q) should fail", bad)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	r, err := Resolve("1.12.1", nil)
+	if err != nil || r.OutOfRange || r.Profile != "q-1.10" || r.Warning() != "" {
+		t.Fatalf("version in range: %+v err=%v", r, err)
+	}
+	if !r.Patterns.Prompt.MatchString("> ") || r.Patterns.InitBanner.FindStringSubmatch("You are chatting with claude-sonnet-4")[1] != "claude-sonnet-4" {
+		t.Fatal("profile patterns not compiled")
+	}
+
+	r, _ = Resolve("1.15.0", nil)
+	if !r.OutOfRange || r.Profile != Profiles[len(Profiles)-1].Name || !strings.Contains(r.Warning(), "1.15.0 is outside the known range (>=1.10.0 <1.14.0)") {
+		t.Fatalf("newer version: %+v", r)
+	}
+	r, _ = Resolve("1.2.0", nil)
+	if !r.OutOfRange || r.Profile != Profiles[0].Name {
+		t.Fatalf("older version: %+v", r)
+	}
+	r, _ = Resolve("", nil)
+	if !r.OutOfRange || r.Profile != Profiles[len(Profiles)-1].Name || !strings.Contains(r.Warning(), "version unknown") {
+		t.Fatalf("unknown version: %+v", r)
+	}
+}
+
+func TestResolve_Overrides(t *testing.T) {
+	overrides := []Override{
+		{Versions: ">=1.15", Spec: Spec{Prompt: `^\s*λ\s*$`, Thinking: `(?i)Pondering`}},
+		{Versions: "1.12", Spec: Spec{Spinner: `never`}},
+		{Spec: Spec{InitBanner: `Model: (\S+)`}},
+	}
+	r, err := Resolve("1.15.2", overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(r.Overrides, ",") != ">=1.15,*" {
+		t.Fatalf("applied overrides: %v", r.Overrides)
+	}
+	p := r.Patterns
+	if !p.Prompt.MatchString(" λ ") || p.Prompt.MatchString("> ") || !p.Thinking.MatchString("Pondering...") {
+		t.Fatal("matching override should replace prompt and thinking")
+	}
+	if !p.Spinner.MatchString("⠋ Loading...") {
+		t.Fatal("override for another version must not apply")
+	}
+	if !p.InitBanner.MatchString("Model: claude") || !p.InitSeparator.MatchString("━━━━━━━━━━━━\n\n") {
+		t.Fatal("unconditional override should replace only the given pattern")
+	}
+
+	// 上書きの誤りは報告し、その上書きだけを無視する
+	r, err = Resolve("1.12.1", []Override{{Spec: Spec{Prompt: "("}}, {Spec: Spec{Thinking: "Hmm"}}})
+	if err == nil || !strings.Contains(err.Error(), "parserOverrides[0]: prompt") {
+		t.Fatalf("err=%v", err)
+	}
+	if !r.Patterns.Prompt.MatchString("> ") || !r.Patterns.Thinking.MatchString("Hmm") {
+		t.Fatal("valid overrides should still apply")
+	}
+}
@@ -2,32 +2,49 @@ package session
 
 import (
     "regexp"
+    "strings"
+
+    "qube/internal/qcompat"
 )
 
 // initDetector は ANSI を除去した上で、初期化完了を表すパターンを検知する。
 // 文言検知と罫線+空行の2系統に対応。
+// 文言で検知した場合は、バナーに含まれる使用モデル名も取り出す。
+// パターンは Q のバージョンに合わせて qcompat が選ぶ。
 type initDetector struct {
-    buf string
+    buf      string
+    model    string
+    patterns qcompat.Patterns
 }
 
-var (
-    reANSI = regexp.MustCompile(`\x1b\[[0-9;]*[mGKJH]`)
-    rePhrase = regexp.MustCompile(`(?i)You are chatting with .+`)
-    // Treat sequences of heavy/light box-drawing characters as a separator
-    reSep    = regexp.MustCompile(`(?m)[━─]{10,}[\s\S]*?\n\s*\n`)
-)
+var reANSI = regexp.MustCompile(`\x1b\[[0-9;]*[mGKJH]`)
+
+func newInitDetector() *initDetector { return newInitDetectorFor(qcompat.Default()) }
 
-func newInitDetector() *initDetector { return &initDetector{} }
+func newInitDetectorFor(p qcompat.Patterns) *initDetector { return &initDetector{patterns: p} }
 
 func (d *initDetector) Feed(s string) bool {
     // 入力を連結し、検知用に ANSI を除去
     d.buf += s
     plain := reANSI.ReplaceAllString(d.buf, "")
-    if rePhrase.MatchString(plain) {
+    if m := d.patterns.InitBanner.FindStringSubmatch(plain); m != nil {
+        if len(m) > 1 {
+            d.model = parseModelName(m[1])
+        }
         return true
     }
-    if reSep.MatchString(plain) {
+    if d.patterns.InitSeparator.MatchString(plain) {
         return true
     }
     return false
 }
+
+// Model は起動バナーから取り出した使用モデル名を返す（未検出なら空）
+func (d *initDetector) Model() string { return d.model }
+
+// parseModelName はバナーのモデル表記から装飾（前後の記号・末尾の句点）を除く
+func parseModelName(s string) string {
+    s = strings.TrimSpace(s)
+    s = strings.TrimRight(s, ".!")
+    return strings.Trim(s, "\"'`* ")
+}
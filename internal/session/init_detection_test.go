@@ -2,6 +2,8 @@ package session
 
 import (
     "testing"
+
+    "qube/internal/qcompat"
 )
 
 func Test_InitializationDetection_ByPhrase(t *testing.T) {
@@ -24,3 +26,32 @@ func Test_InitializationDetection_BySeparator(t *testing.T) {
         t.Fatal("罫線+空行による初期化検知に失敗")
     }
 }
+
+func Test_InitializationDetection_ExtractsModel(t *testing.T) {
+    det := newInitDetector()
+    if !det.Feed("\x1b[1mYou are chatting with \x1b[32mclaude-sonnet-4\x1b[0m\r\n") {
+        t.Fatal("文言による初期化検知に失敗")
+    }
+    if got := det.Model(); got != "claude-sonnet-4" {
+        t.Fatalf("model: got %q", got)
+    }
+
+    // 罫線で検知した場合はモデル名は不明
+    det = newInitDetector()
+    det.Feed("━━━━━━━────────\n\n")
+    if det.Model() != "" {
+        t.Fatalf("model should be empty, got %q", det.Model())
+    }
+}
+
+func Test_InitializationDetection_CustomPatterns(t *testing.T) {
+    patterns, err := qcompat.Spec{InitBanner: `Model: (\S+) ready`}.Apply(qcompat.Default())
+    if err != nil { t.Fatal(err) }
+    det := newInitDetectorFor(patterns)
+    if det.Feed("You are chatting with claude-sonnet-4\n") {
+        t.Fatal("上書き前のバナーで初期化完了としてはならない")
+    }
+    if !det.Feed("Model: claude-opus ready\n") || det.Model() != "claude-opus" {
+        t.Fatalf("上書きしたバナーでの検知に失敗: model=%q", det.Model())
+    }
+}
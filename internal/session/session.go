@@ -4,93 +4,176 @@ import (
     "bufio"
     "errors"
     "io"
+    "log/slog"
     "os"
     "os/exec"
+    "strings"
     "sync"
     "syscall"
     "time"
 
     ptypkg "github.com/creack/pty"
+    "qube/internal/logging"
+    "qube/internal/qcli"
+    "qube/internal/qcompat"
+    "qube/internal/telemetry"
 )
 
 // Session は PTY 上で実行されるインタラクティブシェルを管理する。
 type Session struct {
     cmd   *exec.Cmd
     pty   *os.File
-    once  sync.Once
+    // stopped は Stop で閉じる（Start ごとに作り直す）。停止後の読み込みエラーや終了を通知しないために使う
+    stopped chan struct{}
+    // exited はプロセスの終了（Wait の完了）で閉じる
+    exited  chan struct{}
 
     OnData  func([]byte) // シェル出力受信時に呼ばれる
-    OnExit  func(int)    // プロセス終了時に終了コード付きで呼ばれる
-    OnError func(error)  // 受信/待機中のエラー通知
+    OnExit  func(int)    // プロセス終了時に終了コード付きで呼ばれる（Stop による終了では呼ばれない）
+    OnError func(error)  // 受信/待機中のエラー通知（Stop の後は呼ばれない）
     OnInitialized func() // 初期化完了時に呼ばれる（chatモード）
 
-    // 初期化検知（chatモードのみ有効）
-    initEnabled  bool
+    // chatArgs は q chat の起動時に追加で渡す引数（例: --model, --trust-all-tools）。SetChatArgs で変更
+    chatArgs []string
+    // env は Q のプロセスに追加する環境変数（"KEY=value"。プロファイルの AWS_PROFILE など）。SetEnv で変更
+    env []string
+
+    // Telemetry は起動・初期化・終了・エラーの記録先（nil なら記録しない）
+    Telemetry *telemetry.Recorder
+
+    // 初期化検知（chatモードのみ有効）。検知するかは Start ごとに reader goroutine が持つ
     initialized  bool
     initDet      *initDetector
     initTimer    *time.Timer
+    model        string // 起動バナーから検出した使用モデル名
+    initVia      string // 初期化完了の判定方法（"banner" / "timeout"）
+    patterns     qcompat.Patterns // 初期化検知のパターン（SetPatterns で変更）
     mu           sync.Mutex
+
+    // タイムアウト設定（SetTimeouts で変更）
+    initTimeout  time.Duration // 初期化検知を待つ時間
+    idleTimeout  time.Duration // 送信後に応答が始まるまで待つ時間（0 で無効）
+    idleTimer    *time.Timer
 }
 
-func New() *Session { return &Session{} }
+// DefaultInitTimeout は chat 初期化検知を待つ既定時間
+const DefaultInitTimeout = 10 * time.Second
 
-// detectQCLI はAmazon Q CLIのバイナリパスを検出する（内部使用）
-func detectQCLI() (string, error) {
-    // Q_BIN環境変数が設定されている場合
-    if qBin := os.Getenv("Q_BIN"); qBin != "" {
-        if path, err := exec.LookPath(qBin); err == nil {
-            return path, nil
-        }
-        return "", errors.New("Q_BIN環境変数で指定されたAmazon Q CLIが見つかりません")
-    }
+// ErrIdleTimeout は送信後 idle タイムアウトまでに応答が始まらなかった場合に OnError へ通知される
+var ErrIdleTimeout = errors.New("no response from Q within idle timeout")
 
-    // PATHから検索
-    candidates := []string{"amazonq", "q"}
-    for _, candidate := range candidates {
-        if path, err := exec.LookPath(candidate); err == nil {
-            return path, nil
-        }
+func New() *Session { return &Session{initTimeout: DefaultInitTimeout, patterns: qcompat.Default()} }
+
+// SetPatterns は初期化検知に使うパターンを設定する（次回の Start から有効）
+func (s *Session) SetPatterns(p qcompat.Patterns) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.patterns = p
+}
+
+// SetTimeouts は初期化待ちと応答待ちのタイムアウトを設定する（次回の Start/Send から有効）
+// init が 0 以下の場合は既定値を使う。idle が 0 の場合は応答待ちを監視しない
+func (s *Session) SetTimeouts(init, idle time.Duration) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if init <= 0 {
+        init = DefaultInitTimeout
     }
+    s.initTimeout = init
+    s.idleTimeout = idle
+}
 
-    return "", errors.New("Amazon Q CLIが見つかりません。Q_BIN環境変数を設定するか、amazonqをインストールしてください")
+// SetChatArgs は q chat の起動時に追加で渡す引数を設定する（次回の Start から有効）
+func (s *Session) SetChatArgs(args []string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.chatArgs = append([]string(nil), args...)
+}
+
+// SetEnv は Q のプロセスに追加する環境変数を設定する（次回の Start から有効）
+func (s *Session) SetEnv(env []string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.env = append([]string(nil), env...)
 }
 
 // Start は PTY 上にQ CLIセッションを起動する。
 func (s *Session) Start(mode string) error {
-    // Q CLIバイナリパスを検出
-    qPath, err := detectQCLI()
+    // Q CLIバイナリパスを検出（検出結果は qcli がキャッシュし、execq と共有する）
+    qPath, err := qcli.Path()
     if err != nil {
+        logger().Warn("Q CLI not found", "mode", mode, "err", err)
+        s.record(telemetry.Event{Name: "session.start_failed", Mode: mode, ErrorKind: telemetry.ErrorKind(err)})
         return err
     }
+    // 引数と環境変数は起動時点の値を使う（プロファイルの切り替えは別の goroutine から SetChatArgs / SetEnv で行う）
+    // 状態は前回のセッションの goroutine も参照するため mu の下で書き換える
+    startedAt := time.Now()
+    s.mu.Lock()
+    chatArgs, env := s.chatArgs, s.env
+    s.mu.Unlock()
+    // 再起動した場合も、前回のセッションの goroutine は自分の stopped / det / initTimer だけを参照する
+    stopped := make(chan struct{})
+    exited := make(chan struct{})
 
     // modeに応じたコマンドを構築（現在はchatのみ対応）
     var args []string
+    var det *initDetector
+    var initTimer *time.Timer
     switch mode {
     case "chat":
-        args = []string{qPath, "chat"}
-        s.initEnabled = true
+        args = append([]string{qPath, "chat"}, chatArgs...)
+        s.mu.Lock()
         s.initialized = false
-        s.initDet = newInitDetector()
+        det = newInitDetectorFor(s.patterns)
+        s.initDet = det
+        s.model = ""
+        s.initVia = ""
+        initTimeout := s.initTimeout
+        s.mu.Unlock()
         // タイムアウトで初期化完了扱い
-        s.initTimer = time.AfterFunc(10*time.Second, func() {
+        initTimer = time.AfterFunc(initTimeout, func() {
+            select {
+            case <-stopped:
+                return
+            default:
+            }
             s.mu.Lock()
             s.initialized = true
+            s.initVia = "timeout"
             s.mu.Unlock()
+            logger().Warn("chat init not detected, assuming ready", "timeout", initTimeout)
+            s.recordSince("session.initialized", mode, "timeout", startedAt)
             if s.OnInitialized != nil { s.OnInitialized() }
         })
     default:
         args = []string{qPath, mode}
-        s.initEnabled = false
     }
+    s.mu.Lock()
+    s.initTimer = initTimer
+    s.mu.Unlock()
 
-    s.cmd = exec.Command(args[0], args[1:]...)
+    cmd := exec.Command(args[0], args[1:]...)
+    s.cmd = cmd
     // Node版と同様にTERMを明示
-    s.cmd.Env = append(os.Environ(), "TERM=xterm-256color")
-    f, err := ptypkg.Start(s.cmd)
+    cmd.Env = append(append(os.Environ(), "TERM=xterm-256color"), env...)
+    logger().Info("session starting", "mode", mode, "argv", args, "env", envNames(env))
+    f, err := ptypkg.Start(cmd)
     if err != nil {
+        logger().Error("session start failed", "mode", mode, "err", err)
+        if initTimer != nil {
+            initTimer.Stop()
+        }
+        s.record(telemetry.Event{Name: "session.start_failed", Mode: mode, ErrorKind: telemetry.ErrorKind(err)})
         return err
     }
+    logger().Info("session started", "mode", mode, "pid", cmd.Process.Pid)
+    s.record(telemetry.Event{Name: "session.started", Mode: mode})
     s.pty = f
+    s.mu.Lock()
+    s.stopped = stopped
+    s.exited = exited
+    s.mu.Unlock()
 
     // デフォルトのPTYサイズを指定（Node版: cols=80, rows=30）
     _ = ptypkg.Setsize(s.pty, &ptypkg.Winsize{Rows: 30, Cols: 80})
@@ -102,27 +185,35 @@ func (s *Session) Start(mode string) error {
         for {
             n, err := r.Read(buf)
             if n > 0 {
+                logging.Trace("read", buf[:n])
+                // 応答が始まったので応答待ちの監視を止める
+                s.stopIdleTimer()
                 // 初期化検知（chatモードのみ）
                 forward := true
-                if s.initEnabled {
+                if det != nil {
                     s.mu.Lock()
                     inited := s.initialized
                     s.mu.Unlock()
                     if !inited {
-                        if s.initDet.Feed(string(buf[:n])) {
+                        if det.Feed(string(buf[:n])) {
                             s.mu.Lock()
                             s.initialized = true
+                            s.model = det.Model()
+                            s.initVia = "banner"
                             s.mu.Unlock()
-                            if s.initTimer != nil {
-                                s.initTimer.Stop()
+                            if initTimer != nil {
+                                initTimer.Stop()
                             }
+                            logger().Info("chat init detected", "model", s.Model(), "elapsed", time.Since(startedAt))
+                            s.recordSince("session.initialized", mode, "banner", startedAt)
                             if s.OnInitialized != nil { s.OnInitialized() }
                         }
                         // 初期化完了まではUIに流さない
                         forward = false
+                        logger().Debug("output before init withheld", "len", n)
                     }
                 }
-                if forward && s.OnData != nil {
+                if forward && s.OnData != nil && !isClosed(stopped) {
                     // バッファ使い回しによる裏配列共有を避けるためコピー
                     b := make([]byte, n)
                     copy(b, buf[:n])
@@ -130,10 +221,13 @@ func (s *Session) Start(mode string) error {
                 }
             }
             if err != nil {
-                if errors.Is(err, io.EOF) {
+                if errors.Is(err, io.EOF) || isClosed(stopped) {
                     // 終了コードは Wait ゴルーチンで通知
+                    logger().Debug("pty closed")
                     return
                 }
+                logger().Debug("pty read failed", "err", err)
+                s.record(telemetry.Event{Name: "session.error", Mode: mode, ErrorKind: telemetry.ErrorKind(err)})
                 if s.OnError != nil {
                     s.OnError(err)
                 }
@@ -144,57 +238,152 @@ func (s *Session) Start(mode string) error {
 
     // Wait ゴルーチン
     go func() {
-        err := s.cmd.Wait()
+        err := cmd.Wait()
+        close(exited)
         code := 0
         if err != nil {
             if exitErr, ok := err.(*exec.ExitError); ok {
                 code = exitErr.ExitCode()
             } else {
                 // 不明なエラー
-                if s.OnError != nil { s.OnError(err) }
+                if s.OnError != nil && !isClosed(stopped) { s.OnError(err) }
                 code = -1
             }
         }
-        if s.initTimer != nil {
-            s.initTimer.Stop()
+        if initTimer != nil {
+            initTimer.Stop()
         }
-        if s.OnExit != nil { s.OnExit(code) }
+        logger().Info("session exited", "mode", mode, "code", code, "uptime", time.Since(startedAt))
+        s.record(telemetry.Event{Name: "session.exited", Mode: mode, ExitCode: telemetry.Exit(code), DurationMs: time.Since(startedAt).Milliseconds()})
+        if s.OnExit != nil && !isClosed(stopped) { s.OnExit(code) }
     }()
 
     return nil
 }
 
+// Model は chat の起動バナー（"You are chatting with …"）から検出した使用モデル名を返す
+// 未検出（罫線での検知・タイムアウト・chat 以外）の場合は空文字列
+func (s *Session) Model() string {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.model
+}
+
+// InitDetection は chat の初期化完了をどう判定したかを返す
+// 起動バナー・罫線を検出した場合は "banner"、検出できずタイムアウトした場合は "timeout"、未完了なら空文字列
+func (s *Session) InitDetection() string {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.initVia
+}
+
 // Send は PTY に 1 行書き込む（CRLF 付与）。
 func (s *Session) Send(text string) error {
     if s.pty == nil { return errors.New("session not started") }
     // Node版は input+"\r" を送信している
     // Go版も同等にするため、引数をそのまま書き出す
+    logging.Trace("write", []byte(text))
     _, err := s.pty.Write([]byte(text))
-    return err
+    if err != nil {
+        logger().Warn("send failed", "err", err)
+        return err
+    }
+    s.armIdleTimer()
+    return nil
+}
+
+// armIdleTimer は送信後の応答待ち監視を開始する
+func (s *Session) armIdleTimer() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    // タイマーの goroutine からは s.idleTimeout を読まない（/timeout で同時に変わりうる）
+    timeout := s.idleTimeout
+    if timeout <= 0 {
+        return
+    }
+    if s.idleTimer != nil {
+        s.idleTimer.Stop()
+    }
+    s.idleTimer = time.AfterFunc(timeout, func() {
+        logger().Warn("no response within idle timeout", "timeout", timeout)
+        s.record(telemetry.Event{Name: "session.error", ErrorKind: "idle_timeout"})
+        if s.OnError != nil { s.OnError(ErrIdleTimeout) }
+    })
+}
+
+// stopIdleTimer は応答待ち監視を停止する
+func (s *Session) stopIdleTimer() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.idleTimer != nil {
+        s.idleTimer.Stop()
+        s.idleTimer = nil
+    }
 }
 
 // Stop はセッションを終了する（TERM→Kill フォールバック）。
+// 起動していない・停止済みの場合は何もしない。停止後は同じ Session を再び Start できる
 func (s *Session) Stop() error {
-    var err error
-    s.once.Do(func() {
-        if s.pty != nil {
-            _ = s.pty.Close()
-        }
-        if s.cmd != nil && s.cmd.Process != nil {
-            // まず TERM、一定時間で Kill
-            _ = s.cmd.Process.Signal(syscall.SIGTERM)
-            timer := time.NewTimer(300 * time.Millisecond)
-            done := make(chan struct{})
-            go func() {
-                s.cmd.Wait()
-                close(done)
-            }()
-            select {
-            case <-done:
-            case <-timer.C:
-                _ = s.cmd.Process.Kill()
-            }
+    s.stopIdleTimer()
+    s.mu.Lock()
+    stopped, exited, initTimer := s.stopped, s.exited, s.initTimer
+    s.stopped = nil
+    s.mu.Unlock()
+    if stopped == nil {
+        return nil
+    }
+    close(stopped)
+    logger().Debug("session stopping")
+    if initTimer != nil {
+        initTimer.Stop()
+    }
+    if s.pty != nil {
+        _ = s.pty.Close()
+    }
+    if s.cmd != nil && s.cmd.Process != nil {
+        // まず TERM、一定時間で Kill
+        _ = s.cmd.Process.Signal(syscall.SIGTERM)
+        // 終了は Start の Wait ゴルーチンが exited で知らせる
+        timer := time.NewTimer(300 * time.Millisecond)
+        defer timer.Stop()
+        select {
+        case <-exited:
+        case <-timer.C:
+            logger().Warn("session did not exit on SIGTERM, killing")
+            _ = s.cmd.Process.Kill()
         }
-    })
-    return err
+    }
+    return nil
 }
+
+// isClosed は Stop で ch が閉じられたかを返す
+func isClosed(ch chan struct{}) bool {
+    select {
+    case <-ch:
+        return true
+    default:
+        return false
+    }
+}
+
+// envNames はログに残す環境変数の名前を返す（値には認証情報が含まれうるため記録しない）
+func envNames(env []string) []string {
+    names := make([]string, 0, len(env))
+    for _, kv := range env {
+        name, _, _ := strings.Cut(kv, "=")
+        names = append(names, name)
+    }
+    return names
+}
+
+// record はテレメトリのイベントを記録する（Telemetry が nil なら何もしない）
+func (s *Session) record(ev telemetry.Event) {
+    s.Telemetry.Record(ev)
+}
+
+// recordSince は起動（startedAt）からの経過時間付きでイベントを記録する
+func (s *Session) recordSince(name, mode, outcome string, startedAt time.Time) {
+    s.record(telemetry.Event{Name: name, Mode: mode, Outcome: outcome, DurationMs: time.Since(startedAt).Milliseconds()})
+}
+
+func logger() *slog.Logger { return logging.For("session") }
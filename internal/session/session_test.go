@@ -1,10 +1,15 @@
 package session
 
 import (
+    "os"
+    "path/filepath"
+    "runtime"
     "strings"
     "sync"
     "testing"
     "time"
+
+    "qube/internal/qcli"
 )
 
 // ライフサイクル: Start → Send echo → 受信 → Stop
@@ -49,3 +54,116 @@ func Test_Session_Start_Send_Receive_Stop(t *testing.T) {
         t.Fatalf("stop: %v", err)
     }
 }
+
+// fakeQ は起動バナーと AWS_PROFILE を出力して待機する q を用意し、qcli の既定に設定する
+func fakeQ(t *testing.T) {
+    t.Helper()
+    if runtime.GOOS == "windows" {
+        t.Skip("skip on windows")
+    }
+    path := filepath.Join(t.TempDir(), "q")
+    script := "#!/bin/sh\necho 'You are chatting with fake-model'\nsleep 0.2\necho \"profile=$AWS_PROFILE\"\nexec sleep 30\n"
+    if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+        t.Fatal(err)
+    }
+    prev := qcli.Default()
+    qcli.SetDefault(qcli.New(qcli.Options{Explicit: path}))
+    t.Cleanup(func() { qcli.SetDefault(prev) })
+}
+
+// 停止したセッションを別の環境変数で再起動できる。Stop による終了はエラー・終了として通知しない
+func Test_Session_RestartWithEnv(t *testing.T) {
+    fakeQ(t)
+    s := New()
+    defer s.Stop()
+
+    var mu sync.Mutex
+    var buf strings.Builder
+    s.OnData = func(b []byte) {
+        mu.Lock()
+        buf.Write(b)
+        mu.Unlock()
+    }
+    s.OnError = func(err error) { t.Errorf("session error: %v", err) }
+    s.OnExit = func(code int) { t.Errorf("exit reported after Stop: %d", code) }
+    waitFor := func(want string) {
+        t.Helper()
+        deadline := time.Now().Add(3 * time.Second)
+        for time.Now().Before(deadline) {
+            mu.Lock()
+            got := buf.String()
+            mu.Unlock()
+            if strings.Contains(got, want) {
+                return
+            }
+            time.Sleep(10 * time.Millisecond)
+        }
+        t.Fatalf("did not receive %q", want)
+    }
+
+    for _, profile := range []string{"dev", "prod"} {
+        s.SetEnv([]string{"AWS_PROFILE=" + profile})
+        if err := s.Start("chat"); err != nil {
+            t.Fatalf("start: %v", err)
+        }
+        waitFor("profile=" + profile)
+        if s.Model() != "fake-model" {
+            t.Fatalf("model: %q", s.Model())
+        }
+        if err := s.Stop(); err != nil {
+            t.Fatalf("stop: %v", err)
+        }
+    }
+    // 停止後の読み込みエラー・終了の通知が遅れて届かないことを確かめる
+    time.Sleep(100 * time.Millisecond)
+}
+
+// 停止・再起動・応答待ちの監視を繰り返しても、前回のセッションの goroutine や /timeout の変更と競合しない
+func Test_Session_StopStartIdle(t *testing.T) {
+    fakeQ(t)
+    s := New()
+    defer s.Stop()
+    s.OnError = func(error) {}
+
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        for i := 0; i < 50; i++ {
+            s.SetTimeouts(20*time.Millisecond, time.Duration(i%3)*5*time.Millisecond)
+            time.Sleep(2 * time.Millisecond)
+        }
+    }()
+    for i := 0; i < 3; i++ {
+        if err := s.Start("chat"); err != nil {
+            t.Fatalf("start: %v", err)
+        }
+        if err := s.Send("hello\r"); err != nil {
+            t.Fatalf("send: %v", err)
+        }
+        time.Sleep(30 * time.Millisecond)
+        if err := s.Stop(); err != nil {
+            t.Fatalf("stop: %v", err)
+        }
+    }
+    <-done
+}
+
+// 別の goroutine（/qube profile の切り替え）から引数と環境変数を変えても、起動中の Start と競合しない
+func Test_Session_SetArgsWhileStarting(t *testing.T) {
+    fakeQ(t)
+    s := New()
+    defer s.Stop()
+
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        for i := 0; i < 50; i++ {
+            s.SetChatArgs([]string{"--model", "x"})
+            s.SetEnv([]string{"AWS_PROFILE=prod"})
+        }
+    }()
+    if err := s.Start("chat"); err != nil {
+        t.Fatalf("start: %v", err)
+    }
+    <-done
+}
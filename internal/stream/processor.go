@@ -1,8 +1,22 @@
 package stream
 
 import (
+	"log/slog"
 	"regexp"
 	"strings"
+	"sync"
+
+	"qube/internal/logging"
+	"qube/internal/qcompat"
+
+	"github.com/charmbracelet/x/ansi"
+)
+
+// ブラケットペーストの開始/終了シーケンス
+// 送信時は executor.SessionPayload が付け、エコーバック照合時はここで取り除く
+const (
+	BracketedPasteStart = "\x1b[200~"
+	BracketedPasteEnd   = "\x1b[201~"
 )
 
 // OnLinesReady は履歴に確定した行群を受け取るコールバック型
@@ -23,6 +37,13 @@ type Processor struct {
 	currentProgressLine *string
 	thinkingActive      bool
 	lastSentCommand     *string
+	// pendingEcho は複数行送信時に、lastSentCommand の後に続くエコーバック行
+	pendingEcho []string
+	// echoQueued は lastSentCommand が複数行送信の 2 行目以降であることを示す
+	// この間はプロンプト接頭辞を除いた完全一致だけをエコーバックとみなし、違う行が来たら待ち受けをやめる
+	echoQueued bool
+	// patterns は進捗・Thinking の判定に使うパターン（Q のバージョンに合わせて qcompat が選ぶ）
+	patterns qcompat.Patterns
 
 	onLinesReady    OnLinesReady
 	onProgressUpdate OnProgressUpdate
@@ -32,11 +53,15 @@ type Processor struct {
 // onLines は履歴確定行の通知、onProgress は進捗表示の通知を受け取る
 func NewProcessor(onLines OnLinesReady, onProgress OnProgressUpdate) *Processor {
 	return &Processor{
+		patterns:         qcompat.Default(),
 		onLinesReady:     onLines,
 		onProgressUpdate: onProgress,
 	}
 }
 
+// SetPatterns は進捗・Thinking の判定に使うパターンを設定する
+func (p *Processor) SetPatterns(patterns qcompat.Patterns) { p.patterns = patterns }
+
 // ProcessData はストリームのデータチャンクを処理する（stdout/stderr 共通）
 // CR/ANSI を保持しつつ、思考・進捗・履歴化・エコーバック抑制を行う
 func (p *Processor) ProcessData(_type string, data string) {
@@ -48,21 +73,20 @@ func (p *Processor) ProcessData(_type string, data string) {
 		parts := strings.Split(merged, "\r")
 		lastPart := parts[len(parts)-1]
 
-        // 進捗パターン（Thinking... は除外）
-        spinnerPattern := regexp.MustCompile(`[⠋⠙⠹⠸⠼⠴⠦⠧⠇⠏].*\.{3}`)
-        loadingPattern := regexp.MustCompile(`(?i)Loading\.{3}`)
-        processingPattern := regexp.MustCompile(`(?i)Processing\.{3}`)
-        ioPattern := regexp.MustCompile(`(?i)(Downloading|Uploading|Indexing)`)
-        thinkingPattern := regexp.MustCompile(`(?i)Thinking`)
-
-		if thinkingPattern.MatchString(lastPart) {
+		if len(parts) > 2 {
+			logger().Debug("progress frames overwritten by CR", "frames", len(parts)-1)
+		}
+        // 進捗パターン（スピナー・Loading... など。Thinking... は進捗として履歴化しない）
+		if p.patterns.Thinking.MatchString(lastPart) {
+			logger().Debug("progress: thinking")
 			p.thinkingActive = true
 			val := "Thinking..."
 			p.currentProgressLine = &val
 			if p.onProgressUpdate != nil { p.onProgressUpdate(p.currentProgressLine) }
-		} else if spinnerPattern.MatchString(lastPart) || loadingPattern.MatchString(lastPart) || processingPattern.MatchString(lastPart) || ioPattern.MatchString(lastPart) {
+		} else if p.patterns.Spinner.MatchString(lastPart) {
 			p.thinkingActive = false
 			val := strings.TrimSpace(lastPart)
+			logger().Debug("progress updated", "line", val)
 			p.currentProgressLine = &val
 			if p.onProgressUpdate != nil { p.onProgressUpdate(p.currentProgressLine) }
 		}
@@ -79,13 +103,12 @@ func (p *Processor) ProcessData(_type string, data string) {
 
     // 進捗行があり改行が入ったら1度だけ履歴に確定（Thinking は除外）
 	if len(parts) > 0 && p.currentProgressLine != nil && !p.thinkingActive {
+		logger().Debug("progress committed to history", "line", *p.currentProgressLine)
 		linesToAdd = append(linesToAdd, *p.currentProgressLine)
 		p.currentProgressLine = nil
 		if p.onProgressUpdate != nil { p.onProgressUpdate(nil) }
 	}
 
-    thinkingLine := regexp.MustCompile(`(?i)Thinking`)
-
 	for _, line := range parts {
 		trimmed := strings.TrimSpace(line)
         if trimmed == "" {
@@ -93,7 +116,8 @@ func (p *Processor) ProcessData(_type string, data string) {
 			continue
 		}
 
-		if thinkingLine.MatchString(trimmed) {
+		if p.patterns.Thinking.MatchString(trimmed) {
+			logger().Debug("line suppressed", "reason", "thinking", "line", trimmed)
 			p.thinkingActive = true
 			val := "Thinking..."
 			p.currentProgressLine = &val
@@ -113,12 +137,24 @@ func (p *Processor) ProcessData(_type string, data string) {
         // - 末尾一致（枠線プレフィックス付き）
         // - サブストリング一致（Q CLI がプロンプト等と混在させる場合を考慮して最初の1回のみ）
 		if p.lastSentCommand != nil {
-			if trimmed == *p.lastSentCommand ||
-                looksLikeBorderPrefixedEcho(trimmed, *p.lastSentCommand) ||
-                strings.Contains(trimmed, *p.lastSentCommand) {
-				p.lastSentCommand = nil
+			reason := ""
+			if p.echoQueued {
+				if trimPromptPrefix(trimmed) == *p.lastSentCommand {
+					reason = "echo (multi-line)"
+				}
+			} else {
+				reason = echoReason(trimmed, *p.lastSentCommand)
+			}
+			if reason != "" {
+				logger().Debug("line suppressed", "reason", reason, "line", trimmed)
+				p.advanceEcho()
 				continue
 			}
+			if p.echoQueued {
+				// エコーバックが途切れたら残りは応答とみなす（応答中の同じ行を誤って除外しない）
+				logger().Debug("multi-line echo ended", "remaining", len(p.pendingEcho)+1)
+				p.clearEcho()
+			}
 		}
 
 		linesToAdd = append(linesToAdd, line)
@@ -131,6 +167,29 @@ func (p *Processor) ProcessData(_type string, data string) {
 	}
 }
 
+// echoReason は line が送信コマンド cmd のエコーバックなら一致の種類を返す（違えば空文字列）
+func echoReason(line, cmd string) string {
+	switch {
+	case line == cmd:
+		return "echo"
+	case looksLikeBorderPrefixedEcho(line, cmd):
+		return "echo (border prefix)"
+	case strings.Contains(line, cmd):
+		return "echo (substring)"
+	}
+	return ""
+}
+
+// promptPrefix は Q のプロンプト（"> "、"!> "、"[profile] > " など）
+var promptPrefix = regexp.MustCompile(`^(?:\[[^\]]*\]\s*)?!?>\s*`)
+
+// trimPromptPrefix は行頭のプロンプトを取り除く
+func trimPromptPrefix(line string) string {
+	return promptPrefix.ReplaceAllString(line, "")
+}
+
+func logger() *slog.Logger { return logging.For("stream") }
+
 // looksLikeBorderPrefixedEcho は、枠線文字などの接頭辞が付いていても
 // 末尾が直前の送信コマンドと一致する行をエコーバックとして扱う
 func looksLikeBorderPrefixedEcho(line, cmd string) bool {
@@ -150,28 +209,69 @@ func looksLikeBorderPrefixedEcho(line, cmd string) bool {
     return true
 }
 
+// AtPrompt は改行待ちの末尾が Q の入力プロンプトか（応答が終わって入力待ちに戻ったか）を返す
+// 送信したコマンドのエコーバックを待っている間は、エコー前のプロンプトとみなして false を返す
+func (p *Processor) AtPrompt() bool {
+	return p.lastSentCommand == nil && p.patterns.Prompt.MatchString(ansi.Strip(p.buffer))
+}
+
 // GetCurrentProgressLine は現在の進捗行を返す
 // 表示中の進捗がなければ nil を返す
 func (p *Processor) GetCurrentProgressLine() *string { return p.currentProgressLine }
 
 // SetLastSentCommand は直前に送信したコマンドを設定する
-// エコーバック抑制のため、完全一致した行を 1 度だけ除外する
+// エコーバック抑制のため、完全一致した行を 1 度だけ除外する。
+// ブラケットペーストで送った複数行の場合は、各行を順に 1 度ずつ除外する（2 行目以降は完全一致のみ）
 func (p *Processor) SetLastSentCommand(command string) {
-	trimmed := strings.TrimSpace(command)
-	p.lastSentCommand = &trimmed
+	command = strings.ReplaceAll(command, BracketedPasteStart, "")
+	command = strings.ReplaceAll(command, BracketedPasteEnd, "")
+	p.clearEcho()
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(command, "\r", "\n"), "\n") {
+		if trimmed := strings.TrimSpace(line); trimmed != "" {
+			lines = append(lines, trimmed)
+		}
+	}
+	if len(lines) == 0 {
+		empty := ""
+		p.lastSentCommand = &empty
+		return
+	}
+	p.lastSentCommand = &lines[0]
+	p.pendingEcho = lines[1:]
+}
+
+// advanceEcho はエコーバック 1 行分の抑制を消費し、次の行があれば待ち受ける
+func (p *Processor) advanceEcho() {
+	if len(p.pendingEcho) == 0 {
+		p.clearEcho()
+		return
+	}
+	p.lastSentCommand = &p.pendingEcho[0]
+	p.pendingEcho = p.pendingEcho[1:]
+	p.echoQueued = true
+}
+
+// clearEcho はエコーバックの待ち受けをやめる
+func (p *Processor) clearEcho() {
+	p.lastSentCommand = nil
+	p.pendingEcho = nil
+	p.echoQueued = false
 }
 
 // Clear は内部バッファと進捗・エコーバック状態をクリアする
 func (p *Processor) Clear() {
 	p.buffer = ""
 	p.currentProgressLine = nil
-	p.lastSentCommand = nil
+	p.clearEcho()
 	p.thinkingActive = false
 }
 
 // SimplifiedProcessor は簡易API用のプロセッサー
+// PTY の読み取り goroutine と送信側 goroutine の双方から呼ばれるため mu で保護する
 type SimplifiedProcessor struct {
 	*Processor
+	mu           sync.Mutex
 	lines        []string
 	progressLine string
 }
@@ -198,6 +298,8 @@ func NewSimplifiedProcessor() *SimplifiedProcessor {
 
 // Process はデータを処理し、確定した行を返す
 func (sp *SimplifiedProcessor) Process(data string) []string {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
 	sp.lines = []string{} // リセット
 	sp.Processor.ProcessData("stdout", data)
 	return sp.lines
@@ -205,5 +307,36 @@ func (sp *SimplifiedProcessor) Process(data string) []string {
 
 // GetProgressLine は現在の進捗行を返す
 func (sp *SimplifiedProcessor) GetProgressLine() string {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
 	return sp.progressLine
 }
+
+// AtPrompt は Q が入力待ちのプロンプトに戻ったかを返す
+func (sp *SimplifiedProcessor) AtPrompt() bool {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.Processor.AtPrompt()
+}
+
+// SetPatterns は進捗・Thinking の判定に使うパターンを設定する
+func (sp *SimplifiedProcessor) SetPatterns(patterns qcompat.Patterns) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.Processor.SetPatterns(patterns)
+}
+
+// SetLastSentCommand は直前に送信したコマンドを設定する
+func (sp *SimplifiedProcessor) SetLastSentCommand(command string) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.Processor.SetLastSentCommand(command)
+}
+
+// Clear は内部バッファと進捗・エコーバック状態をクリアする
+func (sp *SimplifiedProcessor) Clear() {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.Processor.Clear()
+	sp.progressLine = ""
+}
@@ -5,6 +5,8 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"qube/internal/qcompat"
 )
 
 // readFileLines は golden ファイルを読み込み、行配列に変換する
@@ -117,3 +119,78 @@ func Test_FixturesMatchGolden(t *testing.T) {
 		}
 	}
 }
+
+// Test_MultilineEchoSuppression は、ブラケットペーストで送った複数行の
+// エコーバックが各行 1 度ずつ除外されることを検証する
+func Test_MultilineEchoSuppression(t *testing.T) {
+    processor := NewProcessor(nil, nil)
+    processor.SetLastSentCommand("\x1b[200~first line\nsecond line\x1b[201~\r")
+
+    got := streamAll(t, processor, "> first line\nsecond line\nanswer\nsecond line\n")
+    want := []string{"answer", "second line"}
+    if strings.Join(got, "|") != strings.Join(want, "|") {
+        t.Fatalf("got %q, want %q", got, want)
+    }
+}
+
+// Test_MultilineEchoShortLine は、短い閉じ行（"}"）のエコーバックの後に
+// 同じ文字を含む応答が来ても、応答の行を除外しないことを検証する
+func Test_MultilineEchoShortLine(t *testing.T) {
+    processor := NewProcessor(nil, nil)
+    processor.SetLastSentCommand("\x1b[200~func f() {\n}\x1b[201~\r")
+
+    got := streamAll(t, processor, "> func f() {\n> }\nreturn map[string]int{}\n}\n")
+    want := []string{"return map[string]int{}", "}"}
+    if strings.Join(got, "|") != strings.Join(want, "|") {
+        t.Fatalf("got %q, want %q", got, want)
+    }
+
+    // 閉じ行のエコーバックが来ないまま応答が始まった場合も、以降の行を除外しない
+    processor.SetLastSentCommand("\x1b[200~func f() {\n}\x1b[201~\r")
+    got = streamAll(t, processor, "> func f() {\nreturn {}\n}\n")
+    want = []string{"return {}", "}"}
+    if strings.Join(got, "|") != strings.Join(want, "|") {
+        t.Fatalf("got %q, want %q", got, want)
+    }
+}
+
+// Test_AtPrompt は、応答の後に入力プロンプトが戻ったことを検出し、
+// エコーバック待ちの間のプロンプトは応答の終わりとみなさないことを検証する
+func Test_AtPrompt(t *testing.T) {
+    processor := NewProcessor(nil, nil)
+    processor.SetLastSentCommand("hello")
+    processor.ProcessData("stdout", "\x1b[35m> \x1b[0m")
+    if processor.AtPrompt() {
+        t.Fatal("prompt before the echo should not count")
+    }
+    processor.ProcessData("stdout", "hello\nHi there\n")
+    if processor.AtPrompt() {
+        t.Fatal("answer in progress should not count")
+    }
+    processor.ProcessData("stdout", "\n\x1b[35m> \x1b[0m")
+    if !processor.AtPrompt() {
+        t.Fatal("prompt after the answer should be detected")
+    }
+}
+
+// Test_PatternsOverride は、qcompat で上書きしたパターンで
+// 進捗・Thinking を判定することを検証する
+func Test_PatternsOverride(t *testing.T) {
+    patterns, err := qcompat.Spec{Spinner: `^\s*[◐◓◑◒]`, Thinking: `(?i)Pondering`}.Apply(qcompat.Default())
+    if err != nil { t.Fatal(err) }
+    var progress []string
+    processor := NewProcessor(nil, nil)
+    processor.SetPatterns(patterns)
+    got := streamAll(t, processor, "Pondering...\nanswer\n")
+    if strings.Join(got, "|") != "answer" {
+        t.Fatalf("thinking should be suppressed: %q", got)
+    }
+
+    processor.onProgressUpdate = func(line *string) {
+        if line != nil { progress = append(progress, *line) }
+    }
+    processor.ProcessData("stdout", "\r◐ fetching\r◓ fetching")
+    if len(progress) != 1 || progress[0] != "◓ fetching" {
+        t.Fatalf("custom spinner should update progress: %q", progress)
+    }
+}
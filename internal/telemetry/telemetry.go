@@ -0,0 +1,288 @@
+// Package telemetry は Qube の利用状況をローカルの JSONL ファイルに記録する
+//
+// テレメトリは既定で無効で、設定（telemetry.enabled）で明示的に有効にした場合だけ記録する。
+// 記録はローカルのファイルのみで、外部へは送信しない。
+// イベントには所要時間・終了コード・エラーの種類などの匿名の値だけを含め、
+// プロンプトや出力の本文、引数、パス、エラーメッセージは記録しない。
+package telemetry
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"qube/internal/logging"
+)
+
+const (
+	// DefaultFileName はホームディレクトリ直下の記録ファイル名
+	DefaultFileName = ".qube_telemetry.jsonl"
+	// DefaultMaxBytes はローテーションする記録ファイルの大きさ
+	DefaultMaxBytes = 1 << 20
+	// DefaultMaxFiles はローテーション後も残すファイル数（現在のファイルを含む）
+	DefaultMaxFiles = 3
+)
+
+// Event は記録するイベント 1 件
+// 本文やパスを含められないよう、値は列挙値・数値のみとする
+type Event struct {
+	Time       time.Time `json:"time"`
+	Name       string    `json:"event"`             // "command.finished" など
+	Run        string    `json:"run,omitempty"`     // 起動ごとのランダムな ID
+	Version    string    `json:"version,omitempty"` // Qube のバージョン
+	Command    string    `json:"command,omitempty"` // "q doctor" / "passthrough" / "/timeout" など（引数は含めない）
+	Mode       string    `json:"mode,omitempty"`    // "tui" / "ask" / "chat" など
+	DurationMs int64     `json:"duration_ms,omitempty"`
+	ExitCode   *int      `json:"exit_code,omitempty"`
+	ErrorKind  string    `json:"error_kind,omitempty"` // ErrorKind の値
+	Outcome    string    `json:"outcome,omitempty"`    // "allow" / "deny" / "banner" / "timeout" などの列挙値
+}
+
+// Options は Recorder の設定
+type Options struct {
+	MaxBytes int    // 1 ファイルの上限（0 以下なら DefaultMaxBytes）
+	MaxFiles int    // 残すファイル数（0 以下なら DefaultMaxFiles）
+	Version  string // 各イベントに付ける Qube のバージョン
+}
+
+// Recorder はイベントを JSONL ファイルに追記する
+// nil の Recorder は何も記録しない（テレメトリ無効時は nil を渡す）
+type Recorder struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+	run      string
+	version  string
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	failed bool // 書き込みエラーを一度だけログに出すため
+}
+
+// DefaultPath は既定の記録ファイルのパス（~/.qube_telemetry.jsonl）を返す
+func DefaultPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve home directory: %w", err)
+	}
+	return filepath.Join(home, DefaultFileName), nil
+}
+
+// Open は path に追記する Recorder を作成する
+func Open(path string, opts Options) (*Recorder, error) {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultMaxBytes
+	}
+	if opts.MaxFiles <= 0 {
+		opts.MaxFiles = DefaultMaxFiles
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Recorder{
+		path:     path,
+		maxBytes: int64(opts.MaxBytes),
+		maxFiles: opts.MaxFiles,
+		run:      newRunID(),
+		version:  opts.Version,
+		f:        f,
+		size:     st.Size(),
+	}, nil
+}
+
+func newRunID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// Path は記録ファイルのパスを返す
+func (r *Recorder) Path() string { return r.path }
+
+// Record はイベントを記録する。時刻・起動 ID・バージョンは未設定なら補う
+// 書き込みに失敗してもアプリの動作は止めない（最初の失敗だけログに出す）
+func (r *Recorder) Record(ev Event) {
+	if r == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if ev.Run == "" {
+		ev.Run = r.run
+	}
+	if ev.Version == "" {
+		ev.Version = r.version
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return
+	}
+	if r.size > 0 && r.size+int64(len(b)) > r.maxBytes {
+		if err := r.rotateLocked(); err != nil {
+			r.logOnce(err)
+			return
+		}
+	}
+	n, err := r.f.Write(b)
+	r.size += int64(n)
+	if err != nil {
+		r.logOnce(err)
+	}
+}
+
+func (r *Recorder) logOnce(err error) {
+	if !r.failed {
+		r.failed = true
+		logging.For("telemetry").Warn("telemetry write failed", "path", r.path, "err", err)
+	}
+}
+
+// rotateLocked は path → path.1 → path.2 … と送り、古いファイルを削除する
+func (r *Recorder) rotateLocked() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	r.f = nil
+	_ = os.Remove(rotatedPath(r.path, r.maxFiles-1))
+	for i := r.maxFiles - 2; i >= 0; i-- {
+		_ = os.Rename(rotatedPath(r.path, i), rotatedPath(r.path, i+1))
+	}
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	r.f = f
+	r.size = 0
+	return nil
+}
+
+// Close は記録ファイルを閉じる
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// rotatedPath は i 世代前のファイルのパスを返す（0 は現在のファイル）
+func rotatedPath(path string, i int) string {
+	if i == 0 {
+		return path
+	}
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// Files は path とローテーション済みのファイルのうち存在するものを古い順に返す
+func Files(path string) []string {
+	var files []string
+	for i := maxRotations; i >= 0; i-- {
+		p := rotatedPath(path, i)
+		if _, err := os.Stat(p); err == nil {
+			files = append(files, p)
+		}
+	}
+	return files
+}
+
+// maxRotations は Files / Purge が探すローテーション済みファイルの世代数
+// MaxFiles を小さくした後に残った古いファイルも対象にするため、設定値より多めに探す
+const maxRotations = 20
+
+// Read は記録済みのイベントを古い順に返す。壊れた行は読み飛ばす
+func Read(path string) ([]Event, error) {
+	var events []Event
+	for _, p := range Files(path) {
+		f, err := os.Open(p)
+		if err != nil {
+			return events, err
+		}
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 64*1024), 1<<20)
+		for sc.Scan() {
+			var ev Event
+			if json.Unmarshal(sc.Bytes(), &ev) == nil && ev.Name != "" {
+				events = append(events, ev)
+			}
+		}
+		err = sc.Err()
+		f.Close()
+		if err != nil {
+			return events, err
+		}
+	}
+	return events, nil
+}
+
+// Purge は記録ファイルをローテーション済みのものも含めて削除し、削除したファイル数を返す
+func Purge(path string) (int, error) {
+	n := 0
+	var errs []error
+	for _, p := range Files(path) {
+		if err := os.Remove(p); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		n++
+	}
+	return n, errors.Join(errs...)
+}
+
+// ErrorKind はエラーを記録用の種類に分類する（メッセージは記録しない）
+func ErrorKind(err error) string {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		return "not_found"
+	case errors.Is(err, fs.ErrPermission):
+		return "permission"
+	case errors.As(err, &exitErr):
+		return "exit_status"
+	}
+	return "other"
+}
+
+// Exit は終了コードを Event.ExitCode 用のポインタにして返す
+func Exit(code int) *int { return &code }
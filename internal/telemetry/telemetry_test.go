@@ -0,0 +1,133 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecorder_RecordAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telemetry.jsonl")
+	r, err := Open(path, Options{Version: "1.2.3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Record(Event{Name: "command.finished", Command: "q doctor", DurationMs: 120, ExitCode: Exit(0)})
+	r.Record(Event{Name: "app.exited", ExitCode: Exit(1)})
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("events: %+v", events)
+	}
+	ev := events[0]
+	if ev.Name != "command.finished" || ev.Version != "1.2.3" || ev.Run == "" || ev.Time.IsZero() || *ev.ExitCode != 0 {
+		t.Fatalf("recorder should fill in time, run ID and version: %+v", ev)
+	}
+	if events[1].Run != ev.Run {
+		t.Fatal("events from the same recorder should share the run ID")
+	}
+	if st, _ := os.Stat(path); st.Mode().Perm() != 0o600 {
+		t.Fatalf("telemetry file should be private: %v", st.Mode())
+	}
+
+	// nil の Recorder は何もしない
+	var disabled *Recorder
+	disabled.Record(Event{Name: "x"})
+	if err := disabled.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecorder_RotatesAndPurges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telemetry.jsonl")
+	r, err := Open(path, Options{MaxBytes: 300, MaxFiles: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 30; i++ {
+		r.Record(Event{Name: fmt.Sprintf("event.%02d", i)})
+	}
+	r.Close()
+
+	files := Files(path)
+	if len(files) != 3 || files[2] != path || files[0] != path+".2" {
+		t.Fatalf("should keep 3 files, oldest first: %v", files)
+	}
+	for _, f := range files {
+		if st, _ := os.Stat(f); st.Size() > 300 {
+			t.Fatalf("%s exceeds MaxBytes: %d", f, st.Size())
+		}
+	}
+	events, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) == 0 || len(events) >= 30 || events[len(events)-1].Name != "event.29" {
+		t.Fatalf("old events should be dropped, newest kept: %d events, last %+v", len(events), events[len(events)-1])
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i-1].Name >= events[i].Name {
+			t.Fatalf("events should be in recording order: %s before %s", events[i-1].Name, events[i].Name)
+		}
+	}
+
+	n, err := Purge(path)
+	if err != nil || n != 3 {
+		t.Fatalf("purge: n=%d err=%v", n, err)
+	}
+	if len(Files(path)) != 0 {
+		t.Fatal("purge should remove all files")
+	}
+}
+
+func TestRead_SkipsBrokenLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telemetry.jsonl")
+	data := `{"time":"2026-10-18T10:00:00Z","event":"app.started"}` + "\n{broken\n\n" + `{"time":"2026-10-18T10:00:01Z","event":"app.exited"}` + "\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	events, err := Read(path)
+	if err != nil || len(events) != 2 {
+		t.Fatalf("events=%+v err=%v", events, err)
+	}
+	if events, err := Read(filepath.Join(t.TempDir(), "missing.jsonl")); err != nil || len(events) != 0 {
+		t.Fatalf("missing file should read as empty: %v %v", events, err)
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	_, notFound := exec.LookPath("qube-no-such-binary")
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{fmt.Errorf("run: %w", context.DeadlineExceeded), "timeout"},
+		{context.Canceled, "canceled"},
+		{notFound, "not_found"},
+		{os.ErrPermission, "permission"},
+		{exitErr, "exit_status"},
+		{errors.New("secret /home/me/path"), "other"},
+	}
+	for _, tt := range tests {
+		got := ErrorKind(tt.err)
+		if got != tt.want {
+			t.Errorf("ErrorKind(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+		if strings.Contains(got, "/") {
+			t.Errorf("error kind must not leak the message: %q", got)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package transcript
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// reFence は Markdown のコードフェンス（``` または ~~~ で始まる行）
+var reFence = regexp.MustCompile("^\\s{0,3}(`{3,}|~{3,})")
+
+// Markdown は t を Markdown に変換する
+//
+// Q の応答はそのまま本文として書き、応答中のコードフェンスは保つ（閉じられずに終わった場合は閉じる）。
+// 短命コマンドの出力は Markdown として解釈されないようコードブロックに入れる。
+func Markdown(t Transcript) string {
+	var b strings.Builder
+	b.WriteString("# Qube conversation\n\n")
+	meta := []struct{ name, value string }{
+		{"Exported", t.Exported.Format(time.RFC3339)},
+		{"Directory", t.Cwd},
+		{"Profile", t.Profile},
+		{"Model", t.Model},
+		{"Q CLI", t.QVersion},
+	}
+	for _, m := range meta {
+		if m.value != "" {
+			fmt.Fprintf(&b, "- %s: %s\n", m.name, m.value)
+		}
+	}
+
+	n := 0
+	for _, turn := range t.Turns {
+		if len(turn.Entries) == 0 && turn.Prompt == "" {
+			continue
+		}
+		b.WriteString("\n")
+		if turn.Prompt == "" {
+			fmt.Fprintf(&b, "## Startup · %s\n\n", turn.Started.Format("15:04:05"))
+		} else {
+			n++
+			fmt.Fprintf(&b, "## %d · %s\n\n", n, turnHeading(turn))
+			writeQuote(&b, turn.Prompt)
+		}
+		writeEntries(&b, turn)
+	}
+	return b.String()
+}
+
+// turnHeading はターンの見出し（時刻・種類・所要時間・状態）を返す
+func turnHeading(t Turn) string {
+	parts := []string{t.Started.Format("15:04:05")}
+	if t.Mode != "" {
+		parts = append(parts, t.Mode)
+	}
+	if d := t.Duration(); d > 0 {
+		parts = append(parts, d.Round(100*time.Millisecond).String())
+	}
+	if t.Status == "failed" {
+		parts = append(parts, "failed")
+	}
+	return strings.Join(parts, " · ")
+}
+
+// writeQuote はプロンプトを引用として書く
+func writeQuote(b *strings.Builder, text string) {
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString(strings.TrimRight("> "+line, " ") + "\n")
+	}
+	b.WriteString("\n")
+}
+
+// writeEntries はターンの出力を書く。連続する出力行は 1 つのブロックにまとめる
+func writeEntries(b *strings.Builder, t Turn) {
+	var block []string
+	flush := func() {
+		if len(block) == 0 {
+			return
+		}
+		if t.Mode == ModeCommand {
+			writeCodeBlock(b, block)
+		} else {
+			writeProse(b, block)
+		}
+		block = nil
+	}
+	for _, e := range t.Entries {
+		switch e.Kind {
+		case KindOutput:
+			block = append(block, strings.Split(e.Text, "\n")...)
+		case KindTool:
+			flush()
+			fmt.Fprintf(b, "_%s_\n\n", escapeInline(strings.TrimSpace(e.Text)))
+		case KindSystem:
+			flush()
+			// 複数行でも引用の外に出ないよう、各行に "> " を付ける
+			lines := strings.Split(escapeInline(strings.TrimSpace(e.Text)), "\n")
+			fmt.Fprintf(b, "> **Qube:** %s\n", lines[0])
+			for _, l := range lines[1:] {
+				fmt.Fprintf(b, "> %s\n", l)
+			}
+			b.WriteString("\n")
+		case KindResult:
+			flush()
+			fmt.Fprintf(b, "`%s`\n\n", describeResult(e))
+		}
+	}
+	flush()
+}
+
+// writeProse は Q の応答を本文として書く。前後の空行は詰め、閉じられていないコードフェンスは閉じる
+func writeProse(b *strings.Builder, lines []string) {
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return
+	}
+	open := ""
+	for _, line := range lines {
+		b.WriteString(line + "\n")
+		m := reFence.FindStringSubmatch(line)
+		switch {
+		case m == nil:
+		case open == "":
+			open = m[1]
+		case m[1][0] == open[0] && len(m[1]) >= len(open) && strings.TrimSpace(line) == m[1]:
+			open = ""
+		}
+	}
+	if open != "" {
+		b.WriteString(open + "\n")
+	}
+	b.WriteString("\n")
+}
+
+// writeCodeBlock はコマンドの出力をコードブロックとして書く
+// 出力に含まれるバッククォートの連続より長いフェンスを使う
+func writeCodeBlock(b *strings.Builder, lines []string) {
+	fence := "```"
+	for _, line := range lines {
+		for strings.Contains(line, fence) {
+			fence += "`"
+		}
+	}
+	b.WriteString(fence + "text\n")
+	for _, line := range lines {
+		b.WriteString(line + "\n")
+	}
+	b.WriteString(fence + "\n\n")
+}
+
+// describeResult は短命コマンドの完了を 1 行で表す（例: "exit 0 · 1.2s"）
+func describeResult(e Entry) string {
+	if e.Result == nil {
+		return strings.TrimSpace(e.Text)
+	}
+	r := e.Result
+	s := fmt.Sprintf("exit %d · %s", r.ExitCode, (time.Duration(r.DurationMs) * time.Millisecond).Round(100*time.Millisecond))
+	if r.TimedOut {
+		s += " · timeout"
+	}
+	if r.Truncated {
+		s += " · truncated"
+	}
+	return s
+}
+
+// escapeInline は強調の中で解釈される記号をエスケープする
+func escapeInline(s string) string {
+	return strings.NewReplacer("_", `\_`, "*", `\*`).Replace(s)
+}
@@ -0,0 +1,177 @@
+// Package transcript は会話の記録（プロンプト・応答・コマンドの結果・時刻）を書き出す
+//
+// 記録は UI が保持するターン（stream.Processor で処理済みの出力）から作る。
+// 画面の描画結果ではないため、折り返しやスクロールの状態に影響されない。
+// 書き出し形式は人が読むための Markdown と、機械処理のための JSON の 2 種類。
+package transcript
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/x/ansi"
+)
+
+// FormatVersion は JSON 形式の版。互換性のない変更をしたら上げる
+const FormatVersion = 1
+
+// Transcript は会話全体の記録
+type Transcript struct {
+	Version  int       `json:"version"`
+	Exported time.Time `json:"exported"`
+	Cwd      string    `json:"cwd,omitempty"`
+	Profile  string    `json:"profile,omitempty"`
+	Model    string    `json:"model,omitempty"`     // Q の使用モデル
+	QVersion string    `json:"q_version,omitempty"` // Q CLI のバージョン
+	Turns    []Turn    `json:"turns"`
+}
+
+// 入力の種類（Turn.Mode）
+const (
+	ModeChat    = "chat"    // q chat への入力
+	ModeCommand = "command" // 短命コマンド
+	ModeQube    = "qube"    // Qube のスラッシュコマンド
+)
+
+// Prompts は記録に含まれる入力の件数を返す
+func (t Transcript) Prompts() int {
+	n := 0
+	for _, turn := range t.Turns {
+		if turn.Prompt != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Turn はプロンプト 1 件と、それに対する応答
+// Prompt が空のターンは最初のプロンプトより前の出力（起動メッセージ等）
+type Turn struct {
+	Prompt   string    `json:"prompt,omitempty"`
+	Mode     string    `json:"mode,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitzero"`
+	Status   string    `json:"status"` // "running" / "done" / "failed"
+	Entries  []Entry   `json:"entries"`
+}
+
+// Duration はターンの所要時間を返す（完了していなければ 0）
+func (t Turn) Duration() time.Duration {
+	if t.Finished.IsZero() {
+		return 0
+	}
+	return t.Finished.Sub(t.Started)
+}
+
+// エントリの種類（Entry.Kind）
+const (
+	KindOutput = "output" // Q の応答・コマンドの出力
+	KindTool   = "tool"   // ツール実行の通知
+	KindSystem = "system" // Qube 自身のメッセージ（ポリシー判定・エラー等）
+	KindResult = "result" // 短命コマンドの完了
+)
+
+// Entry はターン内の 1 行の出力（ANSI エスケープは除去済み）
+type Entry struct {
+	Kind   string    `json:"kind"`
+	Text   string    `json:"text"`
+	Time   time.Time `json:"time"`
+	Result *Result   `json:"result,omitempty"` // KindResult のみ
+}
+
+// Result は短命コマンドの完了情報
+type Result struct {
+	ExitCode   int   `json:"exit_code"`
+	DurationMs int64 `json:"duration_ms"`
+	TimedOut   bool  `json:"timed_out,omitempty"`
+	Truncated  bool  `json:"truncated,omitempty"`
+}
+
+// Clean は出力から ANSI エスケープと各行の行末の空白・CR、末尾の改行を取り除く
+func Clean(s string) string {
+	lines := strings.Split(ansi.Strip(s), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
+
+// Format は書き出し形式
+type Format string
+
+const (
+	FormatMarkdown Format = "markdown"
+	FormatJSON     Format = "json"
+)
+
+// Formats は指定できる書き出し形式の名前
+var Formats = []string{string(FormatMarkdown), string(FormatJSON)}
+
+// ParseFormat は形式の名前（"markdown" / "md" / "json"）を解釈する
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "markdown", "md":
+		return FormatMarkdown, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return "", fmt.Errorf("unknown export format %q (want one of: %s)", s, strings.Join(Formats, ", "))
+}
+
+// FormatFor はファイル名の拡張子から形式を選ぶ（.json なら JSON、それ以外は Markdown）
+func FormatFor(path string) Format {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return FormatJSON
+	}
+	return FormatMarkdown
+}
+
+// Ext は形式のファイル拡張子を返す
+func (f Format) Ext() string {
+	if f == FormatJSON {
+		return ".json"
+	}
+	return ".md"
+}
+
+// DefaultName は書き出し時刻から既定のファイル名を返す（例: "qube-20261018-210405.md"）
+func DefaultName(now time.Time, f Format) string {
+	return "qube-" + now.Format("20060102-150405") + f.Ext()
+}
+
+// Write は t を形式 f で w に書き出す
+func Write(w io.Writer, t Transcript, f Format) error {
+	if f == FormatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(t)
+	}
+	_, err := io.WriteString(w, Markdown(t))
+	return err
+}
+
+// Save は t をファイル path に書き出す（形式は拡張子で選ぶ）
+// path がディレクトリ、または空の場合は、その中（空ならカレントディレクトリ）に既定の名前で作る
+// 書き出したファイルのパスを返す。会話にはプロンプトが含まれるため、ファイルは本人だけが読める権限で作る
+func Save(path string, t Transcript, def Format) (string, error) {
+	if path == "" {
+		path = "."
+	}
+	if st, err := os.Stat(path); err == nil && st.IsDir() {
+		path = filepath.Join(path, DefaultName(t.Exported, def))
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", err
+	}
+	if err := Write(f, t, FormatFor(path)); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
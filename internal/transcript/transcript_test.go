@@ -0,0 +1,116 @@
+package transcript
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sample() Transcript {
+	t0 := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	return Transcript{
+		Version:  FormatVersion,
+		Exported: t0.Add(time.Hour),
+		Cwd:      "/work",
+		Profile:  "prod",
+		Turns: []Turn{
+			{Started: t0, Status: "done", Entries: []Entry{{Kind: KindOutput, Text: "Welcome to Q", Time: t0}}},
+			{
+				Prompt: "show a script", Mode: ModeChat, Started: t0, Finished: t0.Add(2 * time.Second), Status: "done",
+				Entries: []Entry{
+					{Kind: KindTool, Text: "Using tool: fs_read", Time: t0},
+					{Kind: KindOutput, Text: "Here it is:\n\n```sh\necho hi", Time: t0},
+				},
+			},
+			{
+				Prompt: "cat notes", Mode: ModeCommand, Started: t0, Finished: t0.Add(time.Second), Status: "failed",
+				Entries: []Entry{
+					{Kind: KindOutput, Text: "has ``` inside", Time: t0},
+					{Kind: KindResult, Text: "✗ 1", Time: t0, Result: &Result{ExitCode: 1, DurationMs: 1200, TimedOut: true}},
+					{Kind: KindSystem, Text: "policy: *confirm* make_all\n# not a heading", Time: t0},
+				},
+			},
+		},
+	}
+}
+
+func TestClean(t *testing.T) {
+	if got := Clean("\x1b[1;32mok\x1b[0m  \r\n\x1b[2Kdone\t\n\n"); got != "ok\ndone" {
+		t.Fatalf("Clean: %q", got)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	md := Markdown(sample())
+	for _, want := range []string{
+		"# Qube conversation\n",
+		"- Directory: /work\n- Profile: prod\n",
+		"## Startup · 03:04:05\n\nWelcome to Q\n",
+		"## 1 · 03:04:05 · chat · 2s\n\n> show a script\n",
+		"_Using tool: fs\\_read_\n",
+		// 閉じられていないコードフェンスは閉じる
+		"```sh\necho hi\n```\n",
+		// コマンドの出力は中のバッククォートより長いフェンスで囲む
+		"## 2 · 03:04:05 · command · 1s · failed\n",
+		"````text\nhas ``` inside\n````\n",
+		"`exit 1 · 1.2s · timeout`",
+		// Qube のメッセージは強調を無効にし、全ての行を引用にする
+		"> **Qube:** policy: \\*confirm\\* make\\_all\n> # not a heading\n\n",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown should contain %q, got:\n%s", want, md)
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, sample(), FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	var got Transcript
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != FormatVersion || len(got.Turns) != 3 || got.Turns[2].Entries[1].Result.ExitCode != 1 {
+		t.Fatalf("round trip: %+v", got)
+	}
+	if !strings.Contains(buf.String(), `"exit_code": 1`) {
+		t.Errorf("unexpected JSON:\n%s", buf.String())
+	}
+}
+
+func TestSave(t *testing.T) {
+	dir := t.TempDir()
+	tr := sample()
+
+	// ディレクトリを指定すると既定の名前で作る
+	path, err := Save(dir, tr, FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(dir, "qube-20250102-040405.json") {
+		t.Fatalf("path: %s", path)
+	}
+	st, err := os.Stat(path)
+	if err != nil || st.Mode().Perm() != 0o600 {
+		t.Fatalf("file should be private: %v %v", st, err)
+	}
+
+	// 拡張子で形式を選ぶ
+	path, err = Save(filepath.Join(dir, "notes.md"), tr, FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); !strings.HasPrefix(string(b), "# Qube conversation") {
+		t.Fatalf("expected markdown, got %q", b)
+	}
+
+	if _, err := ParseFormat("html"); err == nil {
+		t.Fatal("unknown format should be rejected")
+	}
+}
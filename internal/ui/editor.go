@@ -0,0 +1,290 @@
+package ui
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Editor は入力欄のエディタ。カーソル移動、単語単位の移動/削除、
+// readline 風の kill/yank、ブラケットペースト、複数行入力に対応する。
+// 行頭/行末への移動や kill は、カーソルのある論理行を対象にする。
+// キー割り当ては EditorKeyMap で変更できる。
+type Editor struct {
+	value  []rune
+	cursor int // value 上のカーソル位置（0..len(value)）
+	keys   EditorKeyMap
+
+	killBuf  []rune // 直近に kill した文字列（Ctrl+Y で yank）
+	lastKill bool   // 直前の操作が kill か（連続 kill は killBuf に連結する）
+}
+
+// NewEditor は空の Editor を返す
+func NewEditor() Editor {
+	return Editor{keys: DefaultEditorKeyMap()}
+}
+
+// SetKeyMap は編集操作のキー割り当てを設定する
+func (e *Editor) SetKeyMap(keys EditorKeyMap) { e.keys = keys }
+
+// Value は入力中の文字列を返す
+func (e *Editor) Value() string { return string(e.value) }
+
+// SetValue は入力内容を置き換え、カーソルを末尾に移動する
+func (e *Editor) SetValue(s string) {
+	e.value = []rune(s)
+	e.cursor = len(e.value)
+	e.lastKill = false
+}
+
+// Reset は入力内容を空にする（kill バッファは保持する）
+func (e *Editor) Reset() { e.SetValue("") }
+
+// Cursor はカーソル位置（rune 単位）を返す
+func (e *Editor) Cursor() int { return e.cursor }
+
+// Insert はカーソル位置に文字列を挿入する
+func (e *Editor) Insert(s string) {
+	r := []rune(s)
+	if len(r) == 0 {
+		return
+	}
+	v := make([]rune, 0, len(e.value)+len(r))
+	v = append(v, e.value[:e.cursor]...)
+	v = append(v, r...)
+	v = append(v, e.value[e.cursor:]...)
+	e.value = v
+	e.cursor += len(r)
+}
+
+// HandleKey は編集系のキーを処理し、処理した場合は true を返す
+// Enter や履歴移動など、エディタが扱わないキーは false を返して呼び出し元に委ねる
+func (e *Editor) HandleKey(msg tea.KeyMsg) bool {
+	wasKill := e.lastKill
+	e.lastKill = false
+	k := &e.keys
+
+	switch {
+	case msg.Type == tea.KeyRunes && (!msg.Alt || msg.Paste):
+		e.Insert(sanitizePaste(string(msg.Runes)))
+	case msg.Type == tea.KeySpace:
+		e.Insert(" ")
+	case key.Matches(msg, k.WordLeft):
+		e.cursor = e.wordLeft()
+	case key.Matches(msg, k.WordRight):
+		e.cursor = e.wordRight()
+	case key.Matches(msg, k.CharLeft):
+		if e.cursor > 0 {
+			e.cursor--
+		}
+	case key.Matches(msg, k.CharRight):
+		if e.cursor < len(e.value) {
+			e.cursor++
+		}
+	case key.Matches(msg, k.LineStart):
+		e.cursor = e.lineStart()
+	case key.Matches(msg, k.LineEnd):
+		e.cursor = e.lineEnd()
+	case key.Matches(msg, k.Newline):
+		e.Insert("\n")
+	case key.Matches(msg, k.DeleteWordBack):
+		e.kill(e.wordLeft(), e.cursor, wasKill, true)
+	case key.Matches(msg, k.DeleteWordForward):
+		e.kill(e.cursor, e.wordRight(), wasKill, false)
+	case key.Matches(msg, k.DeleteBack):
+		if e.cursor > 0 {
+			e.delete(e.cursor-1, e.cursor)
+		}
+	case key.Matches(msg, k.DeleteForward):
+		if e.cursor < len(e.value) {
+			e.delete(e.cursor, e.cursor+1)
+		}
+	case key.Matches(msg, k.KillToStart):
+		e.kill(e.lineStart(), e.cursor, wasKill, true)
+	case key.Matches(msg, k.KillToEnd):
+		// 行末にいる場合は改行を kill して次の行と連結する
+		end := e.lineEnd()
+		if end == e.cursor && end < len(e.value) {
+			end++
+		}
+		e.kill(e.cursor, end, wasKill, false)
+	case key.Matches(msg, k.Yank):
+		e.Insert(string(e.killBuf))
+	default:
+		return false
+	}
+	return true
+}
+
+// delete は [from, to) を削除してカーソルを from に置く
+func (e *Editor) delete(from, to int) {
+	if from >= to {
+		return
+	}
+	e.value = append(e.value[:from:from], e.value[to:]...)
+	e.cursor = from
+}
+
+// kill は [from, to) を削除して kill バッファに入れる
+// 連続した kill は readline と同様に 1 つの kill として連結する
+func (e *Editor) kill(from, to int, appendToPrev, backward bool) {
+	if from >= to {
+		e.lastKill = appendToPrev
+		return
+	}
+	killed := append([]rune(nil), e.value[from:to]...)
+	switch {
+	case !appendToPrev:
+		e.killBuf = killed
+	case backward:
+		e.killBuf = append(killed, e.killBuf...)
+	default:
+		e.killBuf = append(e.killBuf, killed...)
+	}
+	e.delete(from, to)
+	e.lastKill = true
+}
+
+// IsMultiline は入力が複数行かどうかを返す
+func (e *Editor) IsMultiline() bool {
+	for _, r := range e.value {
+		if r == '\n' {
+			return true
+		}
+	}
+	return false
+}
+
+// CursorUp はカーソルを 1 行上の同じ桁へ移動する
+// 先頭行にいて移動できない場合は false を返す（呼び出し元で履歴移動に使う）
+func (e *Editor) CursorUp() bool {
+	start := e.lineStart()
+	if start == 0 {
+		return false
+	}
+	col := e.cursor - start
+	prevStart := e.lineStartAt(start - 1)
+	e.cursor = min(prevStart+col, start-1)
+	return true
+}
+
+// CursorDown はカーソルを 1 行下の同じ桁へ移動する
+// 最終行にいて移動できない場合は false を返す
+func (e *Editor) CursorDown() bool {
+	end := e.lineEnd()
+	if end == len(e.value) {
+		return false
+	}
+	col := e.cursor - e.lineStart()
+	nextStart := end + 1
+	e.cursor = nextStart + col
+	if nextEnd := e.lineEndAt(nextStart); e.cursor > nextEnd {
+		e.cursor = nextEnd
+	}
+	return true
+}
+
+// lineStart はカーソルのある行の先頭位置を返す
+func (e *Editor) lineStart() int { return e.lineStartAt(e.cursor) }
+
+// lineEnd はカーソルのある行の末尾位置（改行の手前）を返す
+func (e *Editor) lineEnd() int { return e.lineEndAt(e.cursor) }
+
+func (e *Editor) lineStartAt(pos int) int {
+	for pos > 0 && e.value[pos-1] != '\n' {
+		pos--
+	}
+	return pos
+}
+
+func (e *Editor) lineEndAt(pos int) int {
+	for pos < len(e.value) && e.value[pos] != '\n' {
+		pos++
+	}
+	return pos
+}
+
+// wordLeft はカーソルの左側にある単語の先頭位置を返す
+func (e *Editor) wordLeft() int {
+	i := e.cursor
+	for i > 0 && !isWordRune(e.value[i-1]) {
+		i--
+	}
+	for i > 0 && isWordRune(e.value[i-1]) {
+		i--
+	}
+	return i
+}
+
+// wordRight はカーソルの右側にある単語の末尾位置を返す
+func (e *Editor) wordRight() int {
+	i := e.cursor
+	for i < len(e.value) && !isWordRune(e.value[i]) {
+		i++
+	}
+	for i < len(e.value) && isWordRune(e.value[i]) {
+		i++
+	}
+	return i
+}
+
+// isWordRune は単語を構成する文字かどうかを返す
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// sanitizePaste はペーストされた文字列を入力用に整える
+// 改行コードは \n に統一し、タブは空白に、その他の制御文字は取り除く
+func sanitizePaste(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n':
+			return r
+		case r == '\r':
+			return '\n'
+		case r == '\t':
+			return ' '
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// View はカーソル付きで入力内容を描画する（行は \n 区切り）
+// showCursor が false の場合はカーソルを表示しない。
+// maxLines が正の場合、カーソル行が見える範囲で最大 maxLines 行に絞って描画する
+func (e *Editor) View(showCursor bool, maxLines int) string {
+	lines := strings.Split(string(e.value), "\n")
+
+	// カーソルのある行と桁
+	row := 0
+	for _, r := range e.value[:e.cursor] {
+		if r == '\n' {
+			row++
+		}
+	}
+	if showCursor {
+		col := e.cursor - e.lineStart()
+		line := []rune(lines[row])
+		under, rest := " ", ""
+		if col < len(line) {
+			under, rest = string(line[col]), string(line[col+1:])
+		}
+		cursorStyle := lipgloss.NewStyle().Reverse(true)
+		lines[row] = string(line[:col]) + cursorStyle.Render(under) + rest
+	}
+
+	if maxLines > 0 && len(lines) > maxLines {
+		first := row - maxLines + 1
+		if first < 0 {
+			first = 0
+		}
+		lines = lines[first : first+maxLines]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,167 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// typeKeys は Editor にキー列を順に渡す
+func typeKeys(e *Editor, keys ...tea.KeyMsg) {
+	for _, k := range keys {
+		e.HandleKey(k)
+	}
+}
+
+func runes(s string) tea.KeyMsg         { return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)} }
+func keyPress(t tea.KeyType) tea.KeyMsg { return tea.KeyMsg{Type: t} }
+func alt(r rune) tea.KeyMsg             { return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}, Alt: true} }
+
+func Test_Editor_MidLineEditing(t *testing.T) {
+	e := NewEditor()
+	typeKeys(&e, runes("helo"), keyPress(tea.KeySpace), runes("world"))
+	if e.Value() != "helo world" {
+		t.Fatalf("got %q", e.Value())
+	}
+	// Ctrl+A で先頭へ移動し、3 文字進めて挿入
+	typeKeys(&e, keyPress(tea.KeyCtrlA), keyPress(tea.KeyRight), keyPress(tea.KeyRight), keyPress(tea.KeyRight), runes("l"))
+	if e.Value() != "hello world" || e.Cursor() != 4 {
+		t.Fatalf("insert: got %q cursor=%d", e.Value(), e.Cursor())
+	}
+	// Delete でカーソル位置の文字を削除、End で末尾へ
+	typeKeys(&e, keyPress(tea.KeyDelete), keyPress(tea.KeyEnd), keyPress(tea.KeyBackspace))
+	if e.Value() != "hell worl" || e.Cursor() != 9 {
+		t.Fatalf("delete: got %q cursor=%d", e.Value(), e.Cursor())
+	}
+}
+
+func Test_Editor_WordMovementAndDeletion(t *testing.T) {
+	e := NewEditor()
+	e.SetValue("git commit -m message")
+
+	typeKeys(&e, alt('b'))
+	if e.Cursor() != len("git commit -m ") {
+		t.Fatalf("Alt+B: cursor=%d", e.Cursor())
+	}
+	typeKeys(&e, keyPress(tea.KeyCtrlLeft), keyPress(tea.KeyCtrlLeft))
+	if e.Cursor() != len("git ") {
+		t.Fatalf("Ctrl+Left x2: cursor=%d", e.Cursor())
+	}
+	typeKeys(&e, alt('f'))
+	if e.Cursor() != len("git commit") {
+		t.Fatalf("Alt+F: cursor=%d", e.Cursor())
+	}
+	typeKeys(&e, keyPress(tea.KeyCtrlW))
+	if e.Value() != "git  -m message" {
+		t.Fatalf("Ctrl+W: got %q", e.Value())
+	}
+	typeKeys(&e, alt('d'))
+	if e.Value() != "git  message" {
+		t.Fatalf("Alt+D: got %q", e.Value())
+	}
+}
+
+func Test_Editor_KillAndYank(t *testing.T) {
+	e := NewEditor()
+	e.SetValue("one two three")
+
+	// 連続した kill は 1 つにまとまる
+	typeKeys(&e, keyPress(tea.KeyCtrlW), keyPress(tea.KeyCtrlW))
+	if e.Value() != "one " {
+		t.Fatalf("Ctrl+W x2: got %q", e.Value())
+	}
+	typeKeys(&e, keyPress(tea.KeyCtrlA), keyPress(tea.KeyCtrlY))
+	if e.Value() != "two threeone " {
+		t.Fatalf("Ctrl+Y: got %q", e.Value())
+	}
+
+	// Ctrl+K は行末まで、Ctrl+U は行頭まで kill する
+	e.SetValue("abc def")
+	typeKeys(&e, keyPress(tea.KeyCtrlA), keyPress(tea.KeyRight), keyPress(tea.KeyCtrlK))
+	if e.Value() != "a" {
+		t.Fatalf("Ctrl+K: got %q", e.Value())
+	}
+	// 直後の Ctrl+U は直前の kill と連結され、yank で両方が戻る
+	typeKeys(&e, keyPress(tea.KeyCtrlU), keyPress(tea.KeyCtrlY))
+	if e.Value() != "abc def" {
+		t.Fatalf("Ctrl+U then Ctrl+Y: got %q", e.Value())
+	}
+}
+
+func Test_Editor_BracketedPaste(t *testing.T) {
+	e := NewEditor()
+	e.SetValue("say ")
+	e.HandleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("hello\r\nworld\x07"), Paste: true})
+	// 改行は保持され、制御文字は取り除かれる
+	if e.Value() != "say hello\nworld" {
+		t.Fatalf("paste: got %q", e.Value())
+	}
+}
+
+func Test_Editor_MultilineNavigation(t *testing.T) {
+	e := NewEditor()
+	typeKeys(&e, runes("first line"), keyPress(tea.KeyCtrlJ), runes("2nd"))
+	if e.Value() != "first line\n2nd" || !e.IsMultiline() {
+		t.Fatalf("Ctrl+J: got %q", e.Value())
+	}
+	// Home は論理行の先頭へ
+	typeKeys(&e, keyPress(tea.KeyHome))
+	if e.Cursor() != len("first line\n") {
+		t.Fatalf("Home: cursor=%d", e.Cursor())
+	}
+	// 上の行の同じ桁へ移動し、先頭行ではそれ以上移動しない
+	typeKeys(&e, keyPress(tea.KeyRight), keyPress(tea.KeyRight))
+	if !e.CursorUp() || e.Cursor() != 2 {
+		t.Fatalf("CursorUp: cursor=%d", e.Cursor())
+	}
+	if e.CursorUp() {
+		t.Fatal("CursorUp on the first line should report false")
+	}
+	// 短い行へ下がる場合は行末に揃える
+	typeKeys(&e, keyPress(tea.KeyEnd))
+	if !e.CursorDown() || e.Cursor() != len(e.Value()) {
+		t.Fatalf("CursorDown: cursor=%d", e.Cursor())
+	}
+	if e.CursorDown() {
+		t.Fatal("CursorDown on the last line should report false")
+	}
+	if got := e.View(false, 1); got != "2nd" {
+		t.Fatalf("View limited to 1 line: got %q", got)
+	}
+}
+
+func Test_Update_MultilineComposeAndSubmit(t *testing.T) {
+	// Alt+Enter/Ctrl+J で改行し、Enter で複数行をまとめて送信する
+	m := New()
+	m.height = 30
+	_, _ = m.Update(runes("line one"))
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter, Alt: true})
+	_, _ = m.Update(runes("line two"))
+	_, _ = m.Update(keyPress(tea.KeyCtrlJ))
+	_, _ = m.Update(runes("line three"))
+
+	if h := strings.Count(m.renderInput(), "\n") + 1; h != 5 {
+		t.Fatalf("input box should grow to 3 lines plus borders, got height %d", h)
+	}
+
+	_, cmd := m.Update(keyPress(tea.KeyEnter))
+	if cmd == nil {
+		t.Fatal("Enter should submit")
+	}
+	if v, ok := cmd().(MsgSubmit); !ok || v.Value != "line one\nline two\nline three" {
+		t.Fatalf("submitted value: %#v", v)
+	}
+}
+
+func Test_Update_EditingKeysDoNotBreakHistory(t *testing.T) {
+	// 行エディタ導入後も ↑↓ の履歴ナビゲーションは維持される
+	m := New()
+	m.history.Add("first")
+	_, _ = m.Update(keyPress(tea.KeyUp))
+	_, _ = m.Update(keyPress(tea.KeyHome))
+	_, _ = m.Update(runes(">"))
+	if m.input.Value() != ">first" {
+		t.Fatalf("got %q", m.input.Value())
+	}
+}
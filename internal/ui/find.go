@@ -0,0 +1,229 @@
+package ui
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// findMatch はスクロールバック上の一致 1 件（content の行番号と、ANSI を除いた行でのバイト範囲）
+type findMatch struct {
+	line       int
+	start, end int
+}
+
+// finder はスクロールバック内検索（Ctrl+F）の状態
+//
+// クエリ入力中（editing）は入力に合わせて一致を更新し、Enter で確定すると n/N で一致間を移動できる。
+// 一致した行は ANSI を取り除いてからハイライトするため、エスケープシーケンスの途中で色が壊れることはない。
+type finder struct {
+	query         Editor
+	editing       bool
+	regex         bool // true ならクエリを正規表現として扱う
+	caseSensitive bool // true なら大文字小文字を区別する
+
+	matches []findMatch
+	current int   // matches 上の現在位置
+	err     error // 正規表現のコンパイルエラー
+}
+
+func newFinder() *finder {
+	return &finder{query: NewEditor(), editing: true}
+}
+
+// pattern はクエリと切り替え状態から検索用の正規表現を作る（クエリが空なら nil）
+func (f *finder) pattern() (*regexp.Regexp, error) {
+	q := f.query.Value()
+	if q == "" {
+		return nil, nil
+	}
+	if !f.regex {
+		q = regexp.QuoteMeta(q)
+	}
+	if !f.caseSensitive {
+		q = "(?i)" + q
+	}
+	return regexp.Compile(q)
+}
+
+// apply は content の一致を探し、一致箇所をハイライトした content を返す
+// 現在の一致は他の一致と異なるスタイルで描画する
+func (f *finder) apply(content string, th Theme) string {
+	f.matches = nil
+	re, err := f.pattern()
+	f.err = err
+	if re == nil {
+		return content
+	}
+
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		plain := ansi.Strip(line)
+		locs := re.FindAllStringIndex(plain, -1)
+		if len(locs) == 0 {
+			continue
+		}
+		var b strings.Builder
+		prev := 0
+		for _, loc := range locs {
+			if loc[0] == loc[1] {
+				continue // 空文字への一致は数えない
+			}
+			style := th.Match
+			if len(f.matches) == f.current {
+				style = th.CurrentMatch
+			}
+			f.matches = append(f.matches, findMatch{line: i, start: loc[0], end: loc[1]})
+			b.WriteString(plain[prev:loc[0]])
+			b.WriteString(style.Render(plain[loc[0]:loc[1]]))
+			prev = loc[1]
+		}
+		if prev == 0 {
+			continue
+		}
+		b.WriteString(plain[prev:])
+		lines[i] = b.String()
+	}
+	if f.current >= len(f.matches) {
+		f.current = max(len(f.matches)-1, 0)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Current は現在の一致を返す（一致がなければ false）
+func (f *finder) Current() (findMatch, bool) {
+	if f.current < 0 || f.current >= len(f.matches) {
+		return findMatch{}, false
+	}
+	return f.matches[f.current], true
+}
+
+// step は現在位置を delta だけ移動する（端では反対側に回り込む）
+func (f *finder) step(delta int) {
+	if len(f.matches) == 0 {
+		return
+	}
+	f.current = (f.current + delta + len(f.matches)) % len(f.matches)
+}
+
+// startAt は line 以降で最初の一致を現在位置にする（なければ先頭）
+// 検索を始めたときに、表示中の位置から近い一致へ移動するために使う
+func (f *finder) startAt(line int) {
+	f.current = 0
+	for i, m := range f.matches {
+		if m.line >= line {
+			f.current = i
+			return
+		}
+	}
+}
+
+// Counter はステータスバーに表示する一致数（例: "3/12"）を返す
+func (f *finder) Counter() string {
+	switch {
+	case f.err != nil:
+		return "bad regex"
+	case f.query.Value() == "":
+		return ""
+	case len(f.matches) == 0:
+		return "no matches"
+	}
+	return fmt.Sprintf("%d/%d", f.current+1, len(f.matches))
+}
+
+// findAction は検索中のキー操作の結果
+type findAction int
+
+const (
+	findIgnored findAction = iota // 検索では扱わないキー
+	findHandled                   // 処理済み（一致は変わらない）
+	findUpdated                   // クエリや切り替えが変わった（一致を探し直す）
+	findMoved                     // 現在の一致が移動した
+	findClose                     // 検索を閉じる
+)
+
+// HandleKey は検索中のキー入力を処理する
+// 既定の割り当て:
+// 入力中: 文字でクエリ編集、Enter で確定、↑↓ で前後の一致、Alt+R で正規表現、Alt+C で大文字小文字の区別
+// 確定後: n/N で次/前の一致、/ または Ctrl+F でクエリを再編集、Esc で閉じる
+func (f *finder) HandleKey(msg tea.KeyMsg, km *KeyMap) findAction {
+	switch {
+	case key.Matches(msg, km.Cancel):
+		return findClose
+	case key.Matches(msg, km.Down):
+		f.step(1)
+		return findMoved
+	case key.Matches(msg, km.Up):
+		f.step(-1)
+		return findMoved
+	case key.Matches(msg, km.ToggleRegex):
+		f.regex = !f.regex
+		return findUpdated
+	case key.Matches(msg, km.ToggleCase):
+		f.caseSensitive = !f.caseSensitive
+		return findUpdated
+	}
+
+	if !f.editing {
+		switch {
+		case key.Matches(msg, km.FindNext):
+			f.step(1)
+			return findMoved
+		case key.Matches(msg, km.FindPrev):
+			f.step(-1)
+			return findMoved
+		case key.Matches(msg, km.FindEdit):
+			f.editing = true
+			return findHandled
+		}
+		return findIgnored
+	}
+
+	switch {
+	case key.Matches(msg, km.Accept):
+		f.editing = false
+		// 一致がなければ閉じる
+		if len(f.matches) == 0 {
+			return findClose
+		}
+		return findHandled
+	case key.Matches(msg, km.Editor.Newline):
+		return findHandled // クエリは 1 行
+	}
+	before := f.query.Value()
+	if !f.query.HandleKey(msg) {
+		return findIgnored
+	}
+	if f.query.Value() != before {
+		return findUpdated
+	}
+	return findHandled
+}
+
+// View はクエリバーを描画する
+func (f *finder) View(width int, th Theme) string {
+	toggle := func(on bool, label string) string {
+		if on {
+			return th.Highlight.Render("[" + label + "]")
+		}
+		return th.Faint.Render("[" + label + "]")
+	}
+
+	var query string
+	if f.editing {
+		query = f.query.View(true, 1)
+	} else {
+		query = f.query.Value()
+	}
+	help := "Enter confirm  ↑↓ prev/next  Alt+R regex  Alt+C case  Esc close"
+	if !f.editing {
+		help = "n/N next/prev  / edit  Esc close"
+	}
+	line := fmt.Sprintf("find: %s  %s %s  %s", query, toggle(f.regex, ".*"), toggle(f.caseSensitive, "Aa"), th.Faint.Render(help))
+	return lipgloss.NewStyle().Width(width).Render(line)
+}
@@ -0,0 +1,103 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+)
+
+func Test_Finder_HighlightsAcrossANSI(t *testing.T) {
+	f := newFinder()
+	f.query.SetValue("error")
+	// 色付きの行でも、エスケープシーケンスを跨いだ一致を正しく扱う
+	content := "ok\n\x1b[31mERR\x1b[0mOR here\nno Error again error"
+	out := f.apply(content, DefaultTheme())
+
+	if len(f.matches) != 3 {
+		t.Fatalf("expected 3 matches, got %+v", f.matches)
+	}
+	if f.matches[0] != (findMatch{line: 1, start: 0, end: 5}) {
+		t.Fatalf("first match: %+v", f.matches[0])
+	}
+	if got := ansi.Strip(out); got != ansi.Strip(content) {
+		t.Fatalf("highlighting must not change the visible text:\n%q\n%q", got, ansi.Strip(content))
+	}
+	if f.Counter() != "1/3" {
+		t.Fatalf("counter: %q", f.Counter())
+	}
+
+	// 大文字小文字を区別すると一致が減る
+	f.caseSensitive = true
+	f.apply(content, DefaultTheme())
+	if f.Counter() != "1/1" {
+		t.Fatalf("case-sensitive counter: %q", f.Counter())
+	}
+}
+
+func Test_Finder_RegexToggle(t *testing.T) {
+	f := newFinder()
+	f.query.SetValue("q (help|chat)")
+	f.apply("q help\nq chat\nq (help|chat)", DefaultTheme())
+	if f.Counter() != "1/1" {
+		t.Fatalf("literal search: %q", f.Counter())
+	}
+	km := DefaultKeyMap()
+	f.HandleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'r'}, Alt: true}, &km)
+	f.apply("q help\nq chat\nq (help|chat)", DefaultTheme())
+	if f.Counter() != "1/2" {
+		t.Fatalf("regex search: %q", f.Counter())
+	}
+	f.query.SetValue("(")
+	f.apply("x", DefaultTheme())
+	if f.Counter() != "bad regex" {
+		t.Fatalf("invalid regex: %q", f.Counter())
+	}
+}
+
+func Test_Find_NavigatesAndScrolls(t *testing.T) {
+	m := New()
+	_, _ = m.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+	for i := 0; i < 100; i++ {
+		line := fmt.Sprintf("line %d", i)
+		if i == 10 || i == 90 {
+			line += " needle"
+		}
+		m.AddOutput(line)
+	}
+
+	_, _ = m.Update(keyPress(tea.KeyCtrlF))
+	if m.find == nil {
+		t.Fatal("Ctrl+F should open the find bar")
+	}
+	_, _ = m.Update(runes("needle"))
+	_, _ = m.Update(keyPress(tea.KeyEnter))
+	if m.find == nil || m.find.editing {
+		t.Fatal("Enter should confirm the query and keep highlights")
+	}
+	// 検索は表示中の位置（最下部）から始まるため、最初は下側の一致が選ばれる
+	if !strings.Contains(m.renderStatusBar(), "🔍 2/2") {
+		t.Fatalf("status bar should show the match counter: %q", m.renderStatusBar())
+	}
+
+	// n/N で一致間を移動し（端では回り込む）、一致が見える位置までスクロールする
+	visible := func() string { return ansi.Strip(m.viewport.View()) }
+	_, _ = m.Update(runes("n"))
+	if m.find.current != 0 || !strings.Contains(visible(), "line 10 needle") {
+		t.Fatalf("n should wrap to the first match")
+	}
+	_, _ = m.Update(runes("N"))
+	if m.find.current != 1 || !strings.Contains(visible(), "line 90 needle") {
+		t.Fatalf("N should jump back to the last match")
+	}
+	if m.input.Value() != "" {
+		t.Fatalf("n/N must not reach the input, got %q", m.input.Value())
+	}
+
+	_, _ = m.Update(keyPress(tea.KeyEsc))
+	if m.find != nil {
+		t.Fatal("Esc should close the find bar")
+	}
+}
@@ -0,0 +1,83 @@
+package ui
+
+import (
+	"sort"
+	"unicode"
+)
+
+// fuzzyResult はファジー検索の 1 件分の結果
+type fuzzyResult struct {
+	Text      string
+	Score     int
+	Positions []int // 一致した文字の rune インデックス（ハイライト用）
+}
+
+// スコア計算の重み
+const (
+	fuzzyMatchScore       = 1
+	fuzzyConsecutiveBonus = 5
+	fuzzyWordStartBonus   = 3
+	fuzzyLeadingBonus     = 5
+	fuzzyGapPenalty       = 1
+)
+
+// fuzzyMatch は query の各文字が candidate に順番通り現れるかを大文字小文字を無視して判定する
+// 連続一致・単語先頭・先頭一致を高く評価し、一致位置の間隔が空くほど減点する
+func fuzzyMatch(query, candidate string) (fuzzyResult, bool) {
+	q := []rune(query)
+	c := []rune(candidate)
+	res := fuzzyResult{Text: candidate}
+	if len(q) == 0 {
+		return res, true
+	}
+
+	qi := 0
+	prev := -1
+	for ci := 0; ci < len(c) && qi < len(q); ci++ {
+		if unicode.ToLower(c[ci]) != unicode.ToLower(q[qi]) {
+			continue
+		}
+		res.Score += fuzzyMatchScore
+		switch {
+		case ci == 0:
+			res.Score += fuzzyLeadingBonus
+		case !isWordRune(c[ci-1]):
+			res.Score += fuzzyWordStartBonus
+		}
+		if prev >= 0 {
+			if ci == prev+1 {
+				res.Score += fuzzyConsecutiveBonus
+			} else {
+				res.Score -= (ci - prev - 1) * fuzzyGapPenalty
+			}
+		}
+		res.Positions = append(res.Positions, ci)
+		prev = ci
+		qi++
+	}
+	if qi < len(q) {
+		return fuzzyResult{}, false
+	}
+	return res, true
+}
+
+// fuzzyRank は items（古い順）を query で絞り込み、スコアの高い順に並べる
+// 同点の場合は新しい項目を優先し、同じ文字列は最新の 1 件にまとめる
+func fuzzyRank(query string, items []string) []fuzzyResult {
+	seen := map[string]bool{}
+	var results []fuzzyResult
+	for i := len(items) - 1; i >= 0; i-- {
+		if seen[items[i]] {
+			continue
+		}
+		seen[items[i]] = true
+		if r, ok := fuzzyMatch(query, items[i]); ok {
+			results = append(results, r)
+		}
+	}
+	// 新しい順に並んでいるので、安定ソートで同点時の新しさを保つ
+	sort.SliceStable(results, func(a, b int) bool {
+		return results[a].Score > results[b].Score
+	})
+	return results
+}
@@ -0,0 +1,91 @@
+package ui
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func Test_FuzzyMatch(t *testing.T) {
+	r, ok := fuzzyMatch("qch", "q chat")
+	if !ok {
+		t.Fatal("qch should match q chat")
+	}
+	if !reflect.DeepEqual(r.Positions, []int{0, 2, 3}) {
+		t.Fatalf("positions: got %v", r.Positions)
+	}
+	if _, ok := fuzzyMatch("xyz", "q chat"); ok {
+		t.Fatal("xyz should not match q chat")
+	}
+	// 大文字小文字は区別しない
+	if _, ok := fuzzyMatch("HELP", "q help"); !ok {
+		t.Fatal("match should be case-insensitive")
+	}
+}
+
+func Test_FuzzyRank_PrefersTighterAndNewerMatches(t *testing.T) {
+	items := []string{"git status", "q translate", "list things", "q help", "git status"}
+	got := fuzzyRank("st", items)
+	var texts []string
+	for _, r := range got {
+		texts = append(texts, r.Text)
+	}
+	// 連続一致する "st" を含む項目が上位、重複は 1 件にまとまる
+	want := []string{"git status", "list things", "q translate"}
+	if !reflect.DeepEqual(texts, want) {
+		t.Fatalf("got %q, want %q", texts, want)
+	}
+
+	// 空クエリは新しい順にすべて返す
+	all := fuzzyRank("", items)
+	if len(all) != 4 || all[0].Text != "git status" || all[1].Text != "q help" {
+		t.Fatalf("empty query: got %+v", all)
+	}
+}
+
+func Test_HistorySearch_InsertAndRun(t *testing.T) {
+	m := New()
+	for _, s := range []string{"q chat", "git status", "q help"} {
+		m.history.Add(s)
+	}
+
+	// Ctrl+S で開き、入力に合わせて候補が絞り込まれる
+	_, _ = m.Update(keyPress(tea.KeyCtrlS))
+	if m.search == nil {
+		t.Fatal("Ctrl+S should open history search")
+	}
+	_, _ = m.Update(runes("qh"))
+	if got, _ := m.search.Selected(); got != "q help" {
+		t.Fatalf("selected: got %q", got)
+	}
+	if !strings.Contains(m.View(), "history search") {
+		t.Fatal("overlay should be rendered")
+	}
+
+	// Tab は入力欄に挿入して閉じる（実行はしない）
+	_, cmd := m.Update(keyPress(tea.KeyTab))
+	if m.search != nil || cmd != nil || m.input.Value() != "q help" {
+		t.Fatalf("Tab: search=%v cmd=%v input=%q", m.search, cmd, m.input.Value())
+	}
+
+	// ↑ で次の候補を選び、Enter で実行する
+	m.input.Reset()
+	_, _ = m.Update(keyPress(tea.KeyCtrlR))
+	_, _ = m.Update(keyPress(tea.KeyUp))
+	_, cmd = m.Update(keyPress(tea.KeyEnter))
+	if m.search != nil || cmd == nil {
+		t.Fatal("Enter should close the overlay and submit")
+	}
+	if v, ok := cmd().(MsgSubmit); !ok || v.Value != "git status" {
+		t.Fatalf("submitted: %#v", v)
+	}
+
+	// Esc は何もせずに閉じる
+	_, _ = m.Update(keyPress(tea.KeyCtrlS))
+	_, _ = m.Update(keyPress(tea.KeyEsc))
+	if m.search != nil || m.input.Value() != "" {
+		t.Fatal("Esc should cancel without touching the input")
+	}
+}
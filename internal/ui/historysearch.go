@@ -0,0 +1,148 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// historySearchMaxResults はオーバーレイに表示する候補の最大件数
+const historySearchMaxResults = 8
+
+// historySearch は Ctrl+S / Ctrl+R で開く履歴のインクリメンタル検索オーバーレイ
+// クエリを入力するたびに履歴をファジー検索し、一致した文字をハイライトして表示する
+type historySearch struct {
+	query    Editor
+	items    []string // 検索対象の履歴（古い順）
+	results  []fuzzyResult
+	selected int // results 上の選択位置
+}
+
+// newHistorySearch は items を対象にした検索状態を返す
+func newHistorySearch(items []string) *historySearch {
+	s := &historySearch{query: NewEditor(), items: items}
+	s.refresh()
+	return s
+}
+
+// refresh はクエリに合わせて候補を絞り込み、選択を先頭に戻す
+func (s *historySearch) refresh() {
+	s.results = fuzzyRank(s.query.Value(), s.items)
+	s.selected = 0
+}
+
+// Selected は選択中の候補を返す（候補がなければ false）
+func (s *historySearch) Selected() (string, bool) {
+	if s.selected < 0 || s.selected >= len(s.results) {
+		return "", false
+	}
+	return s.results[s.selected].Text, true
+}
+
+// move は選択位置を delta だけ移動する（端で止まる）
+func (s *historySearch) move(delta int) {
+	s.selected += delta
+	if s.selected >= len(s.results) {
+		s.selected = len(s.results) - 1
+	}
+	if s.selected < 0 {
+		s.selected = 0
+	}
+}
+
+// historySearchAction は検索オーバーレイでのキー操作の結果
+type historySearchAction int
+
+const (
+	searchContinue historySearchAction = iota // 検索を継続
+	searchCancel                              // 何もせずに閉じる
+	searchInsert                              // 選択中の候補を入力欄に挿入
+	searchRun                                 // 選択中の候補を実行
+)
+
+// HandleKey は検索中のキー入力を処理し、呼び出し元が取るべき操作を返す
+// 既定では ↑/↓ (Ctrl+P/N) で選択、Ctrl+S/Ctrl+R で次の候補、Tab で挿入、Enter で実行、Esc で中止
+func (s *historySearch) HandleKey(msg tea.KeyMsg, km *KeyMap) historySearchAction {
+	switch {
+	case key.Matches(msg, km.Cancel):
+		return searchCancel
+	case key.Matches(msg, km.Accept):
+		return searchRun
+	case key.Matches(msg, km.Insert):
+		return searchInsert
+	case key.Matches(msg, km.Up, km.HistorySearch):
+		s.move(1)
+	case key.Matches(msg, km.Down):
+		s.move(-1)
+	case key.Matches(msg, km.Editor.Newline):
+		// クエリは 1 行なので改行は受け付けない
+	default:
+		before := s.query.Value()
+		s.query.HandleKey(msg)
+		if s.query.Value() != before {
+			s.refresh()
+		}
+	}
+	return searchContinue
+}
+
+// View は検索オーバーレイを描画する
+// 候補は一致度の高いものが入力欄に近い下側に並び、一致した文字をハイライトする
+func (s *historySearch) View(width int, th Theme) string {
+
+	n := min(len(s.results), historySearchMaxResults)
+	// 選択位置が表示範囲外に出ないように先頭をずらす
+	first := 0
+	if s.selected >= n {
+		first = s.selected - n + 1
+	}
+
+	var rows []string
+	for i := first + n - 1; i >= first; i-- {
+		r := s.results[i]
+		// 複数行の履歴は 1 行にまとめて表示する
+		text := highlightPositions(strings.ReplaceAll(r.Text, "\n", "↵"), r.Positions, th.Highlight)
+		if i == s.selected {
+			rows = append(rows, th.Selected.Render("▶")+" "+text)
+		} else {
+			rows = append(rows, "  "+text)
+		}
+	}
+	if len(rows) == 0 {
+		rows = append(rows, th.Faint.Render("  (no matches)"))
+	}
+
+	header := th.Faint.Render(fmt.Sprintf("history search  %d/%d  ↑↓ select  Tab insert  Enter run  Esc cancel",
+		len(s.results), len(s.items)))
+	query := "search: " + s.query.View(true, 1)
+
+	box := th.Box.
+		Border(lipgloss.RoundedBorder()).
+		Padding(0, 1).
+		Width(width - 2)
+	return box.Render(strings.Join(append(append([]string{header}, rows...), query), "\n"))
+}
+
+// highlightPositions は text の positions（rune インデックス）にある文字を style で描画する
+// 改行を "↵" に置き換えても rune 数は変わらないため、位置はそのまま使える
+func highlightPositions(text string, positions []int, style lipgloss.Style) string {
+	if len(positions) == 0 {
+		return text
+	}
+	marked := make(map[int]bool, len(positions))
+	for _, p := range positions {
+		marked[p] = true
+	}
+	var b strings.Builder
+	for i, r := range []rune(text) {
+		if marked[i] {
+			b.WriteString(style.Render(string(r)))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,539 @@
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/viewport"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// EditorKeyMap は行エディタの編集操作のキー割り当て
+type EditorKeyMap struct {
+	CharLeft          key.Binding
+	CharRight         key.Binding
+	WordLeft          key.Binding
+	WordRight         key.Binding
+	LineStart         key.Binding
+	LineEnd           key.Binding
+	Newline           key.Binding
+	DeleteBack        key.Binding
+	DeleteForward     key.Binding
+	DeleteWordBack    key.Binding
+	DeleteWordForward key.Binding
+	KillToStart       key.Binding
+	KillToEnd         key.Binding
+	Yank              key.Binding
+}
+
+// KeyMap は UI 全体のキー割り当て
+// 割り当ては文脈ごとにまとめる: 入力欄（Input・Editor）、スクロールバック、モーダル（検索・ヘルプ）
+type KeyMap struct {
+	// 入力欄
+	Quit          key.Binding
+	Submit        key.Binding
+	HistoryPrev   key.Binding
+	HistoryNext   key.Binding
+	HistorySearch key.Binding
+	Find          key.Binding
+	Help          key.Binding
+	ScrollMode    key.Binding
+	PrevTurn      key.Binding
+	NextTurn      key.Binding
+	Editor        EditorKeyMap
+
+	// スクロールバック（入力中も有効）
+	PageUp       key.Binding
+	PageDown     key.Binding
+	HalfPageUp   key.Binding
+	HalfPageDown key.Binding
+	LineUp       key.Binding
+	LineDown     key.Binding
+	Top          key.Binding
+	Bottom       key.Binding
+
+	// スクロールバックモード（vi 風のカーソル移動・選択・ヤンク）
+	CursorDown     key.Binding
+	CursorUp       key.Binding
+	CursorHalfDown key.Binding
+	CursorHalfUp   key.Binding
+	CursorTop      key.Binding // 2 回続けて押す（gg）
+	CursorBottom   key.Binding
+	Visual         key.Binding
+	Yank           key.Binding
+	SearchForward  key.Binding
+	CursorPrevTurn key.Binding
+	CursorNextTurn key.Binding
+	ToggleTurn     key.Binding
+	ExitScrollMode key.Binding
+
+	// モーダル（履歴検索・スクロールバック内検索・ヘルプ）
+	Accept      key.Binding
+	Insert      key.Binding
+	Cancel      key.Binding
+	Up          key.Binding
+	Down        key.Binding
+	FindNext    key.Binding
+	FindPrev    key.Binding
+	FindEdit    key.Binding
+	ToggleRegex key.Binding
+	ToggleCase  key.Binding
+}
+
+// binding はキー割り当てを作る（ヘルプ表示用のキー表記はキーから生成する）
+func binding(desc string, keys ...string) key.Binding {
+	b := key.NewBinding(key.WithKeys(keys...))
+	b.SetHelp(keyLabels(keys), desc)
+	return b
+}
+
+// DefaultEditorKeyMap は readline 風の既定の編集キーを返す
+func DefaultEditorKeyMap() EditorKeyMap {
+	return EditorKeyMap{
+		CharLeft:          binding("char left", "left", "ctrl+b"),
+		CharRight:         binding("char right", "right"),
+		WordLeft:          binding("word left", "alt+left", "ctrl+left", "alt+b"),
+		WordRight:         binding("word right", "alt+right", "ctrl+right", "alt+f"),
+		LineStart:         binding("line start", "home", "ctrl+a"),
+		LineEnd:           binding("line end", "end", "ctrl+e"),
+		Newline:           binding("newline", "ctrl+j", "alt+enter"),
+		DeleteBack:        binding("delete back", "backspace", "ctrl+h"),
+		DeleteForward:     binding("delete forward", "delete", "ctrl+d"),
+		DeleteWordBack:    binding("kill word back", "ctrl+w", "alt+backspace"),
+		DeleteWordForward: binding("kill word forward", "alt+d"),
+		KillToStart:       binding("kill to line start", "ctrl+u"),
+		KillToEnd:         binding("kill to line end", "ctrl+k"),
+		Yank:              binding("yank", "ctrl+y"),
+	}
+}
+
+// DefaultKeyMap は既定のキー割り当てを返す
+func DefaultKeyMap() KeyMap {
+	return KeyMap{
+		Quit:          binding("exit", "ctrl+c"),
+		Submit:        binding("send", "enter"),
+		HistoryPrev:   binding("previous history", "up"),
+		HistoryNext:   binding("next history", "down"),
+		HistorySearch: binding("search history", "ctrl+s", "ctrl+r"),
+		Find:          binding("find in scrollback", "ctrl+f"),
+		Help:          binding("key bindings", "f1", "?"),
+		ScrollMode:    binding("scrollback mode", "esc"),
+		PrevTurn:      binding("previous turn", "alt+up"),
+		NextTurn:      binding("next turn", "alt+down"),
+		Editor:        DefaultEditorKeyMap(),
+
+		PageUp:       binding("page up", "pgup"),
+		PageDown:     binding("page down", "pgdown"),
+		HalfPageUp:   binding("half page up", "ctrl+pgup"),
+		HalfPageDown: binding("half page down", "ctrl+pgdown"),
+		LineUp:       binding("line up", "shift+up"),
+		LineDown:     binding("line down", "shift+down"),
+		Top:          binding("top", "ctrl+home"),
+		Bottom:       binding("bottom", "ctrl+end"),
+
+		CursorDown:     binding("cursor down", "j", "down"),
+		CursorUp:       binding("cursor up", "k", "up"),
+		CursorHalfDown: binding("half page down", "ctrl+d"),
+		CursorHalfUp:   binding("half page up", "ctrl+u"),
+		CursorTop:      binding("top (press twice)", "g"),
+		CursorBottom:   binding("bottom", "G"),
+		Visual:         binding("visual line selection", "V", "v"),
+		Yank:           binding("yank to clipboard", "y"),
+		SearchForward:  binding("find in scrollback", "/"),
+		CursorPrevTurn: binding("previous turn", "{"),
+		CursorNextTurn: binding("next turn", "}"),
+		ToggleTurn:     binding("collapse / expand turn", "tab", "o"),
+		ExitScrollMode: binding("back to input", "esc", "i"),
+
+		Accept:      binding("run / confirm", "enter"),
+		Insert:      binding("insert into input", "tab"),
+		Cancel:      binding("close", "esc", "ctrl+g"),
+		Up:          binding("select up", "up", "ctrl+p"),
+		Down:        binding("select down", "down", "ctrl+n"),
+		FindNext:    binding("next match", "n"),
+		FindPrev:    binding("previous match", "N"),
+		FindEdit:    binding("edit query", "/", "ctrl+f"),
+		ToggleRegex: binding("toggle regex", "alt+r"),
+		ToggleCase:  binding("toggle case", "alt+c"),
+	}
+}
+
+// keyContext はキー割り当ての文脈（ヘルプの見出しと衝突検出の単位）
+type keyContext string
+
+const (
+	contextInput      keyContext = "input"
+	contextScrollback keyContext = "scrollback"
+	contextNormal     keyContext = "normal"
+	contextModal      keyContext = "modal"
+)
+
+// namedBinding は設定で指定する名前（例: "input.submit"）付きのキー割り当て
+type namedBinding struct {
+	context keyContext
+	name    string
+	binding *key.Binding
+}
+
+// bindings はすべてのキー割り当てを名前付きで返す（ヘルプの表示順）
+func (k *KeyMap) bindings() []namedBinding {
+	e := &k.Editor
+	return []namedBinding{
+		{contextInput, "input.submit", &k.Submit},
+		{contextInput, "input.historyPrev", &k.HistoryPrev},
+		{contextInput, "input.historyNext", &k.HistoryNext},
+		{contextInput, "input.historySearch", &k.HistorySearch},
+		{contextInput, "input.find", &k.Find},
+		{contextInput, "input.help", &k.Help},
+		{contextInput, "input.scrollMode", &k.ScrollMode},
+		{contextInput, "input.prevTurn", &k.PrevTurn},
+		{contextInput, "input.nextTurn", &k.NextTurn},
+		{contextInput, "input.quit", &k.Quit},
+		{contextInput, "editor.newline", &e.Newline},
+		{contextInput, "editor.charLeft", &e.CharLeft},
+		{contextInput, "editor.charRight", &e.CharRight},
+		{contextInput, "editor.wordLeft", &e.WordLeft},
+		{contextInput, "editor.wordRight", &e.WordRight},
+		{contextInput, "editor.lineStart", &e.LineStart},
+		{contextInput, "editor.lineEnd", &e.LineEnd},
+		{contextInput, "editor.deleteBack", &e.DeleteBack},
+		{contextInput, "editor.deleteForward", &e.DeleteForward},
+		{contextInput, "editor.deleteWordBack", &e.DeleteWordBack},
+		{contextInput, "editor.deleteWordForward", &e.DeleteWordForward},
+		{contextInput, "editor.killToStart", &e.KillToStart},
+		{contextInput, "editor.killToEnd", &e.KillToEnd},
+		{contextInput, "editor.yank", &e.Yank},
+		{contextScrollback, "scrollback.pageUp", &k.PageUp},
+		{contextScrollback, "scrollback.pageDown", &k.PageDown},
+		{contextScrollback, "scrollback.halfPageUp", &k.HalfPageUp},
+		{contextScrollback, "scrollback.halfPageDown", &k.HalfPageDown},
+		{contextScrollback, "scrollback.lineUp", &k.LineUp},
+		{contextScrollback, "scrollback.lineDown", &k.LineDown},
+		{contextScrollback, "scrollback.top", &k.Top},
+		{contextScrollback, "scrollback.bottom", &k.Bottom},
+		{contextNormal, "normal.down", &k.CursorDown},
+		{contextNormal, "normal.up", &k.CursorUp},
+		{contextNormal, "normal.halfPageDown", &k.CursorHalfDown},
+		{contextNormal, "normal.halfPageUp", &k.CursorHalfUp},
+		{contextNormal, "normal.top", &k.CursorTop},
+		{contextNormal, "normal.bottom", &k.CursorBottom},
+		{contextNormal, "normal.visual", &k.Visual},
+		{contextNormal, "normal.yank", &k.Yank},
+		{contextNormal, "normal.find", &k.SearchForward},
+		{contextNormal, "normal.prevTurn", &k.CursorPrevTurn},
+		{contextNormal, "normal.nextTurn", &k.CursorNextTurn},
+		{contextNormal, "normal.toggleTurn", &k.ToggleTurn},
+		{contextNormal, "normal.exit", &k.ExitScrollMode},
+		{contextModal, "modal.accept", &k.Accept},
+		{contextModal, "modal.insert", &k.Insert},
+		{contextModal, "modal.cancel", &k.Cancel},
+		{contextModal, "modal.up", &k.Up},
+		{contextModal, "modal.down", &k.Down},
+		{contextModal, "modal.findNext", &k.FindNext},
+		{contextModal, "modal.findPrev", &k.FindPrev},
+		{contextModal, "modal.findEdit", &k.FindEdit},
+		{contextModal, "modal.toggleRegex", &k.ToggleRegex},
+		{contextModal, "modal.toggleCase", &k.ToggleCase},
+	}
+}
+
+// KeyBindingNames は設定で指定できるキー割り当ての名前（例: "input.submit"）の一覧を返す
+func KeyBindingNames() []string {
+	k := DefaultKeyMap()
+	var names []string
+	for _, nb := range k.bindings() {
+		names = append(names, nb.name)
+	}
+	return names
+}
+
+// Apply は設定のキー割り当て（名前 → キーの一覧）で既定値を上書きする
+// キーの一覧が空の場合はその操作を無効にする。未知の名前はエラー
+func (k *KeyMap) Apply(overrides map[string][]string) error {
+	byName := map[string]*key.Binding{}
+	for _, nb := range k.bindings() {
+		byName[nb.name] = nb.binding
+	}
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var unknown []string
+	for _, name := range names {
+		b, ok := byName[name]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		keys := overrides[name]
+		desc := b.Help().Desc
+		if len(keys) == 0 {
+			b.Unbind()
+			b.SetHelp("", desc)
+			continue
+		}
+		b.SetKeys(keys...)
+		b.SetHelp(keyLabels(keys), desc)
+		b.SetEnabled(true)
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown key binding(s): %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// KeyConflict は同じ文脈で 1 つのキーが複数の操作に割り当てられていることを表す
+type KeyConflict struct {
+	Key     string
+	Actions []string
+}
+
+func (c KeyConflict) String() string {
+	return fmt.Sprintf("%q is bound to %s", c.Key, strings.Join(c.Actions, " and "))
+}
+
+// Conflicts は衝突しているキー割り当てを返す
+// 入力欄とスクロールバックモードではスクロールバックのキーも同時に有効なため、まとめて検査する。
+// モーダルでは終了キーとクエリ編集用のエディタキーも有効
+func (k *KeyMap) Conflicts() []KeyConflict {
+	all := k.bindings()
+	scopes := [][]keyContext{
+		{contextInput, contextScrollback},
+		{contextNormal, contextScrollback},
+		{contextModal},
+	}
+	var conflicts []KeyConflict
+	seen := map[string]bool{}
+	for _, scope := range scopes {
+		owners := map[string][]string{}
+		for _, nb := range all {
+			inScope := false
+			for _, c := range scope {
+				inScope = inScope || nb.context == c
+			}
+			// モーダルでもエディタ・終了・履歴検索のキーは有効
+			if scope[0] == contextModal && (strings.HasPrefix(nb.name, "editor.") ||
+				nb.name == "input.quit" || nb.name == "input.historySearch") {
+				inScope = true
+			}
+			// 終了キーはどの文脈でも有効
+			if nb.name == "input.quit" {
+				inScope = true
+			}
+			if !inScope || !nb.binding.Enabled() {
+				continue
+			}
+			for _, key := range nb.binding.Keys() {
+				owners[key] = append(owners[key], nb.name)
+			}
+		}
+		keys := make([]string, 0, len(owners))
+		for key := range owners {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			names := owners[key]
+			id := key + "\x00" + strings.Join(names, ",")
+			if len(names) < 2 || seen[id] {
+				continue
+			}
+			seen[id] = true
+			conflicts = append(conflicts, KeyConflict{Key: key, Actions: names})
+		}
+	}
+	return conflicts
+}
+
+// Viewport はスクロールバックの割り当てを viewport のキーマップに変換する
+// 横スクロールは使わないため無効にする
+func (k *KeyMap) Viewport() viewport.KeyMap {
+	return viewport.KeyMap{
+		PageUp:       k.PageUp,
+		PageDown:     k.PageDown,
+		HalfPageUp:   k.HalfPageUp,
+		HalfPageDown: k.HalfPageDown,
+		Up:           k.LineUp,
+		Down:         k.LineDown,
+		Left:         key.NewBinding(key.WithDisabled()),
+		Right:        key.NewBinding(key.WithDisabled()),
+	}
+}
+
+// keyLabels はキーの一覧をヘルプ表示用の短い表記（例: "^S/^R"）にする
+func keyLabels(keys []string) string {
+	labels := make([]string, 0, len(keys))
+	for _, k := range keys {
+		labels = append(labels, keyLabel(k))
+	}
+	return strings.Join(labels, "/")
+}
+
+// keyLabel はキー名をヘルプ表示用の表記にする（ctrl+s → ^S, alt+b → M-b, up → ↑）
+func keyLabel(k string) string {
+	switch k {
+	case "up":
+		return "↑"
+	case "down":
+		return "↓"
+	case "left":
+		return "←"
+	case "right":
+		return "→"
+	case "enter":
+		return "Enter"
+	case "esc":
+		return "Esc"
+	case "tab":
+		return "Tab"
+	case " ":
+		return "Space"
+	case "pgup":
+		return "PgUp"
+	case "pgdown":
+		return "PgDn"
+	case "home":
+		return "Home"
+	case "end":
+		return "End"
+	case "delete":
+		return "Del"
+	case "backspace":
+		return "BS"
+	}
+	if rest, ok := strings.CutPrefix(k, "f"); ok && rest != "" && strings.Trim(rest, "0123456789") == "" {
+		return "F" + rest
+	}
+	if rest, ok := strings.CutPrefix(k, "ctrl+"); ok && len(rest) == 1 {
+		return "^" + strings.ToUpper(rest)
+	}
+	if rest, ok := strings.CutPrefix(k, "alt+"); ok {
+		return "M-" + keyLabel(rest)
+	}
+	return k
+}
+
+// helpSections はヘルプに表示する見出しと割り当ての一覧
+// 入力欄の割り当ては、送信や検索などの操作と編集操作に分けて表示する
+func (k *KeyMap) helpSections() []struct {
+	title    string
+	bindings []namedBinding
+} {
+	sections := []struct {
+		title    string
+		bindings []namedBinding
+	}{
+		{title: "Input"},
+		{title: "Editing"},
+		{title: "Scrollback"},
+		{title: "Scrollback mode (Esc)"},
+		{title: "Modals (search, find, help)"},
+	}
+	for _, nb := range k.bindings() {
+		i := 0
+		switch {
+		case strings.HasPrefix(nb.name, "editor."):
+			i = 1
+		case nb.context == contextScrollback:
+			i = 2
+		case nb.context == contextNormal:
+			i = 3
+		case nb.context == contextModal:
+			i = 4
+		}
+		sections[i].bindings = append(sections[i].bindings, nb)
+	}
+	return sections
+}
+
+// ShortHelp はステータスバーに表示する主要な割り当ての要約を返す
+func (k *KeyMap) ShortHelp() string {
+	first := func(b key.Binding) string {
+		if !b.Enabled() || len(b.Keys()) == 0 {
+			return ""
+		}
+		return keyLabel(b.Keys()[0])
+	}
+	pair := func(a, b key.Binding, sep string) string {
+		la, lb := first(a), first(b)
+		if la == "" || lb == "" {
+			return la + lb
+		}
+		return la + sep + lb
+	}
+	items := []struct{ label, desc string }{
+		{first(k.Quit), "Exit"},
+		{pair(k.HistoryPrev, k.HistoryNext, ""), "History"},
+		{first(k.HistorySearch), "Search"},
+		{first(k.Find), "Find"},
+		{first(k.Editor.Newline), "Newline"},
+		{first(k.Help), "Keys"},
+		{pair(k.PageUp, k.PageDown, "/"), "Scroll"},
+	}
+	var parts []string
+	for _, it := range items {
+		if it.label != "" {
+			parts = append(parts, it.label+" "+it.desc)
+		}
+	}
+	return strings.Join(append(parts, "Mouse Wheel"), "  ")
+}
+
+// CompactHelp は狭い端末向けに、終了とキー一覧の表示だけを返す
+func (k *KeyMap) CompactHelp() string {
+	var parts []string
+	for _, it := range []struct {
+		b    key.Binding
+		desc string
+	}{{k.Quit, "Exit"}, {k.Help, "Keys"}} {
+		if it.b.Enabled() && len(it.b.Keys()) > 0 {
+			parts = append(parts, keyLabel(it.b.Keys()[0])+" "+it.desc)
+		}
+	}
+	return strings.Join(parts, "  ")
+}
+
+// helpView はキー割り当ての一覧を文脈ごとの列に並べて描画する
+// 端末の幅に収まらない列は次の段に折り返す
+func helpView(k *KeyMap, width int, th Theme) string {
+	var columns []string
+	for _, sec := range k.helpSections() {
+		rows := []string{th.Highlight.Render(sec.title)}
+		for _, nb := range sec.bindings {
+			label := nb.binding.Help().Key
+			if !nb.binding.Enabled() || label == "" {
+				label = "(unbound)"
+			}
+			rows = append(rows, fmt.Sprintf("%-22s %s", label, th.Faint.Render(nb.binding.Help().Desc)))
+		}
+		columns = append(columns, lipgloss.NewStyle().PaddingRight(3).Render(strings.Join(rows, "\n")))
+	}
+
+	inner := width - 4
+	var lines []string
+	var row []string
+	rowWidth := 0
+	for _, col := range columns {
+		w := lipgloss.Width(col)
+		if len(row) > 0 && rowWidth+w > inner {
+			lines = append(lines, lipgloss.JoinHorizontal(lipgloss.Top, row...))
+			row, rowWidth = nil, 0
+		}
+		row = append(row, col)
+		rowWidth += w
+	}
+	if len(row) > 0 {
+		lines = append(lines, lipgloss.JoinHorizontal(lipgloss.Top, row...))
+	}
+
+	footer := th.Faint.Render(fmt.Sprintf("%s or %s to close", keyLabels(k.Help.Keys()), keyLabels(k.Cancel.Keys())))
+	return th.Box.
+		Border(lipgloss.RoundedBorder()).
+		Padding(0, 1).
+		Width(width - 2).
+		Render(strings.Join(append(lines, footer), "\n\n"))
+}
@@ -0,0 +1,123 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+)
+
+func Test_DefaultKeyMap_HasNoConflicts(t *testing.T) {
+	km := DefaultKeyMap()
+	if c := km.Conflicts(); len(c) != 0 {
+		t.Fatalf("default bindings conflict: %v", c)
+	}
+}
+
+func Test_KeyMap_ApplyOverrides(t *testing.T) {
+	km := DefaultKeyMap()
+	err := km.Apply(map[string][]string{
+		"input.historySearch": {"ctrl+t"},
+		"editor.yank":         {},
+		"input.nope":          {"x"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "input.nope") {
+		t.Fatalf("unknown names should be reported: %v", err)
+	}
+	if got := km.HistorySearch.Keys(); len(got) != 1 || got[0] != "ctrl+t" {
+		t.Fatalf("override not applied: %v", got)
+	}
+	if km.HistorySearch.Help().Key != "^T" {
+		t.Fatalf("help label should follow the override: %q", km.HistorySearch.Help().Key)
+	}
+	if km.Editor.Yank.Enabled() {
+		t.Fatal("an empty key list should unbind the action")
+	}
+	if !strings.Contains(km.ShortHelp(), "^T Search") {
+		t.Fatalf("short help: %q", km.ShortHelp())
+	}
+}
+
+func Test_KeyMap_DetectsConflicts(t *testing.T) {
+	km := DefaultKeyMap()
+	_ = km.Apply(map[string][]string{"input.find": {"ctrl+a"}})
+	conflicts := km.Conflicts()
+	if len(conflicts) != 1 || conflicts[0].Key != "ctrl+a" {
+		t.Fatalf("got %v", conflicts)
+	}
+	if s := conflicts[0].String(); !strings.Contains(s, "editor.lineStart") || !strings.Contains(s, "input.find") {
+		t.Fatalf("conflict should name both actions: %s", s)
+	}
+
+	// 衝突は起動時にヘッダーへ表示される
+	m := New()
+	m.SetKeyMap(km)
+	if !strings.Contains(m.renderHeader(), "key binding conflict") {
+		t.Fatalf("header should report the conflict: %q", m.renderHeader())
+	}
+}
+
+func Test_KeyMap_RemapsEditorAndInput(t *testing.T) {
+	km := DefaultKeyMap()
+	_ = km.Apply(map[string][]string{
+		"editor.lineStart":    {"ctrl+g"},
+		"input.historySearch": {"ctrl+t"},
+	})
+	m := New()
+	m.SetKeyMap(km)
+
+	m.input.SetValue("world")
+	_, _ = m.Update(keyPress(tea.KeyCtrlG))
+	_, _ = m.Update(runes("hello "))
+	if m.input.Value() != "hello world" {
+		t.Fatalf("remapped line start: %q", m.input.Value())
+	}
+	// 元のキーは無効になる
+	_, _ = m.Update(keyPress(tea.KeyCtrlA))
+	if m.input.Cursor() != len("hello ") {
+		t.Fatalf("ctrl+a should no longer move the cursor, cursor=%d", m.input.Cursor())
+	}
+
+	_, _ = m.Update(keyPress(tea.KeyCtrlS))
+	if m.search != nil {
+		t.Fatal("ctrl+s should no longer open history search")
+	}
+	_, _ = m.Update(keyPress(tea.KeyCtrlT))
+	if m.search == nil {
+		t.Fatal("ctrl+t should open history search")
+	}
+}
+
+func Test_HelpOverlay(t *testing.T) {
+	m := New()
+	_, _ = m.Update(tea.WindowSizeMsg{Width: 160, Height: 40})
+
+	// 入力中の "?" は文字として入力される
+	m.input.SetValue("why")
+	_, _ = m.Update(runes("?"))
+	if m.showHelp || m.input.Value() != "why?" {
+		t.Fatalf("? should be typed while the input is not empty (help=%v input=%q)", m.showHelp, m.input.Value())
+	}
+
+	m.input.Reset()
+	_, _ = m.Update(runes("?"))
+	if !m.showHelp {
+		t.Fatal("? on an empty input should open the help overlay")
+	}
+	view := ansi.Strip(m.View())
+	for _, want := range []string{"Input", "Editing", "Scrollback", "Modals", "search history", "^S/^R", "kill word back"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("help overlay should contain %q", want)
+		}
+	}
+
+	_, _ = m.Update(keyPress(tea.KeyEsc))
+	if m.showHelp {
+		t.Fatal("Esc should close the help overlay")
+	}
+	_, _ = m.Update(keyPress(tea.KeyF1))
+	if !m.showHelp {
+		t.Fatal("F1 should open the help overlay")
+	}
+}
@@ -8,7 +8,13 @@ import (
 
     tea "github.com/charmbracelet/bubbletea"
     "github.com/charmbracelet/lipgloss"
+    "github.com/charmbracelet/bubbles/key"
     "github.com/charmbracelet/bubbles/viewport"
+    "qube/internal/execq"
+    "qube/internal/executor"
+    "qube/internal/history"
+    "qube/internal/policy"
+    "qube/internal/transcript"
 )
 
 // Mode は UI の動作モードを表す。
@@ -48,8 +54,17 @@ type MsgSetMode struct{ M Mode }
 type MsgSetInputEnabled struct{ Enabled bool }
 type MsgSetConnected struct{ Connected bool }
 type MsgIncrementError struct{}
+// Q が応答を終えて入力プロンプトに戻った（実行中のチャットのターンを完了にする）
+type MsgChatIdle struct{}
 // 画面と出力履歴のクリア要求
 type MsgClearScreen struct{}
+// ヘッダーへの警告の追加（起動後に見つかった問題。AddWarning を参照）
+type MsgAddWarning struct{ Text string }
+// ヘッダーに表示するプロファイルの変更（SetProfile を参照）
+type MsgSetProfile struct{ Name, Detail string }
+
+// MsgCountdownTick は実行中コマンドのタイムアウト残り時間表示を更新する
+type MsgCountdownTick struct{}
 
 // スクランブルアニメーション制御用メッセージ
 type MsgScrambleUpdate struct{}
@@ -62,10 +77,34 @@ type MsgScrambleStop struct{}
 type History struct {
     items   []string
     pointer int // items のインデックスを指す。len(items) は「空（ブランク）」を表す。
+    limit   int // 保持する最大件数（0 以下なら無制限）
 }
 
+// DefaultHistoryLimit はメモリ上に保持する履歴の既定の上限
+const DefaultHistoryLimit = 1000
+
 func NewHistory() History {
-	return History{items: make([]string, 0), pointer: 0}
+	return History{items: make([]string, 0), pointer: 0, limit: DefaultHistoryLimit}
+}
+
+// SetLimit は保持する最大件数を設定し、超過分を古い順に捨てる
+func (h *History) SetLimit(limit int) {
+    h.limit = limit
+    h.trim()
+}
+
+// Load は永続化された履歴（古い順）で置き換える
+func (h *History) Load(items []string) {
+    h.items = append([]string(nil), items...)
+    h.trim()
+}
+
+// trim は上限を超えた古い履歴を捨て、ポインタを空に戻す
+func (h *History) trim() {
+    if h.limit > 0 && len(h.items) > h.limit {
+        h.items = h.items[len(h.items)-h.limit:]
+    }
+    h.pointer = len(h.items)
 }
 
 func (h *History) Add(text string) {
@@ -75,7 +114,7 @@ func (h *History) Add(text string) {
     }
     h.items = append(h.items, text)
     // 追加後はポインタを「空（最後の次）」へ移動
-    h.pointer = len(h.items)
+    h.trim()
 }
 
 func (h *History) Prev() (string, bool) {
@@ -91,17 +130,24 @@ func (h *History) Next() (string, bool) {
 	return h.items[h.pointer], true
 }
 
+// Entries は履歴を古い順に返す（呼び出し元が変更しても影響しないコピー）
+func (h *History) Entries() []string {
+	return append([]string(nil), h.items...)
+}
+
 // CommandExecutorInterface はコマンド実行を抽象化するインターフェース
+// 状態変化は Events() のチャネル経由で受け取り、Bubble Tea のループ内で反映する
 type CommandExecutorInterface interface {
 	Execute(command string) error
-	SetEventHandlers(
-		onStatusChange func(string),
-		onModeChange func(string),
-		onOutput func(string),
-		onError func(error),
-	)
-	GetMode() string
-	GetStatus() string
+	Confirm(approve bool) error
+	Events() <-chan executor.Event
+	GetMode() executor.Mode
+	GetStatus() executor.Status
+}
+
+// HistoryRecorder は入力履歴を永続化する（history.Recorder が実装する）
+type HistoryRecorder interface {
+	Record(text string, mode history.Mode, exit *int) error
 }
 
 // Model は最小プロトタイプに必要な UI の状態を保持する。
@@ -109,9 +155,10 @@ type CommandExecutorInterface interface {
 type Model struct {
 	mode           Mode
 	status         Status
-	input          string
+	input          Editor
 	history        History
-	lines          []string
+	turns          Scrollback
+	turnLines      []int // 各ターンの先頭行（viewport のコンテンツ上の行番号）
 	progressLine   *string
 	errorCount     int
 	currentCommand string
@@ -124,6 +171,33 @@ type Model struct {
 	viewport       viewport.Model // アプリ全体のスクロール管理
 	ready          bool    // viewportの準備ができているか
 	executor       CommandExecutorInterface // コマンド実行を管理
+	events         <-chan executor.Event    // executor からのイベント
+	pendingConfirm string                   // 実行確認待ちのコマンド（空なら確認待ちなし）
+	deadline       time.Time                // 実行中コマンドのタイムアウト時刻（ゼロ値なら表示しない）
+	theme          Theme                    // 描画に使うスタイル
+	keys           KeyMap                   // キー割り当て
+	keyWarnings    []string                 // キー割り当ての問題（衝突など）。ヘッダーに表示する
+	warnings       []string                 // 設定の誤りなど起動時の問題。ヘッダーに表示する
+	showHelp       bool                     // キー割り当て一覧を表示中か
+	scroll         *scrollMode              // スクロールバックモード（nil なら入力欄にフォーカス）
+	notice         string                   // ステータスバーに一時的に表示する通知
+	clipboard      func(string) tea.Cmd     // ヤンクした文字列をクリップボードへ送る
+	search         *historySearch           // 履歴検索オーバーレイ（nil なら非表示）
+	find           *finder                  // スクロールバック内検索（nil なら非表示）
+	recorder       HistoryRecorder          // 履歴の永続化先（nil なら保存しない）
+	exporter       Exporter                 // 会話の記録の書き出し先（nil なら /qube save は使えない）
+	conversation   ConversationRecorder     // 作業ディレクトリごとの会話の記録先（nil なら保存しない）
+	resume         *resumePicker            // 再開する会話のピッカー（nil なら非表示）
+	keepTurns      bool                     // 次の chat 開始でスクロールバックを消さない（再開した会話を残す）
+	pendingRecord  string                   // 終了コード待ちの短命コマンド（空なら待ちなし）
+	statusSegments []StatusSegment          // ステータスバーに表示する要素と並び順
+	qModel         string                   // Q の使用モデル（起動バナーから取得、空なら非表示）
+	qVersion       string                   // Q CLI のバージョン（空なら非表示）
+	profile        string                   // 使用中のプロファイル名（空なら非表示）
+	profileDetail  string                   // プロファイルの AWS プロファイル・リージョン
+	cwd            string                   // 作業ディレクトリ（空なら非表示）
+	startedAt      time.Time                // 起動時刻（稼働時間の表示に使う）
+	telemetry      Telemetry                // 利用状況の記録先（nil なら記録しない）
 	
 	// スクランブルアニメーション用フィールド
 	scrambleActive bool   // スクランブルアニメーション中か
@@ -135,9 +209,8 @@ func New() Model {
 	return Model{
 		mode:         ModeCommand,
 		status:       StatusReady,
-		input:        "",
+		input:        NewEditor(),
 		history:      NewHistory(),
-		lines:        []string{},
 		progressLine: nil,
 		errorCount:   0,
 		currentCommand: "",
@@ -149,6 +222,11 @@ func New() Model {
 		height:       24,  // デフォルト高さ
 		ready:        false, // viewport初期化前
 		executor:     nil, // 後でSetExecutorで設定
+		theme:        DefaultTheme(),
+		keys:         DefaultKeyMap(),
+		clipboard:    copyToClipboard,
+		statusSegments: DefaultStatusSegments(),
+		startedAt:    time.Now(),
 		
 		// スクランブルアニメーション用フィールドの初期化
 		scrambleActive: false,
@@ -164,48 +242,245 @@ func NewWithExecutor(executor CommandExecutorInterface) Model {
 	return m
 }
 
-// SetExecutor はCommandExecutorを設定し、イベントハンドラーを登録する
+// SetExecutor はCommandExecutorを設定する
+// イベントの購読は Init で開始され、Model の更新は全て Update 内で行われる
 func (m *Model) SetExecutor(executor CommandExecutorInterface) {
 	m.executor = executor
+	m.events = nil
 	if executor != nil {
-		// イベントハンドラーを設定
-		executor.SetEventHandlers(
-			// onStatusChange
-			func(status string) {
-				switch status {
-				case "ready":
-					m.SetStatus(StatusReady)
-					m.SetInputEnabled(true)
-				case "running":
-					m.SetStatus(StatusRunning)
-					m.SetInputEnabled(false)
-				case "error":
-					m.SetStatus(StatusError)
-					m.SetInputEnabled(true)
-				}
-			},
-			// onModeChange
-			func(mode string) {
-				if mode == "session" {
-					m.SetMode(ModeSession)
-				} else {
-					m.SetMode(ModeCommand)
-				}
-			},
-			// onOutput
-			func(output string) {
-				m.AddOutput(output)
-			},
-			// onError
-			func(err error) {
-				m.IncrementErrorCount()
-				m.AddOutput("Error: " + err.Error())
-			},
-		)
-	}
-}
-
-func (m Model) Init() tea.Cmd { return nil }
+		m.events = executor.Events()
+	}
+}
+
+// SetHistoryRecorder は履歴の永続化先を設定し、保存済みの履歴（古い順）を読み込む
+func (m *Model) SetHistoryRecorder(recorder HistoryRecorder, previous []string) {
+	m.recorder = recorder
+	m.history.Load(previous)
+}
+
+// SetHistoryLimit はメモリ上に保持する履歴の上限を設定する
+func (m *Model) SetHistoryLimit(limit int) {
+	m.history.SetLimit(limit)
+}
+
+// recordHistory は入力を永続化する tea.Cmd を返す
+// セッション中の入力はチャットとして即座に記録し、短命コマンドは終了コードが分かるまで保留する
+// （スラッシュコマンドは Qube 自身のコマンドとして記録する）
+func (m *Model) recordHistory(text string) tea.Cmd {
+	if m.recorder == nil {
+		return nil
+	}
+	// 終了コードが届かなかった前回のコマンド（ポリシーで拒否された等）は終了コードなしで記録する
+	cmd := m.flushPendingRecord(nil)
+	switch {
+	case strings.HasPrefix(text, "/"):
+		return tea.Batch(cmd, m.record(text, history.ModeCommand, nil))
+	case m.mode == ModeSession:
+		return tea.Batch(cmd, m.record(text, history.ModeChat, nil))
+	}
+	m.pendingRecord = text
+	return cmd
+}
+
+// flushPendingRecord は保留中の短命コマンドを終了コード付きで記録する
+func (m *Model) flushPendingRecord(exit *int) tea.Cmd {
+	if m.pendingRecord == "" {
+		return nil
+	}
+	text := m.pendingRecord
+	m.pendingRecord = ""
+	return m.record(text, history.ModeCommand, exit)
+}
+
+// record は履歴の書き込みを Bubble Tea の goroutine で行う tea.Cmd を返す
+func (m *Model) record(text string, mode history.Mode, exit *int) tea.Cmd {
+	recorder := m.recorder
+	return func() tea.Msg {
+		if err := recorder.Record(text, mode, exit); err != nil {
+			return MsgAddOutput{Line: "History Error: " + err.Error()}
+		}
+		return nil
+	}
+}
+
+func (m Model) Init() tea.Cmd { return m.waitForEvent() }
+
+// waitForEvent は CommandExecutor のイベントを 1 件待ち受ける tea.Cmd を返す
+// 受信したイベントは tea.Msg として Update に渡され、処理後に再度待ち受ける
+func (m *Model) waitForEvent() tea.Cmd {
+	if m.events == nil {
+		return nil
+	}
+	events := m.events
+	return func() tea.Msg {
+		ev, ok := <-events
+		if !ok {
+			return nil
+		}
+		return ev
+	}
+}
+
+// handleExecutorEvent は CommandExecutor のイベントを UI の状態に反映する
+func (m *Model) handleExecutorEvent(ev executor.Event) tea.Cmd {
+	switch e := ev.(type) {
+	case executor.EventStatusChanged:
+		switch e.To {
+		case executor.StatusReady:
+			m.SetStatus(StatusReady)
+			m.SetInputEnabled(true)
+		case executor.StatusRunning:
+			m.SetStatus(StatusRunning)
+			// セッション実行中は入力有効（チャット入力を許可）
+			m.SetInputEnabled(e.Mode == executor.ModeSession)
+		case executor.StatusError:
+			m.SetStatus(StatusError)
+			m.SetInputEnabled(true)
+		}
+	case executor.EventModeChanged:
+		if e.To != executor.ModeSession {
+			m.SetMode(ModeCommand)
+			return nil
+		}
+		m.SetMode(ModeSession)
+		// 初期化までは Connecting のまま、チャット入力は即有効
+		m.SetConnected(false)
+		m.SetInputEnabled(true)
+		// 再開した会話は残したまま chat を続ける
+		if m.keepTurns {
+			m.keepTurns = false
+			return nil
+		}
+		// 画面クリア → 初期化（React Inkの流れに合わせる）
+		return m.clearScreen()
+	case executor.EventOutput:
+		// 短命コマンドの出力はそのまま追加
+		m.AddOutput(e.Text)
+	case executor.EventError:
+		m.IncrementErrorCount()
+		m.addEntry(EntrySystem, "Error: "+e.Err.Error())
+		m.turns.Finish(TurnFailed, time.Now())
+	case executor.EventCommandStarted:
+		m.deadline = e.Deadline
+		if !e.Deadline.IsZero() {
+			return countdownTick()
+		}
+	case executor.EventCommandFinished:
+		m.deadline = time.Time{}
+		m.addEntry(EntryResult, renderResultBadge(e.Result, m.theme))
+		// 会話の記録には表示用のバッジではなく終了コードと所要時間を残す
+		if t := m.turns.Current(); t != nil {
+			t.Entries[len(t.Entries)-1].Result = &transcript.Result{
+				ExitCode:   e.Result.ExitCode,
+				DurationMs: e.Result.Duration().Milliseconds(),
+				TimedOut:   e.Result.TimedOut,
+				Truncated:  e.Result.Truncated,
+			}
+		}
+		status := TurnDone
+		if e.Err != nil || e.Result.ExitCode != 0 {
+			status = TurnFailed
+		}
+		m.turns.Finish(status, time.Now())
+		exit := e.Result.ExitCode
+		return tea.Batch(m.flushPendingRecord(&exit), m.saveConversation())
+	case executor.EventPolicyDecision:
+		command := strings.Join(e.Argv, " ")
+		m.addEntry(EntrySystem, renderPolicyDecision(command, e.Verdict, m.theme))
+		if e.Verdict.Decision == policy.Deny {
+			m.turns.Finish(TurnFailed, time.Now())
+		}
+		m.pendingConfirm = ""
+		if e.Verdict.Decision == policy.Confirm {
+			m.pendingConfirm = command
+		}
+	}
+	return nil
+}
+
+// countdownTick は 1 秒後に残り時間表示を更新する tea.Cmd を返す
+func countdownTick() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg { return MsgCountdownTick{} })
+}
+
+// renderResultBadge は短命コマンドの完了バッジ（例: "✓ 0  1.2s", "✗ 2  0.3s"）を表示する
+func renderResultBadge(r execq.Result, th Theme) string {
+	mark, style := "✓", th.Success
+	if r.ExitCode != 0 {
+		mark, style = "✗", th.Failure
+	}
+	badge := fmt.Sprintf("%s %d  %.1fs", mark, r.ExitCode, r.Duration().Seconds())
+	switch {
+	case r.TimedOut:
+		badge += "  timeout"
+	case r.Truncated:
+		badge += fmt.Sprintf("  truncated (%s)", formatBytes(r.Bytes))
+	}
+	return style.Render(badge)
+}
+
+// formatBytes はバイト数を B/KB/MB の短い表記に変換する
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
+
+// renderPolicyDecision は実行ポリシーの判定結果を 1 行で表示する
+func renderPolicyDecision(command string, v policy.Verdict, th Theme) string {
+	var style lipgloss.Style
+	switch v.Decision {
+	case policy.Allow:
+		style = th.Faint
+	case policy.Deny:
+		style = th.Failure
+	default:
+		style = th.Warning
+	}
+	line := fmt.Sprintf("policy: %s  %s", v, command)
+	if v.Decision == policy.Confirm {
+		line += "  — run it? [y/N]"
+	}
+	return style.Render(line)
+}
+
+// SetTheme は描画に使うテーマを設定する
+func (m *Model) SetTheme(theme Theme) {
+	m.theme = theme
+	m.updateViewportContent()
+}
+
+// SetKeyMap はキー割り当てを設定する
+// 衝突している割り当ては起動時に気づけるようヘッダーに表示する
+func (m *Model) SetKeyMap(keys KeyMap) {
+	m.keys = keys
+	m.input.SetKeyMap(keys.Editor)
+	if m.ready {
+		m.viewport.KeyMap = keys.Viewport()
+	}
+	m.keyWarnings = nil
+	for _, c := range keys.Conflicts() {
+		m.keyWarnings = append(m.keyWarnings, "key binding conflict: "+c.String())
+	}
+	m.updateViewportContent()
+}
+
+// AddWarning は起動時の問題（設定ファイルの誤りなど）をヘッダーに表示する
+func (m *Model) AddWarning(warning string) {
+	// プロファイルの切り替えなどで同じ警告を再び受け取った場合は重ねて表示しない
+	for _, w := range m.warnings {
+		if w == warning {
+			return
+		}
+	}
+	m.warnings = append(m.warnings, warning)
+	m.updateViewportContent()
+}
 
 // SetTitle はアプリケーションタイトルを設定する
 func (m *Model) SetTitle(title string) {
@@ -222,21 +497,35 @@ func (m *Model) SetConnected(connected bool) {
 	m.connected = connected
 }
 
-// AddUserInput はユーザー入力を履歴に追加する
+// SetProfile はヘッダーに表示するプロファイルを設定する（name が空なら表示しない）
+// detail は AWS プロファイルとリージョンなどの補足（例: "prod-admin, us-east-1"）
+func (m *Model) SetProfile(name, detail string) {
+	m.profile = name
+	m.profileDetail = detail
+}
+
+// AddUserInput はユーザー入力で新しいターンを始める
 func (m *Model) AddUserInput(input string) {
-	m.lines = append(m.lines, "USER_INPUT:"+input)
+	m.turns.Begin(input, time.Now()).Mode = m.inputMode(input)
 	m.updateViewportContent()
 }
 
-// AddOutput は通常の出力を履歴に追加する
+// AddOutput は通常の出力を現在のターンに追加する
 func (m *Model) AddOutput(output string) {
-	m.lines = append(m.lines, output)
+	m.addEntry(classifyOutput(output), output)
+}
+
+// addEntry は種類を指定して現在のターンにエントリを追加する
+func (m *Model) addEntry(kind EntryKind, text string) {
+	m.turns.Append(kind, text, time.Now())
 	m.updateViewportContent()
 }
 
 // SetProgressLine は進捗行を設定する
 func (m *Model) SetProgressLine(line string) {
 	m.progressLine = &line
+	// 進捗の変化もターンのイベントとして記録する（描画はしない）
+	m.turns.Append(EntryProgress, line, time.Now())
 	
 	// "Thinking"以外の場合はスクランブルアニメーションを停止
 	if !strings.Contains(strings.ToLower(line), "thinking") {
@@ -251,13 +540,27 @@ func (m *Model) SetProgressLine(line string) {
 // updateViewportContent はviewportのコンテンツを更新する
 func (m *Model) updateViewportContent() {
 	if m.ready {
-		content := m.buildScrollableContent()
-		m.viewport.SetContent(content)
-		// 自動的に最下部にスクロール
-		m.viewport.GotoBottom()
+		m.setViewportContent()
+		// 自動的に最下部にスクロール（検索中・スクロールバックモード中は位置を保つ）
+		if m.find == nil && m.scroll == nil {
+			m.viewport.GotoBottom()
+		}
 	}
 }
 
+// setViewportContent はスクロール位置を変えずに viewport のコンテンツを作り直す
+// 検索中は一致箇所をハイライトする
+func (m *Model) setViewportContent() {
+	content := m.buildScrollableContent()
+	if m.find != nil {
+		content = m.find.apply(content, m.theme)
+	}
+	if m.scroll != nil {
+		content = m.scroll.decorate(content, m.width, m.theme)
+	}
+	m.viewport.SetContent(content)
+}
+
 // SetInputEnabled は入力の有効/無効を設定する
 func (m *Model) SetInputEnabled(enabled bool) {
 	m.inputEnabled = enabled
@@ -306,8 +609,13 @@ func (m *Model) buildScrollableContent() string {
 	// ASCIIロゴ
 	ascii := m.renderQubeASCII()
 
-	// 出力履歴
-	output := m.renderAllOutput()
+	// 出力履歴（ターンの先頭行をコンテンツ上の行番号として記録する）
+	output, starts := m.renderTurns()
+	offset := lipgloss.Height(header) + lipgloss.Height(ascii)
+	m.turnLines = m.turnLines[:0]
+	for _, l := range starts {
+		m.turnLines = append(m.turnLines, offset+l)
+	}
 
 	// progressLineがある場合は追加
 	progressRendered := m.renderProgressLine()
@@ -335,8 +643,11 @@ func (m *Model) buildContent() string {
 		output += "\n" + progressRendered
 	}
 
-	// 入力
+	// 入力（検索中は入力欄の上にオーバーレイを表示）
 	input := m.renderInput()
+	if overlay := m.renderOverlay(); overlay != "" {
+		input = overlay + "\n" + input
+	}
 
 	// ステータスバー
 	statusBar := m.renderStatusBar()
@@ -344,31 +655,59 @@ func (m *Model) buildContent() string {
 	return strings.Join([]string{header, ascii, output, input, statusBar}, "\n")
 }
 
-// renderAllOutput は全ての出力を表示する（スクロール制御なし）
+// renderAllOutput は全てのターンを表示する（スクロール制御なし）
 func (m *Model) renderAllOutput() string {
+	out, _ := m.renderTurns()
+	return out
+}
+
+// renderTurns は全てのターンを描画し、各ターンの先頭が出力の何行目かを返す
+// プロンプトは枠線付きで表示し、折りたたまれたターンは隠した行数の要約だけを表示する
+func (m *Model) renderTurns() (string, []int) {
 	var result []string
-	
-	// スタイル定義 - 紫と青の組み合わせ
-	userStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("165")) // 紫（テキスト）
-	boxStyle := lipgloss.NewStyle().
+	starts := make([]int, 0, m.turns.Len())
+	lineCount := 0
+	add := func(s string) {
+		result = append(result, s)
+		lineCount += strings.Count(s, "\n") + 1
+	}
+
+	// スタイル定義（テーマの強調色と枠線色）
+	userStyle := m.theme.UserInput
+	boxStyle := m.theme.Box.
 		Border(lipgloss.RoundedBorder()).
-		BorderForeground(lipgloss.Color("93")). // 青（枠線）
 		Width(m.width - 2)
-	
-	// 全ての行を表示
-	for _, line := range m.lines {
-		if strings.HasPrefix(line, "USER_INPUT:") {
+
+	now := time.Now()
+	turns := m.turns.Turns()
+	for i := range turns {
+		t := &turns[i]
+		starts = append(starts, lineCount)
+		if !t.IsPreamble() {
 			// ユーザー入力は枠線付きで表示
-			message := strings.TrimPrefix(line, "USER_INPUT:")
-			userLine := userStyle.Render("▶ " + message)
-			result = append(result, boxStyle.Render(userLine))
-		} else {
+			add(boxStyle.Render(userStyle.Render("▶ " + t.Prompt)))
+		}
+		entries := t.visibleEntries()
+		if t.Collapsed {
+			hidden := 0
+			for _, e := range entries {
+				hidden += strings.Count(e.Text, "\n") + 1
+			}
+			summary := fmt.Sprintf("▸ %d line(s) hidden  %s  %.1fs", hidden, t.Status, t.Elapsed(now).Seconds())
+			add(m.theme.Faint.Render(summary))
+			continue
+		}
+		for _, e := range entries {
+			if e.Kind == EntryTool {
+				add(m.theme.Faint.Render(e.Text))
+				continue
+			}
 			// 通常の出力はそのまま表示
-			result = append(result, line)
+			add(e.Text)
 		}
 	}
-	
-	return strings.Join(result, "\n")
+
+	return strings.Join(result, "\n"), starts
 }
 
 func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -382,33 +721,32 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
         
         if !m.ready {
             // 初回のウィンドウサイズ設定時にviewportを初期化
-            // 固定部分の高さを計算：入力(3行) + ステータスバー(1行) = 4行
-            viewportHeight := v.Height - 4
-            if viewportHeight < 10 {
-                viewportHeight = 10 // 最小高さを確保
-            }
-            m.viewport = viewport.New(v.Width, viewportHeight)
+            m.viewport = viewport.New(v.Width, m.viewportHeight())
+            m.viewport.KeyMap = m.keys.Viewport()
             m.viewport.SetContent(m.buildScrollableContent())
             m.viewport.GotoBottom() // 初期位置は最下部
             m.ready = true
+            // 経過時間を表示する場合は毎秒ステータスバーを更新する
+            if m.needsStatusTick() {
+                return m, statusTick()
+            }
         } else {
             // サイズ変更時はviewportのサイズを更新
-            viewportHeight := v.Height - 4
-            if viewportHeight < 10 {
-                viewportHeight = 10
-            }
             m.viewport.Width = v.Width
-            m.viewport.Height = viewportHeight
+            m.viewport.Height = m.viewportHeight()
             m.updateViewportContent()
         }
         return m, nil
     case MsgSubmit:
         // MsgSubmitを受け取った時にCommandExecutorを呼び出す
+        // 実行は tea.Cmd として Bubble Tea の goroutine で行い、Model には触れない
         if m.executor != nil {
             m.SetCurrentCommand(v.Value)
-            go func() {
-                _ = m.executor.Execute(v.Value)
-            }()
+            exec := m.executor
+            return m, func() tea.Msg {
+                _ = exec.Execute(v.Value)
+                return nil
+            }
         }
         return m, nil
     case MsgAddOutput:
@@ -444,20 +782,37 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
     case MsgIncrementError:
         m.IncrementErrorCount()
         return m, nil
+    case MsgChatIdle:
+        return m, m.finishChatTurn()
     case MsgClearScreen:
-        // 出力履歴と進捗をクリア
-        m.lines = []string{}
-        m.progressLine = nil
-        // スクランブルアニメーションも停止
-        m.stopScrambleAnimation()
-        // viewportのコンテンツもクリア
-        m.updateViewportContent()
-        // 物理画面もクリア（スクロールバック含め可能な範囲で）
-        return m, func() tea.Msg {
-            // ESC[3J: スクロールバック消去, ESC[H: カーソル先頭, ESC[2J: 画面消去
-            print("\x1b[3J\x1b[H\x1b[2J")
-            return nil
+        return m, m.clearScreen()
+    case executor.Event:
+        return m, tea.Batch(m.handleExecutorEvent(v), m.waitForEvent())
+    case MsgSetQModel:
+        m.SetQModel(v.Name)
+        return m, nil
+    case MsgSetQVersion:
+        m.SetQVersion(v.Version)
+        return m, nil
+    case MsgAddWarning:
+        m.AddWarning(v.Text)
+        return m, nil
+    case MsgSetProfile:
+        m.SetProfile(v.Name, v.Detail)
+        return m, nil
+    case MsgExport:
+        return m, m.export(v.Path)
+    case MsgRestoreConversation:
+        m.restoreConversation(v)
+        return m, nil
+    case MsgStatusTick:
+        return m, statusTick()
+    case MsgCountdownTick:
+        // コマンド完了で deadline がクリアされたら更新を止める
+        if m.deadline.IsZero() {
+            return m, nil
         }
+        return m, countdownTick()
     case MsgScrambleUpdate:
         // スクランブルアニメーションフレーム更新
         cmd := m.updateScrambleText()
@@ -472,45 +827,10 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
         m.stopScrambleAnimation()
         return m, nil
     case tea.KeyMsg:
-        switch v.Type {
-        case tea.KeyCtrlC:
-            return m, tea.Quit
-        case tea.KeyEnter:
-            text := m.input
-            if text == "" { return m, nil }
-            m.history.Add(text)
-            m.input = ""
-            // ユーザー入力を表示に追加
-            m.AddUserInput(text)
-            return m, func() tea.Msg { return MsgSubmit{Value: text} }
-        case tea.KeyBackspace, tea.KeyCtrlH:
-            // バックスペースで末尾 1 文字（rune）を削除
-            if m.input != "" {
-                r := []rune(m.input)
-                if len(r) > 0 { m.input = string(r[:len(r)-1]) }
-            }
-            return m, nil
-        case tea.KeyRunes:
-            // 入力された文字（rune）を末尾に追加
-            if len(v.Runes) > 0 {
-                m.input += string(v.Runes)
-            }
-            return m, nil
-        case tea.KeyUp:
-            // 履歴ナビゲーション
-            if s, ok := m.history.Prev(); ok { m.input = s }
-            return m, nil
-        case tea.KeyDown:
-            // 履歴ナビゲーション
-            if s, ok := m.history.Next(); ok { m.input = s }
-            return m, nil
-        default:
-            // その他のキー（スクロール関連含む）はviewportに委譲
-            if m.ready {
-                m.viewport, cmd = m.viewport.Update(msg)
-            }
-            return m, cmd
-        }
+        cmd = m.handleKey(v)
+        // 入力欄の行数が変わった場合に備えてレイアウトを合わせる
+        m.syncLayout()
+        return m, cmd
     default:
         // マウス操作など、その他のメッセージもviewportに委譲
         if m.ready {
@@ -520,6 +840,253 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
     return m, cmd
 }
 
+// handleKey はキー入力を処理する
+func (m *Model) handleKey(v tea.KeyMsg) tea.Cmd {
+    var cmd tea.Cmd
+    if key.Matches(v, m.keys.Quit) {
+        // 終了コード待ちのコマンドも失わないよう記録してから終了する
+        if cmd := m.flushPendingRecord(nil); cmd != nil {
+            return tea.Sequence(cmd, tea.Quit)
+        }
+        return tea.Quit
+    }
+    if m.resume != nil {
+        return m.handleResumeKey(v)
+    }
+    if m.showHelp {
+        // ヘルプ表示中はヘルプ/閉じるキーで閉じ、その他のキーは無視する
+        if key.Matches(v, m.keys.Help, m.keys.Cancel) {
+            m.showHelp = false
+        }
+        return nil
+    }
+    if m.search != nil {
+        return m.handleSearchKey(v)
+    }
+    if m.find != nil {
+        if cmd, handled := m.handleFindKey(v); handled {
+            return cmd
+        }
+    }
+    if m.scroll != nil {
+        return m.handleScrollModeKey(v)
+    }
+    switch {
+    case key.Matches(v, m.keys.Help) && (v.Type != tea.KeyRunes || m.input.Value() == ""):
+        // "?" のような文字キーは入力が空のときだけヘルプとして扱う
+        m.showHelp = true
+        m.recordFeature("help")
+        return nil
+    case key.Matches(v, m.keys.Submit):
+        text := m.input.Value()
+        // 実行確認待ちの場合は y/yes のみ承認として扱い、履歴には残さない
+        if m.pendingConfirm != "" && m.executor != nil {
+            answer := strings.ToLower(strings.TrimSpace(text))
+            approve := answer == "y" || answer == "yes"
+            m.pendingConfirm = ""
+            m.input.Reset()
+            exec := m.executor
+            return func() tea.Msg {
+                _ = exec.Confirm(approve)
+                return nil
+            }
+        }
+        return m.submit(text)
+    case key.Matches(v, m.keys.Find):
+        // スクロールバック内検索を開く
+        m.find = newFinder()
+        m.find.query.SetKeyMap(m.keys.Editor)
+        m.recordFeature("find")
+        return nil
+    case key.Matches(v, m.keys.HistorySearch):
+        // 履歴のインクリメンタル検索を開く
+        m.search = newHistorySearch(m.history.Entries())
+        m.search.query.SetKeyMap(m.keys.Editor)
+        m.recordFeature("history_search")
+        return nil
+    case key.Matches(v, m.keys.HistoryPrev):
+        // 複数行入力中は行移動、先頭行では履歴ナビゲーション
+        if m.input.CursorUp() { return nil }
+        if s, ok := m.history.Prev(); ok { m.input.SetValue(s) }
+        return nil
+    case key.Matches(v, m.keys.HistoryNext):
+        // 複数行入力中は行移動、最終行では履歴ナビゲーション
+        if m.input.CursorDown() { return nil }
+        if s, ok := m.history.Next(); ok { m.input.SetValue(s) }
+        return nil
+    case key.Matches(v, m.keys.ScrollMode):
+        // Esc でスクロールバックモードへ
+        m.enterScrollMode()
+        m.recordFeature("scroll_mode")
+        return nil
+    case key.Matches(v, m.keys.PrevTurn):
+        m.jumpTurn(-1)
+        return nil
+    case key.Matches(v, m.keys.NextTurn):
+        m.jumpTurn(1)
+        return nil
+    case key.Matches(v, m.keys.Top):
+        if m.ready { m.viewport.GotoTop() }
+        return nil
+    case key.Matches(v, m.keys.Bottom):
+        if m.ready { m.viewport.GotoBottom() }
+        return nil
+    default:
+        // 編集系のキーは行エディタで処理する
+        if m.input.HandleKey(v) {
+            return nil
+        }
+        // その他のキー（スクロール関連含む）はviewportに委譲
+        if m.ready {
+            m.viewport, cmd = m.viewport.Update(v)
+        }
+        return cmd
+    }
+}
+
+// submit は入力を履歴と表示に追加し、実行を要求する
+func (m *Model) submit(text string) tea.Cmd {
+    if text == "" { return nil }
+    m.history.Add(text)
+    m.input.Reset()
+    // ユーザー入力を表示に追加
+    m.AddUserInput(text)
+    submit := func() tea.Msg { return MsgSubmit{Value: text} }
+    // 入力のたびに（前のターンの応答を含めて）会話を保存する
+    return tea.Batch(submit, m.recordHistory(text), m.saveConversation())
+}
+
+// handleSearchKey は履歴検索中のキー入力を処理する
+func (m *Model) handleSearchKey(v tea.KeyMsg) tea.Cmd {
+    switch m.search.HandleKey(v, &m.keys) {
+    case searchCancel:
+        m.search = nil
+    case searchInsert:
+        if s, ok := m.search.Selected(); ok {
+            m.input.SetValue(s)
+        }
+        m.search = nil
+    case searchRun:
+        s, ok := m.search.Selected()
+        m.search = nil
+        if ok {
+            return m.submit(s)
+        }
+    }
+    return nil
+}
+
+// handleFindKey はスクロールバック内検索中のキー入力を処理する
+// 検索で扱わないキーは、クエリ入力中は viewport に、確定後は通常の処理に委ねる（handled=false）
+func (m *Model) handleFindKey(v tea.KeyMsg) (tea.Cmd, bool) {
+    switch m.find.HandleKey(v, &m.keys) {
+    case findClose:
+        m.find = nil
+        if m.ready {
+            m.setViewportContent()
+        }
+    case findUpdated:
+        // 表示中の位置から最も近い一致へ移動する
+        if m.ready {
+            m.setViewportContent()
+            m.find.startAt(m.viewport.YOffset)
+            m.setViewportContent()
+            m.scrollToMatch()
+        }
+    case findMoved:
+        if m.ready {
+            m.setViewportContent()
+            m.scrollToMatch()
+        }
+    case findIgnored:
+        if !m.find.editing {
+            return nil, false
+        }
+        var cmd tea.Cmd
+        if m.ready {
+            m.viewport, cmd = m.viewport.Update(v)
+        }
+        return cmd, true
+    }
+    return nil, true
+}
+
+// scrollToMatch は現在の一致が見えるように viewport をスクロールする
+// スクロールバックモード中はカーソルも一致の行へ移動する
+func (m *Model) scrollToMatch() {
+    match, ok := m.find.Current()
+    if !ok {
+        return
+    }
+    if m.scroll != nil {
+        m.scroll.cursor = match.line
+        m.setViewportContent()
+    }
+    top := m.viewport.YOffset
+    if match.line < top || match.line >= top+m.viewport.Height {
+        m.viewport.SetYOffset(match.line - m.viewport.Height/2)
+    }
+}
+
+// renderOverlay は入力欄の上に表示するオーバーレイ（履歴検索・スクロールバック内検索）を描画する
+func (m Model) renderOverlay() string {
+    switch {
+    case m.resume != nil:
+        return m.resume.View(m.width, m.theme)
+    case m.search != nil:
+        return m.search.View(m.width, m.theme)
+    case m.find != nil:
+        return m.find.View(m.width, m.theme)
+    }
+    return ""
+}
+
+// viewportHeight は入力欄とステータスバーを除いた viewport の高さを返す
+func (m *Model) viewportHeight() int {
+    // 固定部分の高さ：入力欄（枠線込み、複数行入力で伸びる） + ステータスバー(1行)
+    h := m.height - lipgloss.Height(m.renderInput()) - 1
+    if overlay := m.renderOverlay(); overlay != "" {
+        h -= lipgloss.Height(overlay)
+    }
+    if h < 10 {
+        h = 10 // 最小高さを確保
+    }
+    return h
+}
+
+// syncLayout は入力欄の高さの変化に合わせて viewport の高さを調整する
+func (m *Model) syncLayout() {
+    if !m.ready {
+        return
+    }
+    h := m.viewportHeight()
+    if h == m.viewport.Height {
+        return
+    }
+    atBottom := m.viewport.AtBottom()
+    m.viewport.Height = h
+    if atBottom {
+        m.viewport.GotoBottom()
+    }
+}
+
+// clearScreen は出力履歴と進捗をクリアし、物理画面をクリアする tea.Cmd を返す
+func (m *Model) clearScreen() tea.Cmd {
+	// 出力履歴と進捗をクリア
+	m.turns.Clear()
+	m.progressLine = nil
+	// スクランブルアニメーションも停止
+	m.stopScrambleAnimation()
+	// viewportのコンテンツもクリア
+	m.updateViewportContent()
+	// 物理画面もクリア（スクロールバック含め可能な範囲で）
+	return func() tea.Msg {
+		// ESC[3J: スクロールバック消去, ESC[H: カーソル先頭, ESC[2J: 画面消去
+		print("\x1b[3J\x1b[H\x1b[2J")
+		return nil
+	}
+}
+
 // renderQubeASCII はQUBEのASCIIロゴを生成する
 func (m Model) renderQubeASCII() string {
 	// シンプルなASCIIアート（figlet風）
@@ -533,14 +1100,16 @@ func (m Model) renderQubeASCII() string {
 
 	}
 	
-	// 紫系グラデーション色（256色パレット）
-	gradientColors := []string{"165", "129", "93", "57", "21", "90", "126"} // 紫系のグラデーション
+	// テーマのグラデーション色を行ごとに循環させる
+	gradient := m.theme.Logo
 	
 	var result []string
 	for i, line := range asciiLines {
-		colorIndex := i % len(gradientColors)
-		style := lipgloss.NewStyle().Foreground(lipgloss.Color(gradientColors[colorIndex]))
-		result = append(result, style.Render(line))
+		if len(gradient) == 0 {
+			result = append(result, line)
+			continue
+		}
+		result = append(result, gradient[i%len(gradient)].Render(line))
 	}
 	
 	return strings.Join(result, "\n")
@@ -562,71 +1131,48 @@ func (m Model) renderInput() string {
 	
 	// プロンプトの選択
 	var prompt string
-	if m.inputEnabled {
+	switch {
+	case m.pendingConfirm != "":
+		prompt = "? "
+	case m.inputEnabled:
 		prompt = "▶ "
-	} else {
+	default:
 		prompt = "◌ "
 	}
 	
-	// 入力フィールドのレンダリング
-	inputField := prompt + m.input
+	// 入力フィールドのレンダリング（入力可能な間はカーソルを表示）
+	// 複数行入力では画面の 1/3 まで入力欄を伸ばし、継続行はプロンプト幅だけ字下げする
+	maxLines := m.height / 3
+	if maxLines < 1 {
+		maxLines = 1
+	}
+	indent := strings.Repeat(" ", lipgloss.Width(prompt))
+	showCursor := m.inputEnabled && m.scroll == nil
+	inputField := prompt + strings.ReplaceAll(m.input.View(showCursor, maxLines), "\n", "\n"+indent)
 	
 	// プレースホルダー表示
-	if m.input == "" && !m.inputEnabled {
-		inputField = prompt + lipgloss.NewStyle().Faint(true).Render("(waiting...)")
+	empty := m.input.Value() == ""
+	if empty && m.pendingConfirm != "" {
+		inputField = prompt + m.theme.Faint.Render("(y/N)")
+	} else if empty && !m.inputEnabled {
+		inputField = prompt + m.theme.Faint.Render("(waiting...)")
 	}
 	
 	return boxStyle.Render(inputField)
 }
 
 // renderStatusBar はステータスバー部分のレンダリングを行う
+// 表示する要素は SetStatusSegments で設定し、幅が足りない場合は優先度の低いものから短縮・省略する
 func (m Model) renderStatusBar() string {
-	// スタイル定義
-	faint := lipgloss.NewStyle().Faint(true)
-	
-	// コマンドの省略表示（20文字まで）
-	cmd := m.currentCommand
-	if len(cmd) > 20 {
-		cmd = cmd[:17] + "..."
-	}
-	
-	// ヘルプテキスト
-	help := "^C Exit  ↑↓ History  PgUp/PgDn Scroll  Mouse Wheel"
-	
-	// viewportのスクロール情報を取得
-	scrollInfo := ""
-	if m.ready {
-		scrollPercent := m.viewport.ScrollPercent()
-		if scrollPercent <= 0.0 {
-			scrollInfo = "⬆ TOP"
-		} else if scrollPercent >= 1.0 {
-			scrollInfo = "⬇ BOTTOM"
-		} else {
-			scrollInfo = fmt.Sprintf("📜 %.0f%%", scrollPercent*100)
-		}
-	}
-	
-	// ステータスバーの組み立て
-	statusBar := fmt.Sprintf("Mode:%s  Status:%s  Errors:%d",
-		m.modeStringShort(),
-		m.statusStringShort(),
-		m.errorCount,
-	)
-	
-	if scrollInfo != "" {
-		statusBar = fmt.Sprintf("%s  [%s]  %s", statusBar, scrollInfo, help)
-	} else {
-		statusBar = fmt.Sprintf("%s  %s", statusBar, help)
-	}
-	
-	return faint.Render(statusBar)
+	line := fitStatusParts(m.statusParts(time.Now()), m.width)
+	return m.theme.Faint.Render(line)
 }
 
 // renderHeader はヘッダー部分のレンダリングを行う
 func (m Model) renderHeader() string {
 	// スタイル定義
-	connectedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("10")) // 緑
-	disconnectedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("13")) // 黄色
+	connectedStyle := m.theme.Connected
+	disconnectedStyle := m.theme.Connecting
 	
 	// 接続インジケータのみ表示
 	var connectionPart string
@@ -636,8 +1182,19 @@ func (m Model) renderHeader() string {
 		connectionPart = disconnectedStyle.Render("○ Connecting...")
 	}
 	
-	// ヘッダー行を組み立て（接続状態のみ）
+	// 使用中のプロファイル（AWS アカウント・リージョンの取り違えを防ぐため常に表示する）
+	if m.profile != "" {
+		connectionPart += "  " + m.theme.Faint.Render("profile:") + " " + m.theme.Highlight.Render(m.profile)
+		if m.profileDetail != "" {
+			connectionPart += m.theme.Faint.Render(" (" + m.profileDetail + ")")
+		}
+	}
+	
+	// ヘッダー行を組み立て（接続状態と、設定やキー割り当ての警告があればその下に表示）
 	header := connectionPart
+	for _, w := range append(append([]string(nil), m.warnings...), m.keyWarnings...) {
+		header += "\n" + m.theme.Warning.Render("⚠ "+w)
+	}
 	
 	return header
 }
@@ -648,15 +1205,23 @@ func (m Model) View() string {
         return m.buildContent()
     }
     
-    // スクロール可能部分（viewport）
+    // スクロール可能部分（viewport）。ヘルプ表示中は同じ高さでキー割り当て一覧に置き換える
     scrollableContent := m.viewport.View()
+    if m.showHelp {
+        scrollableContent = lipgloss.NewStyle().Height(m.viewport.Height).MaxHeight(m.viewport.Height).
+            Render(helpView(&m.keys, m.width, m.theme))
+    }
     
     // 固定部分
     input := m.renderInput()
     statusBar := m.renderStatusBar()
     
-    // レイアウト組み立て：スクロール可能部分 + 固定部分
-    return strings.Join([]string{scrollableContent, input, statusBar}, "\n")
+    // レイアウト組み立て：スクロール可能部分 + (検索オーバーレイ) + 固定部分
+    parts := []string{scrollableContent}
+    if overlay := m.renderOverlay(); overlay != "" {
+        parts = append(parts, overlay)
+    }
+    return strings.Join(append(parts, input, statusBar), "\n")
 }
 
 // 描画用の表記変換ヘルパ
@@ -796,16 +1361,13 @@ func (m *Model) renderProgressLine() string {
 	if strings.Contains(strings.ToLower(line), "thinking") {
 		// スクランブルアニメーション中の場合はスクランブルテキストを表示
 		if m.scrambleActive && m.scrambleText != "" {
-			scrambleStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("13")) // 黄色
-			return scrambleStyle.Render(m.scrambleText)
+			return m.theme.Progress.Render(m.scrambleText)
 		} else {
 			// アニメーション未開始の場合は元のテキストを表示
-			scrambleStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("13")) // 黄色
-			return scrambleStyle.Render("Thinking...")
+			return m.theme.Progress.Render("Thinking...")
 		}
 	} else {
 		// Thinking以外の進捗は通常のfaintスタイルで表示
-		faintStyle := lipgloss.NewStyle().Faint(true)
-		return faintStyle.Render(line)
+		return m.theme.Faint.Render(line)
 	}
 }
@@ -1,12 +1,18 @@
 package ui
 
 import (
+	"errors"
 	"math/rand"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"qube/internal/execq"
+	"qube/internal/executor"
+	"qube/internal/history"
+	"qube/internal/policy"
 )
 
 func Test_NewModel_DefaultState(t *testing.T) {
@@ -17,8 +23,8 @@ func Test_NewModel_DefaultState(t *testing.T) {
 	if m.status != StatusReady {
 		t.Fatalf("status: got %v, want %v", m.status, StatusReady)
 	}
-	if m.input != "" {
-		t.Fatalf("input: got %q, want empty", m.input)
+	if m.input.Value() != "" {
+		t.Fatalf("input: got %q, want empty", m.input.Value())
 	}
 	if len(m.history.items) != 0 {
 		t.Fatalf("history length: got %d, want 0", len(m.history.items))
@@ -26,8 +32,8 @@ func Test_NewModel_DefaultState(t *testing.T) {
 	if m.progressLine != nil {
 		t.Fatalf("progressLine: got non-nil, want nil")
 	}
-	if len(m.lines) != 0 {
-		t.Fatalf("lines length: got %d, want 0", len(m.lines))
+	if m.turns.Len() != 0 {
+		t.Fatalf("turns: got %d, want 0", m.turns.Len())
 	}
 	if m.errorCount != 0 {
 		t.Fatalf("errorCount: got %d, want 0", m.errorCount)
@@ -39,7 +45,7 @@ func Test_NewModel_DefaultState(t *testing.T) {
 
 func Test_Update_EnterSubmitsMsgAndClearsInputAndAddsHistory(t *testing.T) {
 	m := New()
-	m.input = "echo hi"
+	m.input.SetValue("echo hi")
 
 	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
 	if cmd == nil {
@@ -54,8 +60,8 @@ func Test_Update_EnterSubmitsMsgAndClearsInputAndAddsHistory(t *testing.T) {
 	default:
 		t.Fatalf("unexpected msg type: %T", msg)
 	}
-	if m.input != "" {
-		t.Fatalf("input not cleared: got %q, want empty", m.input)
+	if m.input.Value() != "" {
+		t.Fatalf("input not cleared: got %q, want empty", m.input.Value())
 	}
 	if len(m.history.items) != 1 || m.history.items[0] != "echo hi" {
 		t.Fatalf("history not updated: %#v", m.history.items)
@@ -69,23 +75,23 @@ func Test_Update_UpDownHistoryNavigation(t *testing.T) {
 
     // 上矢印: two へ
 	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyUp})
-	if m.input != "two" {
-		t.Fatalf("after 1x Up: got %q, want %q", m.input, "two")
+	if m.input.Value() != "two" {
+		t.Fatalf("after 1x Up: got %q, want %q", m.input.Value(), "two")
 	}
     // 上矢印: one へ
 	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyUp})
-	if m.input != "one" {
-		t.Fatalf("after 2x Up: got %q, want %q", m.input, "one")
+	if m.input.Value() != "one" {
+		t.Fatalf("after 2x Up: got %q, want %q", m.input.Value(), "one")
 	}
     // 下矢印: two へ
 	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyDown})
-	if m.input != "two" {
-		t.Fatalf("after Down: got %q, want %q", m.input, "two")
+	if m.input.Value() != "two" {
+		t.Fatalf("after Down: got %q, want %q", m.input.Value(), "two")
 	}
     // 下矢印: 空文字 へ
 	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyDown})
-	if m.input != "" {
-		t.Fatalf("after 2x Down: got %q, want empty", m.input)
+	if m.input.Value() != "" {
+		t.Fatalf("after 2x Down: got %q, want empty", m.input.Value())
 	}
 }
 
@@ -143,6 +149,22 @@ func Test_Header_ShowsConnectionIndicator(t *testing.T) {
 	}
 }
 
+func Test_Header_ShowsProfile(t *testing.T) {
+	// 使用中のプロファイルを接続状態の横に表示する
+	m := New()
+	m.SetConnected(true)
+	m.SetProfile("prod", "prod-admin, us-east-1")
+	view := m.renderHeader()
+	if !strings.Contains(view, "profile: prod (prod-admin, us-east-1)") || strings.Contains(view, "\n") {
+		t.Errorf("Header should show the profile on the connection line, got: %q", view)
+	}
+	
+	m.SetProfile("", "")
+	if view = m.renderHeader(); strings.Contains(view, "profile") {
+		t.Errorf("Header should hide the profile when none is active, got: %q", view)
+	}
+}
+
 // QUBE ASCII ロゴのパリティテスト
 
 func Test_QubeASCII_DisplaysFigletLogo(t *testing.T) {
@@ -485,6 +507,7 @@ func Test_StatusBar_ShowsModeAndStatus(t *testing.T) {
 	m.status = StatusRunning
 	m.errorCount = 2
 	m.currentCommand = "q chat"
+	m.width = 200 // 省略されない幅で全要素を確認する
 	
 	statusBar := m.renderStatusBar()
 	
@@ -508,3 +531,193 @@ func Test_StatusBar_ShowsModeAndStatus(t *testing.T) {
 		t.Errorf("StatusBar should show help text, got: %s", statusBar)
 	}
 }
+
+// CommandExecutor イベント連携のテスト
+
+type fakeExecutor struct {
+	events    chan executor.Event
+	confirmed []bool
+}
+
+func (f *fakeExecutor) Execute(string) error          { return nil }
+func (f *fakeExecutor) Events() <-chan executor.Event { return f.events }
+func (f *fakeExecutor) GetMode() executor.Mode        { return executor.ModeCommand }
+func (f *fakeExecutor) GetStatus() executor.Status    { return executor.StatusReady }
+
+func (f *fakeExecutor) Confirm(approve bool) error {
+	f.confirmed = append(f.confirmed, approve)
+	return nil
+}
+
+func Test_ExecutorEvents_AreConsumedAsMessages(t *testing.T) {
+	// Init が返す Cmd でイベントを 1 件受信し、Update で状態に反映されることを確認
+	fe := &fakeExecutor{events: make(chan executor.Event, 4)}
+	m := NewWithExecutor(fe)
+
+	fe.events <- executor.EventStatusChanged{From: executor.StatusReady, To: executor.StatusRunning, Mode: executor.ModeCommand}
+	cmd := m.Init()
+	if cmd == nil {
+		t.Fatal("Init should return a command waiting for executor events")
+	}
+	_, next := m.Update(cmd())
+	if m.status != StatusRunning {
+		t.Fatalf("status: got %v, want %v", m.status, StatusRunning)
+	}
+	if m.inputEnabled {
+		t.Fatal("input should be disabled while a short-lived command runs")
+	}
+	if next == nil {
+		t.Fatal("Update should keep waiting for the next executor event")
+	}
+
+	fe.events <- executor.EventModeChanged{From: executor.ModeCommand, To: executor.ModeSession}
+	_, _ = m.Update(m.waitForEvent()())
+	if m.mode != ModeSession || !m.inputEnabled || m.connected {
+		t.Fatalf("after session start: mode=%v inputEnabled=%v connected=%v", m.mode, m.inputEnabled, m.connected)
+	}
+
+	fe.events <- executor.EventError{Err: errors.New("boom")}
+	_, _ = m.Update(m.waitForEvent()())
+	if m.errorCount != 1 || !strings.Contains(m.renderAllOutput(), "Error: boom") {
+		t.Fatalf("error event not reflected: count=%d output=%q", m.errorCount, m.renderAllOutput())
+	}
+}
+
+func Test_PolicyConfirmation_EnterAnswersExecutor(t *testing.T) {
+	// 確認待ちの判定を受けると入力が y/N の回答として扱われる
+	fe := &fakeExecutor{events: make(chan executor.Event, 1)}
+	m := NewWithExecutor(fe)
+
+	verdict := policy.Verdict{Decision: policy.Confirm, Reason: "not listed"}
+	_, _ = m.Update(executor.EventPolicyDecision{Argv: []string{"make", "all"}, Verdict: verdict})
+	if m.pendingConfirm != "make all" {
+		t.Fatalf("pendingConfirm: got %q, want %q", m.pendingConfirm, "make all")
+	}
+	if !strings.Contains(m.renderAllOutput(), "policy: confirm") {
+		t.Fatalf("policy decision should be shown in output, got: %q", m.renderAllOutput())
+	}
+
+	m.input.SetValue("y")
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if cmd == nil {
+		t.Fatal("Enter should return a command answering the confirmation")
+	}
+	cmd()
+	if len(fe.confirmed) != 1 || !fe.confirmed[0] {
+		t.Fatalf("confirm answers: got %v, want [true]", fe.confirmed)
+	}
+	if m.pendingConfirm != "" || len(m.history.items) != 0 {
+		t.Fatalf("confirmation answer should clear pending state without touching history")
+	}
+}
+
+func Test_CommandFinished_ShowsResultBadge(t *testing.T) {
+	// 短命コマンドの完了時に終了コードと所要時間のバッジが表示される
+	m := New()
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	_, _ = m.Update(executor.EventCommandFinished{Result: execq.Result{
+		Argv: []string{"q", "help"}, ExitCode: 0, StartedAt: start, EndedAt: start.Add(1200 * time.Millisecond),
+	}})
+	_, _ = m.Update(executor.EventCommandFinished{Result: execq.Result{
+		Argv: []string{"false"}, ExitCode: 2, StartedAt: start, EndedAt: start.Add(300 * time.Millisecond),
+	}})
+
+	out := m.renderAllOutput()
+	if !strings.Contains(out, "✓ 0  1.2s") {
+		t.Errorf("success badge missing, got: %q", out)
+	}
+	if !strings.Contains(out, "✗ 2  0.3s") {
+		t.Errorf("failure badge missing, got: %q", out)
+	}
+}
+
+func Test_StatusBar_ShowsTimeoutCountdown(t *testing.T) {
+	// 実行中コマンドのタイムアウトまでの残り時間が表示され、完了で消える
+	m := New()
+	_, cmd := m.Update(executor.EventCommandStarted{
+		Argv: []string{"q", "help"}, Deadline: time.Now().Add(90 * time.Second),
+	})
+	if cmd == nil {
+		t.Fatal("command start with a deadline should schedule a countdown tick")
+	}
+	if bar := m.renderStatusBar(); !strings.Contains(bar, "⏱ 1m30s") && !strings.Contains(bar, "⏱ 1m29s") {
+		t.Errorf("status bar should show the countdown, got: %s", bar)
+	}
+
+	_, _ = m.Update(executor.EventCommandFinished{Result: execq.Result{Argv: []string{"q", "help"}}})
+	if strings.Contains(m.renderStatusBar(), "⏱") {
+		t.Errorf("countdown should disappear after the command finished")
+	}
+	if _, cmd := m.Update(MsgCountdownTick{}); cmd != nil {
+		t.Errorf("countdown tick should stop once the command finished")
+	}
+}
+
+// fakeRecorder は永続化された履歴を記録するテスト用の HistoryRecorder
+type fakeRecorder struct{ entries []history.Entry }
+
+func (r *fakeRecorder) Record(text string, mode history.Mode, exit *int) error {
+	r.entries = append(r.entries, history.Entry{Text: text, Mode: mode, Exit: exit})
+	return nil
+}
+
+// runCmd は tea.Cmd を（Batch/Sequence を展開しながら）同期的に実行する
+func runCmd(cmd tea.Cmd) {
+	if cmd == nil {
+		return
+	}
+	switch msg := cmd().(type) {
+	case tea.BatchMsg:
+		for _, c := range msg {
+			runCmd(c)
+		}
+	}
+}
+
+func Test_PersistentHistory_RecordsModeAndExitCode(t *testing.T) {
+	rec := &fakeRecorder{}
+	m := New()
+	m.SetHistoryRecorder(rec, []string{"older", "previous"})
+
+	// 保存済みの履歴は ↑ で呼び出せる
+	_, _ = m.Update(keyPress(tea.KeyUp))
+	if m.input.Value() != "previous" {
+		t.Fatalf("loaded history: got %q", m.input.Value())
+	}
+	m.input.Reset()
+
+	// 短命コマンドは終了コードが届いてから記録する
+	m.input.SetValue("q help")
+	_, cmd := m.Update(keyPress(tea.KeyEnter))
+	runCmd(cmd)
+	if len(rec.entries) != 0 {
+		t.Fatalf("command should wait for its exit code, got %+v", rec.entries)
+	}
+	_, cmd = m.Update(executor.EventCommandFinished{Result: execq.Result{ExitCode: 3}})
+	runCmd(cmd)
+	if len(rec.entries) != 1 || rec.entries[0].Mode != history.ModeCommand ||
+		rec.entries[0].Exit == nil || *rec.entries[0].Exit != 3 {
+		t.Fatalf("command entry: %+v", rec.entries)
+	}
+
+	// セッション中の入力はチャットとして即座に記録する
+	m.SetMode(ModeSession)
+	m.input.SetValue("hello")
+	_, cmd = m.Update(keyPress(tea.KeyEnter))
+	runCmd(cmd)
+	if len(rec.entries) != 2 || rec.entries[1].Mode != history.ModeChat || rec.entries[1].Exit != nil {
+		t.Fatalf("chat entry: %+v", rec.entries)
+	}
+}
+
+func Test_History_Limit(t *testing.T) {
+	h := NewHistory()
+	h.SetLimit(2)
+	h.Add("a")
+	h.Add("b")
+	h.Add("c")
+	if got := h.Entries(); len(got) != 2 || got[0] != "b" || got[1] != "c" {
+		t.Fatalf("got %q", got)
+	}
+}
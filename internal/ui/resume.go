@@ -0,0 +1,183 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"qube/internal/transcript"
+)
+
+// resumePickerMaxRows はピッカーに表示する候補の最大件数
+const resumePickerMaxRows = 8
+
+// ConversationRecorder は作業ディレクトリごとの会話の記録先（conversation.Recorder が実装する）
+type ConversationRecorder interface {
+	Save(t transcript.Transcript) error
+}
+
+// SetConversationRecorder は会話の記録先を設定する
+func (m *Model) SetConversationRecorder(r ConversationRecorder) {
+	m.conversation = r
+}
+
+// saveConversation は会話の記録を保存する tea.Cmd を返す
+// 記録は Update の中で作り、書き込みだけを Bubble Tea の goroutine で行う
+func (m *Model) saveConversation() tea.Cmd {
+	if m.conversation == nil {
+		return nil
+	}
+	t := m.Transcript(time.Now())
+	rec := m.conversation
+	return func() tea.Msg {
+		if err := rec.Save(t); err != nil {
+			return MsgAddOutput{Line: "Conversation Error: " + err.Error()}
+		}
+		return nil
+	}
+}
+
+// ResumeOption は再開できる会話の候補
+type ResumeOption struct {
+	ID      string
+	Title   string // 最初の入力
+	Updated time.Time
+	Prompts int
+	Detail  string // プロファイルなどの補足（空なら表示しない）
+}
+
+// MsgRestoreConversation は保存済みの会話をスクロールバックに戻す
+// Chat が true の場合、続けて開始する q chat でスクロールバックを消さない
+type MsgRestoreConversation struct {
+	Transcript transcript.Transcript
+	Note       string // 復元した会話の後に表示するメッセージ
+	Chat       bool
+}
+
+// resumePicker は起動時に再開する会話を選ぶオーバーレイ
+// 先頭の行は「新しい会話」で、その後に保存済みの会話が新しい順に並ぶ
+type resumePicker struct {
+	options  []ResumeOption
+	selected int // 0 は新しい会話、i+1 は options[i]
+	choose   func(id string)
+}
+
+// ShowResumePicker は再開する会話を選ぶピッカーを開く
+// 選んだ会話の ID（新しい会話なら空）で choose を呼ぶ。choose は Bubble Tea の goroutine で呼ばれる
+func (m *Model) ShowResumePicker(options []ResumeOption, choose func(id string)) {
+	m.resume = &resumePicker{options: options, choose: choose}
+	if len(options) > 0 {
+		m.resume.selected = 1
+	}
+}
+
+// handleResumeKey はピッカーでのキー入力を処理する
+// ↑/↓ で選択、Enter で決定、Esc で新しい会話を始める
+func (m *Model) handleResumeKey(v tea.KeyMsg) tea.Cmd {
+	p := m.resume
+	switch {
+	case key.Matches(v, m.keys.Up, m.keys.HistoryPrev):
+		p.selected = max(p.selected-1, 0)
+		return nil
+	case key.Matches(v, m.keys.Down, m.keys.HistoryNext):
+		p.selected = min(p.selected+1, len(p.options))
+		return nil
+	case key.Matches(v, m.keys.Cancel):
+		p.selected = 0
+	case !key.Matches(v, m.keys.Accept):
+		return nil
+	}
+	m.resume = nil
+	id := ""
+	if p.selected > 0 {
+		id = p.options[p.selected-1].ID
+	}
+	return func() tea.Msg {
+		p.choose(id)
+		return nil
+	}
+}
+
+// View はピッカーを描画する
+func (p *resumePicker) View(width int, th Theme) string {
+	rows := []string{"Start a new conversation"}
+	for _, o := range p.options {
+		parts := []string{
+			o.Updated.Local().Format("2006-01-02 15:04"),
+			fmt.Sprintf("%d prompts", o.Prompts),
+			strings.ReplaceAll(o.Title, "\n", "↵"),
+		}
+		if o.Detail != "" {
+			parts = append(parts, th.Faint.Render(o.Detail))
+		}
+		rows = append(rows, strings.Join(parts, "  "))
+	}
+
+	// 選択位置が表示範囲外に出ないように先頭をずらす
+	n := min(len(rows), resumePickerMaxRows)
+	first := max(p.selected-n+1, 0)
+	var lines []string
+	for i := first; i < first+n; i++ {
+		if i == p.selected {
+			lines = append(lines, th.Selected.Render("▶")+" "+rows[i])
+		} else {
+			lines = append(lines, "  "+rows[i])
+		}
+	}
+
+	header := th.Faint.Render(fmt.Sprintf("resume a conversation in this directory  %d saved  ↑↓ select  Enter open  Esc new",
+		len(p.options)))
+	box := th.Box.
+		Border(lipgloss.RoundedBorder()).
+		Padding(0, 1).
+		Width(width - 2)
+	return box.Render(strings.Join(append([]string{header}, lines...), "\n"))
+}
+
+// restoreConversation は保存済みの会話のターンをスクロールバックに戻す
+func (m *Model) restoreConversation(v MsgRestoreConversation) {
+	var turns []Turn
+	for _, t := range v.Transcript.Turns {
+		turn := Turn{
+			Prompt:   t.Prompt,
+			Mode:     t.Mode,
+			Started:  t.Started,
+			Finished: t.Finished,
+			Status:   TurnDone,
+		}
+		if t.Status == TurnFailed.String() {
+			turn.Status = TurnFailed
+		}
+		if turn.Finished.IsZero() {
+			turn.Finished = turn.Started
+		}
+		for _, e := range t.Entries {
+			turn.Entries = append(turn.Entries, TurnEntry{Kind: entryKind(e.Kind), Text: e.Text, Time: e.Time, Result: e.Result})
+		}
+		turns = append(turns, turn)
+	}
+	m.turns.turns = append(turns, m.turns.turns...)
+	if v.Note != "" {
+		now := time.Now()
+		m.turns.turns = append(m.turns.turns, Turn{Started: now, Finished: now, Status: TurnDone,
+			Entries: []TurnEntry{{Kind: EntrySystem, Text: v.Note, Time: now}}})
+	}
+	m.keepTurns = v.Chat
+	m.updateViewportContent()
+}
+
+// entryKind は会話の記録のエントリの種類を EntryKind に戻す
+func entryKind(kind string) EntryKind {
+	switch kind {
+	case transcript.KindTool:
+		return EntryTool
+	case transcript.KindSystem:
+		return EntrySystem
+	case transcript.KindResult:
+		return EntryResult
+	}
+	return EntryOutput
+}
@@ -0,0 +1,95 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"qube/internal/executor"
+	"qube/internal/transcript"
+)
+
+func Test_ResumePicker_ChoosesConversation(t *testing.T) {
+	m := New()
+	var got []string
+	choose := func(id string) { got = append(got, id) }
+	options := []ResumeOption{
+		{ID: "new", Title: "latest question", Updated: time.Now(), Prompts: 3, Detail: "profile prod"},
+		{ID: "old", Title: "older question", Updated: time.Now().Add(-time.Hour), Prompts: 1},
+	}
+
+	// 最新の会話が選ばれた状態で開き、↓ で古い会話へ移る
+	m.ShowResumePicker(options, choose)
+	view := m.renderOverlay()
+	for _, want := range []string{"Start a new conversation", "latest question", "3 prompts", "profile prod", "2 saved"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("picker should show %q, got:\n%s", want, view)
+		}
+	}
+	m.handleKey(tea.KeyMsg{Type: tea.KeyDown})
+	runCmd(m.handleKey(tea.KeyMsg{Type: tea.KeyEnter}))
+	if m.resume != nil || len(got) != 1 || got[0] != "old" {
+		t.Fatalf("enter should choose the selected conversation: %v", got)
+	}
+
+	// Esc は新しい会話を始める
+	m.ShowResumePicker(options, choose)
+	runCmd(m.handleKey(tea.KeyMsg{Type: tea.KeyEsc}))
+	if len(got) != 2 || got[1] != "" {
+		t.Fatalf("esc should start a new conversation: %v", got)
+	}
+}
+
+func Test_RestoreConversation_SurvivesChatStart(t *testing.T) {
+	m := New()
+	t0 := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	saved := transcript.Transcript{Turns: []transcript.Turn{{
+		Prompt: "explain this", Mode: transcript.ModeChat, Started: t0, Status: "running",
+		Entries: []transcript.Entry{{Kind: transcript.KindTool, Text: "Using tool: fs_read", Time: t0}, {Kind: transcript.KindOutput, Text: "It is a TUI.", Time: t0}},
+	}}}
+	_, _ = m.Update(MsgRestoreConversation{Transcript: saved, Note: "Resumed conversation", Chat: true})
+
+	turns := m.turns.Turns()
+	if len(turns) != 2 || turns[0].Prompt != "explain this" || turns[0].Status != TurnDone || turns[0].Entries[0].Kind != EntryTool {
+		t.Fatalf("restored turns: %+v", turns)
+	}
+	if turns[1].Entries[0].Kind != EntrySystem || turns[1].Entries[0].Text != "Resumed conversation" {
+		t.Fatalf("note: %+v", turns[1])
+	}
+
+	// 続けて開始する chat ではスクロールバックを消さない（その次からは通常どおり消す）
+	m.handleExecutorEvent(executor.EventModeChanged{From: executor.ModeCommand, To: executor.ModeSession})
+	if m.turns.Len() != 2 {
+		t.Fatalf("restored conversation should survive the chat start, got %d turns", m.turns.Len())
+	}
+	m.handleExecutorEvent(executor.EventModeChanged{From: executor.ModeCommand, To: executor.ModeSession})
+	if m.turns.Len() != 0 {
+		t.Fatalf("later chat starts should clear the screen, got %d turns", m.turns.Len())
+	}
+
+	// 復元した会話も記録に含まれる
+	m.Update(MsgRestoreConversation{Transcript: saved})
+	if tr := m.Transcript(t0); tr.Prompts() != 1 || tr.Turns[0].Entries[1].Text != "It is a TUI." {
+		t.Fatalf("transcript: %+v", tr)
+	}
+}
+
+// fakeConversation は保存された会話を保持するテスト用の ConversationRecorder
+type fakeConversation struct{ saved []transcript.Transcript }
+
+func (f *fakeConversation) Save(t transcript.Transcript) error {
+	f.saved = append(f.saved, t)
+	return nil
+}
+
+func Test_Conversation_SavedOnSubmit(t *testing.T) {
+	m := New()
+	rec := &fakeConversation{}
+	m.SetConversationRecorder(rec)
+	m.input.SetValue("q help")
+	runCmd(m.handleKey(tea.KeyMsg{Type: tea.KeyEnter}))
+	if len(rec.saved) != 1 || rec.saved[0].Turns[0].Prompt != "q help" {
+		t.Fatalf("submit should save the conversation: %+v", rec.saved)
+	}
+}
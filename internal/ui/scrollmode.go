@@ -0,0 +1,204 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aymanbagabas/go-osc52/v2"
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// scrollMode はスクロールバックモード（vi のノーマル/ビジュアルモード相当）の状態
+// マウス選択に頼らずに、カーソル行を動かして行単位で選択・コピーできる
+type scrollMode struct {
+	cursor   int  // content 上のカーソル行
+	anchor   int  // ビジュアル選択の開始行（-1 なら選択なし）
+	pendingG bool // 直前に g が押されたか（gg で先頭へ移動）
+}
+
+func newScrollMode(cursor int) *scrollMode {
+	return &scrollMode{cursor: cursor, anchor: -1}
+}
+
+// visual はビジュアル選択中かどうかを返す
+func (s *scrollMode) visual() bool { return s.anchor >= 0 }
+
+// selection は選択範囲（選択がなければカーソル行）を [from, to] で返す
+func (s *scrollMode) selection() (from, to int) {
+	if !s.visual() {
+		return s.cursor, s.cursor
+	}
+	return min(s.anchor, s.cursor), max(s.anchor, s.cursor)
+}
+
+// decorate はカーソル行と選択範囲を強調した content を返す
+// 強調する行は ANSI を取り除き、背景色が行末まで届くよう width まで空白で埋める
+func (s *scrollMode) decorate(content string, width int, th Theme) string {
+	lines := strings.Split(content, "\n")
+	from, to := s.selection()
+	for i := from; i <= to && i < len(lines); i++ {
+		style := th.Selection
+		if i == s.cursor {
+			style = th.CursorLine
+		}
+		plain := ansi.Strip(lines[i])
+		if pad := width - ansi.StringWidth(plain); pad > 0 {
+			plain += strings.Repeat(" ", pad)
+		}
+		lines[i] = style.Render(plain)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// yankText は選択範囲の行を ANSI を取り除いて返す（行末の空白は削る）
+func yankText(content string, from, to int) string {
+	lines := strings.Split(content, "\n")
+	if to >= len(lines) {
+		to = len(lines) - 1
+	}
+	var out []string
+	for i := from; i <= to; i++ {
+		out = append(out, strings.TrimRight(ansi.Strip(lines[i]), " "))
+	}
+	return strings.Join(out, "\n")
+}
+
+// copyToClipboard は OSC 52 で端末のクリップボードに text をコピーする tea.Cmd を返す
+// tmux / screen の中では、それぞれのパススルー形式で送る
+func copyToClipboard(text string) tea.Cmd {
+	return func() tea.Msg {
+		seq := osc52.New(text)
+		switch {
+		case os.Getenv("TMUX") != "":
+			seq = seq.Tmux()
+		case strings.HasPrefix(os.Getenv("TERM"), "screen"):
+			seq = seq.Screen()
+		}
+		if _, err := seq.WriteTo(os.Stderr); err != nil {
+			return MsgAddOutput{Line: "Clipboard Error: " + err.Error()}
+		}
+		return nil
+	}
+}
+
+// enterScrollMode はスクロールバックモードに入り、表示中の最下行にカーソルを置く
+func (m *Model) enterScrollMode() {
+	if !m.ready {
+		return
+	}
+	last := m.viewport.YOffset + m.viewport.Height - 1
+	m.scroll = newScrollMode(max(min(last, m.viewport.TotalLineCount()-1), 0))
+	m.setViewportContent()
+}
+
+// exitScrollMode は入力欄に戻る
+func (m *Model) exitScrollMode() {
+	m.scroll = nil
+	m.notice = ""
+	if m.ready {
+		m.setViewportContent()
+	}
+}
+
+// handleScrollModeKey はスクロールバックモードでのキー入力を処理する
+func (m *Model) handleScrollModeKey(v tea.KeyMsg) tea.Cmd {
+	s := m.scroll
+	k := &m.keys
+	total := m.viewport.TotalLineCount()
+	half := max(m.viewport.Height/2, 1)
+
+	// gg: g を 2 回続けて押すと先頭へ
+	wasG := s.pendingG
+	s.pendingG = false
+
+	var cmd tea.Cmd
+	switch {
+	case key.Matches(v, k.ExitScrollMode):
+		// ビジュアル選択中の Esc は選択の解除のみ
+		if s.visual() && v.Type == tea.KeyEsc {
+			s.anchor = -1
+			break
+		}
+		m.exitScrollMode()
+		return nil
+	case key.Matches(v, k.CursorDown):
+		s.cursor++
+	case key.Matches(v, k.CursorUp):
+		s.cursor--
+	case key.Matches(v, k.CursorHalfDown):
+		s.cursor += half
+	case key.Matches(v, k.CursorHalfUp):
+		s.cursor -= half
+	case key.Matches(v, k.CursorTop):
+		if !wasG {
+			s.pendingG = true
+			return nil
+		}
+		s.cursor = 0
+	case key.Matches(v, k.CursorBottom):
+		s.cursor = total - 1
+	case key.Matches(v, k.Visual):
+		if s.visual() {
+			s.anchor = -1
+		} else {
+			s.anchor = s.cursor
+		}
+	case key.Matches(v, k.Yank):
+		from, to := s.selection()
+		text := yankText(m.buildScrollableContent(), from, to)
+		s.anchor = -1
+		m.notice = "yanked 1 line"
+		if n := to - from + 1; n > 1 {
+			m.notice = fmt.Sprintf("yanked %d lines", n)
+		}
+		cmd = m.clipboard(text)
+		m.recordFeature("yank")
+	case key.Matches(v, k.CursorPrevTurn), key.Matches(v, k.CursorNextTurn):
+		delta := 1
+		if key.Matches(v, k.CursorPrevTurn) {
+			delta = -1
+		}
+		if line, ok := m.targetTurn(s.cursor, delta); ok {
+			s.cursor = line
+			m.setViewportContent()
+			m.viewport.SetYOffset(line)
+		}
+		return nil
+	case key.Matches(v, k.ToggleTurn):
+		if i := m.turnIndexAt(s.cursor); i >= 0 {
+			m.turns.Toggle(i)
+			m.setViewportContent()
+			s.cursor = m.turnLines[i]
+			total = m.viewport.TotalLineCount()
+		}
+	case key.Matches(v, k.SearchForward):
+		m.find = newFinder()
+		m.find.query.SetKeyMap(m.keys.Editor)
+		return nil
+	default:
+		// PgUp/PgDn などのスクロールは viewport に任せ、カーソルを表示範囲に収める
+		m.viewport, cmd = m.viewport.Update(v)
+		s.cursor = max(min(s.cursor, m.viewport.YOffset+m.viewport.Height-1), m.viewport.YOffset)
+		m.setViewportContent()
+		return cmd
+	}
+
+	s.cursor = max(min(s.cursor, total-1), 0)
+	m.setViewportContent()
+	m.scrollToLine(s.cursor)
+	return cmd
+}
+
+// scrollToLine は line が表示範囲に入るよう最小限だけスクロールする
+func (m *Model) scrollToLine(line int) {
+	top := m.viewport.YOffset
+	switch {
+	case line < top:
+		m.viewport.SetYOffset(line)
+	case line >= top+m.viewport.Height:
+		m.viewport.SetYOffset(line - m.viewport.Height + 1)
+	}
+}
@@ -0,0 +1,141 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// newScrollModel は 100 行の出力を持つ、サイズ設定済みの Model を返す
+// ヤンクされた文字列は戻り値のポインタに記録される
+func newScrollModel(t *testing.T) (*Model, *string) {
+	t.Helper()
+	m := New()
+	_, _ = m.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+	for i := 0; i < 100; i++ {
+		m.AddOutput(fmt.Sprintf("\x1b[32mline %d\x1b[0m", i))
+	}
+	yanked := new(string)
+	m.clipboard = func(text string) tea.Cmd {
+		*yanked = text
+		return nil
+	}
+	return &m, yanked
+}
+
+func Test_ScrollMode_Movement(t *testing.T) {
+	m, _ := newScrollModel(t)
+	total := m.viewport.TotalLineCount()
+
+	_, _ = m.Update(keyPress(tea.KeyEsc))
+	if m.scroll == nil {
+		t.Fatal("Esc should enter scrollback mode")
+	}
+	if m.scroll.cursor != total-1 {
+		t.Fatalf("cursor should start on the last visible line, got %d of %d", m.scroll.cursor, total)
+	}
+
+	_, _ = m.Update(runes("k"))
+	_, _ = m.Update(runes("k"))
+	_, _ = m.Update(runes("j"))
+	if m.scroll.cursor != total-2 {
+		t.Fatalf("j/k: got %d", m.scroll.cursor)
+	}
+	if m.input.Value() != "" {
+		t.Fatalf("scrollback mode keys must not reach the input, got %q", m.input.Value())
+	}
+
+	_, _ = m.Update(runes("g"))
+	_, _ = m.Update(runes("g"))
+	if m.scroll.cursor != 0 || m.viewport.YOffset != 0 {
+		t.Fatalf("gg: cursor=%d offset=%d", m.scroll.cursor, m.viewport.YOffset)
+	}
+
+	_, _ = m.Update(keyPress(tea.KeyCtrlD))
+	if m.scroll.cursor != m.viewport.Height/2 {
+		t.Fatalf("ctrl+d: got %d", m.scroll.cursor)
+	}
+	_, _ = m.Update(keyPress(tea.KeyCtrlU))
+	if m.scroll.cursor != 0 {
+		t.Fatalf("ctrl+u: got %d", m.scroll.cursor)
+	}
+
+	_, _ = m.Update(runes("G"))
+	if m.scroll.cursor != total-1 || !m.viewport.AtBottom() {
+		t.Fatalf("G: cursor=%d", m.scroll.cursor)
+	}
+	if !strings.Contains(m.renderStatusBar(), "-- SCROLL --") {
+		t.Fatal("status bar should show the mode")
+	}
+
+	_, _ = m.Update(runes("i"))
+	if m.scroll != nil {
+		t.Fatal("i should return to the input")
+	}
+	_, _ = m.Update(runes("j"))
+	if m.input.Value() != "j" {
+		t.Fatalf("typing should go to the input again, got %q", m.input.Value())
+	}
+}
+
+func Test_ScrollMode_VisualYankStripsANSI(t *testing.T) {
+	m, yanked := newScrollModel(t)
+	_, _ = m.Update(keyPress(tea.KeyEsc))
+	_, _ = m.Update(runes("G"))
+	_, _ = m.Update(runes("V"))
+	_, _ = m.Update(runes("k"))
+	_, _ = m.Update(runes("k"))
+	if !strings.Contains(m.renderStatusBar(), "VISUAL") {
+		t.Fatal("status bar should show visual mode")
+	}
+
+	_, _ = m.Update(runes("y"))
+	if *yanked != "line 97\nline 98\nline 99" {
+		t.Fatalf("yanked %q", *yanked)
+	}
+	if m.scroll.visual() {
+		t.Fatal("yank should end the selection")
+	}
+	if !strings.Contains(m.renderStatusBar(), "yanked 3 lines") {
+		t.Fatalf("status bar should confirm the yank: %q", m.renderStatusBar())
+	}
+
+	// 選択なしの y はカーソル行をヤンクする
+	_, _ = m.Update(runes("y"))
+	if *yanked != "line 97" {
+		t.Fatalf("yanked %q", *yanked)
+	}
+
+	// ビジュアル選択中の Esc は選択だけを解除する
+	_, _ = m.Update(runes("V"))
+	_, _ = m.Update(keyPress(tea.KeyEsc))
+	if m.scroll == nil || m.scroll.visual() {
+		t.Fatal("Esc in visual mode should only clear the selection")
+	}
+	_, _ = m.Update(keyPress(tea.KeyEsc))
+	if m.scroll != nil {
+		t.Fatal("second Esc should return to the input")
+	}
+}
+
+func Test_ScrollMode_SlashSearchMovesCursor(t *testing.T) {
+	m, _ := newScrollModel(t)
+	_, _ = m.Update(keyPress(tea.KeyEsc))
+	_, _ = m.Update(runes("/"))
+	if m.find == nil {
+		t.Fatal("/ should open the find bar in scrollback mode")
+	}
+	_, _ = m.Update(runes("line 42"))
+	_, _ = m.Update(keyPress(tea.KeyEnter))
+	match, _ := m.find.Current()
+	if m.scroll.cursor != match.line {
+		t.Fatalf("cursor should follow the match: cursor=%d match=%d", m.scroll.cursor, match.line)
+	}
+	// 検索を閉じてもスクロールバックモードは続く
+	_, _ = m.Update(keyPress(tea.KeyEsc))
+	if m.find != nil || m.scroll == nil {
+		t.Fatal("Esc should close only the find bar")
+	}
+}
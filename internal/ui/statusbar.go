@@ -0,0 +1,307 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// StatusSegment はステータスバーに表示する要素の名前
+type StatusSegment string
+
+const (
+	SegmentMode       StatusSegment = "mode"       // Cmd / Chat
+	SegmentStatus     StatusSegment = "status"     // Ready / Running / Error
+	SegmentErrors     StatusSegment = "errors"     // エラー数
+	SegmentIndicators StatusSegment = "indicators" // スクロールバックモード・通知・検索件数・タイムアウト
+	SegmentModel      StatusSegment = "model"      // Q の使用モデル
+	SegmentElapsed    StatusSegment = "elapsed"    // 現在（直前）のターンの経過時間
+	SegmentUptime     StatusSegment = "uptime"     // 起動からの経過時間
+	SegmentCwd        StatusSegment = "cwd"        // 作業ディレクトリ
+	SegmentVersion    StatusSegment = "version"    // Q CLI のバージョン
+	SegmentScroll     StatusSegment = "scroll"     // スクロール位置
+	SegmentHelp       StatusSegment = "help"       // キー操作のヒント
+)
+
+// statusSegmentPriority は幅が足りない場合に残す優先度（大きいほど最後まで残る）
+var statusSegmentPriority = map[StatusSegment]int{
+	SegmentIndicators: 100,
+	SegmentMode:       90,
+	SegmentStatus:     85,
+	SegmentErrors:     80,
+	SegmentElapsed:    70,
+	SegmentModel:      60,
+	SegmentScroll:     50,
+	SegmentCwd:        40,
+	SegmentUptime:     30,
+	SegmentVersion:    20,
+	SegmentHelp:       10,
+}
+
+// DefaultStatusSegments は既定のステータスバーの並び
+func DefaultStatusSegments() []StatusSegment {
+	return []StatusSegment{
+		SegmentMode, SegmentStatus, SegmentErrors, SegmentIndicators,
+		SegmentModel, SegmentElapsed, SegmentUptime, SegmentCwd, SegmentVersion,
+		SegmentScroll, SegmentHelp,
+	}
+}
+
+// StatusSegmentNames は指定できるセグメント名の一覧を返す
+func StatusSegmentNames() []string {
+	var names []string
+	for _, s := range DefaultStatusSegments() {
+		names = append(names, string(s))
+	}
+	return names
+}
+
+// ParseStatusSegments はカンマ区切りのセグメント名（例: "mode,status,model"）を解釈する
+// 空文字列の場合は既定の並びを返す
+func ParseStatusSegments(spec string) ([]StatusSegment, error) {
+	if strings.TrimSpace(spec) == "" {
+		return DefaultStatusSegments(), nil
+	}
+	var segs []StatusSegment
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		seg := StatusSegment(name)
+		if _, ok := statusSegmentPriority[seg]; !ok {
+			return nil, fmt.Errorf("unknown status bar segment %q (available: %s)", name, strings.Join(StatusSegmentNames(), ", "))
+		}
+		segs = append(segs, seg)
+	}
+	return segs, nil
+}
+
+// MsgSetQModel は Q の使用モデル（起動バナーから取得）を設定する
+type MsgSetQModel struct{ Name string }
+
+// MsgSetQVersion は Q CLI のバージョンを設定する
+type MsgSetQVersion struct{ Version string }
+
+// MsgStatusTick は経過時間などの時刻に依存する表示を更新する
+type MsgStatusTick struct{}
+
+// statusTick は 1 秒後にステータスバーを更新する tea.Cmd を返す
+func statusTick() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg { return MsgStatusTick{} })
+}
+
+// SetStatusSegments はステータスバーに表示するセグメントと並び順を設定する
+func (m *Model) SetStatusSegments(segs []StatusSegment) {
+	m.statusSegments = append([]StatusSegment(nil), segs...)
+}
+
+// SetQModel は Q の使用モデル名を設定する
+func (m *Model) SetQModel(name string) {
+	m.qModel = strings.TrimSpace(name)
+}
+
+// SetQVersion は Q CLI のバージョンを設定する
+func (m *Model) SetQVersion(version string) {
+	m.qVersion = strings.TrimSpace(version)
+}
+
+// SetWorkingDir はステータスバーに表示する作業ディレクトリを設定する
+// ホームディレクトリ配下は ~ で省略する
+func (m *Model) SetWorkingDir(dir string) {
+	if home, err := os.UserHomeDir(); err == nil && home != "" {
+		if rel, err := filepath.Rel(home, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			if rel == "." {
+				dir = "~"
+			} else {
+				dir = filepath.Join("~", rel)
+			}
+		}
+	}
+	m.cwd = dir
+}
+
+// needsStatusTick は時刻に依存するセグメントを表示しているかを返す
+func (m Model) needsStatusTick() bool {
+	for _, s := range m.statusSegments {
+		if s == SegmentElapsed || s == SegmentUptime {
+			return true
+		}
+	}
+	return false
+}
+
+// statusPart はステータスバーの 1 要素
+// short は幅が足りない場合に使う短い表記（空なら短縮せずに省く）
+type statusPart struct {
+	text     string
+	short    string
+	priority int
+}
+
+// statusParts は設定された並びに従ってステータスバーの要素を組み立てる
+// 値のないセグメント（モデル未検出など）は含めない
+func (m Model) statusParts(now time.Time) []statusPart {
+	var parts []statusPart
+	add := func(seg StatusSegment, text, short string) {
+		if text != "" {
+			parts = append(parts, statusPart{text: text, short: short, priority: statusSegmentPriority[seg]})
+		}
+	}
+
+	segs := m.statusSegments
+	// スクロールバックモードや検索の状態は操作に必要なため、並びに無くても先頭に表示する
+	hasIndicators := false
+	for _, s := range segs {
+		hasIndicators = hasIndicators || s == SegmentIndicators
+	}
+	if !hasIndicators {
+		segs = append([]StatusSegment{SegmentIndicators}, segs...)
+	}
+
+	for _, seg := range segs {
+		switch seg {
+		case SegmentMode:
+			add(seg, "Mode:"+m.modeStringShort(), m.modeStringShort())
+		case SegmentStatus:
+			add(seg, "Status:"+m.statusStringShort(), m.statusStringShort())
+		case SegmentErrors:
+			add(seg, fmt.Sprintf("Errors:%d", m.errorCount), fmt.Sprintf("E:%d", m.errorCount))
+		case SegmentIndicators:
+			for _, text := range m.statusIndicators(now) {
+				add(seg, text, "")
+			}
+		case SegmentModel:
+			if m.qModel != "" {
+				add(seg, "Model:"+m.qModel, m.qModel)
+			}
+		case SegmentElapsed:
+			if t := m.turns.Current(); t != nil && !t.IsPreamble() {
+				add(seg, "⏲ "+formatDuration(t.Elapsed(now)), "")
+			}
+		case SegmentUptime:
+			if !m.startedAt.IsZero() {
+				add(seg, "Up "+formatDuration(now.Sub(m.startedAt)), "")
+			}
+		case SegmentCwd:
+			if m.cwd != "" {
+				add(seg, m.cwd, filepath.Base(m.cwd))
+			}
+		case SegmentVersion:
+			if m.qVersion != "" {
+				add(seg, "Q "+m.qVersion, "")
+			}
+		case SegmentScroll:
+			add(seg, m.scrollPosition(), "")
+		case SegmentHelp:
+			add(seg, m.keys.ShortHelp(), m.keys.CompactHelp())
+		}
+	}
+	return parts
+}
+
+// statusIndicators はスクロールバックモード・通知・検索件数・タイムアウトの表示を返す
+func (m Model) statusIndicators(now time.Time) []string {
+	var out []string
+	if m.scroll != nil {
+		label := "-- SCROLL --"
+		if m.scroll.visual() {
+			label = "-- VISUAL LINE --"
+		}
+		out = append(out, label)
+	}
+	if m.notice != "" {
+		out = append(out, m.notice)
+	}
+	// スクロールバック内検索の一致数
+	if m.find != nil {
+		if counter := m.find.Counter(); counter != "" {
+			out = append(out, "🔍 "+counter)
+		}
+	}
+	// 実行中コマンドのタイムアウトまでの残り時間
+	if !m.deadline.IsZero() {
+		remaining := m.deadline.Sub(now).Round(time.Second)
+		if remaining < 0 {
+			remaining = 0
+		}
+		out = append(out, fmt.Sprintf("⏱ %s", remaining))
+	}
+	return out
+}
+
+// scrollPosition は viewport のスクロール位置の表示を返す（viewport 初期化前は空）
+func (m Model) scrollPosition() string {
+	if !m.ready {
+		return ""
+	}
+	scrollPercent := m.viewport.ScrollPercent()
+	switch {
+	case scrollPercent <= 0.0:
+		return "[⬆ TOP]"
+	case scrollPercent >= 1.0:
+		return "[⬇ BOTTOM]"
+	default:
+		return fmt.Sprintf("[📜 %.0f%%]", scrollPercent*100)
+	}
+}
+
+// fitStatusParts は要素を幅に収まるよう連結する
+// 収まらない場合は優先度の低い要素から短い表記に切り替え、それでも足りなければ省く
+func fitStatusParts(parts []statusPart, width int) string {
+	const sep = "  "
+	join := func() string {
+		texts := make([]string, 0, len(parts))
+		for _, p := range parts {
+			texts = append(texts, p.text)
+		}
+		return strings.Join(texts, sep)
+	}
+	line := join()
+	if width <= 0 {
+		return line
+	}
+	for lipgloss.Width(line) > width && len(parts) > 1 {
+		// 最も優先度の低い要素（同じ優先度なら後ろのもの）を選ぶ
+		lowest := 0
+		for i, p := range parts {
+			if p.priority <= parts[lowest].priority {
+				lowest = i
+			}
+		}
+		if p := &parts[lowest]; p.short != "" && p.short != p.text {
+			p.text = p.short
+			p.short = ""
+		} else {
+			parts = append(parts[:lowest], parts[lowest+1:]...)
+		}
+		line = join()
+	}
+	if lipgloss.Width(line) > width {
+		line = ansi.Truncate(line, width, "…")
+	}
+	return line
+}
+
+// formatDuration は経過時間を "4.2s" / "3m05s" / "1h02m" の形式で表示する
+func formatDuration(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	case d < time.Hour:
+		d = d.Round(time.Second)
+		return fmt.Sprintf("%dm%02ds", int(d/time.Minute), int(d%time.Minute/time.Second))
+	default:
+		d = d.Round(time.Minute)
+		return fmt.Sprintf("%dh%02dm", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	}
+}
@@ -0,0 +1,92 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+func Test_StatusBar_Segments(t *testing.T) {
+	m := New()
+	m.width = 200
+	m.startedAt = time.Now().Add(-90 * time.Second)
+	m.cwd = "~/src/qube"
+	_, _ = m.Update(MsgSetQModel{Name: "claude-sonnet-4"})
+	_, _ = m.Update(MsgSetQVersion{Version: "1.12.1"})
+	m.AddUserInput("hello")
+
+	bar := m.renderStatusBar()
+	for _, want := range []string{"Model:claude-sonnet-4", "⏲ ", "Up 1m3", "~/src/qube", "Q 1.12.1", "^C Exit"} {
+		if !strings.Contains(bar, want) {
+			t.Errorf("status bar should contain %q: %s", want, bar)
+		}
+	}
+	if strings.Index(bar, "Mode:") > strings.Index(bar, "Model:") {
+		t.Errorf("segments should follow the configured order: %s", bar)
+	}
+}
+
+func Test_StatusBar_CollapsesOnNarrowTerminals(t *testing.T) {
+	m := New()
+	m.cwd = "~/src/qube"
+	m.qModel = "claude-sonnet-4"
+	m.qVersion = "1.12.1"
+
+	m.width = 60
+	bar := m.renderStatusBar()
+	if w := lipgloss.Width(bar); w > 60 {
+		t.Fatalf("status bar should fit in 60 columns, got %d: %s", w, bar)
+	}
+	// 優先度の高い要素は残り、低いものから省かれる
+	if !strings.Contains(bar, "Mode:Cmd") || !strings.Contains(bar, "claude-sonnet-4") {
+		t.Errorf("high priority segments should stay: %s", bar)
+	}
+	if strings.Contains(bar, "Mouse Wheel") || strings.Contains(bar, "Q 1.12.1") {
+		t.Errorf("low priority segments should collapse first: %s", bar)
+	}
+
+	m.width = 12
+	if w := lipgloss.Width(m.renderStatusBar()); w > 12 {
+		t.Fatalf("status bar should be truncated to the terminal width, got %d", w)
+	}
+}
+
+func Test_StatusBar_CustomLayout(t *testing.T) {
+	segs, err := ParseStatusSegments("model, mode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New()
+	_, _ = m.Update(tea.WindowSizeMsg{Width: 200, Height: 24})
+	m.qModel = "claude-sonnet-4"
+	m.SetStatusSegments(segs)
+	if got := m.renderStatusBar(); !strings.HasPrefix(got, "Model:claude-sonnet-4  Mode:Cmd") || strings.Contains(got, "Errors") {
+		t.Fatalf("only the configured segments should be shown: %q", got)
+	}
+
+	// 並びに無くてもスクロールバックモードの表示は残る
+	m.enterScrollMode()
+	if got := m.renderStatusBar(); !strings.HasPrefix(got, "-- SCROLL --") {
+		t.Fatalf("indicators should always be shown: %q", got)
+	}
+
+	if _, err := ParseStatusSegments("mode,clock"); err == nil || !strings.Contains(err.Error(), "clock") {
+		t.Fatalf("unknown segment should be rejected: %v", err)
+	}
+}
+
+func Test_FormatDuration(t *testing.T) {
+	cases := map[time.Duration]string{
+		4200 * time.Millisecond:        "4.2s",
+		3*time.Minute + 5*time.Second:  "3m05s",
+		time.Hour + 2*time.Minute + 10: "1h02m",
+	}
+	for d, want := range cases {
+		if got := formatDuration(d); got != want {
+			t.Errorf("formatDuration(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
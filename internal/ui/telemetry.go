@@ -0,0 +1,21 @@
+package ui
+
+import "qube/internal/telemetry"
+
+// Telemetry は UI の利用状況の記録先（telemetry.Recorder が実装する）
+type Telemetry interface {
+	Record(ev telemetry.Event)
+}
+
+// SetTelemetry は利用状況の記録先を設定する（nil なら記録しない）
+func (m *Model) SetTelemetry(t Telemetry) {
+	m.telemetry = t
+}
+
+// recordFeature は UI の機能（ヘルプ・検索・スクロールバックモードなど）の利用を記録する
+// 入力や表示中の内容は記録しない
+func (m *Model) recordFeature(feature string) {
+	if m.telemetry != nil {
+		m.telemetry.Record(telemetry.Event{Name: "ui." + feature})
+	}
+}
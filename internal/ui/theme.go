@@ -0,0 +1,312 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+// ThemeSpec はテーマの色定義
+// 色は 256 色パレットの番号（"165"）または 16 進表記（"#af00ff"）で指定する。
+// 空の項目は Base に指定した組み込みテーマ（省略時は dark）の値を引き継ぐため、
+// ユーザー定義テーマでは変えたい色だけを書けばよい
+type ThemeSpec struct {
+	Name string `json:"name"`
+	Base string `json:"base,omitempty"`
+
+	Accent     string   `json:"accent,omitempty"`     // ユーザー入力・強調表示
+	Border     string   `json:"border,omitempty"`     // 枠線
+	Success    string   `json:"success,omitempty"`    // 成功・接続済み
+	Error      string   `json:"error,omitempty"`      // 失敗・拒否
+	Warning    string   `json:"warning,omitempty"`    // 確認待ち
+	Progress   string   `json:"progress,omitempty"`   // Thinking... / Connecting...
+	MatchFg    string   `json:"matchFg,omitempty"`    // 検索一致の文字色
+	MatchBg    string   `json:"matchBg,omitempty"`    // 検索一致の背景色
+	CurrentFg  string   `json:"currentFg,omitempty"`  // 現在の検索一致の文字色
+	CurrentBg  string   `json:"currentBg,omitempty"`  // 現在の検索一致の背景色
+	CursorLine string   `json:"cursorLine,omitempty"` // スクロールバックモードのカーソル行の背景色
+	Selection  string   `json:"selection,omitempty"`  // ビジュアル選択の背景色
+	Logo       []string `json:"logo,omitempty"`       // ASCII ロゴのグラデーション（上の行から順に循環）
+	BoldAccent bool     `json:"boldAccent,omitempty"` // 強調表示を太字にする
+}
+
+// Theme は UI 全体で使うスタイルの集合
+// 描画処理は色を直接指定せず、必ず Theme のスタイルを使う
+type Theme struct {
+	Name string
+
+	UserInput    lipgloss.Style // 送信したユーザー入力のテキスト
+	Box          lipgloss.Style // ユーザー入力・オーバーレイの枠線
+	Connected    lipgloss.Style
+	Connecting   lipgloss.Style
+	Progress     lipgloss.Style // Thinking... のアニメーション
+	Faint        lipgloss.Style // 補助的な情報（ステータスバー・ヘルプ・進捗）
+	Success      lipgloss.Style // 完了バッジ（終了コード 0）
+	Failure      lipgloss.Style // 完了バッジ（非ゼロ終了）、ポリシーの拒否
+	Warning      lipgloss.Style // ポリシーの確認待ち
+	Highlight    lipgloss.Style // 履歴検索の一致文字、有効なトグル
+	Selected     lipgloss.Style // 履歴検索の選択行
+	Match        lipgloss.Style // スクロールバック内検索の一致
+	CurrentMatch lipgloss.Style // スクロールバック内検索の現在の一致
+	CursorLine   lipgloss.Style // スクロールバックモードのカーソル行
+	Selection    lipgloss.Style // スクロールバックモードのビジュアル選択
+	Logo         []lipgloss.Style
+}
+
+// 組み込みテーマ
+var builtinThemes = map[string]ThemeSpec{
+	// dark は従来の配色（紫と青の組み合わせ）
+	"dark": {
+		Name:       "dark",
+		Accent:     "165",
+		Border:     "93",
+		Success:    "10",
+		Error:      "9",
+		Warning:    "11",
+		Progress:   "13",
+		MatchFg:    "15",
+		MatchBg:    "93",
+		CurrentFg:  "0",
+		CurrentBg:  "11",
+		CursorLine: "237",
+		Selection:  "54",
+		Logo:       []string{"165", "129", "93", "57", "21", "90", "126"},
+	},
+	// light は明るい背景でも読めるよう、彩度を保ったまま暗めの色を使う
+	"light": {
+		Name:       "light",
+		Accent:     "90",
+		Border:     "25",
+		Success:    "28",
+		Error:      "160",
+		Warning:    "130",
+		Progress:   "127",
+		MatchFg:    "15",
+		MatchBg:    "25",
+		CurrentFg:  "0",
+		CurrentBg:  "214",
+		CursorLine: "254",
+		Selection:  "189",
+		Logo:       []string{"90", "91", "55", "54", "19", "18", "89"},
+	},
+	// high-contrast は基本 16 色の明るい色と太字だけで構成する
+	"high-contrast": {
+		Name:       "high-contrast",
+		Accent:     "14",
+		Border:     "15",
+		Success:    "10",
+		Error:      "9",
+		Warning:    "11",
+		Progress:   "11",
+		MatchFg:    "0",
+		MatchBg:    "14",
+		CurrentFg:  "0",
+		CurrentBg:  "11",
+		CursorLine: "8",
+		Selection:  "4",
+		Logo:       []string{"15"},
+		BoldAccent: true,
+	},
+}
+
+// ThemeNames は組み込みテーマの名前（"auto" を含む）を返す
+func ThemeNames() []string {
+	names := []string{"auto"}
+	for name := range builtinThemes {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// DefaultTheme は従来の配色（dark）のテーマを返す
+func DefaultTheme() Theme {
+	return builtinThemes["dark"].Theme()
+}
+
+var colorPattern = regexp.MustCompile(`^(#[0-9a-fA-F]{3}|#[0-9a-fA-F]{6}|[0-9]{1,3})$`)
+
+// Validate は色の指定が正しいかを検査する
+func (s ThemeSpec) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("theme has no name")
+	}
+	if s.Base != "" {
+		if _, ok := builtinThemes[s.Base]; !ok {
+			return fmt.Errorf("theme %q: unknown base theme %q", s.Name, s.Base)
+		}
+	}
+	fields := map[string]string{
+		"accent": s.Accent, "border": s.Border, "success": s.Success, "error": s.Error,
+		"warning": s.Warning, "progress": s.Progress, "matchFg": s.MatchFg, "matchBg": s.MatchBg,
+		"currentFg": s.CurrentFg, "currentBg": s.CurrentBg, "cursorLine": s.CursorLine, "selection": s.Selection,
+	}
+	for i, c := range s.Logo {
+		fields[fmt.Sprintf("logo[%d]", i)] = c
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := ValidateColor(fields[k]); err != nil {
+			return fmt.Errorf("theme %q: %s: %w", s.Name, k, err)
+		}
+	}
+	return nil
+}
+
+// ValidateColor は色の指定（0-255 または #rrggbb、空は未指定）が正しいかを検査する
+func ValidateColor(c string) error {
+	if c == "" {
+		return nil
+	}
+	if !colorPattern.MatchString(c) {
+		return fmt.Errorf("invalid color %q (want 0-255 or #rrggbb)", c)
+	}
+	if c[0] != '#' {
+		if n, _ := strconv.Atoi(c); n > 255 {
+			return fmt.Errorf("invalid color %q (want 0-255 or #rrggbb)", c)
+		}
+	}
+	return nil
+}
+
+// merged は Base の値で空の項目を埋めたテーマ定義を返す
+func (s ThemeSpec) merged() ThemeSpec {
+	baseName := s.Base
+	if baseName == "" {
+		baseName = "dark"
+	}
+	base := builtinThemes[baseName]
+	pick := func(v, fallback string) string {
+		if v == "" {
+			return fallback
+		}
+		return v
+	}
+	out := base
+	out.Name = s.Name
+	out.Accent = pick(s.Accent, base.Accent)
+	out.Border = pick(s.Border, base.Border)
+	out.Success = pick(s.Success, base.Success)
+	out.Error = pick(s.Error, base.Error)
+	out.Warning = pick(s.Warning, base.Warning)
+	out.Progress = pick(s.Progress, base.Progress)
+	out.MatchFg = pick(s.MatchFg, base.MatchFg)
+	out.MatchBg = pick(s.MatchBg, base.MatchBg)
+	out.CurrentFg = pick(s.CurrentFg, base.CurrentFg)
+	out.CurrentBg = pick(s.CurrentBg, base.CurrentBg)
+	out.CursorLine = pick(s.CursorLine, base.CursorLine)
+	out.Selection = pick(s.Selection, base.Selection)
+	if len(s.Logo) > 0 {
+		out.Logo = s.Logo
+	}
+	out.BoldAccent = s.BoldAccent || base.BoldAccent
+	return out
+}
+
+// Theme はテーマ定義からスタイルを組み立てる
+func (s ThemeSpec) Theme() Theme {
+	s = s.merged()
+	fg := func(c string) lipgloss.Style { return lipgloss.NewStyle().Foreground(lipgloss.Color(c)) }
+
+	th := Theme{
+		Name:       s.Name,
+		UserInput:  fg(s.Accent).Bold(s.BoldAccent),
+		Box:        lipgloss.NewStyle().BorderForeground(lipgloss.Color(s.Border)),
+		Connected:  fg(s.Success),
+		Connecting: fg(s.Progress),
+		Progress:   fg(s.Progress),
+		Faint:      lipgloss.NewStyle().Faint(true),
+		Success:    fg(s.Success),
+		Failure:    fg(s.Error),
+		Warning:    fg(s.Warning),
+		Highlight:  fg(s.Accent).Bold(true),
+		Selected:   lipgloss.NewStyle().Reverse(true),
+		Match:      lipgloss.NewStyle().Foreground(lipgloss.Color(s.MatchFg)).Background(lipgloss.Color(s.MatchBg)),
+		CurrentMatch: lipgloss.NewStyle().
+			Foreground(lipgloss.Color(s.CurrentFg)).
+			Background(lipgloss.Color(s.CurrentBg)),
+		CursorLine: lipgloss.NewStyle().Background(lipgloss.Color(s.CursorLine)),
+		Selection:  lipgloss.NewStyle().Background(lipgloss.Color(s.Selection)),
+	}
+	for _, c := range s.Logo {
+		th.Logo = append(th.Logo, fg(c))
+	}
+	return th
+}
+
+// MonochromeTheme は色を使わず、太字・反転・下線などの属性だけで区別するテーマを返す
+// NO_COLOR が設定されている場合や、色を表示できない端末で使う
+func MonochromeTheme() Theme {
+	plain := lipgloss.NewStyle()
+	return Theme{
+		Name:         "monochrome",
+		UserInput:    plain.Bold(true),
+		Box:          plain,
+		Connected:    plain.Bold(true),
+		Connecting:   plain,
+		Progress:     plain.Italic(true),
+		Faint:        plain.Faint(true),
+		Success:      plain,
+		Failure:      plain.Bold(true),
+		Warning:      plain.Bold(true),
+		Highlight:    plain.Bold(true).Underline(true),
+		Selected:     plain.Reverse(true),
+		Match:        plain.Underline(true),
+		CurrentMatch: plain.Reverse(true),
+		CursorLine:   plain.Reverse(true),
+		Selection:    plain.Underline(true),
+		Logo:         []lipgloss.Style{plain},
+	}
+}
+
+// NoColor は NO_COLOR（https://no-color.org/）が設定されているか、
+// 端末が色を表示できない場合に true を返す
+func NoColor() bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return true
+	}
+	return lipgloss.ColorProfile() == termenv.Ascii
+}
+
+// ResolveTheme は名前からテーマを決める
+// "auto"（または空）は端末の背景色から dark / light を選ぶ。
+// custom のテーマは組み込みテーマより優先する。NO_COLOR の場合は常にモノクロになる
+func ResolveTheme(name string, custom []ThemeSpec) (Theme, error) {
+	if NoColor() {
+		return MonochromeTheme(), nil
+	}
+	return resolveTheme(name, custom, lipgloss.HasDarkBackground)
+}
+
+// resolveTheme は端末の判定を差し替えられる ResolveTheme の本体
+func resolveTheme(name string, custom []ThemeSpec, hasDarkBackground func() bool) (Theme, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || name == "auto" {
+		name = "light"
+		if hasDarkBackground() {
+			name = "dark"
+		}
+	}
+	for _, spec := range custom {
+		if strings.EqualFold(spec.Name, name) {
+			if err := spec.Validate(); err != nil {
+				return DefaultTheme(), err
+			}
+			return spec.Theme(), nil
+		}
+	}
+	if spec, ok := builtinThemes[name]; ok {
+		return spec.Theme(), nil
+	}
+	return DefaultTheme(), fmt.Errorf("unknown theme %q (available: %s)", name, strings.Join(ThemeNames(), ", "))
+}
@@ -0,0 +1,85 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+func Test_DefaultTheme_KeepsOriginalColors(t *testing.T) {
+	th := DefaultTheme()
+	if th.UserInput.GetForeground() != lipgloss.Color("165") {
+		t.Fatalf("user input color: %v", th.UserInput.GetForeground())
+	}
+	if th.Box.GetBorderTopForeground() != lipgloss.Color("93") {
+		t.Fatalf("border color: %v", th.Box.GetBorderTopForeground())
+	}
+	if len(th.Logo) != 7 {
+		t.Fatalf("logo gradient: %d colors", len(th.Logo))
+	}
+}
+
+func Test_ResolveTheme(t *testing.T) {
+	dark := func() bool { return true }
+	light := func() bool { return false }
+
+	if th, _ := resolveTheme("auto", nil, light); th.Name != "light" {
+		t.Fatalf("auto on a light terminal: %q", th.Name)
+	}
+	if th, _ := resolveTheme("", nil, dark); th.Name != "dark" {
+		t.Fatalf("auto on a dark terminal: %q", th.Name)
+	}
+	if th, _ := resolveTheme("High-Contrast", nil, dark); th.Name != "high-contrast" {
+		t.Fatalf("built-in by name: %q", th.Name)
+	}
+
+	// ユーザー定義テーマは base の値を引き継ぎ、指定した色だけを上書きする
+	custom := []ThemeSpec{{Name: "mine", Base: "light", Accent: "#ff8800"}}
+	th, err := resolveTheme("mine", custom, dark)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if th.UserInput.GetForeground() != lipgloss.Color("#ff8800") {
+		t.Fatalf("custom accent: %v", th.UserInput.GetForeground())
+	}
+	if th.Failure.GetForeground() != lipgloss.Color("160") {
+		t.Fatalf("inherited error color: %v", th.Failure.GetForeground())
+	}
+
+	if _, err := resolveTheme("solarized", nil, dark); err == nil || !strings.Contains(err.Error(), "available") {
+		t.Fatalf("unknown theme should list the available ones: %v", err)
+	}
+}
+
+func Test_ThemeSpec_Validate(t *testing.T) {
+	cases := map[string]ThemeSpec{
+		"no name":      {Accent: "1"},
+		"bad base":     {Name: "x", Base: "nope"},
+		"out of range": {Name: "x", Border: "300"},
+		"bad hex":      {Name: "x", Logo: []string{"#12"}},
+		"named color":  {Name: "x", Success: "green"},
+	}
+	for name, spec := range cases {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if err := (ThemeSpec{Name: "ok", Accent: "#abc", Border: "255"}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_ResolveTheme_RespectsNoColor(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	th, err := ResolveTheme("dark", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if th.Name != "monochrome" {
+		t.Fatalf("NO_COLOR should force the monochrome theme, got %q", th.Name)
+	}
+	if _, ok := th.UserInput.GetForeground().(lipgloss.NoColor); !ok {
+		t.Fatal("monochrome theme must not set colors")
+	}
+}
@@ -0,0 +1,96 @@
+package ui
+
+import (
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"qube/internal/transcript"
+)
+
+// Exporter は会話の記録を書き出す（/qube save）
+// path が空の場合は設定の既定の書き出し先を使い、書き出したファイルのパスを返す
+type Exporter interface {
+	Export(t transcript.Transcript, path string) (string, error)
+}
+
+// MsgExport は会話の記録の書き出しを要求する（/qube save [path]）
+type MsgExport struct{ Path string }
+
+// SetExporter は /qube save の書き出し先を設定する
+func (m *Model) SetExporter(e Exporter) {
+	m.exporter = e
+}
+
+// inputMode は入力の種類（チャット・短命コマンド・スラッシュコマンド）を返す
+func (m *Model) inputMode(input string) string {
+	switch {
+	case strings.HasPrefix(input, "/"):
+		return transcript.ModeQube
+	case m.mode == ModeSession:
+		return transcript.ModeChat
+	}
+	return transcript.ModeCommand
+}
+
+// finishChatTurn は実行中のチャットのターンを完了にして会話を保存する
+// 経過時間の表示と書き出しは、ここで応答の終わりを知る（次の入力を待たない）
+func (m *Model) finishChatTurn() tea.Cmd {
+	t := m.turns.Current()
+	if t == nil || t.Status != TurnRunning || t.Mode != transcript.ModeChat {
+		return nil
+	}
+	m.turns.Finish(TurnDone, time.Now())
+	return m.saveConversation()
+}
+
+// Transcript はスクロールバックのターンを会話の記録として返す
+// 進捗表示（Thinking... 等）は含めず、出力の ANSI エスケープは取り除く
+func (m *Model) Transcript(now time.Time) transcript.Transcript {
+	t := transcript.Transcript{
+		Version:  transcript.FormatVersion,
+		Exported: now,
+		Profile:  m.profile,
+		Model:    m.qModel,
+		QVersion: m.qVersion,
+		Turns:    []transcript.Turn{},
+	}
+	for _, turn := range m.turns.Turns() {
+		out := transcript.Turn{
+			Prompt:   turn.Prompt,
+			Mode:     turn.Mode,
+			Started:  turn.Started,
+			Finished: turn.Finished,
+			Status:   turn.Status.String(),
+			Entries:  []transcript.Entry{},
+		}
+		for _, e := range turn.visibleEntries() {
+			out.Entries = append(out.Entries, transcript.Entry{
+				Kind:   e.Kind.String(),
+				Text:   transcript.Clean(e.Text),
+				Time:   e.Time,
+				Result: e.Result,
+			})
+		}
+		t.Turns = append(t.Turns, out)
+	}
+	return t
+}
+
+// export は会話の記録を書き出す tea.Cmd を返す
+// 記録は Update の中で作り、書き出し（ファイル I/O）だけを Bubble Tea の goroutine で行う
+func (m *Model) export(path string) tea.Cmd {
+	if m.exporter == nil {
+		m.addEntry(EntrySystem, "Save Error: exporting is not available")
+		return nil
+	}
+	t := m.Transcript(time.Now())
+	exporter := m.exporter
+	return func() tea.Msg {
+		saved, err := exporter.Export(t, path)
+		if err != nil {
+			return MsgAddOutput{Line: "Save Error: " + err.Error()}
+		}
+		return MsgAddOutput{Line: "Saved conversation to " + saved}
+	}
+}
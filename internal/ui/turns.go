@@ -0,0 +1,207 @@
+package ui
+
+import (
+	"strings"
+	"time"
+
+	"qube/internal/transcript"
+)
+
+// EntryKind はターン内のエントリの種類
+type EntryKind int
+
+const (
+	EntryOutput   EntryKind = iota // Q の応答・コマンドの出力
+	EntryTool                      // ツール実行の通知（"Using tool: ..."）
+	EntryProgress                  // 進捗表示（Thinking... 等）。記録のみで通常は描画しない
+	EntrySystem                    // Qube 自身のメッセージ（ポリシー判定・エラー等）
+	EntryResult                    // 短命コマンドの完了バッジ
+)
+
+func (k EntryKind) String() string {
+	switch k {
+	case EntryOutput:
+		return "output"
+	case EntryTool:
+		return "tool"
+	case EntryProgress:
+		return "progress"
+	case EntrySystem:
+		return "system"
+	case EntryResult:
+		return "result"
+	}
+	return "unknown"
+}
+
+// TurnEntry はターン内の 1 件の出力
+type TurnEntry struct {
+	Kind EntryKind
+	Text string
+	Time time.Time
+	// Result は短命コマンドの完了情報（EntryResult のみ。会話の記録に使う）
+	Result *transcript.Result
+}
+
+// TurnStatus はターンの状態
+type TurnStatus int
+
+const (
+	TurnRunning TurnStatus = iota // 応答待ち・出力中
+	TurnDone                      // 完了
+	TurnFailed                    // エラー・非ゼロ終了
+)
+
+func (s TurnStatus) String() string {
+	switch s {
+	case TurnRunning:
+		return "running"
+	case TurnDone:
+		return "done"
+	case TurnFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// Turn はプロンプト 1 件と、それに対する応答のまとまり
+// Prompt が空のターンは、最初のプロンプトより前の出力（起動メッセージ等）を保持する
+type Turn struct {
+	Prompt    string
+	Mode      string // 入力の種類（transcript.ModeChat / ModeCommand / ModeQube）
+	Entries   []TurnEntry
+	Started   time.Time
+	Finished  time.Time // 完了時刻（実行中はゼロ値）
+	Status    TurnStatus
+	Collapsed bool
+}
+
+// IsPreamble は最初のプロンプトより前の出力をまとめたターンかどうかを返す
+func (t *Turn) IsPreamble() bool { return t.Prompt == "" }
+
+// Elapsed はターンの所要時間を返す（実行中なら now までの時間）
+func (t *Turn) Elapsed(now time.Time) time.Duration {
+	if !t.Finished.IsZero() {
+		return t.Finished.Sub(t.Started)
+	}
+	return now.Sub(t.Started)
+}
+
+// visibleEntries は描画対象のエントリ（進捗以外）を返す
+func (t *Turn) visibleEntries() []TurnEntry {
+	var out []TurnEntry
+	for _, e := range t.Entries {
+		if e.Kind != EntryProgress {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Scrollback はスクロールバックをターンの列として保持する
+type Scrollback struct {
+	turns []Turn
+}
+
+// Turns はすべてのターンを返す
+func (s *Scrollback) Turns() []Turn { return s.turns }
+
+// Len はターン数を返す
+func (s *Scrollback) Len() int { return len(s.turns) }
+
+// Current は最後のターンを返す（ターンがなければ nil）
+func (s *Scrollback) Current() *Turn {
+	if len(s.turns) == 0 {
+		return nil
+	}
+	return &s.turns[len(s.turns)-1]
+}
+
+// Begin は新しいターンを始める
+// 実行中のまま残っている直前のターンは完了として閉じる（チャットでは次の入力が応答の終わりを意味する）
+func (s *Scrollback) Begin(prompt string, now time.Time) *Turn {
+	s.Finish(TurnDone, now)
+	s.turns = append(s.turns, Turn{Prompt: prompt, Started: now, Status: TurnRunning})
+	return s.Current()
+}
+
+// Append は最後のターンにエントリを追加する
+// ターンがなければ、プロンプトなしのターン（preamble）を作る
+func (s *Scrollback) Append(kind EntryKind, text string, now time.Time) {
+	if len(s.turns) == 0 {
+		s.turns = append(s.turns, Turn{Started: now, Status: TurnDone, Finished: now})
+	}
+	t := s.Current()
+	// 進捗は同じ表示が続く間は 1 件にまとめる
+	if kind == EntryProgress && len(t.Entries) > 0 {
+		if last := t.Entries[len(t.Entries)-1]; last.Kind == EntryProgress && last.Text == text {
+			return
+		}
+	}
+	t.Entries = append(t.Entries, TurnEntry{Kind: kind, Text: text, Time: now})
+}
+
+// Finish は実行中の最後のターンを status で閉じる
+func (s *Scrollback) Finish(status TurnStatus, now time.Time) {
+	t := s.Current()
+	if t == nil || t.Status != TurnRunning {
+		return
+	}
+	t.Status = status
+	t.Finished = now
+}
+
+// Toggle は i 番目のターンの折りたたみを切り替える
+func (s *Scrollback) Toggle(i int) {
+	if i >= 0 && i < len(s.turns) {
+		s.turns[i].Collapsed = !s.turns[i].Collapsed
+	}
+}
+
+// Clear はすべてのターンを捨てる
+func (s *Scrollback) Clear() { s.turns = nil }
+
+// classifyOutput は出力行の種類を推定する（ツール実行の通知を区別する）
+func classifyOutput(line string) EntryKind {
+	if strings.Contains(line, "Using tool:") {
+		return EntryTool
+	}
+	return EntryOutput
+}
+
+// turnIndexAt はコンテンツの line 行目を含むターンの番号を返す（ターンがなければ -1）
+func (m *Model) turnIndexAt(line int) int {
+	idx := -1
+	for i, start := range m.turnLines {
+		if start > line {
+			break
+		}
+		idx = i
+	}
+	return idx
+}
+
+// targetTurn は line を基準に delta 個先のターンの先頭行を返す
+// 後ろへ戻る場合、line がターンの途中ならまずそのターンの先頭を対象にする
+func (m *Model) targetTurn(line, delta int) (int, bool) {
+	if len(m.turnLines) == 0 {
+		return 0, false
+	}
+	cur := m.turnIndexAt(line)
+	target := cur + delta
+	if delta < 0 && cur >= 0 && line > m.turnLines[cur] {
+		target = cur + delta + 1
+	}
+	target = max(min(target, len(m.turnLines)-1), 0)
+	return m.turnLines[target], true
+}
+
+// jumpTurn は表示位置をターン単位で移動する（先頭のターンを画面の一番上に置く）
+func (m *Model) jumpTurn(delta int) {
+	if !m.ready {
+		return
+	}
+	if line, ok := m.targetTurn(m.viewport.YOffset, delta); ok {
+		m.viewport.SetYOffset(line)
+	}
+}
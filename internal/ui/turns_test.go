@@ -0,0 +1,229 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+	"qube/internal/execq"
+	"qube/internal/executor"
+	"qube/internal/transcript"
+)
+
+func Test_Scrollback_GroupsOutputIntoTurns(t *testing.T) {
+	var s Scrollback
+	t0 := time.Unix(1000, 0)
+
+	// 最初のプロンプトより前の出力は preamble にまとまる
+	s.Append(EntryOutput, "Welcome to Q", t0)
+	s.Begin("hello", t0.Add(time.Second))
+	s.Append(EntryProgress, "Thinking...", t0.Add(2*time.Second))
+	s.Append(EntryProgress, "Thinking...", t0.Add(3*time.Second))
+	s.Append(EntryTool, "Using tool: fs_read", t0.Add(4*time.Second))
+	s.Append(EntryOutput, "Hi!", t0.Add(5*time.Second))
+	s.Begin("next", t0.Add(6*time.Second))
+
+	turns := s.Turns()
+	if len(turns) != 3 || !turns[0].IsPreamble() || turns[1].Prompt != "hello" {
+		t.Fatalf("unexpected turns: %+v", turns)
+	}
+	hello := turns[1]
+	if len(hello.Entries) != 3 {
+		t.Fatalf("repeated progress should be recorded once, got %+v", hello.Entries)
+	}
+	if hello.Status != TurnDone || hello.Elapsed(time.Time{}) != 5*time.Second {
+		t.Fatalf("starting the next turn should finish the previous one: %+v", hello)
+	}
+	if turns[2].Status != TurnRunning {
+		t.Fatalf("latest turn should be running, got %v", turns[2].Status)
+	}
+}
+
+func Test_Model_TurnsTrackPromptsAndResults(t *testing.T) {
+	m := New()
+	m.AddOutput("banner")
+	m.AddUserInput("q help")
+	_, _ = m.Update(executor.EventOutput{Text: "usage: q ..."})
+	_, _ = m.Update(executor.EventCommandFinished{Result: execq.Result{ExitCode: 2}})
+
+	turns := m.turns.Turns()
+	if len(turns) != 2 {
+		t.Fatalf("got %d turns", len(turns))
+	}
+	cmd := turns[1]
+	if cmd.Prompt != "q help" || cmd.Status != TurnFailed {
+		t.Fatalf("command turn: %+v", cmd)
+	}
+	if len(cmd.Entries) != 2 || cmd.Entries[0].Kind != EntryOutput || cmd.Entries[1].Kind != EntryResult {
+		t.Fatalf("entries should be typed: %+v", cmd.Entries)
+	}
+
+	// ツール実行の通知は種類で区別される
+	m.AddOutput("🛠️  Using tool: execute_bash")
+	if k := m.turns.Current().Entries[2].Kind; k != EntryTool {
+		t.Fatalf("tool line kind: %v", k)
+	}
+}
+
+// Test_Model_ChatTurnFinishesAtPrompt は、Q がプロンプトに戻った時点でチャットのターンが完了し、
+// 経過時間が止まることを検証する
+func Test_Model_ChatTurnFinishesAtPrompt(t *testing.T) {
+	m := New()
+	m.SetMode(ModeSession)
+	m.AddUserInput("hello")
+	m.AddOutput("Hi!")
+	if got := m.turns.Current().Status; got != TurnRunning {
+		t.Fatalf("chat turn should be running until the prompt returns: %v", got)
+	}
+
+	_, _ = m.Update(MsgChatIdle{})
+	turn := m.turns.Current()
+	if turn.Status != TurnDone || turn.Finished.IsZero() {
+		t.Fatalf("chat turn should be done: %+v", turn)
+	}
+	later := turn.Finished.Add(time.Minute)
+	if got := turn.Elapsed(later); got != turn.Finished.Sub(turn.Started) {
+		t.Fatalf("elapsed should stop at the prompt: %v", got)
+	}
+	if tr := m.Transcript(later); tr.Turns[len(tr.Turns)-1].Status == "running" {
+		t.Fatalf("exported turn should not be running: %+v", tr.Turns)
+	}
+}
+
+func Test_ScrollMode_CollapseAndJumpBetweenTurns(t *testing.T) {
+	m := New()
+	_, _ = m.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+	for i := 0; i < 3; i++ {
+		m.AddUserInput(fmt.Sprintf("prompt %d", i))
+		for j := 0; j < 30; j++ {
+			m.AddOutput(fmt.Sprintf("answer %d.%d", i, j))
+		}
+	}
+
+	_, _ = m.Update(keyPress(tea.KeyEsc))
+	_, _ = m.Update(runes("{"))
+	if got := m.turnIndexAt(m.scroll.cursor); got != 2 || m.scroll.cursor != m.turnLines[2] {
+		t.Fatalf("{ should move to the start of the current turn, got turn %d", got)
+	}
+	_, _ = m.Update(runes("{"))
+	if m.scroll.cursor != m.turnLines[1] {
+		t.Fatalf("{ should move to the previous turn")
+	}
+	_, _ = m.Update(runes("}"))
+	if m.scroll.cursor != m.turnLines[2] {
+		t.Fatalf("} should move to the next turn")
+	}
+
+	// 折りたたむと応答が隠れ、要約だけが表示される
+	_, _ = m.Update(runes("{"))
+	_, _ = m.Update(keyPress(tea.KeyTab))
+	if !m.turns.Turns()[1].Collapsed {
+		t.Fatal("Tab should collapse the turn under the cursor")
+	}
+	out := ansi.Strip(m.renderAllOutput())
+	if strings.Contains(out, "answer 1.5") || !strings.Contains(out, "▸ 30 line(s) hidden") {
+		t.Fatalf("collapsed turn should only show a summary:\n%s", out)
+	}
+	if !strings.Contains(out, "prompt 1") || !strings.Contains(out, "answer 2.5") {
+		t.Fatal("other turns and the prompt must stay visible")
+	}
+	_, _ = m.Update(runes("o"))
+	if m.turns.Turns()[1].Collapsed {
+		t.Fatal("o should expand the turn again")
+	}
+}
+
+func Test_Input_JumpBetweenTurns(t *testing.T) {
+	m := New()
+	_, _ = m.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+	for i := 0; i < 3; i++ {
+		m.AddUserInput(fmt.Sprintf("prompt %d", i))
+		for j := 0; j < 30; j++ {
+			m.AddOutput("answer")
+		}
+	}
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyUp, Alt: true})
+	if m.viewport.YOffset != m.turnLines[2] {
+		t.Fatalf("alt+up should show the start of the last turn, offset=%d want %d", m.viewport.YOffset, m.turnLines[2])
+	}
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyUp, Alt: true})
+	if m.viewport.YOffset != m.turnLines[1] {
+		t.Fatalf("alt+up again should show the previous turn")
+	}
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyDown, Alt: true})
+	if m.viewport.YOffset != m.turnLines[2] {
+		t.Fatalf("alt+down should move forward a turn")
+	}
+}
+
+// fakeExporter は書き出しを要求された記録を保持するテスト用の Exporter
+type fakeExporter struct {
+	got  transcript.Transcript
+	path string
+}
+
+func (e *fakeExporter) Export(t transcript.Transcript, path string) (string, error) {
+	e.got, e.path = t, path
+	return "/tmp/out.md", nil
+}
+
+func Test_Model_TranscriptFromTurns(t *testing.T) {
+	m := New()
+	m.SetProfile("prod", "prod-ro")
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.AddUserInput("ls -l")
+	_, _ = m.Update(executor.EventOutput{Text: "\x1b[32mtotal 0\x1b[0m  \n"})
+	_, _ = m.Update(executor.EventCommandFinished{Result: execq.Result{
+		ExitCode: 1, StartedAt: start, EndedAt: start.Add(1500 * time.Millisecond), TimedOut: true,
+	}})
+	m.mode = ModeSession
+	m.AddUserInput("hello")
+	m.turns.Append(EntryProgress, "Thinking...", time.Now())
+	m.AddOutput("Hi!")
+	m.AddUserInput("/qube save")
+
+	tr := m.Transcript(start)
+	if tr.Profile != "prod" || !tr.Exported.Equal(start) || len(tr.Turns) != 3 {
+		t.Fatalf("unexpected transcript: %+v", tr)
+	}
+	cmd, chat := tr.Turns[0], tr.Turns[1]
+	if cmd.Mode != transcript.ModeCommand || chat.Mode != transcript.ModeChat || tr.Turns[2].Mode != transcript.ModeQube {
+		t.Fatalf("modes: %q %q %q", cmd.Mode, chat.Mode, tr.Turns[2].Mode)
+	}
+	// 出力は ANSI エスケープを除き、終了コードは表示用のバッジではなく値で残る
+	if cmd.Status != "failed" || len(cmd.Entries) != 2 || cmd.Entries[0].Text != "total 0" {
+		t.Fatalf("command turn: %+v", cmd)
+	}
+	if r := cmd.Entries[1].Result; r == nil || r.ExitCode != 1 || r.DurationMs != 1500 || !r.TimedOut {
+		t.Fatalf("command result: %+v", r)
+	}
+	// 進捗表示は記録に含めない
+	if len(chat.Entries) != 1 || chat.Entries[0].Kind != transcript.KindOutput || chat.Entries[0].Text != "Hi!" {
+		t.Fatalf("chat turn: %+v", chat.Entries)
+	}
+}
+
+func Test_Model_ExportUsesExporter(t *testing.T) {
+	m := New()
+	if cmd := m.export(""); cmd != nil {
+		t.Fatalf("export without an exporter should not run")
+	}
+
+	exp := &fakeExporter{}
+	m.SetExporter(exp)
+	m.AddUserInput("q help")
+	_, cmd := m.Update(MsgExport{Path: "notes.md"})
+	if cmd == nil {
+		t.Fatal("MsgExport should return a command")
+	}
+	msg, ok := cmd().(MsgAddOutput)
+	if !ok || msg.Line != "Saved conversation to /tmp/out.md" {
+		t.Fatalf("unexpected result: %#v", msg)
+	}
+	if exp.path != "notes.md" || len(exp.got.Turns) == 0 || exp.got.Turns[len(exp.got.Turns)-1].Prompt != "q help" {
+		t.Fatalf("exporter got %q %+v", exp.path, exp.got)
+	}
+}